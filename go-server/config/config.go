@@ -4,6 +4,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -26,6 +29,9 @@ type Config struct {
 	JWTSecret    string
 	JWTExpiresIn string
 
+	// EncryptionKey protects secrets stored at rest (falls back to JWTSecret)
+	EncryptionKey string
+
 	// Frontend
 	FrontendURL string
 
@@ -41,6 +47,82 @@ type Config struct {
 	GoogleRedirectURI  string
 	GithubClientID     string
 	GithubClientSecret string
+
+	// Hosting
+	ServerIP   string
+	ServerIPv6 string
+	// AdminEmails are granted administrator rights on startup
+	AdminEmails []string
+	// TrustedProxies are the reverse proxies (IPs or CIDRs) whose
+	// X-Forwarded-For header gives the client address; empty trusts none
+	TrustedProxies []string
+
+	// Web Server (vhost provisioning, disabled when VhostDir is empty)
+	VhostServer    string
+	VhostDir       string
+	VhostTestCmd   string
+	VhostReloadCmd string
+	PHPHandler     string
+	SSLCertDir     string
+	StatusPageDir  string
+
+	// DNS (SOA defaults; the first nameserver is the SOA primary)
+	DNSNameservers []string
+	DNSHostmaster  string
+	// DNSDefaultTemplate is the preset applied to new domains
+	DNSDefaultTemplate string
+
+	// PowerDNS HTTP API (sync disabled when PowerDNSAPIURL is empty)
+	PowerDNSAPIURL            string
+	PowerDNSAPIKey            string
+	PowerDNSServerID          string
+	PowerDNSZoneKind          string
+	PowerDNSSyncInterval      time.Duration
+	PowerDNSReconcileInterval time.Duration
+
+	// Embedded authoritative DNS server (disabled when DNSServerAddr is empty)
+	DNSServerAddr           string
+	DNSServerSecondaries    []string
+	DNSServerReloadInterval time.Duration
+
+	// DNSSEC key rollover (a lifetime of 0 disables automatic rollover)
+	DNSSECZSKLifetime      time.Duration
+	DNSSECKSKLifetime      time.Duration
+	DNSSECPropagation      time.Duration
+	DNSSECRolloverInterval time.Duration
+
+	// DNS propagation checks: public resolvers ("name=ip[:port]" or "ip")
+	// and the per-query timeout
+	DNSPropagationResolvers []string
+	DNSPropagationTimeout   time.Duration
+
+	// DynDNS update protocol (/nic/update): requests per update token, and
+	// failed logins per client IP, allowed within the window (0 disables)
+	DynDNSRateLimit  int
+	DynDNSRateWindow time.Duration
+
+	// ACME certificate issuance. ACMECAFile adds a CA trusted for the ACME
+	// directory (e.g. a local Pebble); ACMEChallenge is the default
+	// challenge type. DNS-01 records are awaited on the zone's nameservers
+	// (or ACMEDNSNameservers, "ip[:port]") for up to
+	// ACMEDNSPropagationTimeout
+	ACMEDirectoryURL          string
+	ACMEEmail                 string
+	ACMECAFile                string
+	ACMEChallenge             string
+	ACMEDNSNameservers        []string
+	ACMEDNSPropagationTimeout time.Duration
+	ACMETimeout               time.Duration
+
+	// SSL auto-renewal: certificates of domains with auto_renew_ssl are
+	// renewed once they expire within SSLRenewBefore, each at a random
+	// point within SSLRenewalJitter of becoming due
+	SSLRenewBefore   time.Duration
+	SSLRenewalJitter time.Duration
+
+	// Background Workers (an interval of 0 disables the worker)
+	DomainMonitorInterval time.Duration
+	SSLRenewalInterval    time.Duration
 }
 
 // AppConfig is the global configuration instance
@@ -85,6 +167,9 @@ func Load() *Config {
 		JWTSecret:    getEnv("JWT_SECRET", "your-secret-key-change-this"),
 		JWTExpiresIn: getEnv("JWT_EXPIRES_IN", "7d"),
 
+		// Encryption at rest
+		EncryptionKey: getEnv("ENCRYPTION_KEY", ""),
+
 		// Frontend
 		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:5173"),
 
@@ -100,6 +185,70 @@ func Load() *Config {
 		GoogleRedirectURI:  getEnv("GOOGLE_REDIRECT_URI", "http://localhost:5173/auth/google/callback"),
 		GithubClientID:     getEnv("GITHUB_CLIENT_ID", ""),
 		GithubClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+
+		// Hosting
+		ServerIP:       getEnv("SERVER_IP", ""),
+		ServerIPv6:     getEnv("SERVER_IPV6", ""),
+		AdminEmails:    getEnvList("ADMIN_EMAILS"),
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		// Web Server
+		VhostServer:    getEnv("VHOST_SERVER", "nginx"),
+		VhostDir:       getEnv("VHOST_DIR", ""),
+		VhostTestCmd:   getEnv("VHOST_TEST_CMD", ""),
+		VhostReloadCmd: getEnv("VHOST_RELOAD_CMD", ""),
+		PHPHandler:     getEnv("PHP_HANDLER", "unix:/run/php/php-fpm.sock"),
+		SSLCertDir:     getEnv("SSL_CERT_DIR", "./ssl-certs"),
+		StatusPageDir:  getEnv("STATUS_PAGE_DIR", "./status-pages"),
+
+		// DNS
+		DNSNameservers:     getEnvList("DNS_NAMESERVERS"),
+		DNSHostmaster:      getEnv("DNS_HOSTMASTER", ""),
+		DNSDefaultTemplate: getEnv("DNS_DEFAULT_TEMPLATE", "default"),
+
+		// PowerDNS
+		PowerDNSAPIURL:            getEnv("POWERDNS_API_URL", ""),
+		PowerDNSAPIKey:            getEnv("POWERDNS_API_KEY", ""),
+		PowerDNSServerID:          getEnv("POWERDNS_SERVER_ID", "localhost"),
+		PowerDNSZoneKind:          getEnv("POWERDNS_ZONE_KIND", "Native"),
+		PowerDNSSyncInterval:      getEnvDuration("POWERDNS_SYNC_INTERVAL", 30*time.Second),
+		PowerDNSReconcileInterval: getEnvDuration("POWERDNS_RECONCILE_INTERVAL", time.Hour),
+
+		// Embedded DNS server
+		DNSServerAddr:           getEnv("DNS_SERVER_ADDR", ""),
+		DNSServerSecondaries:    getEnvList("DNS_SERVER_SECONDARIES"),
+		DNSServerReloadInterval: getEnvDuration("DNS_SERVER_RELOAD_INTERVAL", 5*time.Second),
+
+		// DNSSEC
+		DNSSECZSKLifetime:      getEnvDuration("DNSSEC_ZSK_LIFETIME", 90*24*time.Hour),
+		DNSSECKSKLifetime:      getEnvDuration("DNSSEC_KSK_LIFETIME", 365*24*time.Hour),
+		DNSSECPropagation:      getEnvDuration("DNSSEC_PROPAGATION_DELAY", 48*time.Hour),
+		DNSSECRolloverInterval: getEnvDuration("DNSSEC_ROLLOVER_INTERVAL", time.Hour),
+
+		// DNS propagation checks
+		DNSPropagationResolvers: getEnvList("DNS_PROPAGATION_RESOLVERS"),
+		DNSPropagationTimeout:   getEnvDuration("DNS_PROPAGATION_TIMEOUT", 3*time.Second),
+
+		// DynDNS
+		DynDNSRateLimit:  getEnvInt("DYNDNS_RATE_LIMIT", 10),
+		DynDNSRateWindow: getEnvDuration("DYNDNS_RATE_WINDOW", 10*time.Minute),
+
+		// ACME
+		ACMEDirectoryURL:          getEnv("ACME_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory"),
+		ACMEEmail:                 getEnv("ACME_EMAIL", ""),
+		ACMECAFile:                getEnv("ACME_CA_FILE", ""),
+		ACMEChallenge:             getEnv("ACME_CHALLENGE", "http-01"),
+		ACMEDNSNameservers:        getEnvList("ACME_DNS_NAMESERVERS"),
+		ACMEDNSPropagationTimeout: getEnvDuration("ACME_DNS_PROPAGATION_TIMEOUT", 2*time.Minute),
+		ACMETimeout:               getEnvDuration("ACME_TIMEOUT", 3*time.Minute),
+
+		// SSL auto-renewal
+		SSLRenewBefore:   getEnvDuration("SSL_RENEW_BEFORE", 30*24*time.Hour),
+		SSLRenewalJitter: getEnvDuration("SSL_RENEWAL_JITTER", 6*time.Hour),
+
+		// Background Workers
+		DomainMonitorInterval: getEnvDuration("DOMAIN_MONITOR_INTERVAL", time.Minute),
+		SSLRenewalInterval:    getEnvDuration("SSL_RENEWAL_INTERVAL", 10*time.Minute),
 	}
 
 	return AppConfig
@@ -112,3 +261,45 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma separated environment variable, dropping empty
+// entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getEnvInt parses an integer environment variable, falling back to the
+// default when unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		log.Printf("⚠️ Invalid integer for %s: %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvDuration parses a duration environment variable (e.g. "5m"),
+// falling back to the default when unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ Invalid duration for %s: %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminController handles administrative endpoints
+type AdminController struct {
+	vhost *services.VhostService
+	dns   *services.PowerDNSSyncService
+}
+
+// NewAdminController creates a new admin controller
+func NewAdminController(vhost *services.VhostService, dns *services.PowerDNSSyncService) *AdminController {
+	return &AdminController{vhost: vhost, dns: dns}
+}
+
+// loadDomain parses the domain ID route param and loads the domain of any
+// user, writing the error response itself when it returns false
+func (ac *AdminController) loadDomain(c *gin.Context) (*models.Domain, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid domain ID",
+		})
+		return nil, false
+	}
+
+	domain, err := models.FindDomainByID(context.Background(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Domain not found",
+		})
+		return nil, false
+	}
+
+	return domain, true
+}
+
+// changeStatus applies an administrative status change and re-provisions
+// the vhost so the status page is served (or removed) right away
+func (ac *AdminController) changeStatus(c *gin.Context, domain *models.Domain, status, reason, message string) {
+	ctx := context.Background()
+	adminID := middleware.GetUserID(c)
+	if _, err := models.ChangeDomainStatus(ctx, domain.ID, status, reason, &adminID); err != nil {
+		respondStatusChangeError(c, err)
+		return
+	}
+
+	updated, err := models.FindDomainByID(ctx, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch domain",
+		})
+		return
+	}
+	if err := ac.vhost.SyncDomain(ctx, updated); err != nil {
+		log.Printf("WARN: Failed to provision vhost for %s: %v", updated.DomainName, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"domain":  updated.ToResponse(),
+	})
+}
+
+// SuspendDomainRequest represents the suspend domain request
+type SuspendDomainRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// SuspendDomain takes a domain offline, serving the suspended page instead
+// of the site until an administrator lifts the suspension
+func (ac *AdminController) SuspendDomain(c *gin.Context) {
+	domain, ok := ac.loadDomain(c)
+	if !ok {
+		return
+	}
+
+	var req SuspendDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A suspension reason is required",
+		})
+		return
+	}
+
+	if domain.Status == models.DomainStatusSuspended {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain is already suspended",
+		})
+		return
+	}
+
+	ac.changeStatus(c, domain, models.DomainStatusSuspended, strings.TrimSpace(req.Reason),
+		"Domain "+domain.DomainName+" suspended")
+}
+
+// UnsuspendDomainRequest represents the unsuspend domain request
+type UnsuspendDomainRequest struct {
+	Reason string `json:"reason"`
+}
+
+// UnsuspendDomain lifts a suspension. Verified domains go back to active,
+// unverified ones to pending
+func (ac *AdminController) UnsuspendDomain(c *gin.Context) {
+	domain, ok := ac.loadDomain(c)
+	if !ok {
+		return
+	}
+
+	var req UnsuspendDomainRequest
+	_ = c.ShouldBindJSON(&req)
+
+	if domain.Status != models.DomainStatusSuspended {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain is not suspended",
+		})
+		return
+	}
+
+	target := models.DomainStatusPending
+	if domain.VerifiedAt.Valid {
+		target = models.DomainStatusActive
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "Suspension lifted"
+	}
+
+	ac.changeStatus(c, domain, target, reason, "Domain "+domain.DomainName+" is no longer suspended")
+}
+
+// GetDNSDrift compares every zone in Postgres with PowerDNS without changing
+// anything
+func (ac *AdminController) GetDNSDrift(c *gin.Context) {
+	ac.reconcileDNS(c, false)
+}
+
+// ReconcileDNS pushes drifted zones to PowerDNS. With ?delete_orphans=true
+// zones in PowerDNS that no domain owns are deleted too
+func (ac *AdminController) ReconcileDNS(c *gin.Context) {
+	ac.reconcileDNS(c, true)
+}
+
+func (ac *AdminController) reconcileDNS(c *gin.Context, apply bool) {
+	if !ac.dns.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "PowerDNS integration is not configured",
+		})
+		return
+	}
+
+	deleteOrphans := apply && c.Query("delete_orphans") == "true"
+	report, err := ac.dns.ReconcileAll(context.Background(), apply, deleteOrphans)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"message": "Failed to reach PowerDNS",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	message := "All zones are in sync"
+	switch {
+	case apply && len(report.Drifted) > 0:
+		message = "Drifted zones were pushed to PowerDNS"
+	case len(report.Drifted) > 0:
+		message = "Some zones differ from PowerDNS"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": len(report.Errors) == 0,
+		"message": message,
+		"report":  report,
+	})
+}
@@ -1,72 +1,83 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"log"
 	"net/http"
 	"strconv"
 
 	"cloudku-server/database"
 	"cloudku-server/middleware"
 	"cloudku-server/models"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // DNSController handles DNS management endpoints
-type DNSController struct{}
+type DNSController struct {
+	dns         *services.PowerDNSSyncService
+	dnssec      *services.DNSSECService
+	propagation *services.DNSPropagationService
+}
 
 // NewDNSController creates a new DNS controller
-func NewDNSController() *DNSController {
-	return &DNSController{}
+func NewDNSController(dns *services.PowerDNSSyncService, dnssec *services.DNSSECService) *DNSController {
+	return &DNSController{
+		dns:         dns,
+		dnssec:      dnssec,
+		propagation: services.NewDNSPropagationService(),
+	}
 }
 
-// GetDNSStats returns DNS statistics
-func (dc *DNSController) GetDNSStats(c *gin.Context) {
+// syncZone pushes a changed zone to PowerDNS. Failures are logged rather
+// than returned: the change is committed and the zone stays pending until
+// the background sync pushes it
+func syncZone(ctx context.Context, dns *services.PowerDNSSyncService, domainID int) {
+	if err := dns.SyncDomainID(ctx, domainID); err != nil {
+		log.Printf("WARN: Failed to sync zone %d to PowerDNS: %v", domainID, err)
+	}
+}
+
+// dnsChangeActor attributes a zone change to the authenticated user
+func dnsChangeActor(c *gin.Context, source string) models.DNSChangeActor {
 	userID := middleware.GetUserID(c)
-	ctx := context.Background()
+	return models.DNSChangeActor{UserID: &userID, Source: source}
+}
+
+// GetDNSStats returns DNS statistics: record counts for every type, in
+// total and per domain
+func (dc *DNSController) GetDNSStats(c *gin.Context) {
+	domains, err := models.GetDNSStatsByUserID(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS statistics",
+		})
+		return
+	}
 
-	var totalRecords, aRecords, cnameRecords, mxRecords int
-
-	query := `
-		SELECT COUNT(r.id)
-		FROM dns_records r
-		JOIN domains d ON r.domain_id = d.id
-		WHERE d.user_id = $1
-	`
-	database.DB.QueryRow(ctx, query, userID).Scan(&totalRecords)
-
-	query = `
-		SELECT COUNT(r.id)
-		FROM dns_records r
-		JOIN domains d ON r.domain_id = d.id
-		WHERE d.user_id = $1 AND r.record_type = 'A'
-	`
-	database.DB.QueryRow(ctx, query, userID).Scan(&aRecords)
-
-	query = `
-		SELECT COUNT(r.id)
-		FROM dns_records r
-		JOIN domains d ON r.domain_id = d.id
-		WHERE d.user_id = $1 AND r.record_type = 'CNAME'
-	`
-	database.DB.QueryRow(ctx, query, userID).Scan(&cnameRecords)
-
-	query = `
-		SELECT COUNT(r.id)
-		FROM dns_records r
-		JOIN domains d ON r.domain_id = d.id
-		WHERE d.user_id = $1 AND r.record_type = 'MX'
-	`
-	database.DB.QueryRow(ctx, query, userID).Scan(&mxRecords)
+	totalRecords := 0
+	byType := map[string]int{}
+	for _, d := range domains {
+		totalRecords += d.TotalRecords
+		for recordType, n := range d.ByType {
+			byType[recordType] += n
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"stats": gin.H{
+			"totalDomains": len(domains),
 			"totalRecords": totalRecords,
-			"aRecords":     aRecords,
-			"cnameRecords": cnameRecords,
-			"mxRecords":    mxRecords,
-			"otherRecords": totalRecords - aRecords - cnameRecords - mxRecords,
+			"aRecords":     byType["A"],
+			"cnameRecords": byType["CNAME"],
+			"mxRecords":    byType["MX"],
+			"otherRecords": totalRecords - byType["A"] - byType["CNAME"] - byType["MX"],
+			"byType":       byType,
+			"domains":      domains,
 		},
 	})
 }
@@ -106,32 +117,15 @@ func (dc *DNSController) ExportZone(c *gin.Context) {
 		return
 	}
 
-	// Generate BIND zone file format
-	zoneFile := "; Zone file for " + domain.DomainName + "\n"
-	zoneFile += "; Exported from CloudKu\n"
-	zoneFile += "$ORIGIN " + domain.DomainName + ".\n"
-	zoneFile += "$TTL 3600\n\n"
-
-	// Add SOA record
-	zoneFile += "@ IN SOA ns1." + domain.DomainName + ". admin." + domain.DomainName + ". (\n"
-	zoneFile += "    2024010101 ; Serial\n"
-	zoneFile += "    3600       ; Refresh\n"
-	zoneFile += "    1800       ; Retry\n"
-	zoneFile += "    604800     ; Expire\n"
-	zoneFile += "    86400 )    ; Minimum TTL\n\n"
-
-	// Add records
-	for _, r := range records {
-		name := r.Name
-		if name == "@" {
-			name = domain.DomainName + "."
-		}
-		priority := ""
-		if r.Priority.Valid && r.RecordType == "MX" {
-			priority = strconv.Itoa(int(r.Priority.Int32)) + " "
-		}
-		zoneFile += name + " " + strconv.Itoa(r.TTL) + " IN " + r.RecordType + " " + priority + r.Value + "\n"
+	soa, err := services.GetZoneSOA(ctx, domain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load zone SOA",
+		})
+		return
 	}
+	zoneFile := services.RenderZoneFile(domain.DomainName, soa, records)
 
 	// Send as downloadable file
 	c.Header("Content-Type", "text/plain")
@@ -139,28 +133,63 @@ func (dc *DNSController) ExportZone(c *gin.Context) {
 	c.String(http.StatusOK, zoneFile)
 }
 
-// GetPowerDNSStatus returns PowerDNS server status
+// GetPowerDNSStatus returns the live PowerDNS server status: version,
+// uptime, query counters and zone count
 func (dc *DNSController) GetPowerDNSStatus(c *gin.Context) {
-	// In production, this would check actual PowerDNS status
+	status := dc.dns.Status(context.Background())
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"powerdns": gin.H{
-			"running": true,
-			"version": "4.7.0",
-			"uptime":  "5 days",
-			"queries": 12345,
-			"zones":   42,
-		},
+		"success":  true,
+		"powerdns": status,
 	})
 }
 
-// ReloadPowerDNS reloads PowerDNS server
+// ReloadPowerDNS pushes every zone of the user to PowerDNS, fixing any drift
 func (dc *DNSController) ReloadPowerDNS(c *gin.Context) {
-	// In production, this would execute pdns_control reload
+	if !dc.dns.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "PowerDNS integration is not configured",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	domains, err := models.GetDomainsByUserID(ctx, middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch domains",
+		})
+		return
+	}
+
+	zones := make([]*services.ZoneDrift, 0, len(domains))
+	failed := gin.H{}
+	for i := range domains {
+		drift, err := dc.dns.SyncZone(ctx, &domains[i])
+		if err != nil {
+			failed[domains[i].DomainName] = err.Error()
+			continue
+		}
+		zones = append(zones, drift)
+	}
+
+	if len(failed) > 0 {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"message": "Some zones could not be synced to PowerDNS; they will be retried in the background",
+			"zones":   zones,
+			"errors":  failed,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
 		"reloaded": true,
-		"message":  "PowerDNS reloaded successfully",
+		"message":  "Zones synced to PowerDNS",
+		"zones":    zones,
 	})
 }
 
@@ -180,7 +209,7 @@ func (dc *DNSController) GetPowerDNSRecords(c *gin.Context) {
 	ctx := context.Background()
 
 	// Verify ownership
-	_, err = models.GetDomainByID(ctx, domainID, userID)
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -204,10 +233,22 @@ func (dc *DNSController) GetPowerDNSRecords(c *gin.Context) {
 		recordsResponse[i] = r.ToResponse()
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"records": recordsResponse,
-	})
+	}
+
+	// Show what PowerDNS actually serves next to the stored records
+	if dc.dns.Enabled() {
+		drift, err := dc.dns.CheckZone(ctx, domain)
+		if err != nil {
+			response["powerdns"] = gin.H{"error": err.Error()}
+		} else {
+			response["powerdns"] = gin.H{"in_sync": drift.InSync(), "drift": drift}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // IncrementSOASerial increments SOA serial for zone updates
@@ -235,9 +276,252 @@ func (dc *DNSController) IncrementSOASerial(c *gin.Context) {
 		return
 	}
 
-	// In production, this would update the SOA record serial
+	serial, err := models.BumpZoneSerial(ctx, database.DB, domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to increment SOA serial",
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domainID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "SOA serial incremented",
+		"serial":  serial,
+	})
+}
+
+// CheckPropagation queries public resolvers and the zone's nameservers for
+// the domain's records and reports which of them serve the stored values.
+// Query params name (e.g. www or @) and type limit the check
+func (dc *DNSController) CheckPropagation(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	report, err := dc.propagation.CheckZone(c.Request.Context(), domain, c.Query("name"), c.Query("type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to check DNS propagation",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"propagation": report,
+	})
+}
+
+// GetSOA returns the SOA of a domain's zone
+func (dc *DNSController) GetSOA(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	soa, err := services.GetZoneSOA(context.Background(), domain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load zone SOA",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"soa":         soa,
+		"nameservers": services.ZoneNameservers(domain.DomainName),
+	})
+}
+
+// UpdateSOARequest represents the update SOA request; omitted fields are
+// left unchanged and an empty primary_ns or hostmaster restores the default
+type UpdateSOARequest struct {
+	PrimaryNS  *string `json:"primary_ns"`
+	Hostmaster *string `json:"hostmaster"`
+	Refresh    *int    `json:"refresh"`
+	Retry      *int    `json:"retry"`
+	Expire     *int    `json:"expire"`
+	Minimum    *int    `json:"minimum"`
+}
+
+// UpdateSOA changes the SOA fields of a domain's zone. The serial is
+// managed by the server and bumped by the change
+func (dc *DNSController) UpdateSOA(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	var req UpdateSOARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx := context.Background()
+	current, err := models.GetDNSZone(ctx, database.DB, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load zone SOA",
+		})
+		return
+	}
+
+	fields := models.SOAFields{
+		PrimaryNS:  req.PrimaryNS,
+		Hostmaster: req.Hostmaster,
+		Refresh:    req.Refresh,
+		Retry:      req.Retry,
+		Expire:     req.Expire,
+		Minimum:    req.Minimum,
+	}
+	if err := services.ValidateSOAFields(current, &fields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid SOA values",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	zone, err := models.UpdateDNSZoneSOA(ctx, domain.ID, fields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update zone SOA",
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "SOA updated successfully",
+		"soa":     services.ResolveSOA(domain.DomainName, zone),
+	})
+}
+
+// ImportZone imports a BIND zone file into a domain's records.
+// Query params: mode (merge|replace, default merge) and dry_run=true to only
+// return the diff preview
+func (dc *DNSController) ImportZone(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	domainID, err := strconv.Atoi(c.Param("domainId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid domain ID",
+		})
+		return
+	}
+
+	ctx := context.Background()
+
+	// Verify ownership
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Domain not found",
+		})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", services.ZoneImportMerge)
+	if mode != services.ZoneImportMerge && mode != services.ZoneImportReplace {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Mode must be merge or replace",
+		})
+		return
+	}
+
+	data, _, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to read zone file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	parsed, err := services.ParseZoneFile(bytes.NewReader(data), domain.DomainName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to parse zone file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	records, err := models.GetDNSRecordsByDomainID(ctx, domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS records",
+		})
+		return
+	}
+	subdomains, err := models.GetSubdomainsByDomainID(ctx, domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch subdomains",
+		})
+		return
+	}
+	diff := services.DiffZone(records, parsed.Records, mode, subdomains)
+
+	dryRun := c.Query("dry_run") == "true" || c.Query("dry_run") == "1"
+	if dryRun || len(parsed.Invalid) > 0 || len(diff.Conflicts) > 0 {
+		status := http.StatusOK
+		message := "Zone import preview"
+		if len(parsed.Invalid) > 0 || len(diff.Conflicts) > 0 {
+			status, message = http.StatusUnprocessableEntity, "Zone file has records that cannot be imported"
+		}
+		c.JSON(status, gin.H{
+			"success": status == http.StatusOK,
+			"message": message,
+			"applied": false,
+			"diff":    diff,
+			"skipped": parsed.Skipped,
+			"invalid": parsed.Invalid,
+		})
+		return
+	}
+
+	if !diff.Empty() {
+		if err := services.ApplyZoneDiff(ctx, dnsChangeActor(c, models.DNSChangeSourceImport), domainID, diff); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to import zone",
+				"error":   err.Error(),
+			})
+			return
+		}
+		syncZone(ctx, dc.dns, domainID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Zone imported successfully",
+		"applied": true,
+		"diff":    diff,
+		"skipped": parsed.Skipped,
 	})
 }
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RollbackZoneRequest represents the zone rollback request. Either
+// change_id (keep that change and everything before it) or at (a point in
+// time) is set
+type RollbackZoneRequest struct {
+	ChangeID *int64    `json:"change_id"`
+	At       time.Time `json:"at"`
+}
+
+// GetDNSHistory returns the changelog of a zone, newest first. Query params:
+// limit (default 50, max 200) and before (the ID of the last entry of the
+// previous page)
+func (dc *DNSController) GetDNSHistory(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "limit must be between 1 and 200",
+			})
+			return
+		}
+		limit = n
+	}
+	var before int64
+	if v := c.Query("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid before change ID",
+			})
+			return
+		}
+		before = n
+	}
+
+	changes, err := models.GetDNSRecordChanges(context.Background(), domain.ID, before, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS history",
+		})
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"domain":  domain.DomainName,
+		"changes": changes,
+	}
+	if len(changes) == limit {
+		response["next_before"] = changes[len(changes)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RollbackZone restores the records of a zone to an earlier point of its
+// changelog in one transaction. The rollback is logged as new changes, so
+// it can be rolled back itself. Query param dry_run=true only returns the
+// plan
+func (dc *DNSController) RollbackZone(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	var req RollbackZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if (req.ChangeID == nil) == req.At.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Either change_id or at is required",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	var toChangeID int64
+	if req.ChangeID != nil {
+		if _, err := models.GetDNSRecordChange(ctx, domain.ID, *req.ChangeID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Change not found",
+			})
+			return
+		}
+		toChangeID = *req.ChangeID
+	} else {
+		if req.At.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "at must not be in the future",
+			})
+			return
+		}
+		id, err := models.LastDNSRecordChangeIDAt(ctx, domain.ID, req.At)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to fetch DNS history",
+			})
+			return
+		}
+		toChangeID = id
+	}
+
+	dryRun := c.Query("dry_run") == "true" || c.Query("dry_run") == "1"
+	actor := dnsChangeActor(c, models.DNSChangeSourceRollback)
+	diff, err := services.RollbackZone(ctx, actor, domain.ID, toChangeID, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to roll back zone",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	applied := !dryRun && !diff.Empty()
+	message := "Rollback preview"
+	switch {
+	case applied:
+		syncZone(ctx, dc.dns, domain.ID)
+		message = "Zone rolled back successfully"
+	case diff.Empty():
+		message = "Zone already matches that point in its history"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"message":      message,
+		"applied":      applied,
+		"to_change_id": toChangeID,
+		"diff":         diff,
+	})
+}
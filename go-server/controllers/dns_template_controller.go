@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DNSTemplateRequest represents the create/update DNS template request
+type DNSTemplateRequest struct {
+	Name        string                     `json:"name" binding:"required"`
+	Description string                     `json:"description"`
+	Records     []models.DNSTemplateRecord `json:"records" binding:"required"`
+}
+
+// ApplyDNSTemplateRequest represents the apply DNS template request. Either
+// preset (a shipped template slug) or template_id (a user template) is set
+type ApplyDNSTemplateRequest struct {
+	Preset     string            `json:"preset"`
+	TemplateID int               `json:"template_id"`
+	Variables  map[string]string `json:"variables"`
+	// ReplaceConflicting removes stored records that clash with the
+	// template, such as the MX records of a previous mail provider
+	ReplaceConflicting bool `json:"replace_conflicting"`
+}
+
+// loadDNSTemplate parses the template ID route param and loads the user's
+// template, writing the error response itself when it returns false
+func loadDNSTemplate(c *gin.Context) (*models.DNSTemplate, bool) {
+	templateID, err := strconv.Atoi(c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid template ID",
+		})
+		return nil, false
+	}
+
+	template, err := models.GetDNSTemplateByID(context.Background(), templateID, middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Template not found",
+		})
+		return nil, false
+	}
+	return template, true
+}
+
+// bindDNSTemplate reads and validates a template request, writing the error
+// response itself when it returns false
+func bindDNSTemplate(c *gin.Context, currentID int) (*DNSTemplateRequest, bool) {
+	var req DNSTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Template name must be 1 to 100 characters",
+		})
+		return nil, false
+	}
+	if err := services.ValidateDNSTemplate(req.Records); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid template records",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	templates, err := models.GetDNSTemplatesByUserID(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS templates",
+		})
+		return nil, false
+	}
+	for _, t := range templates {
+		if t.ID != currentID && strings.EqualFold(t.Name, req.Name) {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": services.ErrDNSTemplateExists.Error(),
+			})
+			return nil, false
+		}
+	}
+	return &req, true
+}
+
+// templateResponse adds the custom variables a user template needs
+func templateResponse(t *models.DNSTemplate) gin.H {
+	return gin.H{
+		"id":          t.ID,
+		"name":        t.Name,
+		"description": t.Description,
+		"variables":   services.TemplateVariables(t.Records),
+		"records":     t.Records,
+		"created_at":  t.CreatedAt,
+		"updated_at":  t.UpdatedAt,
+	}
+}
+
+// GetDNSTemplates lists the shipped presets and the user's own templates
+func (dc *DNSController) GetDNSTemplates(c *gin.Context) {
+	templates, err := models.GetDNSTemplatesByUserID(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS templates",
+		})
+		return
+	}
+
+	userTemplates := make([]gin.H, 0, len(templates))
+	for i := range templates {
+		userTemplates = append(userTemplates, templateResponse(&templates[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"presets":   services.DNSTemplatePresets(),
+		"templates": userTemplates,
+	})
+}
+
+// GetDNSTemplate returns one of the user's templates
+func (dc *DNSController) GetDNSTemplate(c *gin.Context) {
+	template, ok := loadDNSTemplate(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"template": templateResponse(template),
+	})
+}
+
+// CreateDNSTemplate stores a user template. Names and values may use
+// {domain}, {domain_dashed}, {server_ip} and custom {placeholders} that are
+// asked for when the template is applied
+func (dc *DNSController) CreateDNSTemplate(c *gin.Context) {
+	req, ok := bindDNSTemplate(c, 0)
+	if !ok {
+		return
+	}
+
+	template, err := models.CreateDNSTemplate(context.Background(), middleware.GetUserID(c), req.Name, req.Description, req.Records)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create DNS template",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"message":  "DNS template created successfully",
+		"template": templateResponse(template),
+	})
+}
+
+// UpdateDNSTemplate replaces one of the user's templates
+func (dc *DNSController) UpdateDNSTemplate(c *gin.Context) {
+	current, ok := loadDNSTemplate(c)
+	if !ok {
+		return
+	}
+	req, ok := bindDNSTemplate(c, current.ID)
+	if !ok {
+		return
+	}
+
+	template, err := models.UpdateDNSTemplate(context.Background(), current.ID, current.UserID, req.Name, req.Description, req.Records)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update DNS template",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "DNS template updated successfully",
+		"template": templateResponse(template),
+	})
+}
+
+// DeleteDNSTemplate deletes one of the user's templates
+func (dc *DNSController) DeleteDNSTemplate(c *gin.Context) {
+	template, ok := loadDNSTemplate(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteDNSTemplate(context.Background(), template.ID, template.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete DNS template",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNS template deleted successfully",
+	})
+}
+
+// ApplyDNSTemplate adds the records of a template to a domain. Records the
+// domain already has are skipped; stored records that clash with the
+// template are reported as conflicts unless replace_conflicting is set.
+// Query param dry_run=true only returns the preview
+func (dc *DNSController) ApplyDNSTemplate(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	var req ApplyDNSTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if (req.Preset == "") == (req.TemplateID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Either preset or template_id is required",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	templateRecords, err := services.ResolveDNSTemplate(ctx, domain.UserID, req.Preset, req.TemplateID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Template not found",
+		})
+		return
+	}
+
+	rendered, err := services.RenderDNSTemplate(templateRecords, domain.DomainName, getServerIP(), req.Variables)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to render template",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	records, err := models.GetDNSRecordsByDomainID(ctx, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS records",
+		})
+		return
+	}
+	diff := services.DiffDNSTemplate(records, rendered, req.ReplaceConflicting)
+
+	dryRun := c.Query("dry_run") == "true" || c.Query("dry_run") == "1"
+	if dryRun || len(diff.Conflicts) > 0 {
+		status := http.StatusOK
+		message := "Template preview"
+		if len(diff.Conflicts) > 0 {
+			status = http.StatusConflict
+			message = "Template conflicts with existing records; set replace_conflicting to remove them"
+		}
+		c.JSON(status, gin.H{
+			"success": status == http.StatusOK,
+			"message": message,
+			"applied": false,
+			"diff":    diff,
+		})
+		return
+	}
+
+	if !diff.Empty() {
+		if err := services.ApplyZoneDiff(ctx, dnsChangeActor(c, models.DNSChangeSourceTemplate), domain.ID, diff); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to apply template",
+				"error":   err.Error(),
+			})
+			return
+		}
+		syncZone(ctx, dc.dns, domain.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Template applied successfully",
+		"applied": true,
+		"diff":    diff,
+	})
+}
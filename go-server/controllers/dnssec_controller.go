@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dnssecErrorStatus maps DNSSEC service errors to HTTP status codes
+func dnssecErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDNSSECEnabled),
+		errors.Is(err, services.ErrRolloverInProgress):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrDNSSECDisabled),
+		errors.Is(err, services.ErrNoKSKRollover):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// GetDNSSEC returns the DNSSEC state of a domain's zone with its keys and
+// the DS records to publish at the registrar
+func (dc *DNSController) GetDNSSEC(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	status, err := dc.dnssec.Status(context.Background(), domain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load DNSSEC status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"dnssec":  status,
+	})
+}
+
+// EnableDNSSEC generates a KSK and a ZSK and starts signing the zone. The
+// returned DS records must be added at the registrar to complete the chain
+// of trust
+func (dc *DNSController) EnableDNSSEC(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := dc.dnssec.Enable(ctx, domain); err != nil {
+		c.JSON(dnssecErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to enable DNSSEC",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	status, err := dc.dnssec.Status(ctx, domain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "DNSSEC enabled but failed to load its status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNSSEC enabled. Add the DS record at your registrar to activate the chain of trust",
+		"dnssec":  status,
+	})
+}
+
+// DisableDNSSEC stops signing the zone and deletes its keys. The DS record
+// has to be removed at the registrar first, otherwise validating resolvers
+// will reject the zone
+func (dc *DNSController) DisableDNSSEC(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := dc.dnssec.Disable(ctx, domain); err != nil {
+		c.JSON(dnssecErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to disable DNSSEC",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNSSEC disabled. Make sure the DS record is removed at your registrar",
+	})
+}
+
+// DNSSECRolloverRequest represents the start rollover request
+type DNSSECRolloverRequest struct {
+	KeyType string `json:"key_type" binding:"required"`
+}
+
+// StartDNSSECRollover replaces the ZSK or KSK of a zone. A ZSK rollover
+// completes by itself; a KSK rollover needs the new DS record at the
+// registrar and a call to CompleteDNSSECRollover
+func (dc *DNSController) StartDNSSECRollover(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	var req DNSSECRolloverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+	keyType := strings.ToUpper(req.KeyType)
+	if keyType != models.DNSSECKeyKSK && keyType != models.DNSSECKeyZSK {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "key_type must be KSK or ZSK",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	key, err := dc.dnssec.StartRollover(ctx, domain, keyType)
+	if err != nil {
+		c.JSON(dnssecErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to start key rollover",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	message := "ZSK rollover started; the new key signs once it has propagated"
+	if keyType == models.DNSSECKeyKSK {
+		message = "KSK rollover started. Add the new DS record at your registrar, then complete the rollover"
+	}
+	status, err := dc.dnssec.Status(ctx, domain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Rollover started but failed to load the DNSSEC status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"key":     key,
+		"dnssec":  status,
+	})
+}
+
+// CompleteDNSSECRollover retires the old KSK once the DS record of the new
+// one is published at the registrar
+func (dc *DNSController) CompleteDNSSECRollover(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "domainId")
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := dc.dnssec.CompleteKSKRollover(ctx, domain); err != nil {
+		c.JSON(dnssecErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to complete KSK rollover",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "KSK rollover completed. The old DS record can be removed at your registrar",
+	})
+}
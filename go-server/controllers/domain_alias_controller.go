@@ -0,0 +1,480 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloudku-server/database"
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadOwnedDomain parses the domain ID from the given route param and loads
+// the domain, writing the error response itself when it returns false
+func loadOwnedDomain(c *gin.Context, param string) (*models.Domain, bool) {
+	userID := middleware.GetUserID(c)
+	domainID, err := strconv.Atoi(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid domain ID",
+		})
+		return nil, false
+	}
+
+	domain, err := models.GetDomainByID(context.Background(), domainID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Domain not found",
+		})
+		return nil, false
+	}
+
+	return domain, true
+}
+
+// loadDomainAlias parses the alias ID route param and loads the alias of the
+// given domain, writing the error response itself when it returns false
+func loadDomainAlias(c *gin.Context, domainID int) (*models.DomainAlias, bool) {
+	aliasID, err := strconv.Atoi(c.Param("aliasId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid alias ID",
+		})
+		return nil, false
+	}
+
+	alias, err := models.GetAliasByID(context.Background(), aliasID, domainID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Alias not found",
+		})
+		return nil, false
+	}
+
+	return alias, true
+}
+
+// GetAliases returns all aliases of a domain
+func (dc *DomainController) GetAliases(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	aliases, err := models.GetAliasesByDomainID(context.Background(), domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch aliases",
+		})
+		return
+	}
+
+	if aliases == nil {
+		aliases = []models.DomainAlias{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"aliases": aliases,
+	})
+}
+
+// CreateAliasRequest represents the create alias request
+type CreateAliasRequest struct {
+	AliasName    string `json:"alias_name" binding:"required"`
+	IncludeInSSL *bool  `json:"include_in_ssl"`
+	CreateDNS    *bool  `json:"create_dns"`
+}
+
+// CreateAlias attaches an alias (parked domain) to a domain
+func (dc *DomainController) CreateAlias(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	var req CreateAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Alias name is required",
+		})
+		return
+	}
+
+	aliasName := strings.ToLower(strings.TrimSpace(req.AliasName))
+	if !domainNameRegex.MatchString(aliasName) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid alias name format",
+		})
+		return
+	}
+
+	ctx := context.Background()
+
+	exists, _ := models.DomainExists(ctx, aliasName)
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain or alias already exists",
+		})
+		return
+	}
+
+	// An alias under a registered domain would take over part of its zone
+	if parent, err := models.FindParentDomain(ctx, aliasName); err == nil {
+		message := "Alias belongs to an existing zone"
+		if parent.UserID == domain.UserID {
+			message = "Alias is under " + parent.DomainName + ". Add it as a subdomain instead"
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+
+	includeInSSL := true
+	if req.IncludeInSSL != nil {
+		includeInSSL = *req.IncludeInSSL
+	}
+
+	// Seed the alias zone with default records unless explicitly disabled
+	serverIP := getServerIP()
+	if req.CreateDNS != nil && !*req.CreateDNS {
+		serverIP = ""
+	}
+
+	alias, err := models.CreateAlias(ctx, domain.ID, aliasName, includeInSSL, serverIP)
+	if errors.Is(err, models.ErrHostnameTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain or alias already exists",
+		})
+		return
+	}
+	if errors.Is(err, models.ErrHostnameNested) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Alias overlaps an existing domain or alias",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create alias",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Alias " + alias.AliasName + " added to " + domain.DomainName,
+		"alias":   alias,
+	})
+}
+
+// UpdateAliasRequest represents the update alias request
+type UpdateAliasRequest struct {
+	IncludeInSSL *bool `json:"include_in_ssl"`
+}
+
+// UpdateAlias updates alias settings
+func (dc *DomainController) UpdateAlias(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	var req UpdateAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.IncludeInSSL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "No fields to update",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	if err := models.UpdateAliasSSL(ctx, alias.ID, domain.ID, *req.IncludeInSSL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update alias",
+		})
+		return
+	}
+	changed := alias.IncludeInSSL != *req.IncludeInSSL
+	alias.IncludeInSSL = *req.IncludeInSSL
+	if changed {
+		dc.syncVhost(ctx, domain.ID, domain.UserID)
+	}
+
+	// The installed certificate keeps its SANs until it is issued again
+	reissueRequired := changed && domain.SSLEnabled
+	message := "Alias updated successfully"
+	if reissueRequired {
+		message = "Alias updated. The certificate covers the change once it is renewed; renew it now to apply it immediately"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":              true,
+		"message":              message,
+		"alias":                alias,
+		"ssl_reissue_required": reissueRequired,
+	})
+}
+
+// DeleteAlias detaches an alias from a domain
+func (dc *DomainController) DeleteAlias(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	aliasName, err := models.DeleteAlias(ctx, alias.ID, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Alias not found",
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+	if err := dc.dns.RemoveZone(ctx, aliasName); err != nil {
+		log.Printf("WARN: Failed to remove PowerDNS zone %s: %v", aliasName, err)
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Alias " + aliasName + " deleted successfully",
+	})
+}
+
+// GetAliasDNSRecords returns the DNS records of an alias zone
+func (dc *DomainController) GetAliasDNSRecords(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	records, err := models.GetAliasDNSRecords(context.Background(), alias.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS records",
+		})
+		return
+	}
+
+	recordsResponse := make([]models.DNSRecordResponse, len(records))
+	for i, r := range records {
+		recordsResponse[i] = r.ToResponse()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"records": recordsResponse,
+	})
+}
+
+// loadAliasDNSRecord parses the record ID route param and loads the record
+// of the alias zone, writing the error response itself when it returns false
+func loadAliasDNSRecord(c *gin.Context, aliasID int) (*models.DNSRecord, bool) {
+	recordID, err := strconv.Atoi(c.Param("recordId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid record ID",
+		})
+		return nil, false
+	}
+
+	record, err := models.GetAliasDNSRecordByID(context.Background(), recordID, aliasID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "DNS record not found",
+		})
+		return nil, false
+	}
+
+	return record, true
+}
+
+// validateAliasDNSRecordRequest binds and validates a record of an alias
+// zone against the other records of that zone, skipping exceptID. It writes
+// the error response itself when it returns false
+func validateAliasDNSRecordRequest(c *gin.Context, alias *models.DomainAlias, exceptID int) (*CreateDNSRecordRequest, bool) {
+	var req CreateDNSRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Record type, name, and value are required",
+		})
+		return nil, false
+	}
+
+	records, err := models.GetAliasDNSRecords(context.Background(), alias.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS records",
+		})
+		return nil, false
+	}
+	in, ok := validateZoneRecordRequest(c, alias.AliasName, records, &req, exceptID)
+	if !ok {
+		return nil, false
+	}
+
+	req.RecordType, req.Name, req.Value, req.TTL, req.Priority = in.RecordType, in.Name, in.Value, in.TTL, in.Priority
+	return &req, true
+}
+
+// CreateAliasDNSRecord adds a record to an alias zone
+func (dc *DomainController) CreateAliasDNSRecord(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+	in, ok := validateAliasDNSRecordRequest(c, alias, 0)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	var record *models.DNSRecord
+	err := models.UpdateZone(ctx, domain.ID, func(q database.Querier) error {
+		var err error
+		record, err = models.InsertAliasDNSRecord(ctx, q, alias, in.RecordType, in.Name, in.Value, in.TTL, in.Priority)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create DNS record",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "DNS record created successfully",
+		"record":  record.ToResponse(),
+	})
+}
+
+// UpdateAliasDNSRecord replaces a record of an alias zone
+func (dc *DomainController) UpdateAliasDNSRecord(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+	existing, ok := loadAliasDNSRecord(c, alias.ID)
+	if !ok {
+		return
+	}
+	in, ok := validateAliasDNSRecordRequest(c, alias, existing.ID)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	var record *models.DNSRecord
+	err := models.UpdateZone(ctx, domain.ID, func(q database.Querier) error {
+		var err error
+		record, err = models.UpdateAliasDNSRecord(ctx, q, existing.ID, alias.ID, in.RecordType, in.Name, in.Value, in.TTL, in.Priority)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update DNS record",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNS record updated successfully",
+		"record":  record.ToResponse(),
+	})
+}
+
+// DeleteAliasDNSRecord deletes a record of an alias zone
+func (dc *DomainController) DeleteAliasDNSRecord(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	alias, ok := loadDomainAlias(c, domain.ID)
+	if !ok {
+		return
+	}
+	record, ok := loadAliasDNSRecord(c, alias.ID)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	err := models.UpdateZone(ctx, domain.ID, func(q database.Querier) error {
+		return models.DeleteAliasDNSRecord(ctx, q, record.ID, alias.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "DNS record not found",
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNS record deleted successfully",
+	})
+}
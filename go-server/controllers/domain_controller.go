@@ -2,25 +2,67 @@ package controllers
 
 import (
 	"context"
-	"net"
+	"errors"
+	"log"
 	"net/http"
-	"os"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"cloudku-server/config"
+	"cloudku-server/database"
 	"cloudku-server/middleware"
 	"cloudku-server/models"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
 
+// domainNameRegex validates lowercase fully-qualified domain names
+var domainNameRegex = services.DomainNameRegex
+
 // DomainController handles domain management endpoints
-type DomainController struct{}
+type DomainController struct {
+	vhost     *services.VhostService
+	verifier  *services.DomainVerificationService
+	transfers *services.DomainTransferService
+	imports   *services.DomainImportService
+	dns       *services.PowerDNSSyncService
+	dyndns    *services.DynDNSService
+}
 
 // NewDomainController creates a new domain controller
-func NewDomainController() *DomainController {
-	return &DomainController{}
+func NewDomainController(vhost *services.VhostService, dns *services.PowerDNSSyncService) *DomainController {
+	return &DomainController{
+		vhost:     vhost,
+		dns:       dns,
+		verifier:  services.NewDomainVerificationService(),
+		transfers: services.NewDomainTransferService(),
+		imports:   services.NewDomainImportService(getServerIP()),
+		dyndns:    services.NewDynDNSService(),
+	}
+}
+
+// syncVhost re-provisions the web server config of a domain. Failures are
+// logged rather than returned: the database change has already been made and
+// the vhost can be rebuilt later via /domains/:id/vhost/rebuild
+func (dc *DomainController) syncVhost(ctx context.Context, domainID, userID int) {
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	if err != nil {
+		return
+	}
+	if err := dc.vhost.SyncDomain(ctx, domain); err != nil {
+		log.Printf("WARN: Failed to provision vhost for %s: %v", domain.DomainName, err)
+	}
+}
+
+// getServerIP returns the public IP new DNS records should point to
+func getServerIP() string {
+	serverIP := config.AppConfig.ServerIP
+	if serverIP == "" {
+		serverIP = "0.0.0.0"
+	}
+	return serverIP
 }
 
 // GetDomains returns all domains for the authenticated user
@@ -38,9 +80,20 @@ func (dc *DomainController) GetDomains(c *gin.Context) {
 		return
 	}
 
+	// Subdomains are listed alongside their parent domain
+	subdomains, err := models.GetSubdomainsByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Failed to fetch subdomains for user %d: %v", userID, err)
+	}
+	subdomainsByDomain := make(map[int][]models.Subdomain)
+	for _, s := range subdomains {
+		subdomainsByDomain[s.DomainID] = append(subdomainsByDomain[s.DomainID], s)
+	}
+
 	// Convert to response format
 	domainsResponse := make([]models.DomainResponse, len(domains))
 	for i, d := range domains {
+		d.Subdomains = subdomainsByDomain[d.ID]
 		domainsResponse[i] = d.ToResponse()
 	}
 
@@ -73,6 +126,8 @@ func (dc *DomainController) GetDomain(c *gin.Context) {
 		return
 	}
 
+	domain.Subdomains, _ = models.GetSubdomainsByDomainID(ctx, domain.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"domain":  domain.ToResponse(),
@@ -99,9 +154,8 @@ func (dc *DomainController) CreateDomain(c *gin.Context) {
 	}
 
 	// Validate domain name format
-	domainRegex := regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9][a-z0-9-]{0,61}[a-z0-9]$`)
 	domainName := strings.ToLower(req.DomainName)
-	if !domainRegex.MatchString(domainName) {
+	if !domainNameRegex.MatchString(domainName) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "Invalid domain name format",
@@ -121,6 +175,20 @@ func (dc *DomainController) CreateDomain(c *gin.Context) {
 		return
 	}
 
+	// Hosts under an existing domain must be added as subdomains, otherwise
+	// they would create a second, conflicting zone
+	if parent, err := models.FindParentDomain(ctx, domainName); err == nil {
+		message := "Domain belongs to an existing zone"
+		if parent.UserID == userID {
+			message = "Domain is under " + parent.DomainName + ". Add it as a subdomain instead"
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+
 	// Set default document root
 	documentRoot := req.DocumentRoot
 	if documentRoot == "" {
@@ -129,6 +197,13 @@ func (dc *DomainController) CreateDomain(c *gin.Context) {
 
 	// Create domain
 	domain, err := models.CreateDomain(ctx, userID, domainName, documentRoot)
+	if errors.Is(err, models.ErrHostnameTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain already exists",
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -138,12 +213,21 @@ func (dc *DomainController) CreateDomain(c *gin.Context) {
 		return
 	}
 
-	// Create default DNS records
-	serverIP := os.Getenv("SERVER_IP")
-	if serverIP == "" {
-		serverIP = "0.0.0.0"
+	// Create the records of the default DNS template
+	if err := services.CreateDefaultDNSRecords(ctx, dnsChangeActor(c, models.DNSChangeSourceDomain), domain.ID, domainName, getServerIP()); err != nil {
+		log.Printf("WARN: Failed to create default DNS records for %s: %v", domainName, err)
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	if err := prepareDocumentRoot(userID, documentRoot); err != nil {
+		log.Printf("WARN: Failed to create document root for %s: %v", domainName, err)
+	}
+	dc.syncVhost(ctx, domain.ID, userID)
+
+	// Issue the ownership token up front so TXT instructions can be shown
+	if _, err := dc.verifier.EnsureToken(ctx, domain); err != nil {
+		log.Printf("WARN: Failed to generate verification token for %s: %v", domainName, err)
 	}
-	models.CreateDefaultDNSRecords(ctx, domain.ID, domainName, serverIP)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
@@ -156,8 +240,8 @@ func (dc *DomainController) CreateDomain(c *gin.Context) {
 type UpdateDomainRequest struct {
 	DocumentRoot string `json:"document_root"`
 	Status       string `json:"status"`
-	SSLEnabled   *bool  `json:"ssl_enabled"`
 	AutoRenewSSL *bool  `json:"auto_renew_ssl"`
+	ForceHTTPS   *bool  `json:"force_https"`
 }
 
 // UpdateDomain updates a domain
@@ -185,7 +269,7 @@ func (dc *DomainController) UpdateDomain(c *gin.Context) {
 	ctx := context.Background()
 
 	// Verify ownership
-	_, err = models.GetDomainByID(ctx, id, userID)
+	existing, err := models.GetDomainByID(ctx, id, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -194,22 +278,51 @@ func (dc *DomainController) UpdateDomain(c *gin.Context) {
 		return
 	}
 
+	// Enforcing HTTPS can turn a redirect to an http:// URL into a loop
+	if req.ForceHTTPS != nil && *req.ForceHTTPS && !existing.ForceHTTPS {
+		redirects, err := models.GetRedirectsByDomainID(ctx, id)
+		if err == nil {
+			existing.ForceHTTPS = true
+			err = services.ValidateDomainRedirects(ctx, existing, redirects)
+		}
+		if err != nil {
+			respondRedirectValidationError(c, err)
+			return
+		}
+	}
+
 	// Build updates map
 	updates := make(map[string]interface{})
 	if req.DocumentRoot != "" {
+		if err := prepareDocumentRoot(userID, req.DocumentRoot); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid document root",
+				"error":   err.Error(),
+			})
+			return
+		}
 		updates["document_root"] = req.DocumentRoot
 	}
-	if req.Status != "" {
-		updates["status"] = req.Status
-	}
-	if req.SSLEnabled != nil {
-		updates["ssl_enabled"] = *req.SSLEnabled
-	}
 	if req.AutoRenewSSL != nil {
 		updates["auto_renew_ssl"] = *req.AutoRenewSSL
 	}
+	if req.ForceHTTPS != nil {
+		updates["force_https"] = *req.ForceHTTPS
+	}
+
+	// Owners may only toggle maintenance; other status changes go through
+	// verification, the monitor or an administrator
+	statusChange := req.Status != "" && req.Status != existing.Status
+	if statusChange && !models.CanUserTransitionDomainStatus(existing.Status, req.Status) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Cannot change domain status from " + existing.Status + " to " + req.Status,
+		})
+		return
+	}
 
-	if len(updates) == 0 {
+	if len(updates) == 0 && !statusChange {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "No fields to update",
@@ -217,16 +330,35 @@ func (dc *DomainController) UpdateDomain(c *gin.Context) {
 		return
 	}
 
-	domain, err := models.UpdateDomain(ctx, id, userID, updates)
+	if len(updates) > 0 {
+		if _, err := models.UpdateDomain(ctx, id, userID, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to update domain",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if statusChange {
+		if _, err := models.ChangeDomainStatus(ctx, id, req.Status, "", &userID); err != nil {
+			respondStatusChangeError(c, err)
+			return
+		}
+	}
+
+	domain, err := models.GetDomainByID(ctx, id, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"message": "Failed to update domain",
-			"error":   err.Error(),
 		})
 		return
 	}
 
+	dc.syncVhost(ctx, domain.ID, userID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Domain updated successfully",
@@ -248,6 +380,8 @@ func (dc *DomainController) DeleteDomain(c *gin.Context) {
 	}
 
 	ctx := context.Background()
+	// Alias zones are removed with the domain, so note them before the cascade
+	aliases, _ := models.GetAliasesByDomainID(ctx, id)
 	domainName, err := models.DeleteDomain(ctx, id, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -257,6 +391,18 @@ func (dc *DomainController) DeleteDomain(c *gin.Context) {
 		return
 	}
 
+	if err := dc.vhost.RemoveDomain(ctx, domainName); err != nil {
+		log.Printf("WARN: Failed to remove vhost for %s: %v", domainName, err)
+	}
+	if err := dc.dns.RemoveZone(ctx, domainName); err != nil {
+		log.Printf("WARN: Failed to remove PowerDNS zone %s: %v", domainName, err)
+	}
+	for _, a := range aliases {
+		if err := dc.dns.RemoveZone(ctx, a.AliasName); err != nil {
+			log.Printf("WARN: Failed to remove PowerDNS zone %s: %v", a.AliasName, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Domain " + domainName + " deleted successfully",
@@ -343,7 +489,7 @@ func (dc *DomainController) CreateDNSRecord(c *gin.Context) {
 	ctx := context.Background()
 
 	// Verify ownership
-	_, err = models.GetDomainByID(ctx, domainID, userID)
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -352,13 +498,12 @@ func (dc *DomainController) CreateDNSRecord(c *gin.Context) {
 		return
 	}
 
-	// Default TTL
-	ttl := req.TTL
-	if ttl == 0 {
-		ttl = 3600
+	in, ok := validateDNSRecordRequest(c, domain, &req, 0)
+	if !ok {
+		return
 	}
 
-	record, err := models.CreateDNSRecord(ctx, domainID, req.RecordType, req.Name, req.Value, ttl, req.Priority)
+	record, err := models.CreateDNSRecord(ctx, dnsChangeActor(c, models.DNSChangeSourceAPI), domainID, in.RecordType, in.Name, in.Value, in.TTL, in.Priority)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -367,6 +512,7 @@ func (dc *DomainController) CreateDNSRecord(c *gin.Context) {
 		})
 		return
 	}
+	syncZone(ctx, dc.dns, domainID)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
@@ -375,6 +521,117 @@ func (dc *DomainController) CreateDNSRecord(c *gin.Context) {
 	})
 }
 
+// validateDNSRecordRequest normalizes a submitted record and validates it
+// against its type and the other records of the zone, skipping the record
+// with ID exceptID. It writes the error response itself when it returns false
+func validateDNSRecordRequest(c *gin.Context, domain *models.Domain, req *CreateDNSRecordRequest, exceptID int) (*services.DNSRecordInput, bool) {
+	records, err := models.GetDNSRecordsByDomainID(context.Background(), domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch DNS records",
+		})
+		return nil, false
+	}
+	return validateZoneRecordRequest(c, domain.DomainName, records, req, exceptID)
+}
+
+// validateZoneRecordRequest is validateDNSRecordRequest for any zone, given
+// its name and records
+func validateZoneRecordRequest(c *gin.Context, zone string, records []models.DNSRecord, req *CreateDNSRecordRequest, exceptID int) (*services.DNSRecordInput, bool) {
+	in := &services.DNSRecordInput{
+		RecordType: req.RecordType,
+		Name:       req.Name,
+		Value:      req.Value,
+		TTL:        req.TTL,
+		Priority:   req.Priority,
+	}
+	services.NormalizeDNSRecord(in, zone)
+
+	others := records[:0]
+	for _, r := range records {
+		if r.ID != exceptID {
+			others = append(others, r)
+		}
+	}
+
+	if err := services.ValidateDNSRecord(in, others); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrDuplicateDNSRecord) || errors.Is(err, services.ErrCNAMEConflict) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Invalid DNS record",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	return in, true
+}
+
+// UpdateDNSRecord replaces a DNS record
+func (dc *DomainController) UpdateDNSRecord(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	recordID, err := strconv.Atoi(c.Param("recordId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid record ID",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := models.GetDNSRecordByID(ctx, recordID, domain.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "DNS record not found",
+		})
+		return
+	}
+
+	var req CreateDNSRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Record type, name, and value are required",
+		})
+		return
+	}
+
+	in, ok := validateDNSRecordRequest(c, domain, &req, recordID)
+	if !ok {
+		return
+	}
+
+	var record *models.DNSRecord
+	err = models.UpdateZone(ctx, domain.ID, func(q database.Querier) error {
+		record, err = models.UpdateDNSRecord(ctx, q, dnsChangeActor(c, models.DNSChangeSourceAPI), recordID, domain.ID, in.RecordType, in.Name, in.Value, in.TTL, in.Priority)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update DNS record",
+			"error":   err.Error(),
+		})
+		return
+	}
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "DNS record updated successfully",
+		"record":  record.ToResponse(),
+	})
+}
+
 // DeleteDNSRecord deletes a DNS record
 func (dc *DomainController) DeleteDNSRecord(c *gin.Context) {
 	userID := middleware.GetUserID(c)
@@ -411,7 +668,9 @@ func (dc *DomainController) DeleteDNSRecord(c *gin.Context) {
 		return
 	}
 
-	err = models.DeleteDNSRecord(ctx, recordID, domainID)
+	err = models.UpdateZone(ctx, domainID, func(q database.Querier) error {
+		return models.DeleteDNSRecord(ctx, q, dnsChangeActor(c, models.DNSChangeSourceAPI), recordID, domainID)
+	})
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -419,6 +678,7 @@ func (dc *DomainController) DeleteDNSRecord(c *gin.Context) {
 		})
 		return
 	}
+	syncZone(ctx, dc.dns, domainID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -426,72 +686,219 @@ func (dc *DomainController) DeleteDNSRecord(c *gin.Context) {
 	})
 }
 
-// VerifyDomain verifies domain DNS is pointing to server
+// VerifyDomainRequest represents the verify domain request
+type VerifyDomainRequest struct {
+	// Method is one of txt, http, dns or auto (default)
+	Method string `json:"method"`
+}
+
+// VerifyDomain verifies domain ownership using the requested method
 func (dc *DomainController) VerifyDomain(c *gin.Context) {
-	userID := middleware.GetUserID(c)
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	// The body is optional; an empty request tries every method
+	var req VerifyDomainRequest
+	_ = c.ShouldBindJSON(&req)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := dc.verifier.Verify(ctx, domain, strings.ToLower(req.Method))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUnknownVerifyMethod) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
 			"success": false,
-			"message": "Invalid domain ID",
+			"message": "Verification failed",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	ctx := context.Background()
+	if !result.Verified {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"message":      "Verification failed. " + result.Message,
+			"verification": result,
+		})
+		return
+	}
 
-	domain, err := models.GetDomainByID(ctx, id, userID)
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"message":      "Domain verified successfully! " + result.Message,
+		"verification": result,
+	})
+}
+
+// GetVerification returns the verification token, instructions for each
+// method and recent verification attempts
+func (dc *DomainController) GetVerification(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	token, err := dc.verifier.EnsureToken(ctx, domain)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "Domain not found",
+			"message": "Failed to generate verification token",
 		})
 		return
 	}
 
-	expectedIP := os.Getenv("SERVER_IP")
-	if expectedIP == "" {
-		expectedIP = "127.0.0.1"
+	attempts, _ := models.GetVerificationAttempts(ctx, domain.ID, 20)
+	attemptsResponse := make([]models.DomainVerificationAttemptResponse, len(attempts))
+	for i, a := range attempts {
+		attemptsResponse[i] = a.ToResponse()
+	}
+
+	var verifiedAt *time.Time
+	if domain.VerifiedAt.Valid {
+		verifiedAt = &domain.VerifiedAt.Time
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"status":      domain.Status,
+		"verified_at": verifiedAt,
+		"token":       token,
+		"methods": gin.H{
+			services.VerifyMethodTXT: gin.H{
+				"record_type": "TXT",
+				"name":        services.VerifyTXTPrefix + "." + domain.DomainName,
+				"value":       token,
+			},
+			services.VerifyMethodHTTP: gin.H{
+				"url":     "http://" + domain.DomainName + services.VerifyHTTPPath,
+				"content": token,
+				"note":    "Upload this file to the site the domain currently points to",
+			},
+			services.VerifyMethodDNS: gin.H{
+				"record_types": []string{"A", "AAAA"},
+				"values":       dc.verifier.ServerIPs(),
+			},
+		},
+		"attempts": attemptsResponse,
+	})
+}
+
+// GetStatusHistory returns the status changes of a domain, newest first
+func (dc *DomainController) GetStatusHistory(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
 	}
 
-	// Perform DNS lookup
-	ips, err := net.LookupIP(domain.DomainName)
+	history, err := models.GetDomainStatusHistory(context.Background(), domain.ID, 100)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "Domain '" + domain.DomainName + "' could not be resolved. Please ensure DNS records are propagated.",
+			"message": "Failed to fetch status history",
 		})
 		return
 	}
 
-	// Check if any IP matches expected
-	var resolvedIPs []string
-	verified := false
-	for _, ip := range ips {
-		if ipv4 := ip.To4(); ipv4 != nil {
-			resolvedIPs = append(resolvedIPs, ipv4.String())
-			if ipv4.String() == expectedIP {
-				verified = true
-			}
-		}
+	if history == nil {
+		history = []models.DomainStatusChange{}
 	}
 
-	if verified {
-		// Update domain status
-		updates := map[string]interface{}{
-			"status": "active",
-		}
-		models.UpdateDomain(ctx, id, userID, updates)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  domain.Status,
+		"history": history,
+	})
+}
+
+// respondStatusChangeError writes the response for a failed status change
+func respondStatusChangeError(c *gin.Context, err error) {
+	if errors.Is(err, models.ErrInvalidStatusTransition) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Domain status cannot change that way",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"success": false,
+		"message": "Failed to change domain status",
+		"error":   err.Error(),
+	})
+}
+
+// MaintenanceRequest represents the maintenance mode request
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+// SetMaintenance puts a domain into maintenance, serving the maintenance
+// page instead of the site, or brings it back online
+func (dc *DomainController) SetMaintenance(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
 
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	target := models.DomainStatusActive
+	if req.Enabled {
+		target = models.DomainStatusMaintenance
+	}
+	if domain.Status == target {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"message": "Domain verified successfully! Pointing to " + expectedIP,
+			"message": "Domain is already " + target,
+			"domain":  domain.ToResponse(),
 		})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{
+		return
+	}
+	if !models.CanUserTransitionDomainStatus(domain.Status, target) {
+		c.JSON(http.StatusConflict, gin.H{
 			"success": false,
-			"message": "Verification failed. Domain points to: " + strings.Join(resolvedIPs, ", ") + ". Please update your DNS A Record to point to: " + expectedIP,
+			"message": "Cannot change domain status from " + domain.Status + " to " + target,
 		})
+		return
 	}
+
+	ctx := context.Background()
+	userID := middleware.GetUserID(c)
+	if _, err := models.ChangeDomainStatus(ctx, domain.ID, target, strings.TrimSpace(req.Reason), &userID); err != nil {
+		respondStatusChangeError(c, err)
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, userID)
+
+	updated, err := models.GetDomainByID(ctx, domain.ID, userID)
+	if err != nil {
+		updated = domain
+	}
+
+	message := "Domain is back online"
+	if req.Enabled {
+		message = "Maintenance mode enabled"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"domain":  updated.ToResponse(),
+	})
 }
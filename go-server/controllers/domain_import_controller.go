@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloudku-server/middleware"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDomainImportSize caps the size of an uploaded import file
+const maxDomainImportSize = 5 << 20
+
+// importFormat picks the import/export format from the format query param,
+// falling back to the file extension or content type
+func importFormat(c *gin.Context, filename string) string {
+	if format := strings.ToLower(c.Query("format")); format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return services.DomainImportCSV
+	case ".json":
+		return services.DomainImportJSON
+	}
+	if strings.Contains(c.ContentType(), "csv") {
+		return services.DomainImportCSV
+	}
+	return services.DomainImportJSON
+}
+
+// readImportFile returns the uploaded import file: a multipart "file" field
+// or the raw request body
+func readImportFile(c *gin.Context) ([]byte, string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDomainImportSize)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		return data, header.Filename, err
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	return data, "", err
+}
+
+// ImportDomains creates domains in bulk from a CSV or JSON file.
+// Query params: format (csv|json), mode (all_or_nothing|best_effort) and
+// dry_run=true to only validate
+func (dc *DomainController) ImportDomains(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	data, filename, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to read import file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	rows, err := services.ParseDomainImport(importFormat(c, filename), bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to parse import file",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx := context.Background()
+	dryRun := c.Query("dry_run") == "true" || c.Query("dry_run") == "1"
+	report, err := dc.imports.Import(ctx, userID, rows, c.Query("mode"), dryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidImport) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to import domains",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Same follow-up as CreateDomain, once the rows are committed
+	for i := range report.Domains {
+		domain := &report.Domains[i]
+		if err := prepareDocumentRoot(userID, domain.DocumentRoot); err != nil {
+			log.Printf("WARN: Failed to create document root for %s: %v", domain.DomainName, err)
+		}
+		dc.syncVhost(ctx, domain.ID, userID)
+		syncZone(ctx, dc.dns, domain.ID)
+		if _, err := dc.verifier.EnsureToken(ctx, domain); err != nil {
+			log.Printf("WARN: Failed to generate verification token for %s: %v", domain.DomainName, err)
+		}
+	}
+
+	status := http.StatusOK
+	message := "Import file is valid"
+	switch {
+	case dryRun && report.Failed > 0:
+		message = "Import file has errors"
+	case !dryRun && report.Created > 0 && report.Failed == 0:
+		status, message = http.StatusCreated, "Domains imported successfully"
+	case !dryRun && report.Created > 0:
+		status, message = http.StatusCreated, "Some domains were imported; see results for failed rows"
+	case !dryRun:
+		status, message = http.StatusUnprocessableEntity, "No domains were imported"
+	}
+
+	c.JSON(status, gin.H{
+		"success": report.Failed == 0,
+		"message": message,
+		"report":  report,
+	})
+}
+
+// ExportDomains downloads all domains of the user with their DNS records,
+// in the format ImportDomains accepts
+func (dc *DomainController) ExportDomains(c *gin.Context) {
+	format := importFormat(c, "")
+	if format != services.DomainImportCSV && format != services.DomainImportJSON {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Format must be csv or json",
+		})
+		return
+	}
+
+	rows, err := dc.imports.Export(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to export domains",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := services.WriteDomainExport(&buf, format, rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to export domains",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	contentType := "application/json"
+	if format == services.DomainImportCSV {
+		contentType = "text/csv"
+	}
+	filename := "domains-" + time.Now().Format("20060102") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, contentType+"; charset=utf-8", buf.Bytes())
+}
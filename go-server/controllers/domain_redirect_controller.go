@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadRedirect parses the redirect ID route param and loads the rule of the
+// given domain, writing the error response itself when it returns false
+func loadRedirect(c *gin.Context, domainID int) (*models.DomainRedirect, bool) {
+	redirectID, err := strconv.Atoi(c.Param("redirectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid redirect ID",
+		})
+		return nil, false
+	}
+
+	redirect, err := models.GetRedirectByID(context.Background(), redirectID, domainID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Redirect not found",
+		})
+		return nil, false
+	}
+
+	return redirect, true
+}
+
+// respondRedirectValidationError writes the response for a rule set that
+// failed validation
+func respondRedirectValidationError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "Failed to validate redirect rules"
+	switch {
+	case errors.Is(err, services.ErrInvalidRedirect):
+		status, message = http.StatusBadRequest, "Invalid redirect rule"
+	case errors.Is(err, services.ErrRedirectLoop):
+		status, message = http.StatusUnprocessableEntity, "Redirect rules would create a redirect loop"
+	}
+
+	c.JSON(status, gin.H{
+		"success": false,
+		"message": message,
+		"error":   err.Error(),
+	})
+}
+
+// GetRedirects returns the redirect rules of a domain in evaluation order
+func (dc *DomainController) GetRedirects(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	redirects, err := models.GetRedirectsByDomainID(context.Background(), domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch redirects",
+		})
+		return
+	}
+
+	if redirects == nil {
+		redirects = []models.DomainRedirect{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"force_https": domain.ForceHTTPS,
+		"redirects":   redirects,
+	})
+}
+
+// RedirectRequest represents the create/update redirect request
+type RedirectRequest struct {
+	SourceHost string `json:"source_host"`
+	SourcePath string `json:"source_path" binding:"required"`
+	// MatchType is exact (default), prefix or regex
+	MatchType     string `json:"match_type"`
+	Target        string `json:"target" binding:"required"`
+	StatusCode    int    `json:"status_code"`
+	PreserveQuery *bool  `json:"preserve_query"`
+}
+
+// toRedirect applies request defaults and converts it to a rule
+func (req *RedirectRequest) toRedirect(domainID int) models.DomainRedirect {
+	r := models.DomainRedirect{
+		DomainID:      domainID,
+		SourceHost:    strings.ToLower(strings.TrimSpace(req.SourceHost)),
+		SourcePath:    strings.TrimSpace(req.SourcePath),
+		MatchType:     strings.ToLower(req.MatchType),
+		Target:        strings.TrimSpace(req.Target),
+		StatusCode:    req.StatusCode,
+		PreserveQuery: true,
+	}
+	if r.MatchType == "" {
+		r.MatchType = models.RedirectMatchExact
+	}
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusMovedPermanently
+	}
+	if req.PreserveQuery != nil {
+		r.PreserveQuery = *req.PreserveQuery
+	}
+	return r
+}
+
+// CreateRedirect appends a redirect rule to a domain
+func (dc *DomainController) CreateRedirect(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	var req RedirectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Source path and target are required",
+		})
+		return
+	}
+	redirect := req.toRedirect(domain.ID)
+
+	ctx := context.Background()
+	existing, err := models.GetRedirectsByDomainID(ctx, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch redirects",
+		})
+		return
+	}
+
+	if err := services.ValidateDomainRedirects(ctx, domain, append(existing, redirect)); err != nil {
+		respondRedirectValidationError(c, err)
+		return
+	}
+
+	created, err := models.CreateRedirect(ctx, &redirect)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create redirect",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"message":  "Redirect created successfully",
+		"redirect": created,
+	})
+}
+
+// UpdateRedirect replaces a redirect rule, keeping its position
+func (dc *DomainController) UpdateRedirect(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	current, ok := loadRedirect(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	var req RedirectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Source path and target are required",
+		})
+		return
+	}
+	redirect := req.toRedirect(domain.ID)
+	redirect.ID = current.ID
+
+	ctx := context.Background()
+	rules, err := models.GetRedirectsByDomainID(ctx, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch redirects",
+		})
+		return
+	}
+	for i := range rules {
+		if rules[i].ID == redirect.ID {
+			rules[i] = redirect
+		}
+	}
+
+	if err := services.ValidateDomainRedirects(ctx, domain, rules); err != nil {
+		respondRedirectValidationError(c, err)
+		return
+	}
+
+	updated, err := models.UpdateRedirect(ctx, &redirect)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update redirect",
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Redirect updated successfully",
+		"redirect": updated,
+	})
+}
+
+// DeleteRedirect deletes a redirect rule
+func (dc *DomainController) DeleteRedirect(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	redirect, ok := loadRedirect(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := models.DeleteRedirect(ctx, redirect.ID, domain.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete redirect",
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Redirect deleted successfully",
+	})
+}
+
+// ReorderRedirectsRequest represents the reorder redirects request
+type ReorderRedirectsRequest struct {
+	// IDs lists every redirect of the domain in the new evaluation order
+	IDs []int `json:"ids" binding:"required"`
+}
+
+// ReorderRedirects changes the evaluation order of a domain's redirects
+func (dc *DomainController) ReorderRedirects(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	var req ReorderRedirectsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Redirect IDs are required",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	rules, err := models.GetRedirectsByDomainID(ctx, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch redirects",
+		})
+		return
+	}
+
+	// Order matters for loop detection, so validate the new order first
+	byID := make(map[int]models.DomainRedirect, len(rules))
+	for _, r := range rules {
+		byID[r.ID] = r
+	}
+	ordered := make([]models.DomainRedirect, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		r, exists := byID[id]
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Redirect " + strconv.Itoa(id) + " does not belong to this domain",
+			})
+			return
+		}
+		ordered = append(ordered, r)
+	}
+
+	if err := services.ValidateDomainRedirects(ctx, domain, ordered); err != nil {
+		respondRedirectValidationError(c, err)
+		return
+	}
+
+	if err := models.ReorderRedirects(ctx, domain.ID, req.IDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to reorder redirects",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+
+	redirects, _ := models.GetRedirectsByDomainID(ctx, domain.ID)
+	if redirects == nil {
+		redirects = []models.DomainRedirect{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Redirects reordered successfully",
+		"redirects": redirects,
+	})
+}
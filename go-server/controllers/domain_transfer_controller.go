@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transferErrorStatus maps domain transfer errors to HTTP status codes
+func transferErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRecipientNotFound),
+		errors.Is(err, services.ErrTransferNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrTransferToSelf),
+		errors.Is(err, services.ErrTransferHomeRoot):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrTransferWrongRecipient):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrTransferExpired):
+		return http.StatusGone
+	case errors.Is(err, models.ErrTransferNotPending),
+		errors.Is(err, services.ErrTransferStale),
+		errors.Is(err, services.ErrTransferFileConflict),
+		errors.Is(err, services.ErrTransferSharedRoot):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// InitiateTransferRequest represents the start transfer request
+type InitiateTransferRequest struct {
+	RecipientEmail string `json:"recipient_email" binding:"required,email"`
+	// MoveFiles moves the document roots into the recipient's home
+	MoveFiles bool `json:"move_files"`
+}
+
+// InitiateTransfer starts handing a domain to another user. The response
+// carries the acceptance token, which is not stored and cannot be shown again
+func (dc *DomainController) InitiateTransfer(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	var req InitiateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A valid recipient email is required",
+		})
+		return
+	}
+
+	transfer, token, err := dc.transfers.Initiate(context.Background(), domain, req.RecipientEmail, req.MoveFiles)
+	if err != nil {
+		c.JSON(transferErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to start transfer",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"message":  "Transfer of " + domain.DomainName + " to " + transfer.ToEmail + " started. Share the token with the recipient to accept it",
+		"transfer": transfer,
+		"token":    token,
+	})
+}
+
+// CancelTransfer withdraws the pending transfer of a domain
+func (dc *DomainController) CancelTransfer(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := dc.transfers.Cancel(context.Background(), domain, middleware.GetUserID(c)); err != nil {
+		c.JSON(transferErrorStatus(err), gin.H{
+			"success": false,
+			"message": "No pending transfer to cancel",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Transfer cancelled",
+	})
+}
+
+// GetDomainTransfers returns the transfer history and audit trail of a domain
+func (dc *DomainController) GetDomainTransfers(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	transfers, err := models.GetTransfersByDomainID(context.Background(), domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch transfers",
+		})
+		return
+	}
+
+	if transfers == nil {
+		transfers = []models.DomainTransfer{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"transfers": transfers,
+	})
+}
+
+// GetIncomingTransfers returns the pending transfers addressed to the user
+func (dc *DomainController) GetIncomingTransfers(c *gin.Context) {
+	transfers, err := models.GetIncomingTransfers(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch transfers",
+		})
+		return
+	}
+
+	if transfers == nil {
+		transfers = []models.DomainTransfer{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"transfers": transfers,
+	})
+}
+
+// AcceptTransferRequest represents the accept transfer request
+type AcceptTransferRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AcceptTransfer completes a transfer addressed to the user
+func (dc *DomainController) AcceptTransfer(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req AcceptTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Transfer token is required",
+		})
+		return
+	}
+
+	ctx := context.Background()
+	domain, transfer, err := dc.transfers.Accept(ctx, req.Token, userID)
+	if err != nil {
+		c.JSON(transferErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to accept transfer",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Without moved files the new owner starts with empty document roots
+	if !transfer.MoveFiles {
+		_ = prepareDocumentRoot(userID, domain.DocumentRoot)
+		subdomains, _ := models.GetSubdomainsByDomainID(ctx, domain.ID)
+		for _, sub := range subdomains {
+			_ = prepareDocumentRoot(userID, sub.DocumentRoot)
+		}
+	}
+
+	dc.syncVhost(ctx, domain.ID, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Domain " + domain.DomainName + " transferred to your account",
+		"domain":   domain.ToResponse(),
+		"transfer": transfer,
+	})
+}
+
+// DeclineTransfer rejects a transfer addressed to the user
+func (dc *DomainController) DeclineTransfer(c *gin.Context) {
+	transferID, err := strconv.Atoi(c.Param("transferId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid transfer ID",
+		})
+		return
+	}
+
+	if err := dc.transfers.Decline(context.Background(), transferID, middleware.GetUserID(c)); err != nil {
+		c.JSON(transferErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to decline transfer",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Transfer declined",
+	})
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetVhostConfig returns the rendered web server config of a domain without
+// installing it
+func (dc *DomainController) GetVhostConfig(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	cfg, err := dc.vhost.BuildConfig(ctx, domain)
+	if err == nil {
+		var content []byte
+		content, err = dc.vhost.Render(cfg)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"enabled": dc.vhost.Enabled(),
+				"config":  string(content),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"success": false,
+		"message": "Failed to render vhost config",
+		"error":   err.Error(),
+	})
+}
+
+// RebuildVhost renders and installs the web server config of a domain,
+// reporting config test or reload failures to the caller
+func (dc *DomainController) RebuildVhost(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	if !dc.vhost.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Vhost provisioning is not configured on this server",
+		})
+		return
+	}
+
+	if err := dc.vhost.SyncDomain(context.Background(), domain); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"message": "Failed to provision vhost",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Vhost for " + domain.DomainName + " provisioned successfully",
+	})
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cloudku-server/models"
+	"cloudku-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DynDNSController serves the dyndns2 update protocol for routers and
+// DynDNS clients. It is mounted outside the versioned API because clients
+// expect /nic/update on the server root
+type DynDNSController struct {
+	dns    *services.PowerDNSSyncService
+	dyndns *services.DynDNSService
+}
+
+// NewDynDNSController creates a new DynDNS controller
+func NewDynDNSController(dns *services.PowerDNSSyncService) *DynDNSController {
+	return &DynDNSController{
+		dns:    dns,
+		dyndns: services.NewDynDNSService(),
+	}
+}
+
+// Update changes the address of a record: GET /nic/update?hostname=&myip=
+// with an update token as the HTTP Basic password (the username is
+// ignored). Without myip the client address is used. The response is a
+// plain text dyndns2 code such as "good 203.0.113.7" or "nochg ..."
+func (dc *DynDNSController) Update(c *gin.Context) {
+	_, token, _ := c.Request.BasicAuth()
+
+	ctx := context.Background()
+	result := dc.dyndns.Update(ctx, token, c.Query("hostname"), c.Query("myip"), c.ClientIP())
+	if result.DomainID != 0 {
+		syncZone(ctx, dc.dns, result.DomainID)
+	}
+
+	status := http.StatusOK
+	switch result.Code {
+	case services.DynDNSBadAuth:
+		c.Header("WWW-Authenticate", `Basic realm="CloudKu DynDNS"`)
+		status = http.StatusUnauthorized
+	case services.DynDNSServerErr:
+		status = http.StatusInternalServerError
+	}
+	c.String(status, result.String())
+}
+
+// loadDynDNSRecord parses the record ID route param and loads the record of
+// the given domain, writing the error response itself when it returns false
+func loadDynDNSRecord(c *gin.Context, domainID int) (*models.DNSRecord, bool) {
+	recordID, err := strconv.Atoi(c.Param("recordId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid record ID",
+		})
+		return nil, false
+	}
+
+	record, err := models.GetDNSRecordByID(context.Background(), recordID, domainID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "DNS record not found",
+		})
+		return nil, false
+	}
+	return record, true
+}
+
+// GetUpdateTokens lists the DynDNS update tokens of a record
+func (dc *DomainController) GetUpdateTokens(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	record, ok := loadDynDNSRecord(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	tokens, err := models.GetDNSUpdateTokensByRecordID(context.Background(), record.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch update tokens",
+		})
+		return
+	}
+
+	responses := make([]models.DNSUpdateTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		responses = append(responses, tokens[i].ToResponse())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"hostname": services.DynDNSHostname(record, domain.DomainName),
+		"tokens":   responses,
+	})
+}
+
+// CreateUpdateTokenRequest represents the create update token request
+type CreateUpdateTokenRequest struct {
+	Description string `json:"description"`
+}
+
+// CreateUpdateToken issues a DynDNS update token for an A/AAAA record. The
+// token is only returned in this response
+func (dc *DomainController) CreateUpdateToken(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	record, ok := loadDynDNSRecord(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	var req CreateUpdateTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	if len(req.Description) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Description must be at most 255 characters",
+		})
+		return
+	}
+
+	token, secret, err := dc.dyndns.IssueToken(context.Background(), record, req.Description)
+	if err != nil {
+		if errors.Is(err, services.ErrDynDNSRecordType) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create update token",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	hostname := services.DynDNSHostname(record, domain.DomainName)
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"message":  "Update token created. Store it now; it will not be shown again",
+		"token":    token.ToResponse(),
+		"secret":   secret,
+		"hostname": hostname,
+		"usage":    "GET /nic/update?hostname=" + hostname + "&myip=<address> with HTTP Basic auth (any username, the secret as password)",
+	})
+}
+
+// DeleteUpdateToken revokes a DynDNS update token
+func (dc *DomainController) DeleteUpdateToken(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	record, ok := loadDynDNSRecord(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid token ID",
+		})
+		return
+	}
+
+	if err := models.DeleteDNSUpdateToken(context.Background(), tokenID, record.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Update token not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Update token revoked successfully",
+	})
+}
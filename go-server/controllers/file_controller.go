@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"cloudku-server/middleware"
+	"cloudku-server/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -48,11 +49,7 @@ type DirectoryStats struct {
 
 // getUserFilesPath returns the base path for user files
 func getUserFilesPath(userID string) string {
-	basePath := os.Getenv("USER_FILES_BASE_PATH")
-	if basePath == "" {
-		basePath = "./user-files"
-	}
-	return filepath.Join(basePath, userID)
+	return utils.UserFilesPath(userID)
 }
 
 // ensureUserDirectory ensures the user directory exists
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationController handles user notification endpoints
+type NotificationController struct{}
+
+// NewNotificationController creates a new notification controller
+func NewNotificationController() *NotificationController {
+	return &NotificationController{}
+}
+
+// GetNotifications returns the latest notifications of the user, newest
+// first. Query params: unread=true for unread only, limit (default 50, max
+// 200)
+func (nc *NotificationController) GetNotifications(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "limit must be between 1 and 200",
+			})
+			return
+		}
+		limit = n
+	}
+	unreadOnly := c.Query("unread") == "true" || c.Query("unread") == "1"
+
+	ctx := context.Background()
+	notifications, err := models.GetNotificationsByUserID(ctx, userID, unreadOnly, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch notifications",
+		})
+		return
+	}
+	unread, err := models.CountUnreadNotifications(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch notifications",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"notifications": notifications,
+		"unread":        unread,
+	})
+}
+
+// MarkNotificationRead marks one notification as read
+func (nc *NotificationController) MarkNotificationRead(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid notification ID",
+		})
+		return
+	}
+
+	if err := models.MarkNotificationRead(context.Background(), id, middleware.GetUserID(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Notification not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Notification marked as read",
+	})
+}
+
+// MarkAllNotificationsRead marks every notification of the user as read
+func (nc *NotificationController) MarkAllNotificationsRead(c *gin.Context) {
+	count, err := models.MarkAllNotificationsRead(context.Background(), middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to mark notifications as read",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Notifications marked as read",
+		"marked":  count,
+	})
+}
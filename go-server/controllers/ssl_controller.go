@@ -2,26 +2,123 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"cloudku-server/config"
 	"cloudku-server/database"
 	"cloudku-server/middleware"
 	"cloudku-server/models"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SSLController handles SSL management endpoints
-type SSLController struct{}
+type SSLController struct {
+	vhost *services.VhostService
+	acme  *services.ACMEService
+	certs *services.SSLCertificateService
+}
 
 // NewSSLController creates a new SSL controller
-func NewSSLController() *SSLController {
-	return &SSLController{}
+func NewSSLController(vhost *services.VhostService, dns *services.PowerDNSSyncService) *SSLController {
+	return &SSLController{
+		vhost: vhost,
+		acme:  services.NewACMEService(vhost, dns),
+		certs: services.NewSSLCertificateService(vhost),
+	}
+}
+
+// syncVhost re-provisions the web server config after a certificate change
+func (sc *SSLController) syncVhost(ctx context.Context, domainID, userID int) {
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	if err != nil {
+		return
+	}
+	if err := sc.vhost.SyncDomain(ctx, domain); err != nil {
+		log.Printf("WARN: Failed to provision vhost for %s: %v", domain.DomainName, err)
+	}
 }
 
-// EnableSSL enables SSL for a domain
+// IssueSSLRequest represents the enable / renew SSL request. Challenge is
+// http-01 or dns-01; empty uses the configured default (enable) or the
+// challenge the certificate was issued with (renew). Wildcard requests a
+// certificate for *.domain, which needs dns-01; omitted on renew it keeps
+// the kind of the current certificate
+type IssueSSLRequest struct {
+	Challenge string `json:"challenge"`
+	Wildcard  *bool  `json:"wildcard"`
+}
+
+// issueCertificate runs an ACME order for the domain and writes the
+// response, either the installed certificate or the error. Renewals are
+// recorded in the domain's renewal history
+func (sc *SSLController) issueCertificate(c *gin.Context, domain *models.Domain, renew bool, message string) {
+	var req IssueSSLRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.AppConfig.ACMETimeout)
+	defer cancel()
+
+	actor := dnsChangeActor(c, models.DNSChangeSourceACME)
+	acmeReq := services.ACMERequest{Challenge: req.Challenge, Wildcard: req.Wildcard}
+	var cert *services.IssuedCertificate
+	var err error
+	if renew {
+		cert, err = sc.acme.Renew(ctx, actor, domain, acmeReq, models.SSLRenewalTriggerManual)
+	} else {
+		cert, err = sc.acme.Issue(ctx, actor, domain, acmeReq)
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, services.ErrACMEChallenge), errors.Is(err, services.ErrACMEWildcardChallenge):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrACMEInProgress):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to issue SSL certificate",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"certificate": gin.H{
+			"domain":       domain.DomainName,
+			"hostnames":    cert.SANs,
+			"challenge":    cert.Challenge,
+			"wildcard":     cert.Wildcard,
+			"issuer":       cert.Issuer,
+			"serialNumber": cert.SerialNumber,
+			"fingerprint":  cert.FingerprintSHA256,
+			"issuedAt":     cert.NotBefore,
+			"expiresAt":    cert.NotAfter,
+		},
+	})
+}
+
+// EnableSSL obtains a certificate for a domain over ACME and enables SSL
+// once it is installed
 func (sc *SSLController) EnableSSL(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	domainIDStr := c.Param("domainId")
@@ -34,10 +131,8 @@ func (sc *SSLController) EnableSSL(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-
 	// Verify ownership
-	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	domain, err := models.GetDomainByID(context.Background(), domainID, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -55,35 +150,7 @@ func (sc *SSLController) EnableSSL(c *gin.Context) {
 		return
 	}
 
-	// In production, this would call Let's Encrypt certbot
-	// For now, we'll just update the database
-	expiresAt := time.Now().AddDate(0, 3, 0) // 90 days from now
-
-	query := `
-		UPDATE domains 
-		SET ssl_enabled = true, 
-		    ssl_provider = 'letsencrypt',
-		    ssl_expires_at = $1
-		WHERE id = $2
-	`
-	_, err = database.DB.Exec(ctx, query, expiresAt, domainID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "Failed to enable SSL",
-			"error":   err.Error(),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "SSL enabled successfully",
-		"certificate": gin.H{
-			"domain":    domain.DomainName,
-			"expiresAt": expiresAt,
-		},
-	})
+	sc.issueCertificate(c, domain, false, "SSL enabled successfully")
 }
 
 // DisableSSL disables SSL for a domain
@@ -115,6 +182,8 @@ func (sc *SSLController) DisableSSL(c *gin.Context) {
 		UPDATE domains 
 		SET ssl_enabled = false, 
 		    ssl_provider = NULL,
+		    ssl_challenge = NULL,
+		    ssl_wildcard = false,
 		    ssl_expires_at = NULL
 		WHERE id = $1
 	`
@@ -127,13 +196,15 @@ func (sc *SSLController) DisableSSL(c *gin.Context) {
 		return
 	}
 
+	sc.syncVhost(ctx, domainID, userID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "SSL disabled successfully",
 	})
 }
 
-// RenewSSL renews SSL certificate for a domain
+// RenewSSL replaces the certificate of a domain with a newly issued one
 func (sc *SSLController) RenewSSL(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	domainIDStr := c.Param("domainId")
@@ -146,10 +217,8 @@ func (sc *SSLController) RenewSSL(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-
 	// Verify ownership
-	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	domain, err := models.GetDomainByID(context.Background(), domainID, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -165,25 +234,15 @@ func (sc *SSLController) RenewSSL(c *gin.Context) {
 		})
 		return
 	}
-
-	// In production, this would call certbot renew
-	expiresAt := time.Now().AddDate(0, 3, 0) // 90 days from now
-
-	query := `UPDATE domains SET ssl_expires_at = $1 WHERE id = $2`
-	_, err = database.DB.Exec(ctx, query, expiresAt, domainID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if domain.SSLProvider.String == models.SSLProviderCustom {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "Failed to renew SSL certificate",
+			"message": "Custom certificates cannot be renewed automatically; upload the renewed certificate instead",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"message":   "SSL certificate renewed successfully",
-		"expiresAt": expiresAt,
-	})
+	sc.issueCertificate(c, domain, true, "SSL certificate renewed successfully")
 }
 
 // GetSSLInfo returns SSL certificate info for a domain
@@ -231,6 +290,12 @@ func (sc *SSLController) GetSSLInfo(c *gin.Context) {
 		sslProvider = &domain.SSLProvider.String
 	}
 
+	// Hostnames the certificate must cover, including SSL-enabled aliases
+	hostnames, _ := models.GetSSLHostnames(ctx, domain)
+
+	// Details of the installed certificate, nil while none is on disk
+	cert, _ := sc.certs.Installed(domain.DomainName)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"ssl": gin.H{
@@ -238,44 +303,190 @@ func (sc *SSLController) GetSSLInfo(c *gin.Context) {
 			"provider":        sslProvider,
 			"expiresAt":       expiresAt,
 			"daysUntilExpiry": daysUntilExpiry,
+			"hostnames":       hostnames,
+			"certificate":     cert,
 		},
 	})
 }
 
-// GetSSLStats returns SSL statistics
-func (sc *SSLController) GetSSLStats(c *gin.Context) {
+// UploadCertificateRequest represents the custom certificate upload request
+// (PEM). The chain holds the intermediate certificates
+type UploadCertificateRequest struct {
+	Certificate string `json:"certificate" binding:"required"`
+	PrivateKey  string `json:"private_key" binding:"required"`
+	Chain       string `json:"chain"`
+}
+
+// UploadCertificate installs a certificate bought elsewhere (e.g. EV/OV).
+// The key must match, the chain must build to a trusted root and the
+// certificate must cover the domain, its www host and SSL-enabled aliases.
+// It replaces any ACME certificate and is not renewed automatically
+func (sc *SSLController) UploadCertificate(c *gin.Context) {
 	userID := middleware.GetUserID(c)
+	domainID, err := strconv.Atoi(c.Param("domainId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid domain ID",
+		})
+		return
+	}
+
+	var req UploadCertificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+
 	ctx := context.Background()
 
-	// Get SSL statistics for user
-	var totalDomains, sslEnabled, expiringSoon int
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Domain not found",
+		})
+		return
+	}
+
+	cert, err := sc.certs.Upload(ctx, domain, services.CertificateUpload{
+		Certificate: req.Certificate,
+		PrivateKey:  req.PrivateKey,
+		Chain:       req.Chain,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCertificate) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Certificate rejected",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to install certificate",
+			"error":   err.Error(),
+		})
+		return
+	}
 
-	query := `SELECT COUNT(*) FROM domains WHERE user_id = $1`
-	database.DB.QueryRow(ctx, query, userID).Scan(&totalDomains)
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Custom certificate installed successfully",
+		"certificate": cert,
+	})
+}
 
-	query = `SELECT COUNT(*) FROM domains WHERE user_id = $1 AND ssl_enabled = true`
-	database.DB.QueryRow(ctx, query, userID).Scan(&sslEnabled)
+// ListCertificates returns the certificate inventory of the user. Query
+// params: status, provider, q (matches the domain or a SAN), sort, order
+// (asc or desc), limit (default 50, max 200) and offset
+func (sc *SSLController) ListCertificates(c *gin.Context) {
+	userID := middleware.GetUserID(c)
 
-	query = `SELECT COUNT(*) FROM domains WHERE user_id = $1 AND ssl_enabled = true AND ssl_expires_at < NOW() + INTERVAL '30 days' AND ssl_expires_at > NOW()`
-	database.DB.QueryRow(ctx, query, userID).Scan(&expiringSoon)
+	filter := models.SSLCertificateFilter{
+		Status:   c.Query("status"),
+		Provider: c.Query("provider"),
+		Search:   strings.TrimSpace(c.Query("q")),
+		Sort:     c.Query("sort"),
+		Limit:    50,
+	}
+
+	switch filter.Status {
+	case "", models.SSLCertificateActive, models.SSLCertificateExpiring, models.SSLCertificateExpired, models.SSLCertificateDisabled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "status must be active, expiring, expired or disabled",
+		})
+		return
+	}
+	if filter.Sort != "" && !slices.Contains(models.SSLCertificateSortFields, filter.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "sort must be one of " + strings.Join(models.SSLCertificateSortFields, ", "),
+		})
+		return
+	}
+	switch order := strings.ToLower(c.Query("order")); order {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "order must be asc or desc",
+		})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "limit must be between 1 and 200",
+			})
+			return
+		}
+		filter.Limit = n
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid offset",
+			})
+			return
+		}
+		filter.Offset = n
+	}
+
+	certs, total, err := models.ListSSLCertificates(context.Background(), userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to list SSL certificates",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"stats": gin.H{
-			"totalDomains":      totalDomains,
-			"sslEnabled":        sslEnabled,
-			"expiringSoon":      expiringSoon,
-			"percentageSecured": float64(sslEnabled) / float64(totalDomains) * 100,
-		},
-		"certbot": gin.H{
-			"installed":  true,
-			"version":    "1.0.0",
-			"configured": true,
-		},
+		"data":    certs,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// GetSSLStats returns SSL statistics computed from the user's domains and
+// the certificate inventory
+func (sc *SSLController) GetSSLStats(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	stats, err := models.GetSSLStatsByUserID(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get SSL statistics",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"stats":   stats,
 	})
 }
 
-// GetExpiringCertificates returns certificates expiring soon
+// GetExpiringCertificates returns certificates expiring within ?days
+// (default 30), including already expired ones, with their auto-renewal
+// state so failed renewals are visible
 func (sc *SSLController) GetExpiringCertificates(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	daysStr := c.DefaultQuery("days", "30")
@@ -284,12 +495,12 @@ func (sc *SSLController) GetExpiringCertificates(c *gin.Context) {
 	ctx := context.Background()
 
 	query := `
-		SELECT id, domain_name, ssl_expires_at
+		SELECT id, domain_name, ssl_expires_at, auto_renew_ssl,
+		       COALESCE(ssl_renew_failures, 0), ssl_renewal_error, ssl_next_renewal_at
 		FROM domains
 		WHERE user_id = $1
 		AND ssl_enabled = true
 		AND ssl_expires_at < NOW() + INTERVAL '1 day' * $2
-		AND ssl_expires_at > NOW()
 		ORDER BY ssl_expires_at ASC
 	`
 
@@ -303,23 +514,77 @@ func (sc *SSLController) GetExpiringCertificates(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var expiring []gin.H
+	expiring := []gin.H{}
+	failing := 0
 	for rows.Next() {
-		var id int
+		var id, failures int
 		var domainName string
 		var expiresAt time.Time
-		if err := rows.Scan(&id, &domainName, &expiresAt); err != nil {
+		var autoRenew bool
+		var renewalError *string
+		var nextRenewalAt *time.Time
+		if err := rows.Scan(&id, &domainName, &expiresAt, &autoRenew, &failures, &renewalError, &nextRenewalAt); err != nil {
 			continue
 		}
+		if failures > 0 {
+			failing++
+		}
 		expiring = append(expiring, gin.H{
-			"id":             id,
-			"domain_name":    domainName,
-			"ssl_expires_at": expiresAt,
+			"id":                 id,
+			"domain_name":        domainName,
+			"ssl_expires_at":     expiresAt,
+			"expired":            expiresAt.Before(time.Now()),
+			"auto_renew_ssl":     autoRenew,
+			"renewal_failures":   failures,
+			"last_renewal_error": renewalError,
+			"next_renewal_at":    nextRenewalAt,
 		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success":  true,
-		"expiring": expiring,
+		"success":         true,
+		"expiring":        expiring,
+		"renewal_failing": failing,
+	})
+}
+
+// GetRenewalHistory returns the latest renewal attempts of a domain's
+// certificate, automatic and manual, newest first
+func (sc *SSLController) GetRenewalHistory(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	domainID, err := strconv.Atoi(c.Param("domainId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid domain ID",
+		})
+		return
+	}
+
+	ctx := context.Background()
+
+	domain, err := models.GetDomainByID(ctx, domainID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Domain not found",
+		})
+		return
+	}
+
+	attempts, err := models.GetSSLRenewalAttempts(ctx, domain.ID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch renewal history",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"domain":         domain.DomainName,
+		"auto_renew_ssl": domain.AutoRenewSSL,
+		"attempts":       attempts,
 	})
 }
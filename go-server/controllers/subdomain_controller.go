@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloudku-server/middleware"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subdomainLabelRegex validates the host part of a subdomain ("blog", "dev.api")
+var subdomainLabelRegex = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// prepareDocumentRoot validates that a document root stays inside the
+// user's home and creates the directory on disk
+func prepareDocumentRoot(userID int, documentRoot string) error {
+	fullPath, err := utils.ResolveUserPath(strconv.Itoa(userID), documentRoot)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(fullPath, 0755)
+}
+
+// loadSubdomain parses the subdomain ID route param and loads the subdomain
+// of the given domain, writing the error response itself when it returns false
+func loadSubdomain(c *gin.Context, domainID int) (*models.Subdomain, bool) {
+	subdomainID, err := strconv.Atoi(c.Param("subdomainId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid subdomain ID",
+		})
+		return nil, false
+	}
+
+	subdomain, err := models.GetSubdomainByID(context.Background(), subdomainID, domainID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Subdomain not found",
+		})
+		return nil, false
+	}
+
+	return subdomain, true
+}
+
+// GetSubdomains returns all subdomains of a domain
+func (dc *DomainController) GetSubdomains(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	subdomains, err := models.GetSubdomainsByDomainID(context.Background(), domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch subdomains",
+		})
+		return
+	}
+
+	if subdomains == nil {
+		subdomains = []models.Subdomain{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"subdomains": subdomains,
+	})
+}
+
+// CreateSubdomainRequest represents the create subdomain request
+type CreateSubdomainRequest struct {
+	Name         string `json:"name" binding:"required"`
+	DocumentRoot string `json:"document_root"`
+	// Target makes the subdomain a CNAME to another host instead of an A
+	// record pointing at this server
+	Target string `json:"target"`
+}
+
+// CreateSubdomain creates a subdomain and its DNS record in the parent zone
+func (dc *DomainController) CreateSubdomain(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+
+	var req CreateSubdomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Subdomain name is required",
+		})
+		return
+	}
+
+	// Accept both "blog" and "blog.example.com"
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	name = strings.TrimSuffix(name, "."+domain.DomainName)
+	if !subdomainLabelRegex.MatchString(name) || name == "www" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid subdomain name",
+		})
+		return
+	}
+	fullName := name + "." + domain.DomainName
+
+	ctx := context.Background()
+
+	if exists, _ := models.DomainExists(ctx, fullName); exists {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": fullName + " is already registered as a domain or alias",
+		})
+		return
+	}
+	if exists, _ := models.HostRecordExists(ctx, domain.ID, name); exists {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "A DNS record for " + fullName + " already exists",
+		})
+		return
+	}
+
+	documentRoot := req.DocumentRoot
+	if documentRoot == "" {
+		documentRoot = "/" + fullName
+	}
+	if err := prepareDocumentRoot(userID, documentRoot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid document root",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	recordType, recordValue := "A", getServerIP()
+	if req.Target != "" {
+		target := strings.ToLower(strings.TrimSuffix(req.Target, "."))
+		if ip := net.ParseIP(target); ip != nil || !domainNameRegex.MatchString(target) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Target must be a hostname",
+			})
+			return
+		}
+		recordType, recordValue = "CNAME", target
+	}
+
+	subdomain, err := models.CreateSubdomain(ctx, dnsChangeActor(c, models.DNSChangeSourceSubdomain), domain.ID, name, documentRoot, recordType, recordValue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to create subdomain",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, userID)
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"message":   "Subdomain " + subdomain.FullName + " created successfully",
+		"subdomain": subdomain,
+	})
+}
+
+// UpdateSubdomainRequest represents the update subdomain request
+type UpdateSubdomainRequest struct {
+	DocumentRoot string `json:"document_root" binding:"required"`
+}
+
+// UpdateSubdomain changes the document root of a subdomain
+func (dc *DomainController) UpdateSubdomain(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	subdomain, ok := loadSubdomain(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	var req UpdateSubdomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Document root is required",
+		})
+		return
+	}
+
+	if err := prepareDocumentRoot(userID, req.DocumentRoot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid document root",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx := context.Background()
+	if err := models.UpdateSubdomainDocumentRoot(ctx, subdomain.ID, domain.ID, req.DocumentRoot); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update subdomain",
+		})
+		return
+	}
+	subdomain.DocumentRoot = req.DocumentRoot
+
+	dc.syncVhost(ctx, domain.ID, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Subdomain updated successfully",
+		"subdomain": subdomain,
+	})
+}
+
+// DeleteSubdomain deletes a subdomain and its DNS record. Files in the
+// document root are left untouched
+func (dc *DomainController) DeleteSubdomain(c *gin.Context) {
+	domain, ok := loadOwnedDomain(c, "id")
+	if !ok {
+		return
+	}
+	subdomain, ok := loadSubdomain(c, domain.ID)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	name, err := models.DeleteSubdomain(ctx, dnsChangeActor(c, models.DNSChangeSourceSubdomain), subdomain.ID, domain.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete subdomain",
+		})
+		return
+	}
+
+	dc.syncVhost(ctx, domain.ID, domain.UserID)
+	syncZone(ctx, dc.dns, domain.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Subdomain " + name + "." + domain.DomainName + " deleted successfully",
+	})
+}
@@ -36,6 +36,12 @@ func InitSchema() error {
 		return err
 	}
 
+	// Administrators (suspend domains, manage other users' resources)
+	_, err = DB.Exec(ctx, `ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN DEFAULT FALSE`)
+	if err != nil {
+		return err
+	}
+
 	// Domains table
 	_, err = DB.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS domains (
@@ -75,6 +81,337 @@ func InitSchema() error {
 		return err
 	}
 
+	// Domain Aliases table (parked domains share the parent's document root)
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS domain_aliases (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			alias_name VARCHAR(255) UNIQUE NOT NULL,
+			include_in_ssl BOOLEAN DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_aliases_domain_id ON domain_aliases(domain_id);
+		ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS alias_id INTEGER REFERENCES domain_aliases(id) ON DELETE CASCADE;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Indexes for listing a user's domains and counting their records
+	_, err = DB.Exec(ctx, `
+		CREATE INDEX IF NOT EXISTS idx_domains_user_id ON domains(user_id);
+		CREATE INDEX IF NOT EXISTS idx_dns_records_domain_id ON dns_records(domain_id, record_type);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Subdomains table (each subdomain owns an A/CNAME record in the parent zone)
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS subdomains (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			document_root VARCHAR(255) NOT NULL,
+			dns_record_id INTEGER REFERENCES dns_records(id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (domain_id, name)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Domain ownership verification (token + attempt log)
+	_, err = DB.Exec(ctx, `
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS verification_token VARCHAR(64);
+		CREATE TABLE IF NOT EXISTS domain_verification_attempts (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			method VARCHAR(20) NOT NULL,
+			success BOOLEAN NOT NULL,
+			details TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_verification_attempts_domain_id
+			ON domain_verification_attempts(domain_id, created_at DESC);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Domain status monitoring (re-check schedule, status history and the
+	// reason behind the current status)
+	_, err = DB.Exec(ctx, `
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS next_check_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS check_failures INTEGER DEFAULT 0;
+		CREATE TABLE IF NOT EXISTS domain_status_history (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			old_status VARCHAR(50),
+			new_status VARCHAR(50) NOT NULL,
+			reason TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_status_history_domain_id
+			ON domain_status_history(domain_id, created_at DESC);
+		ALTER TABLE domain_status_history ADD COLUMN IF NOT EXISTS changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS status_reason TEXT;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Redirect rules (ordered per domain) and HTTPS enforcement
+	_, err = DB.Exec(ctx, `
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS force_https BOOLEAN DEFAULT true;
+		CREATE TABLE IF NOT EXISTS domain_redirects (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			source_host VARCHAR(255),
+			source_path TEXT NOT NULL,
+			match_type VARCHAR(10) NOT NULL DEFAULT 'exact',
+			target TEXT NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 301,
+			preserve_query BOOLEAN DEFAULT true,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_redirects_domain_id ON domain_redirects(domain_id, position);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Domain transfers between users and their audit trail. References are
+	// SET NULL so the trail survives deleted domains and users
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS domain_transfers (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER REFERENCES domains(id) ON DELETE SET NULL,
+			domain_name VARCHAR(255) NOT NULL,
+			from_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			to_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			to_email VARCHAR(255) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			move_files BOOLEAN DEFAULT FALSE,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_transfers_domain_id ON domain_transfers(domain_id);
+		CREATE INDEX IF NOT EXISTS idx_domain_transfers_to_user_id ON domain_transfers(to_user_id, status);
+		CREATE TABLE IF NOT EXISTS domain_transfer_events (
+			id SERIAL PRIMARY KEY,
+			transfer_id INTEGER NOT NULL REFERENCES domain_transfers(id) ON DELETE CASCADE,
+			actor_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			event VARCHAR(30) NOT NULL,
+			details TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_domain_transfer_events_transfer_id ON domain_transfer_events(transfer_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// DNS zones: per-zone SOA fields. The serial (YYYYMMDDnn) is bumped in
+	// the same transaction as every change to the zone's records; a NULL
+	// primary_ns/hostmaster falls back to the configured defaults
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS dns_zones (
+			domain_id INTEGER PRIMARY KEY REFERENCES domains(id) ON DELETE CASCADE,
+			primary_ns VARCHAR(255),
+			hostmaster VARCHAR(255),
+			serial BIGINT NOT NULL,
+			refresh INTEGER NOT NULL DEFAULT 3600,
+			retry INTEGER NOT NULL DEFAULT 1800,
+			expire INTEGER NOT NULL DEFAULT 1209600,
+			minimum INTEGER NOT NULL DEFAULT 3600,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- PowerDNS sync: the serial last pushed to PowerDNS
+		ALTER TABLE dns_zones ADD COLUMN IF NOT EXISTS pdns_serial BIGINT;
+		ALTER TABLE dns_zones ADD COLUMN IF NOT EXISTS pdns_synced_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE dns_zones ADD COLUMN IF NOT EXISTS pdns_error TEXT;
+
+		-- DNSSEC: per-zone enablement and signing keys (private keys encrypted)
+		ALTER TABLE dns_zones ADD COLUMN IF NOT EXISTS dnssec_enabled BOOLEAN NOT NULL DEFAULT false;
+
+		CREATE TABLE IF NOT EXISTS dnssec_keys (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			key_type VARCHAR(3) NOT NULL,
+			algorithm SMALLINT NOT NULL,
+			flags INTEGER NOT NULL,
+			key_tag INTEGER NOT NULL,
+			public_key TEXT NOT NULL,
+			private_key_encrypted TEXT NOT NULL,
+			state VARCHAR(20) NOT NULL,
+			activate_at TIMESTAMP WITH TIME ZONE,
+			remove_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			activated_at TIMESTAMP WITH TIME ZONE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dnssec_keys_domain_id ON dnssec_keys(domain_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// DNS templates: user-defined record sets applied to domains. Shipped
+	// presets live in code, not here
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS dns_templates (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			description TEXT,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, name)
+		);
+
+		CREATE TABLE IF NOT EXISTS dns_template_records (
+			id SERIAL PRIMARY KEY,
+			template_id INTEGER NOT NULL REFERENCES dns_templates(id) ON DELETE CASCADE,
+			record_type VARCHAR(10) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			value TEXT NOT NULL,
+			ttl INTEGER NOT NULL DEFAULT 3600,
+			priority INTEGER,
+			position INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dns_template_records_template_id ON dns_template_records(template_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Zone changelog: every record change with its before/after content,
+	// who made it and through which path. record_id has no foreign key so
+	// entries outlive the records they describe
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS dns_record_changes (
+			id BIGSERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			record_id INTEGER NOT NULL,
+			action VARCHAR(10) NOT NULL,
+			before JSONB,
+			after JSONB,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			source VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dns_record_changes_domain_id ON dns_record_changes(domain_id, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// DynDNS update tokens: each token may change one A/AAAA record through
+	// /nic/update; only the SHA-256 of the token is stored
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS dns_update_tokens (
+			id SERIAL PRIMARY KEY,
+			record_id INTEGER NOT NULL REFERENCES dns_records(id) ON DELETE CASCADE,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			description VARCHAR(255),
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			update_count INTEGER NOT NULL DEFAULT 0,
+			last_ip VARCHAR(45),
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dns_update_tokens_record_id ON dns_update_tokens(record_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// ACME challenge type a domain certificate was issued with, reused on
+	// renewal, and whether it is a wildcard certificate
+	_, err = DB.Exec(ctx, `
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS ssl_challenge VARCHAR(10);
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS ssl_wildcard BOOLEAN DEFAULT false;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// SSL auto-renewal state per domain, the history of renewal attempts
+	// and user notifications (e.g. failed renewals)
+	_, err = DB.Exec(ctx, `
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS ssl_renew_failures INTEGER DEFAULT 0;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS ssl_next_renewal_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE domains ADD COLUMN IF NOT EXISTS ssl_renewal_error TEXT;
+
+		CREATE TABLE IF NOT EXISTS ssl_renewal_attempts (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+			trigger VARCHAR(10) NOT NULL,
+			challenge VARCHAR(10),
+			success BOOLEAN NOT NULL,
+			error TEXT,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			finished_at TIMESTAMP WITH TIME ZONE NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_ssl_renewal_attempts_domain_id ON ssl_renewal_attempts(domain_id, id);
+
+		CREATE TABLE IF NOT EXISTS notifications (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			domain_id INTEGER REFERENCES domains(id) ON DELETE CASCADE,
+			type VARCHAR(50) NOT NULL,
+			title VARCHAR(255) NOT NULL,
+			message TEXT NOT NULL,
+			read_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Certificate inventory: the certificate currently installed for each
+	// domain, as parsed from the certificate itself
+	_, err = DB.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS ssl_certificates (
+			id SERIAL PRIMARY KEY,
+			domain_id INTEGER NOT NULL UNIQUE REFERENCES domains(id) ON DELETE CASCADE,
+			sans TEXT[] NOT NULL DEFAULT '{}',
+			subject VARCHAR(255),
+			issuer VARCHAR(255),
+			serial_number VARCHAR(64) NOT NULL,
+			fingerprint_sha256 CHAR(64) NOT NULL,
+			key_type VARCHAR(30),
+			provider VARCHAR(20) NOT NULL,
+			wildcard BOOLEAN DEFAULT false,
+			not_before TIMESTAMP WITH TIME ZONE NOT NULL,
+			not_after TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_ssl_certificates_not_after ON ssl_certificates(not_after);
+	`)
+	if err != nil {
+		return err
+	}
+
 	// User Databases table
 	_, err = DB.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS user_databases (
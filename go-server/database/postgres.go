@@ -8,12 +8,22 @@ import (
 
 	"cloudku-server/config"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // DB is the global database connection pool
 var DB *pgxpool.Pool
 
+// Querier is satisfied by both the pool and a pgx.Tx, so model functions
+// can run either standalone or as part of a larger transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 // Connect establishes connection to PostgreSQL database
 func Connect() error {
 	cfg := config.AppConfig
@@ -14,7 +14,9 @@ import (
 	"cloudku-server/config"
 	"cloudku-server/database"
 	"cloudku-server/middleware"
+	"cloudku-server/models"
 	"cloudku-server/routes"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -46,9 +48,25 @@ func main() {
 		log.Fatalf("❌ Failed to initialize database schema: %v", err)
 	}
 
+	// Bootstrap administrators from ADMIN_EMAILS
+	if len(cfg.AdminEmails) > 0 {
+		granted, err := models.GrantAdminByEmail(context.Background(), cfg.AdminEmails)
+		if err != nil {
+			log.Printf("⚠️ Failed to grant admin rights: %v", err)
+		} else if granted > 0 {
+			log.Printf("🛡️ Granted admin rights to %d user(s)", granted)
+		}
+	}
+
 	// Create Gin router
 	r := gin.New()
 
+	// Only the configured proxies may supply the client address, which the
+	// DynDNS limiter and default address rely on
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Add middlewares
 	r.Use(gin.Recovery())
 	r.Use(middleware.LoggerMiddleware())
@@ -57,6 +75,40 @@ func main() {
 	// Setup routes
 	routes.SetupRoutes(r)
 
+	// Start background workers; they stop when workerCtx is cancelled
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	if cfg.DomainMonitorInterval > 0 {
+		go services.NewDomainMonitor().Run(workerCtx)
+	}
+	if cfg.PowerDNSAPIURL != "" && cfg.PowerDNSSyncInterval > 0 {
+		go services.NewPowerDNSSyncService().Run(workerCtx, cfg.PowerDNSSyncInterval, cfg.PowerDNSReconcileInterval)
+	}
+	if cfg.DNSSECRolloverInterval > 0 {
+		go services.NewDNSSECService().Run(workerCtx, cfg.DNSSECRolloverInterval, services.NewPowerDNSSyncService())
+	}
+	if cfg.SSLRenewalInterval > 0 {
+		acme := services.NewACMEService(services.NewVhostService(), services.NewPowerDNSSyncService())
+		go services.NewSSLRenewalWorker(acme).Run(workerCtx)
+	}
+	// Certificates installed before the inventory existed are added once
+	go func() {
+		if n, err := services.NewSSLCertificateService(nil).SyncInventory(workerCtx); err != nil {
+			log.Printf("⚠️ Failed to backfill the SSL certificate inventory: %v", err)
+		} else if n > 0 {
+			log.Printf("🔐 Added %d certificates to the SSL inventory", n)
+		}
+	}()
+	if cfg.DNSServerAddr != "" {
+		dnsServer := services.NewDNSServer()
+		if err := dnsServer.Listen(); err != nil {
+			log.Printf("⚠️ Failed to start DNS server on %s: %v", cfg.DNSServerAddr, err)
+		} else {
+			go dnsServer.Run(workerCtx)
+		}
+	}
+
 	// Create HTTP server with security hardening
 	srv := &http.Server{
 		Addr:              ":" + cfg.Port,
@@ -83,6 +135,7 @@ func main() {
 	<-quit
 
 	log.Println("\n🛑 Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -115,6 +168,7 @@ func printBanner(cfg *config.Config) {
   GET    /health             - Server health check
   GET    /api                - API info
   GET    /api/versions       - List API versions
+  GET    /nic/update         - DynDNS update (update token auth)
 
 🔐 AUTH (/api/v1/auth):
   POST   /register           - Email/password register [PUBLIC]
@@ -147,25 +201,78 @@ func printBanner(cfg *config.Config) {
   POST   /                   - Create domain
   PUT    /:id                - Update domain
   DELETE /:id                - Delete domain
-  POST   /:id/verify         - Verify domain DNS
+  POST   /import             - Bulk import (CSV/JSON, dry run)
+  GET    /export             - Export domains with DNS records
+  POST   /:id/verify         - Verify domain ownership
+  GET    /:id/verification   - Verification instructions
+  GET    /:id/status-history - Domain status history
+  POST   /:id/maintenance    - Toggle maintenance mode
+  POST   /:id/transfer       - Start transfer to another user
+  DELETE /:id/transfer       - Cancel pending transfer
+  GET    /:id/transfers      - Transfer history
+  GET    /transfers/incoming - Incoming transfers
+  POST   /transfers/accept   - Accept transfer
+  POST   /transfers/:transferId/decline - Decline transfer
+  GET    /:id/vhost          - Preview vhost config
+  POST   /:id/vhost/rebuild  - Rebuild vhost config
+  GET    /:id/redirects      - Get redirect rules
+  POST   /:id/redirects      - Add redirect rule
+  POST   /:id/redirects/reorder - Reorder redirect rules
+  PUT    /:id/redirects/:redirectId - Update redirect rule
+  DELETE /:id/redirects/:redirectId - Delete redirect rule
   GET    /:id/dns            - Get DNS records
   POST   /:id/dns            - Create DNS record
+  PUT    /:id/dns/:recordId  - Update DNS record
   DELETE /:id/dns/:recordId  - Delete DNS record
+  GET    /:id/dns/:recordId/update-tokens - DynDNS update tokens
+  POST   /:id/dns/:recordId/update-tokens - Create update token
+  DELETE /:id/dns/:recordId/update-tokens/:tokenId - Revoke update token
+  GET    /:id/aliases        - Get aliases
+  POST   /:id/aliases        - Add alias
+  PUT    /:id/aliases/:aliasId - Update alias
+  DELETE /:id/aliases/:aliasId - Delete alias
+  GET    /:id/aliases/:aliasId/dns - Get alias zone records
+  POST   /:id/aliases/:aliasId/dns - Create alias zone record
+  PUT    /:id/aliases/:aliasId/dns/:recordId - Update alias zone record
+  DELETE /:id/aliases/:aliasId/dns/:recordId - Delete alias zone record
+  GET    /:id/subdomains     - Get subdomains
+  POST   /:id/subdomains     - Create subdomain
+  PUT    /:id/subdomains/:subdomainId - Update subdomain
+  DELETE /:id/subdomains/:subdomainId - Delete subdomain
 
 📝 DNS (/api/v1/dns) [ALL PROTECTED]:
   GET    /stats              - DNS statistics
   GET    /powerdns/status    - PowerDNS status
-  POST   /powerdns/reload    - Reload PowerDNS
+  POST   /powerdns/reload    - Sync zones to PowerDNS
+  GET    /templates          - DNS template presets & user templates
+  POST   /templates          - Create DNS template
+  PUT    /templates/:templateId - Update DNS template
+  DELETE /templates/:templateId - Delete DNS template
   GET    /:domainId/records  - Get PowerDNS records
   GET    /:domainId/export   - Export zone file
+  POST   /:domainId/import   - Import zone file (diff preview)
+  GET    /:domainId/history  - Zone change history
+  POST   /:domainId/rollback - Roll zone back (preview)
+  GET    /:domainId/propagation - Check DNS propagation
+  GET    /:domainId/soa      - Get zone SOA
+  PUT    /:domainId/soa      - Update zone SOA
+  POST   /:domainId/templates/apply - Apply DNS template (preview)
+  GET    /:domainId/dnssec   - DNSSEC keys & DS records
+  POST   /:domainId/dnssec/enable   - Enable DNSSEC
+  POST   /:domainId/dnssec/disable  - Disable DNSSEC
+  POST   /:domainId/dnssec/rollover - Start key rollover
+  POST   /:domainId/dnssec/rollover/complete - Complete KSK rollover
 
 🔒 SSL (/api/v1/ssl) [ALL PROTECTED]:
+  GET    /                   - Certificate inventory (filter & sort)
   GET    /stats              - SSL statistics
-  GET    /expiring           - Expiring certificates
-  POST   /:domainId/enable   - Enable SSL
+  GET    /expiring           - Expiring certificates and failed renewals
+  POST   /:domainId/enable   - Issue an ACME certificate (optionally wildcard) and enable SSL
   POST   /:domainId/disable  - Disable SSL
-  POST   /:domainId/renew    - Renew SSL
+  POST   /:domainId/renew    - Re-issue the ACME certificate
+  POST   /:domainId/upload   - Install a custom certificate
   GET    /:domainId/info     - Get SSL info
+  GET    /:domainId/renewals - Renewal attempt history
 
 🗄️ DATABASES (/api/v1/databases) [ALL PROTECTED]:
   GET    /                   - Get all databases
@@ -176,6 +283,17 @@ func printBanner(cfg *config.Config) {
   GET    /:id/schema         - Get schema (SQL Terminal)
   POST   /:id/query          - Execute query (SQL Terminal)
 
+🔔 NOTIFICATIONS (/api/v1/notifications) [ALL PROTECTED]:
+  GET    /                   - List notifications
+  POST   /read-all           - Mark all as read
+  POST   /:id/read           - Mark as read
+
+🛡️ ADMIN (/api/v1/admin) [ADMIN ONLY]:
+  POST   /domains/:id/suspend   - Suspend domain
+  POST   /domains/:id/unsuspend - Lift suspension
+  GET    /dns/drift             - Compare zones with PowerDNS
+  POST   /dns/reconcile         - Push drifted zones to PowerDNS
+
 %s
 `, line, line, cfg.Port, cfg.Environment, cfg.FrontendURL, line, line)
 
@@ -98,3 +98,21 @@ func GetUser(c *gin.Context) *models.User {
 	}
 	return user.(*models.User)
 }
+
+// AdminMiddleware only lets administrators through. It must run after
+// AuthMiddleware
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUser(c)
+		if user == nil || !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Administrator access required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -3,10 +3,13 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 
 	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // DNSRecord represents a DNS record
@@ -59,7 +62,7 @@ func GetDNSRecordsByDomainID(ctx context.Context, domainID int) ([]DNSRecord, er
 	query := `
 		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
 		FROM dns_records
-		WHERE domain_id = $1
+		WHERE domain_id = $1 AND alias_id IS NULL
 		ORDER BY record_type, name
 	`
 
@@ -87,8 +90,49 @@ func GetDNSRecordsByDomainID(ctx context.Context, domainID int) ([]DNSRecord, er
 	return records, nil
 }
 
+// GetDNSRecordsForUpdate returns the records of a domain using q and locks
+// them until the end of its transaction
+func GetDNSRecordsForUpdate(ctx context.Context, q database.Querier, domainID int) ([]DNSRecord, error) {
+	query := `
+		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+		FROM dns_records
+		WHERE domain_id = $1 AND alias_id IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`
+
+	rows, err := q.Query(ctx, query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []DNSRecord{}
+	for rows.Next() {
+		var r DNSRecord
+		if err := rows.Scan(&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+			&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
 // CreateDNSRecord creates a new DNS record
-func CreateDNSRecord(ctx context.Context, domainID int, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
+func CreateDNSRecord(ctx context.Context, actor DNSChangeActor, domainID int, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
+	var record *DNSRecord
+	err := UpdateZone(ctx, domainID, func(q database.Querier) error {
+		var err error
+		record, err = InsertDNSRecord(ctx, q, actor, domainID, recordType, name, value, ttl, priority)
+		return err
+	})
+	return record, err
+}
+
+// InsertDNSRecord creates a new DNS record using q, so it can take part in a
+// caller's transaction. The change is logged to the zone's changelog
+func InsertDNSRecord(ctx context.Context, q database.Querier, actor DNSChangeActor, domainID int, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
 	query := `
 		INSERT INTO dns_records (domain_id, record_type, name, value, ttl, priority)
 		VALUES ($1, $2, $3, $4, $5, $6)
@@ -96,7 +140,7 @@ func CreateDNSRecord(ctx context.Context, domainID int, recordType, name, value
 	`
 
 	var r DNSRecord
-	err := database.DB.QueryRow(ctx, query, domainID, recordType, name, value, ttl, priority).Scan(
+	err := q.QueryRow(ctx, query, domainID, recordType, name, value, ttl, priority).Scan(
 		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
 		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
 	)
@@ -104,50 +148,93 @@ func CreateDNSRecord(ctx context.Context, domainID int, recordType, name, value
 		return nil, err
 	}
 
+	if err := logDNSRecordChange(ctx, q, actor, domainID, r.ID, DNSChangeCreate, nil, &r); err != nil {
+		return nil, err
+	}
 	return &r, nil
 }
 
-// DeleteDNSRecord deletes a DNS record
-func DeleteDNSRecord(ctx context.Context, recordID, domainID int) error {
-	query := `DELETE FROM dns_records WHERE id = $1 AND domain_id = $2`
-	result, err := database.DB.Exec(ctx, query, recordID, domainID)
+// GetDNSRecordByID gets a DNS record of a domain
+func GetDNSRecordByID(ctx context.Context, recordID, domainID int) (*DNSRecord, error) {
+	query := `
+		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+		FROM dns_records
+		WHERE id = $1 AND domain_id = $2 AND alias_id IS NULL
+	`
+
+	var r DNSRecord
+	err := database.DB.QueryRow(ctx, query, recordID, domainID).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
 	if err != nil {
-		return err
-	}
-	if result.RowsAffected() == 0 {
-		return sql.ErrNoRows
+		return nil, err
 	}
-	return nil
+
+	return &r, nil
 }
 
-// CreateDefaultDNSRecords creates default DNS records for a new domain
-func CreateDefaultDNSRecords(ctx context.Context, domainID int, domainName, serverIP string) error {
-	defaultRecords := []struct {
-		Type     string
-		Name     string
-		Value    string
-		Priority *int
-	}{
-		{"A", "@", serverIP, nil},
-		{"A", "www", serverIP, nil},
-		{"CNAME", "ftp", domainName, nil},
-		{"MX", "@", "mail." + domainName, intPtr(10)},
+// UpdateDNSRecord replaces the fields of a DNS record and logs the change
+// with the previous content
+func UpdateDNSRecord(ctx context.Context, q database.Querier, actor DNSChangeActor, recordID, domainID int, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
+	var before DNSRecord
+	beforeQuery := `
+		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+		FROM dns_records
+		WHERE id = $1 AND domain_id = $2 AND alias_id IS NULL
+		FOR UPDATE
+	`
+	err := q.QueryRow(ctx, beforeQuery, recordID, domainID).Scan(
+		&before.ID, &before.DomainID, &before.RecordType, &before.Name, &before.Value,
+		&before.TTL, &before.Priority, &before.CreatedAt, &before.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, record := range defaultRecords {
-		query := `
-			INSERT INTO dns_records (domain_id, record_type, name, value, priority)
-			VALUES ($1, $2, $3, $4, $5)
-		`
-		_, err := database.DB.Exec(ctx, query, domainID, record.Type, record.Name, record.Value, record.Priority)
-		if err != nil {
-			return err
-		}
+	query := `
+		UPDATE dns_records
+		SET record_type = $1, name = $2, value = $3, ttl = $4, priority = $5, updated_at = NOW()
+		WHERE id = $6 AND domain_id = $7 AND alias_id IS NULL
+		RETURNING id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+	`
+
+	var r DNSRecord
+	err = q.QueryRow(ctx, query, recordType, name, value, ttl, priority, recordID, domainID).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if !before.Snapshot().Equal(r.Snapshot()) {
+		if err := logDNSRecordChange(ctx, q, actor, domainID, r.ID, DNSChangeUpdate, &before, &r); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
 }
 
-func intPtr(i int) *int {
-	return &i
+// DeleteDNSRecord deletes a DNS record and logs its last content
+func DeleteDNSRecord(ctx context.Context, q database.Querier, actor DNSChangeActor, recordID, domainID int) error {
+	query := `
+		DELETE FROM dns_records
+		WHERE id = $1 AND domain_id = $2 AND alias_id IS NULL
+		RETURNING id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+	`
+
+	var r DNSRecord
+	err := q.QueryRow(ctx, query, recordID, domainID).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		return err
+	}
+
+	return logDNSRecordChange(ctx, q, actor, domainID, r.ID, DNSChangeDelete, &r, nil)
 }
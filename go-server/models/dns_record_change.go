@@ -0,0 +1,213 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DNS change actions
+const (
+	DNSChangeCreate = "create"
+	DNSChangeUpdate = "update"
+	DNSChangeDelete = "delete"
+)
+
+// DNS change sources: what made a change on behalf of the actor
+const (
+	DNSChangeSourceAPI       = "api"       // record endpoints
+	DNSChangeSourceDomain    = "domain"    // default records of a new domain
+	DNSChangeSourceImport    = "import"    // domain import and zone file import
+	DNSChangeSourceTemplate  = "template"  // applied DNS template
+	DNSChangeSourceSubdomain = "subdomain" // record managed by a subdomain
+	DNSChangeSourceDynDNS    = "dyndns"    // /nic/update with an update token
+	DNSChangeSourceRollback  = "rollback"  // zone rolled back to an earlier change
+	DNSChangeSourceACME      = "acme"      // DNS-01 challenge record of a certificate order
+)
+
+// DNSChangeActor says who changed a zone and through which path. UserID is
+// nil for changes made with an update token or by a background worker
+type DNSChangeActor struct {
+	UserID *int
+	Source string
+}
+
+// DNSRecordSnapshot is the content of a record before or after a change
+type DNSRecordSnapshot struct {
+	RecordType string `json:"record_type"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	TTL        int    `json:"ttl"`
+	Priority   *int   `json:"priority,omitempty"`
+}
+
+// Snapshot returns the content of a record
+func (r *DNSRecord) Snapshot() DNSRecordSnapshot {
+	resp := r.ToResponse()
+	return DNSRecordSnapshot{
+		RecordType: resp.RecordType,
+		Name:       resp.Name,
+		Value:      resp.Value,
+		TTL:        resp.TTL,
+		Priority:   resp.Priority,
+	}
+}
+
+// Equal reports whether two snapshots have the same content
+func (s DNSRecordSnapshot) Equal(o DNSRecordSnapshot) bool {
+	samePriority := s.Priority == nil && o.Priority == nil ||
+		s.Priority != nil && o.Priority != nil && *s.Priority == *o.Priority
+	return samePriority && s.RecordType == o.RecordType && s.Name == o.Name && s.Value == o.Value && s.TTL == o.TTL
+}
+
+// DNSRecordChange is an entry of a zone's changelog
+type DNSRecordChange struct {
+	ID        int64              `json:"id"`
+	DomainID  int                `json:"domain_id"`
+	RecordID  int                `json:"record_id"`
+	Action    string             `json:"action"`
+	Before    *DNSRecordSnapshot `json:"before"`
+	After     *DNSRecordSnapshot `json:"after"`
+	UserID    *int               `json:"user_id"`
+	UserEmail *string            `json:"user_email"`
+	Source    string             `json:"source"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+const dnsRecordChangeColumns = `c.id, c.domain_id, c.record_id, c.action, c.before, c.after, c.user_id, u.email, c.source, c.created_at`
+
+func scanDNSRecordChange(row pgx.Row, c *DNSRecordChange) error {
+	var before, after []byte
+	var userID sql.NullInt32
+	var email sql.NullString
+	if err := row.Scan(&c.ID, &c.DomainID, &c.RecordID, &c.Action, &before, &after, &userID, &email, &c.Source, &c.CreatedAt); err != nil {
+		return err
+	}
+	if userID.Valid {
+		id := int(userID.Int32)
+		c.UserID = &id
+	}
+	if email.Valid {
+		c.UserEmail = &email.String
+	}
+	var err error
+	if c.Before, err = decodeSnapshot(before); err != nil {
+		return err
+	}
+	c.After, err = decodeSnapshot(after)
+	return err
+}
+
+// decodeSnapshot decodes a JSONB snapshot column, nil for no record
+func decodeSnapshot(data []byte) (*DNSRecordSnapshot, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var snap DNSRecordSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// snapshotJSON encodes a snapshot for a JSONB column, nil for no record
+func snapshotJSON(r *DNSRecord) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r.Snapshot())
+}
+
+// logDNSRecordChange appends a change to the zone's changelog using q, so
+// it is committed together with the change itself
+func logDNSRecordChange(ctx context.Context, q database.Querier, actor DNSChangeActor, domainID, recordID int, action string, before, after *DNSRecord) error {
+	beforeJSON, err := snapshotJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := snapshotJSON(after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO dns_record_changes (domain_id, record_id, action, before, after, user_id, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = q.Exec(ctx, query, domainID, recordID, action, beforeJSON, afterJSON, actor.UserID, actor.Source)
+	return err
+}
+
+// GetDNSRecordChanges returns a page of a zone's changelog, newest first.
+// beforeID > 0 continues after the last entry of the previous page
+func GetDNSRecordChanges(ctx context.Context, domainID int, beforeID int64, limit int) ([]DNSRecordChange, error) {
+	query := `
+		SELECT ` + dnsRecordChangeColumns + `
+		FROM dns_record_changes c
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE c.domain_id = $1 AND ($2::bigint = 0 OR c.id < $2)
+		ORDER BY c.id DESC
+		LIMIT $3
+	`
+	return queryDNSRecordChanges(ctx, database.DB, query, domainID, beforeID, limit)
+}
+
+// GetDNSRecordChangesAfter returns the changes made to a zone after the
+// given change, newest first
+func GetDNSRecordChangesAfter(ctx context.Context, q database.Querier, domainID int, afterID int64) ([]DNSRecordChange, error) {
+	query := `
+		SELECT ` + dnsRecordChangeColumns + `
+		FROM dns_record_changes c
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE c.domain_id = $1 AND c.id > $2
+		ORDER BY c.id DESC
+	`
+	return queryDNSRecordChanges(ctx, q, query, domainID, afterID)
+}
+
+func queryDNSRecordChanges(ctx context.Context, q database.Querier, query string, args ...any) ([]DNSRecordChange, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []DNSRecordChange{}
+	for rows.Next() {
+		var c DNSRecordChange
+		if err := scanDNSRecordChange(rows, &c); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// GetDNSRecordChange returns a changelog entry of a zone
+func GetDNSRecordChange(ctx context.Context, domainID int, id int64) (*DNSRecordChange, error) {
+	query := `
+		SELECT ` + dnsRecordChangeColumns + `
+		FROM dns_record_changes c
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE c.domain_id = $1 AND c.id = $2
+	`
+	var c DNSRecordChange
+	if err := scanDNSRecordChange(database.DB.QueryRow(ctx, query, domainID, id), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LastDNSRecordChangeIDAt returns the ID of the last change committed to a
+// zone at or before t, 0 when the changelog starts later
+func LastDNSRecordChangeIDAt(ctx context.Context, domainID int, t time.Time) (int64, error) {
+	query := `SELECT COALESCE(MAX(id), 0) FROM dns_record_changes WHERE domain_id = $1 AND created_at <= $2`
+	var id int64
+	err := database.DB.QueryRow(ctx, query, domainID, t).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+
+	"cloudku-server/database"
+)
+
+// DomainDNSStats counts the zone records of a domain by type
+type DomainDNSStats struct {
+	DomainID     int            `json:"domainId"`
+	DomainName   string         `json:"domainName"`
+	TotalRecords int            `json:"totalRecords"`
+	ByType       map[string]int `json:"byType"`
+}
+
+// GetDNSStatsByUserID counts the zone records of every domain of a user by
+// type in one grouped query. Domains without records are included with
+// zero counts; alias zones are not counted
+func GetDNSStatsByUserID(ctx context.Context, userID int) ([]DomainDNSStats, error) {
+	query := `
+		SELECT d.id, d.domain_name, UPPER(r.record_type), COUNT(r.id)
+		FROM domains d
+		LEFT JOIN dns_records r ON r.domain_id = d.id AND r.alias_id IS NULL
+		WHERE d.user_id = $1
+		GROUP BY d.id, d.domain_name, UPPER(r.record_type)
+		ORDER BY d.domain_name, d.id
+	`
+	rows, err := database.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []DomainDNSStats{}
+	for rows.Next() {
+		var domainID, count int
+		var domainName string
+		var recordType *string
+		if err := rows.Scan(&domainID, &domainName, &recordType, &count); err != nil {
+			return nil, err
+		}
+
+		if len(stats) == 0 || stats[len(stats)-1].DomainID != domainID {
+			stats = append(stats, DomainDNSStats{DomainID: domainID, DomainName: domainName, ByType: map[string]int{}})
+		}
+		if recordType != nil {
+			s := &stats[len(stats)-1]
+			s.ByType[*recordType] = count
+			s.TotalRecords += count
+		}
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// DNSTemplateRecord is a record of a DNS template. Name and Value may hold
+// {placeholders} that are filled in when the template is applied
+type DNSTemplateRecord struct {
+	RecordType string `json:"record_type"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	TTL        int    `json:"ttl"`
+	Priority   *int   `json:"priority,omitempty"`
+}
+
+// DNSTemplate is a user-defined set of records that can be applied to a
+// domain
+type DNSTemplate struct {
+	ID          int                 `json:"id"`
+	UserID      int                 `json:"user_id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Records     []DNSTemplateRecord `json:"records"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// GetDNSTemplatesByUserID returns the templates of a user with their records
+func GetDNSTemplatesByUserID(ctx context.Context, userID int) ([]DNSTemplate, error) {
+	query := `
+		SELECT id, user_id, name, COALESCE(description, ''), created_at, updated_at
+		FROM dns_templates
+		WHERE user_id = $1
+		ORDER BY name
+	`
+	rows, err := database.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []DNSTemplate{}
+	for rows.Next() {
+		var t DNSTemplate
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range templates {
+		if templates[i].Records, err = getDNSTemplateRecords(ctx, database.DB, templates[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// GetDNSTemplateByID returns a template of a user with its records
+func GetDNSTemplateByID(ctx context.Context, id, userID int) (*DNSTemplate, error) {
+	query := `
+		SELECT id, user_id, name, COALESCE(description, ''), created_at, updated_at
+		FROM dns_templates
+		WHERE id = $1 AND user_id = $2
+	`
+	var t DNSTemplate
+	err := database.DB.QueryRow(ctx, query, id, userID).Scan(&t.ID, &t.UserID, &t.Name, &t.Description, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if t.Records, err = getDNSTemplateRecords(ctx, database.DB, t.ID); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func getDNSTemplateRecords(ctx context.Context, q database.Querier, templateID int) ([]DNSTemplateRecord, error) {
+	query := `
+		SELECT record_type, name, value, ttl, priority
+		FROM dns_template_records
+		WHERE template_id = $1
+		ORDER BY position, id
+	`
+	rows, err := q.Query(ctx, query, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []DNSTemplateRecord{}
+	for rows.Next() {
+		var r DNSTemplateRecord
+		var priority sql.NullInt32
+		if err := rows.Scan(&r.RecordType, &r.Name, &r.Value, &r.TTL, &priority); err != nil {
+			return nil, err
+		}
+		if priority.Valid {
+			p := int(priority.Int32)
+			r.Priority = &p
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// replaceDNSTemplateRecords swaps the records of a template
+func replaceDNSTemplateRecords(ctx context.Context, q database.Querier, templateID int, records []DNSTemplateRecord) error {
+	if _, err := q.Exec(ctx, `DELETE FROM dns_template_records WHERE template_id = $1`, templateID); err != nil {
+		return err
+	}
+	for i, r := range records {
+		query := `
+			INSERT INTO dns_template_records (template_id, record_type, name, value, ttl, priority, position)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+		if _, err := q.Exec(ctx, query, templateID, r.RecordType, r.Name, r.Value, r.TTL, r.Priority, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateDNSTemplate stores a new template with its records
+func CreateDNSTemplate(ctx context.Context, userID int, name, description string, records []DNSTemplateRecord) (*DNSTemplate, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	t := DNSTemplate{UserID: userID, Name: name, Description: description, Records: records}
+	query := `
+		INSERT INTO dns_templates (user_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`
+	if err := tx.QueryRow(ctx, query, userID, name, description).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := replaceDNSTemplateRecords(ctx, tx, t.ID, records); err != nil {
+		return nil, err
+	}
+	return &t, tx.Commit(ctx)
+}
+
+// UpdateDNSTemplate replaces the name, description and records of a
+// template
+func UpdateDNSTemplate(ctx context.Context, id, userID int, name, description string, records []DNSTemplateRecord) (*DNSTemplate, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	t := DNSTemplate{ID: id, UserID: userID, Name: name, Description: description, Records: records}
+	query := `
+		UPDATE dns_templates
+		SET name = $1, description = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+		RETURNING created_at, updated_at
+	`
+	if err := tx.QueryRow(ctx, query, name, description, id, userID).Scan(&t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := replaceDNSTemplateRecords(ctx, tx, id, records); err != nil {
+		return nil, err
+	}
+	return &t, tx.Commit(ctx)
+}
+
+// DeleteDNSTemplate deletes a template of a user
+func DeleteDNSTemplate(ctx context.Context, id, userID int) error {
+	result, err := database.DB.Exec(ctx, `DELETE FROM dns_templates WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
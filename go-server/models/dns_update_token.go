@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DNSUpdateToken lets a DynDNS client change the address of one A/AAAA
+// record without a user session. Only the hash of the token is stored
+type DNSUpdateToken struct {
+	ID          int            `json:"id"`
+	RecordID    int            `json:"record_id"`
+	DomainID    int            `json:"domain_id"`
+	Description string         `json:"description"`
+	UpdateCount int            `json:"update_count"`
+	LastIP      sql.NullString `json:"-"`
+	LastUsedAt  sql.NullTime   `json:"-"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// DNSUpdateTokenResponse is the API response structure
+type DNSUpdateTokenResponse struct {
+	ID          int        `json:"id"`
+	RecordID    int        `json:"record_id"`
+	Description string     `json:"description"`
+	UpdateCount int        `json:"update_count"`
+	LastIP      *string    `json:"last_ip"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ToResponse converts DNSUpdateToken to DNSUpdateTokenResponse
+func (t *DNSUpdateToken) ToResponse() DNSUpdateTokenResponse {
+	resp := DNSUpdateTokenResponse{
+		ID:          t.ID,
+		RecordID:    t.RecordID,
+		Description: t.Description,
+		UpdateCount: t.UpdateCount,
+		CreatedAt:   t.CreatedAt,
+	}
+	if t.LastIP.Valid {
+		resp.LastIP = &t.LastIP.String
+	}
+	if t.LastUsedAt.Valid {
+		resp.LastUsedAt = &t.LastUsedAt.Time
+	}
+	return resp
+}
+
+const dnsUpdateTokenColumns = `id, record_id, domain_id, COALESCE(description, ''), update_count, last_ip, last_used_at, created_at`
+
+func scanDNSUpdateToken(row pgx.Row, t *DNSUpdateToken) error {
+	return row.Scan(&t.ID, &t.RecordID, &t.DomainID, &t.Description, &t.UpdateCount, &t.LastIP, &t.LastUsedAt, &t.CreatedAt)
+}
+
+// GetDNSUpdateTokensByRecordID returns the update tokens of a record
+func GetDNSUpdateTokensByRecordID(ctx context.Context, recordID int) ([]DNSUpdateToken, error) {
+	query := `SELECT ` + dnsUpdateTokenColumns + ` FROM dns_update_tokens WHERE record_id = $1 ORDER BY created_at, id`
+	rows, err := database.DB.Query(ctx, query, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []DNSUpdateToken{}
+	for rows.Next() {
+		var t DNSUpdateToken
+		if err := scanDNSUpdateToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// FindDNSUpdateTokenByHash looks up a token by the hash of its secret
+func FindDNSUpdateTokenByHash(ctx context.Context, tokenHash string) (*DNSUpdateToken, error) {
+	query := `SELECT ` + dnsUpdateTokenColumns + ` FROM dns_update_tokens WHERE token_hash = $1`
+	var t DNSUpdateToken
+	if err := scanDNSUpdateToken(database.DB.QueryRow(ctx, query, tokenHash), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateDNSUpdateToken stores a new update token for a record
+func CreateDNSUpdateToken(ctx context.Context, recordID, domainID int, description, tokenHash string) (*DNSUpdateToken, error) {
+	query := `
+		INSERT INTO dns_update_tokens (record_id, domain_id, description, token_hash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + dnsUpdateTokenColumns
+	var t DNSUpdateToken
+	if err := scanDNSUpdateToken(database.DB.QueryRow(ctx, query, recordID, domainID, description, tokenHash), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// MarkDNSUpdateTokenUsed records the client address of an update request,
+// counting it when the record changed
+func MarkDNSUpdateTokenUsed(ctx context.Context, id int, clientIP string, changed bool) error {
+	query := `
+		UPDATE dns_update_tokens
+		SET last_ip = $1, last_used_at = NOW(), update_count = update_count + CASE WHEN $2 THEN 1 ELSE 0 END
+		WHERE id = $3
+	`
+	_, err := database.DB.Exec(ctx, query, clientIP, changed, id)
+	return err
+}
+
+// DeleteDNSUpdateToken revokes an update token of a record
+func DeleteDNSUpdateToken(ctx context.Context, id, recordID int) error {
+	result, err := database.DB.Exec(ctx, `DELETE FROM dns_update_tokens WHERE id = $1 AND record_id = $2`, id, recordID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
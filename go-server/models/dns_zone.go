@@ -0,0 +1,228 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DNSZone holds the SOA fields of a domain's zone
+type DNSZone struct {
+	DomainID int `json:"domain_id"`
+	// PrimaryNS and Hostmaster are NULL when the configured defaults apply
+	PrimaryNS  sql.NullString `json:"-"`
+	Hostmaster sql.NullString `json:"-"`
+	Serial     int64          `json:"serial"`
+	Refresh    int            `json:"refresh"`
+	Retry      int            `json:"retry"`
+	Expire     int            `json:"expire"`
+	Minimum    int            `json:"minimum"`
+	// DNSSECEnabled is set when the zone is signed
+	DNSSECEnabled bool      `json:"dnssec_enabled"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SOAFields are the editable SOA fields of a zone; nil fields are left
+// unchanged and an empty PrimaryNS/Hostmaster restores the default
+type SOAFields struct {
+	PrimaryNS  *string
+	Hostmaster *string
+	Refresh    *int
+	Retry      *int
+	Expire     *int
+	Minimum    *int
+}
+
+// SerialBase returns the first serial of the given day in YYYYMMDDnn form
+func SerialBase(t time.Time) int64 {
+	base, _ := strconv.ParseInt(t.UTC().Format("20060102")+"00", 10, 64)
+	return base
+}
+
+const dnsZoneColumns = `domain_id, primary_ns, hostmaster, serial, refresh, retry, expire, minimum, dnssec_enabled, updated_at`
+
+func scanDNSZone(row pgx.Row, z *DNSZone) error {
+	return row.Scan(&z.DomainID, &z.PrimaryNS, &z.Hostmaster, &z.Serial,
+		&z.Refresh, &z.Retry, &z.Expire, &z.Minimum, &z.DNSSECEnabled, &z.UpdatedAt)
+}
+
+// GetDNSZone returns the SOA fields of a domain's zone, creating the zone
+// with default values on first use
+func GetDNSZone(ctx context.Context, q database.Querier, domainID int) (*DNSZone, error) {
+	query := `
+		INSERT INTO dns_zones (domain_id, serial) VALUES ($1, $2)
+		ON CONFLICT (domain_id) DO NOTHING
+	`
+	if _, err := q.Exec(ctx, query, domainID, SerialBase(time.Now())); err != nil {
+		return nil, err
+	}
+
+	var z DNSZone
+	if err := scanDNSZone(q.QueryRow(ctx, `SELECT `+dnsZoneColumns+` FROM dns_zones WHERE domain_id = $1`, domainID), &z); err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+// LockDNSZone locks the zone row of a domain until the end of the
+// transaction of q, serialising it with other zone changes
+func LockDNSZone(ctx context.Context, q database.Querier, domainID int) error {
+	if _, err := GetDNSZone(ctx, q, domainID); err != nil {
+		return err
+	}
+	_, err := q.Exec(ctx, `SELECT 1 FROM dns_zones WHERE domain_id = $1 FOR UPDATE`, domainID)
+	return err
+}
+
+// BumpZoneSerial increments the serial of a domain's zone and returns it.
+// The serial moves to today's YYYYMMDD00 if that is higher, so it always
+// increases and reads as the date of the last change. Call it with the
+// transaction that changes the records
+func BumpZoneSerial(ctx context.Context, q database.Querier, domainID int) (int64, error) {
+	query := `
+		INSERT INTO dns_zones (domain_id, serial) VALUES ($1, $2)
+		ON CONFLICT (domain_id) DO UPDATE
+		SET serial = GREATEST(dns_zones.serial + 1, EXCLUDED.serial), updated_at = NOW()
+		RETURNING serial
+	`
+	var serial int64
+	err := q.QueryRow(ctx, query, domainID, SerialBase(time.Now())).Scan(&serial)
+	return serial, err
+}
+
+// UpdateZone runs fn in a transaction and bumps the zone serial in the same
+// transaction, so a zone change and its serial are committed together
+func UpdateZone(ctx context.Context, domainID int, fn func(q database.Querier) error) error {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if _, err := BumpZoneSerial(ctx, tx, domainID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateDNSZoneSOA changes the SOA fields of a zone. Editing the SOA is a
+// zone change too, so the serial is bumped
+func UpdateDNSZoneSOA(ctx context.Context, domainID int, f SOAFields) (*DNSZone, error) {
+	err := UpdateZone(ctx, domainID, func(q database.Querier) error {
+		current, err := GetDNSZone(ctx, q, domainID)
+		if err != nil {
+			return err
+		}
+
+		set := func(dst *int, src *int) {
+			if src != nil {
+				*dst = *src
+			}
+		}
+		set(&current.Refresh, f.Refresh)
+		set(&current.Retry, f.Retry)
+		set(&current.Expire, f.Expire)
+		set(&current.Minimum, f.Minimum)
+		if f.PrimaryNS != nil {
+			current.PrimaryNS = sql.NullString{String: *f.PrimaryNS, Valid: *f.PrimaryNS != ""}
+		}
+		if f.Hostmaster != nil {
+			current.Hostmaster = sql.NullString{String: *f.Hostmaster, Valid: *f.Hostmaster != ""}
+		}
+
+		query := `
+			UPDATE dns_zones
+			SET primary_ns = $1, hostmaster = $2, refresh = $3, retry = $4, expire = $5, minimum = $6, updated_at = NOW()
+			WHERE domain_id = $7
+		`
+		_, err = q.Exec(ctx, query, current.PrimaryNS, current.Hostmaster,
+			current.Refresh, current.Retry, current.Expire, current.Minimum, domainID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return GetDNSZone(ctx, database.DB, domainID)
+}
+
+// GetZonesPendingSync returns the IDs of zones whose current serial has not
+// been pushed to PowerDNS yet, oldest change first
+func GetZonesPendingSync(ctx context.Context, limit int) ([]int, error) {
+	query := `
+		SELECT domain_id FROM dns_zones
+		WHERE pdns_serial IS DISTINCT FROM serial
+		ORDER BY updated_at
+		LIMIT $1
+	`
+	rows, err := database.DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CountZonesPendingSync returns the number of zones with changes not yet
+// pushed to PowerDNS
+func CountZonesPendingSync(ctx context.Context) (int, error) {
+	var count int
+	err := database.DB.QueryRow(ctx, `SELECT COUNT(*) FROM dns_zones WHERE pdns_serial IS DISTINCT FROM serial`).Scan(&count)
+	return count, err
+}
+
+// MarkZoneSynced records the outcome of pushing a zone to PowerDNS. On
+// success the pushed serial is stored; a failure keeps the zone pending and
+// stores the error
+func MarkZoneSynced(ctx context.Context, domainID int, serial int64, syncErr error) error {
+	if syncErr != nil {
+		_, err := database.DB.Exec(ctx, `UPDATE dns_zones SET pdns_error = $1 WHERE domain_id = $2`, syncErr.Error(), domainID)
+		return err
+	}
+	query := `
+		UPDATE dns_zones
+		SET pdns_serial = $1, pdns_synced_at = NOW(), pdns_error = NULL
+		WHERE domain_id = $2
+	`
+	_, err := database.DB.Exec(ctx, query, serial, domainID)
+	return err
+}
+
+// GetZoneSerials returns the current serial of every zone by domain ID, so
+// a DNS server can tell which zones changed since it loaded them
+func GetZoneSerials(ctx context.Context) (map[int]int64, error) {
+	rows, err := database.DB.Query(ctx, `SELECT domain_id, serial FROM dns_zones`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	serials := make(map[int]int64)
+	for rows.Next() {
+		var id int
+		var serial int64
+		if err := rows.Scan(&id, &serial); err != nil {
+			return nil, err
+		}
+		serials[id] = serial
+	}
+	return serials, rows.Err()
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DNSSEC key types
+const (
+	DNSSECKeyKSK = "KSK" // key signing key, signs the DNSKEY set; its DS goes to the registrar
+	DNSSECKeyZSK = "ZSK" // zone signing key, signs every other RRset
+)
+
+// DNSSEC key states
+const (
+	// DNSSECKeyPublished keys are in the DNSKEY set but do not sign yet
+	DNSSECKeyPublished = "published"
+	// DNSSECKeyActive keys sign the zone
+	DNSSECKeyActive = "active"
+	// DNSSECKeyRetired keys no longer sign but stay published until RemoveAt
+	DNSSECKeyRetired = "retired"
+)
+
+// DNSSECKey is a signing key of a zone. The private key is stored encrypted
+type DNSSECKey struct {
+	ID                  int          `json:"id"`
+	DomainID            int          `json:"domain_id"`
+	KeyType             string       `json:"key_type"`
+	Algorithm           int          `json:"algorithm"`
+	Flags               int          `json:"flags"`
+	KeyTag              int          `json:"key_tag"`
+	PublicKey           string       `json:"public_key"`
+	PrivateKeyEncrypted string       `json:"-"`
+	State               string       `json:"state"`
+	ActivateAt          sql.NullTime `json:"activate_at"`
+	RemoveAt            sql.NullTime `json:"remove_at"`
+	CreatedAt           time.Time    `json:"created_at"`
+	ActivatedAt         sql.NullTime `json:"activated_at"`
+}
+
+const dnssecKeyColumns = `id, domain_id, key_type, algorithm, flags, key_tag, public_key, private_key_encrypted,
+	state, activate_at, remove_at, created_at, activated_at`
+
+func scanDNSSECKey(row pgx.Row, k *DNSSECKey) error {
+	return row.Scan(&k.ID, &k.DomainID, &k.KeyType, &k.Algorithm, &k.Flags, &k.KeyTag, &k.PublicKey,
+		&k.PrivateKeyEncrypted, &k.State, &k.ActivateAt, &k.RemoveAt, &k.CreatedAt, &k.ActivatedAt)
+}
+
+// GetDNSSECKeys returns the keys of a zone, KSKs first and oldest first
+func GetDNSSECKeys(ctx context.Context, q database.Querier, domainID int) ([]DNSSECKey, error) {
+	query := `SELECT ` + dnssecKeyColumns + ` FROM dnssec_keys WHERE domain_id = $1 ORDER BY key_type, created_at, id`
+	rows, err := q.Query(ctx, query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []DNSSECKey
+	for rows.Next() {
+		var k DNSSECKey
+		if err := scanDNSSECKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// InsertDNSSECKey stores a new key and fills in its ID and timestamps
+func InsertDNSSECKey(ctx context.Context, q database.Querier, k *DNSSECKey) error {
+	query := `
+		INSERT INTO dnssec_keys (domain_id, key_type, algorithm, flags, key_tag, public_key, private_key_encrypted,
+			state, activate_at, activated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + dnssecKeyColumns
+	var activatedAt sql.NullTime
+	if k.State == DNSSECKeyActive {
+		activatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	return scanDNSSECKey(q.QueryRow(ctx, query, k.DomainID, k.KeyType, k.Algorithm, k.Flags, k.KeyTag,
+		k.PublicKey, k.PrivateKeyEncrypted, k.State, k.ActivateAt, activatedAt), k)
+}
+
+// ActivateDNSSECKey makes a published key sign the zone
+func ActivateDNSSECKey(ctx context.Context, q database.Querier, id int) error {
+	query := `
+		UPDATE dnssec_keys
+		SET state = 'active', activate_at = NULL, activated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := q.Exec(ctx, query, id)
+	return err
+}
+
+// RetireDNSSECKey stops a key from signing; it stays published until
+// removeAt
+func RetireDNSSECKey(ctx context.Context, q database.Querier, id int, removeAt time.Time) error {
+	query := `UPDATE dnssec_keys SET state = 'retired', remove_at = $1 WHERE id = $2`
+	_, err := q.Exec(ctx, query, removeAt, id)
+	return err
+}
+
+// DeleteDNSSECKey removes a key from the zone
+func DeleteDNSSECKey(ctx context.Context, q database.Querier, id int) error {
+	_, err := q.Exec(ctx, `DELETE FROM dnssec_keys WHERE id = $1`, id)
+	return err
+}
+
+// SetZoneDNSSEC turns signing of a zone on or off. Turning it off deletes
+// the keys
+func SetZoneDNSSEC(ctx context.Context, q database.Querier, domainID int, enabled bool) error {
+	if _, err := GetDNSZone(ctx, q, domainID); err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, `UPDATE dns_zones SET dnssec_enabled = $1 WHERE domain_id = $2`, enabled, domainID); err != nil {
+		return err
+	}
+	if !enabled {
+		_, err := q.Exec(ctx, `DELETE FROM dnssec_keys WHERE domain_id = $1`, domainID)
+		return err
+	}
+	return nil
+}
+
+// GetDNSSECDomainIDs returns the domains whose zone is signed
+func GetDNSSECDomainIDs(ctx context.Context) ([]int, error) {
+	rows, err := database.DB.Query(ctx, `SELECT domain_id FROM dns_zones WHERE dnssec_enabled ORDER BY domain_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -3,14 +3,27 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// ErrHostnameTaken is returned when a name is already used by a domain or
+// an alias
+var ErrHostnameTaken = errors.New("domain or alias already exists")
+
+// ErrHostnameNested is returned when an alias would lie inside or above an
+// existing domain or alias, taking over part of its zone
+var ErrHostnameNested = errors.New("hostname overlaps an existing domain or alias")
+
 // Domain represents a domain in the system
 type Domain struct {
 	ID              int            `json:"id"`
@@ -18,39 +31,55 @@ type Domain struct {
 	DomainName      string         `json:"domain_name"`
 	DocumentRoot    string         `json:"document_root"`
 	Status          string         `json:"status"`
+	StatusReason    sql.NullString `json:"status_reason"`
+	StatusChangedAt sql.NullTime   `json:"status_changed_at"`
 	SSLEnabled      bool           `json:"ssl_enabled"`
 	SSLProvider     sql.NullString `json:"ssl_provider"`
 	SSLExpiresAt    sql.NullTime   `json:"ssl_expires_at"`
+	SSLChallenge    sql.NullString `json:"ssl_challenge"`
+	SSLWildcard     bool           `json:"ssl_wildcard"`
 	AutoRenewSSL    bool           `json:"auto_renew_ssl"`
+	ForceHTTPS      bool           `json:"force_https"`
 	VerifiedAt      sql.NullTime   `json:"verified_at"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
+	VerifyToken     sql.NullString `json:"-"`
 	DNSRecordsCount int            `json:"dns_records_count"`
 	AliasesCount    int            `json:"aliases_count"`
+	Subdomains      []Subdomain    `json:"subdomains"`
 }
 
 // DomainResponse is the API response structure
 type DomainResponse struct {
-	ID              int        `json:"id"`
-	UserID          int        `json:"user_id"`
-	DomainName      string     `json:"domain_name"`
-	DocumentRoot    string     `json:"document_root"`
-	Status          string     `json:"status"`
-	SSLEnabled      bool       `json:"ssl_enabled"`
-	SSLProvider     *string    `json:"ssl_provider"`
-	SSLExpiresAt    *time.Time `json:"ssl_expires_at"`
-	AutoRenewSSL    bool       `json:"auto_renew_ssl"`
-	VerifiedAt      *time.Time `json:"verified_at"`
-	CreatedAt       time.Time  `json:"created_at"`
-	DNSRecordsCount int        `json:"dns_records_count"`
-	AliasesCount    int        `json:"aliases_count"`
+	ID              int         `json:"id"`
+	UserID          int         `json:"user_id"`
+	DomainName      string      `json:"domain_name"`
+	DocumentRoot    string      `json:"document_root"`
+	Status          string      `json:"status"`
+	StatusReason    *string     `json:"status_reason"`
+	StatusChangedAt *time.Time  `json:"status_changed_at"`
+	SSLEnabled      bool        `json:"ssl_enabled"`
+	SSLProvider     *string     `json:"ssl_provider"`
+	SSLExpiresAt    *time.Time  `json:"ssl_expires_at"`
+	SSLChallenge    *string     `json:"ssl_challenge"`
+	SSLWildcard     bool        `json:"ssl_wildcard"`
+	AutoRenewSSL    bool        `json:"auto_renew_ssl"`
+	ForceHTTPS      bool        `json:"force_https"`
+	VerifiedAt      *time.Time  `json:"verified_at"`
+	CreatedAt       time.Time   `json:"created_at"`
+	DNSRecordsCount int         `json:"dns_records_count"`
+	AliasesCount    int         `json:"aliases_count"`
+	Subdomains      []Subdomain `json:"subdomains"`
 }
 
 // ToResponse converts Domain to DomainResponse
 func (d *Domain) ToResponse() DomainResponse {
 	var sslProvider *string
 	var sslExpiresAt *time.Time
+	var sslChallenge *string
 	var verifiedAt *time.Time
+	var statusReason *string
+	var statusChangedAt *time.Time
 
 	if d.SSLProvider.Valid {
 		sslProvider = &d.SSLProvider.String
@@ -58,9 +87,23 @@ func (d *Domain) ToResponse() DomainResponse {
 	if d.SSLExpiresAt.Valid {
 		sslExpiresAt = &d.SSLExpiresAt.Time
 	}
+	if d.SSLChallenge.Valid {
+		sslChallenge = &d.SSLChallenge.String
+	}
 	if d.VerifiedAt.Valid {
 		verifiedAt = &d.VerifiedAt.Time
 	}
+	if d.StatusReason.Valid {
+		statusReason = &d.StatusReason.String
+	}
+	if d.StatusChangedAt.Valid {
+		statusChangedAt = &d.StatusChangedAt.Time
+	}
+
+	subdomains := d.Subdomains
+	if subdomains == nil {
+		subdomains = []Subdomain{}
+	}
 
 	return DomainResponse{
 		ID:              d.ID,
@@ -68,23 +111,55 @@ func (d *Domain) ToResponse() DomainResponse {
 		DomainName:      d.DomainName,
 		DocumentRoot:    d.DocumentRoot,
 		Status:          d.Status,
+		StatusReason:    statusReason,
+		StatusChangedAt: statusChangedAt,
 		SSLEnabled:      d.SSLEnabled,
 		SSLProvider:     sslProvider,
 		SSLExpiresAt:    sslExpiresAt,
+		SSLChallenge:    sslChallenge,
+		SSLWildcard:     d.SSLWildcard,
 		AutoRenewSSL:    d.AutoRenewSSL,
+		ForceHTTPS:      d.ForceHTTPS,
 		VerifiedAt:      verifiedAt,
 		CreatedAt:       d.CreatedAt,
 		DNSRecordsCount: d.DNSRecordsCount,
 		AliasesCount:    d.AliasesCount,
+		Subdomains:      subdomains,
+	}
+}
+
+// domainColumns is the column list shared by every query returning a Domain.
+// The alias count is a correlated subquery so it stays correct after updates
+const domainColumns = `id, user_id, domain_name, document_root, status, ssl_enabled,
+		       ssl_provider, ssl_expires_at, auto_renew_ssl, verified_at, created_at, updated_at,
+		       verification_token, COALESCE(force_https, true), status_reason, status_changed_at,
+		       ssl_challenge, COALESCE(ssl_wildcard, false), (SELECT COUNT(*) FROM domain_aliases a WHERE a.domain_id = domains.id)`
+
+// scanDomain scans a row selected with domainColumns, followed by any extra
+// columns the query appends
+func scanDomain(row pgx.Row, d *Domain, extra ...any) error {
+	dest := []any{
+		&d.ID, &d.UserID, &d.DomainName, &d.DocumentRoot, &d.Status,
+		&d.SSLEnabled, &d.SSLProvider, &d.SSLExpiresAt, &d.AutoRenewSSL,
+		&d.VerifiedAt, &d.CreatedAt, &d.UpdatedAt, &d.VerifyToken, &d.ForceHTTPS,
+		&d.StatusReason, &d.StatusChangedAt, &d.SSLChallenge, &d.SSLWildcard, &d.AliasesCount,
 	}
+	return row.Scan(append(dest, extra...)...)
 }
 
-// GetDomainsByUserID gets all domains for a user
+// GetDomainsByUserID gets all domains for a user. The DNS record counts
+// come from one grouped join rather than a query per domain
 func GetDomainsByUserID(ctx context.Context, userID int) ([]Domain, error) {
 	query := `
-		SELECT id, user_id, domain_name, document_root, status, ssl_enabled,
-		       ssl_provider, ssl_expires_at, auto_renew_ssl, verified_at, created_at, updated_at
+		SELECT ` + domainColumns + `, COALESCE(rc.records, 0)
 		FROM domains
+		LEFT JOIN (
+			SELECT r.domain_id, COUNT(*) AS records
+			FROM dns_records r
+			JOIN domains rd ON rd.id = r.domain_id
+			WHERE rd.user_id = $1 AND r.alias_id IS NULL
+			GROUP BY r.domain_id
+		) rc ON rc.domain_id = domains.id
 		WHERE user_id = $1
 		ORDER BY created_at DESC
 	`
@@ -98,21 +173,11 @@ func GetDomainsByUserID(ctx context.Context, userID int) ([]Domain, error) {
 	var domains []Domain
 	for rows.Next() {
 		var d Domain
-		err := rows.Scan(
-			&d.ID, &d.UserID, &d.DomainName, &d.DocumentRoot, &d.Status,
-			&d.SSLEnabled, &d.SSLProvider, &d.SSLExpiresAt, &d.AutoRenewSSL,
-			&d.VerifiedAt, &d.CreatedAt, &d.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanDomain(rows, &d, &d.DNSRecordsCount); err != nil {
 			// SECURITY: Log scan errors for debugging, don't silently ignore
 			log.Printf("WARN: Failed to scan domain row: %v", err)
 			continue
 		}
-
-		// Get DNS records count
-		countQuery := `SELECT COUNT(*) FROM dns_records WHERE domain_id = $1`
-		database.DB.QueryRow(ctx, countQuery, d.ID).Scan(&d.DNSRecordsCount)
-
 		domains = append(domains, d)
 	}
 
@@ -122,41 +187,96 @@ func GetDomainsByUserID(ctx context.Context, userID int) ([]Domain, error) {
 // GetDomainByID gets a domain by ID for a user
 func GetDomainByID(ctx context.Context, id, userID int) (*Domain, error) {
 	query := `
-		SELECT id, user_id, domain_name, document_root, status, ssl_enabled,
-		       ssl_provider, ssl_expires_at, auto_renew_ssl, verified_at, created_at, updated_at
+		SELECT ` + domainColumns + `
 		FROM domains
 		WHERE id = $1 AND user_id = $2
 	`
 
 	var d Domain
-	err := database.DB.QueryRow(ctx, query, id, userID).Scan(
-		&d.ID, &d.UserID, &d.DomainName, &d.DocumentRoot, &d.Status,
-		&d.SSLEnabled, &d.SSLProvider, &d.SSLExpiresAt, &d.AutoRenewSSL,
-		&d.VerifiedAt, &d.CreatedAt, &d.UpdatedAt,
-	)
+	if err := scanDomain(database.DB.QueryRow(ctx, query, id, userID), &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// GetAllDomains gets every domain regardless of owner, for server-wide jobs
+func GetAllDomains(ctx context.Context) ([]Domain, error) {
+	query := `
+		SELECT ` + domainColumns + `
+		FROM domains
+		ORDER BY domain_name
+	`
+
+	rows, err := database.DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var domains []Domain
+	for rows.Next() {
+		var d Domain
+		if err := scanDomain(rows, &d); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+
+	return domains, rows.Err()
+}
+
+// FindDomainByID gets a domain by ID regardless of owner, for administrative
+// actions
+func FindDomainByID(ctx context.Context, id int) (*Domain, error) {
+	query := `
+		SELECT ` + domainColumns + `
+		FROM domains
+		WHERE id = $1
+	`
+
+	var d Domain
+	if err := scanDomain(database.DB.QueryRow(ctx, query, id), &d); err != nil {
+		return nil, err
+	}
 
 	return &d, nil
 }
 
-// CreateDomain creates a new domain
+// CreateDomain creates a new domain. It fails with ErrHostnameTaken when
+// the name is already used by a domain or an alias
 func CreateDomain(ctx context.Context, userID int, domainName, documentRoot string) (*Domain, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	d, err := InsertDomain(ctx, tx, userID, domainName, documentRoot)
+	if err != nil {
+		return nil, err
+	}
+	return d, tx.Commit(ctx)
+}
+
+// InsertDomain creates a new pending domain using q, which must be a
+// transaction so the name stays claimed until it commits
+func InsertDomain(ctx context.Context, q database.Querier, userID int, domainName, documentRoot string) (*Domain, error) {
+	if err := ClaimHostname(ctx, q, domainName); err != nil {
+		return nil, err
+	}
+
 	query := `
 		INSERT INTO domains (user_id, domain_name, document_root, status)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, user_id, domain_name, document_root, status, ssl_enabled, 
-		          ssl_provider, ssl_expires_at, auto_renew_ssl, verified_at, created_at, updated_at
+		RETURNING ` + domainColumns + `
 	`
 
 	var d Domain
-	err := database.DB.QueryRow(ctx, query, userID, domainName, documentRoot, "pending").Scan(
-		&d.ID, &d.UserID, &d.DomainName, &d.DocumentRoot, &d.Status,
-		&d.SSLEnabled, &d.SSLProvider, &d.SSLExpiresAt, &d.AutoRenewSSL,
-		&d.VerifiedAt, &d.CreatedAt, &d.UpdatedAt,
-	)
-	if err != nil {
+	if err := scanDomain(q.QueryRow(ctx, query, userID, domainName, documentRoot, DomainStatusPending), &d); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrHostnameTaken
+		}
 		return nil, err
 	}
 
@@ -164,15 +284,13 @@ func CreateDomain(ctx context.Context, userID int, domainName, documentRoot stri
 }
 
 // SECURITY: Whitelist of allowed update columns to prevent SQL injection (OWASP A03:2021)
-// Only these columns can be updated via the API - prevents attacker from injecting arbitrary SQL
+// Only these columns can be updated via the API - prevents attacker from injecting arbitrary SQL.
+// SSL state follows the installed certificate and verified_at the verify
+// endpoint, so neither is settable here
 var allowedDomainUpdateColumns = map[string]bool{
 	"document_root":  true,
-	"status":         true,
-	"ssl_enabled":    true,
-	"ssl_provider":   true,
-	"ssl_expires_at": true,
 	"auto_renew_ssl": true,
-	"verified_at":    true,
+	"force_https":    true,
 }
 
 // UpdateDomain updates a domain with validated columns only
@@ -210,23 +328,39 @@ func UpdateDomain(ctx context.Context, id, userID int, updates map[string]interf
 	query := `
 		UPDATE domains SET ` + setClauses + `
 		WHERE id = $` + strconv.Itoa(argCount) + ` AND user_id = $` + strconv.Itoa(argCount+1) + `
-		RETURNING id, user_id, domain_name, document_root, status, ssl_enabled,
-		          ssl_provider, ssl_expires_at, auto_renew_ssl, verified_at, created_at, updated_at
+		RETURNING ` + domainColumns + `
 	`
 
 	var d Domain
-	err := database.DB.QueryRow(ctx, query, args...).Scan(
-		&d.ID, &d.UserID, &d.DomainName, &d.DocumentRoot, &d.Status,
-		&d.SSLEnabled, &d.SSLProvider, &d.SSLExpiresAt, &d.AutoRenewSSL,
-		&d.VerifiedAt, &d.CreatedAt, &d.UpdatedAt,
-	)
-	if err != nil {
+	if err := scanDomain(database.DB.QueryRow(ctx, query, args...), &d); err != nil {
 		return nil, fmt.Errorf("failed to update domain: %w", err)
 	}
 
 	return &d, nil
 }
 
+// SSL providers of a domain certificate
+const (
+	SSLProviderLetsEncrypt = "letsencrypt" // issued over ACME, renewed automatically
+	SSLProviderCustom      = "custom"      // uploaded by the user
+)
+
+// SetDomainCertificate marks SSL as enabled with a freshly installed
+// certificate, recording how it was obtained, whether it covers every
+// subdomain (*.domain) and when it expires. The renewal state is reset, so
+// the next renewal is scheduled afresh
+func SetDomainCertificate(ctx context.Context, id int, provider, challenge string, wildcard bool, expiresAt time.Time) error {
+	query := `
+		UPDATE domains
+		SET ssl_enabled = true, ssl_provider = $1, ssl_challenge = NULLIF($2, ''),
+		    ssl_wildcard = $3, ssl_expires_at = $4, ssl_renew_failures = 0,
+		    ssl_next_renewal_at = NULL, ssl_renewal_error = NULL, updated_at = NOW()
+		WHERE id = $5
+	`
+	_, err := database.DB.Exec(ctx, query, provider, challenge, wildcard, expiresAt, id)
+	return err
+}
+
 // DeleteDomain deletes a domain
 func DeleteDomain(ctx context.Context, id, userID int) (string, error) {
 	query := `DELETE FROM domains WHERE id = $1 AND user_id = $2 RETURNING domain_name`
@@ -235,10 +369,95 @@ func DeleteDomain(ctx context.Context, id, userID int) (string, error) {
 	return domainName, err
 }
 
-// DomainExists checks if a domain name already exists
+// DomainExists checks if a domain name already exists, either as a domain
+// or as an alias of another domain
 func DomainExists(ctx context.Context, domainName string) (bool, error) {
-	query := `SELECT COUNT(*) FROM domains WHERE domain_name = $1`
+	return hostnameExists(ctx, database.DB, domainName)
+}
+
+func hostnameExists(ctx context.Context, q database.Querier, name string) (bool, error) {
+	query := `
+		SELECT (SELECT COUNT(*) FROM domains WHERE domain_name = $1)
+		     + (SELECT COUNT(*) FROM domain_aliases WHERE alias_name = $1)
+	`
 	var count int
-	err := database.DB.QueryRow(ctx, query, domainName).Scan(&count)
+	err := q.QueryRow(ctx, query, name).Scan(&count)
 	return count > 0, err
 }
+
+// ClaimHostname checks inside the transaction of q that no domain or alias
+// uses name, and holds an advisory lock on the name until the transaction
+// ends. domains and domain_aliases have separate unique constraints, so the
+// lock is what stops a concurrent insert of the same name into the other
+// table. The parent names are locked too, shortest first, so claims of a
+// name and of a name above or below it are serialized as well
+func ClaimHostname(ctx context.Context, q database.Querier, name string) error {
+	names := append([]string{name}, parentHostnames(name)...)
+	for i := len(names) - 1; i >= 0; i-- {
+		if _, err := q.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('hostname:' || $1))`, names[i]); err != nil {
+			return err
+		}
+	}
+	exists, err := hostnameExists(ctx, q, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrHostnameTaken
+	}
+	return nil
+}
+
+// hostnameNested reports whether a domain or alias lies above or below name,
+// e.g. example.com or blog.shop.example.com for shop.example.com. Call it
+// after ClaimHostname so the answer holds until the transaction ends
+func hostnameNested(ctx context.Context, q database.Querier, name string) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM domains WHERE domain_name = ANY($1) OR domain_name LIKE '%.' || $2)
+		    OR EXISTS (SELECT 1 FROM domain_aliases WHERE alias_name = ANY($1) OR alias_name LIKE '%.' || $2)
+	`
+	var nested bool
+	err := q.QueryRow(ctx, query, parentHostnames(name), name).Scan(&nested)
+	return nested, err
+}
+
+// parentHostnames returns the names above hostname that could be a zone,
+// longest first: shop.example.com and example.com for
+// blog.shop.example.com
+func parentHostnames(hostname string) []string {
+	labels := strings.Split(hostname, ".")
+	var parents []string
+	for i := 1; i < len(labels)-1; i++ {
+		parents = append(parents, strings.Join(labels[i:], "."))
+	}
+	return parents
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// FindParentDomain returns the registered domain (of any user) that the given
+// hostname falls under, e.g. example.com for blog.example.com
+func FindParentDomain(ctx context.Context, hostname string) (*Domain, error) {
+	candidates := parentHostnames(hostname)
+	if len(candidates) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	query := `
+		SELECT ` + domainColumns + `
+		FROM domains
+		WHERE domain_name = ANY($1)
+		ORDER BY LENGTH(domain_name) DESC
+		LIMIT 1
+	`
+
+	var d Domain
+	if err := scanDomain(database.DB.QueryRow(ctx, query, candidates), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// DomainAlias is an additional hostname (alias / parked domain) that serves
+// the same document root as its parent domain
+type DomainAlias struct {
+	ID              int       `json:"id"`
+	DomainID        int       `json:"domain_id"`
+	AliasName       string    `json:"alias_name"`
+	IncludeInSSL    bool      `json:"include_in_ssl"`
+	CreatedAt       time.Time `json:"created_at"`
+	DNSRecordsCount int       `json:"dns_records_count"`
+}
+
+// GetAliasesByDomainID gets all aliases attached to a domain
+func GetAliasesByDomainID(ctx context.Context, domainID int) ([]DomainAlias, error) {
+	query := `
+		SELECT a.id, a.domain_id, a.alias_name, a.include_in_ssl, a.created_at,
+		       (SELECT COUNT(*) FROM dns_records r WHERE r.alias_id = a.id)
+		FROM domain_aliases a
+		WHERE a.domain_id = $1
+		ORDER BY a.alias_name
+	`
+
+	rows, err := database.DB.Query(ctx, query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []DomainAlias
+	for rows.Next() {
+		var a DomainAlias
+		if err := rows.Scan(&a.ID, &a.DomainID, &a.AliasName, &a.IncludeInSSL, &a.CreatedAt, &a.DNSRecordsCount); err != nil {
+			log.Printf("WARN: Failed to scan domain alias row: %v", err)
+			continue
+		}
+		aliases = append(aliases, a)
+	}
+
+	return aliases, nil
+}
+
+// GetAliasByID gets a single alias belonging to a domain
+func GetAliasByID(ctx context.Context, aliasID, domainID int) (*DomainAlias, error) {
+	query := `
+		SELECT a.id, a.domain_id, a.alias_name, a.include_in_ssl, a.created_at,
+		       (SELECT COUNT(*) FROM dns_records r WHERE r.alias_id = a.id)
+		FROM domain_aliases a
+		WHERE a.id = $1 AND a.domain_id = $2
+	`
+
+	var a DomainAlias
+	err := database.DB.QueryRow(ctx, query, aliasID, domainID).Scan(
+		&a.ID, &a.DomainID, &a.AliasName, &a.IncludeInSSL, &a.CreatedAt, &a.DNSRecordsCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// CreateAlias attaches an alias to a domain and, when serverIP is given,
+// seeds the alias zone with default A records in the same transaction. It
+// fails with ErrHostnameTaken when the name is already used by a domain or
+// an alias, and with ErrHostnameNested when it lies inside or above one
+func CreateAlias(ctx context.Context, domainID int, aliasName string, includeInSSL bool, serverIP string) (*DomainAlias, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := ClaimHostname(ctx, tx, aliasName); err != nil {
+		return nil, err
+	}
+	nested, err := hostnameNested(ctx, tx, aliasName)
+	if err != nil {
+		return nil, err
+	}
+	if nested {
+		return nil, ErrHostnameNested
+	}
+
+	query := `
+		INSERT INTO domain_aliases (domain_id, alias_name, include_in_ssl)
+		VALUES ($1, $2, $3)
+		RETURNING id, domain_id, alias_name, include_in_ssl, created_at
+	`
+
+	var a DomainAlias
+	err = tx.QueryRow(ctx, query, domainID, aliasName, includeInSSL).Scan(
+		&a.ID, &a.DomainID, &a.AliasName, &a.IncludeInSSL, &a.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrHostnameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if serverIP != "" {
+		if err := createDefaultAliasDNSRecords(ctx, tx, &a, serverIP); err != nil {
+			return nil, err
+		}
+	}
+	// Alias zones are served with the domain's serial
+	if _, err := BumpZoneSerial(ctx, tx, domainID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// createDefaultAliasDNSRecords seeds the alias zone. Aliases have no mail or
+// FTP of their own, so only the web records are created
+func createDefaultAliasDNSRecords(ctx context.Context, q database.Querier, a *DomainAlias, serverIP string) error {
+	for _, name := range []string{"@", "www"} {
+		query := `
+			INSERT INTO dns_records (domain_id, alias_id, record_type, name, value)
+			VALUES ($1, $2, 'A', $3, $4)
+		`
+		if _, err := q.Exec(ctx, query, a.DomainID, a.ID, name, serverIP); err != nil {
+			return err
+		}
+		a.DNSRecordsCount++
+	}
+	return nil
+}
+
+// UpdateAliasSSL toggles whether an alias is included as a SAN on the
+// domain certificate
+func UpdateAliasSSL(ctx context.Context, aliasID, domainID int, includeInSSL bool) error {
+	query := `UPDATE domain_aliases SET include_in_ssl = $1 WHERE id = $2 AND domain_id = $3`
+	result, err := database.DB.Exec(ctx, query, includeInSSL, aliasID, domainID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAlias removes an alias; its DNS records are removed by cascade and
+// the domain's zone serial is bumped so the alias zone stops being served
+func DeleteAlias(ctx context.Context, aliasID, domainID int) (string, error) {
+	query := `DELETE FROM domain_aliases WHERE id = $1 AND domain_id = $2 RETURNING alias_name`
+	var aliasName string
+	err := UpdateZone(ctx, domainID, func(q database.Querier) error {
+		return q.QueryRow(ctx, query, aliasID, domainID).Scan(&aliasName)
+	})
+	return aliasName, err
+}
+
+// GetAllAliasNames returns the name of every alias, so zones they serve are
+// not mistaken for orphans
+func GetAllAliasNames(ctx context.Context) ([]string, error) {
+	rows, err := database.DB.Query(ctx, `SELECT alias_name FROM domain_aliases ORDER BY alias_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetAliasDNSRecords gets the DNS records of an alias zone
+func GetAliasDNSRecords(ctx context.Context, aliasID int) ([]DNSRecord, error) {
+	query := `
+		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+		FROM dns_records
+		WHERE alias_id = $1
+		ORDER BY record_type, name
+	`
+
+	rows, err := database.DB.Query(ctx, query, aliasID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []DNSRecord
+	for rows.Next() {
+		var r DNSRecord
+		err := rows.Scan(
+			&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+			&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("WARN: Failed to scan DNS record row: %v", err)
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// GetAliasDNSRecordByID gets a DNS record of an alias zone
+func GetAliasDNSRecordByID(ctx context.Context, recordID, aliasID int) (*DNSRecord, error) {
+	query := `
+		SELECT id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+		FROM dns_records
+		WHERE id = $1 AND alias_id = $2
+	`
+
+	var r DNSRecord
+	err := database.DB.QueryRow(ctx, query, recordID, aliasID).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// InsertAliasDNSRecord adds a record to an alias zone using q. Alias zones
+// are served with the domain's serial, so callers run it through
+// UpdateZone; they are not part of the domain's changelog
+func InsertAliasDNSRecord(ctx context.Context, q database.Querier, a *DomainAlias, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
+	query := `
+		INSERT INTO dns_records (domain_id, alias_id, record_type, name, value, ttl, priority)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+	`
+
+	var r DNSRecord
+	err := q.QueryRow(ctx, query, a.DomainID, a.ID, recordType, name, value, ttl, priority).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// UpdateAliasDNSRecord replaces the fields of a record of an alias zone
+func UpdateAliasDNSRecord(ctx context.Context, q database.Querier, recordID, aliasID int, recordType, name, value string, ttl int, priority *int) (*DNSRecord, error) {
+	query := `
+		UPDATE dns_records
+		SET record_type = $1, name = $2, value = $3, ttl = $4, priority = $5, updated_at = NOW()
+		WHERE id = $6 AND alias_id = $7
+		RETURNING id, domain_id, record_type, name, value, ttl, priority, created_at, updated_at
+	`
+
+	var r DNSRecord
+	err := q.QueryRow(ctx, query, recordType, name, value, ttl, priority, recordID, aliasID).Scan(
+		&r.ID, &r.DomainID, &r.RecordType, &r.Name, &r.Value,
+		&r.TTL, &r.Priority, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// DeleteAliasDNSRecord deletes a record of an alias zone
+func DeleteAliasDNSRecord(ctx context.Context, q database.Querier, recordID, aliasID int) error {
+	result, err := q.Exec(ctx, `DELETE FROM dns_records WHERE id = $1 AND alias_id = $2`, recordID, aliasID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetSSLHostnames returns every hostname the domain certificate must cover:
+// the apex, its www host and each alias (plus its www host) flagged for SSL
+func GetSSLHostnames(ctx context.Context, d *Domain) ([]string, error) {
+	hostnames := []string{d.DomainName, "www." + d.DomainName}
+
+	aliases, err := GetAliasesByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range aliases {
+		if a.IncludeInSSL {
+			hostnames = append(hostnames, a.AliasName, "www."+a.AliasName)
+		}
+	}
+
+	return hostnames, nil
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Redirect source match types
+const (
+	RedirectMatchExact  = "exact"  // path equals the source
+	RedirectMatchPrefix = "prefix" // path starts with the source
+	RedirectMatchRegex  = "regex"  // path matches the source regular expression
+)
+
+// DomainRedirect is an HTTP redirect rule of a domain. Rules are evaluated
+// in Position order and the first match wins. Exact and prefix rules send
+// every match to the same target; regex rules may use $1-$9 in the target
+type DomainRedirect struct {
+	ID       int `json:"id"`
+	DomainID int `json:"domain_id"`
+	// SourceHost limits the rule to one hostname of the domain (e.g. the www
+	// host); empty matches every hostname
+	SourceHost    string    `json:"source_host"`
+	SourcePath    string    `json:"source_path"`
+	MatchType     string    `json:"match_type"`
+	Target        string    `json:"target"`
+	StatusCode    int       `json:"status_code"`
+	PreserveQuery bool      `json:"preserve_query"`
+	Position      int       `json:"position"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const redirectColumns = `id, domain_id, COALESCE(source_host, ''), source_path, match_type, target,
+		       status_code, COALESCE(preserve_query, true), position, created_at, updated_at`
+
+func scanRedirect(row pgx.Row, r *DomainRedirect) error {
+	return row.Scan(&r.ID, &r.DomainID, &r.SourceHost, &r.SourcePath, &r.MatchType, &r.Target,
+		&r.StatusCode, &r.PreserveQuery, &r.Position, &r.CreatedAt, &r.UpdatedAt)
+}
+
+// GetRedirectsByDomainID returns the redirect rules of a domain in
+// evaluation order
+func GetRedirectsByDomainID(ctx context.Context, domainID int) ([]DomainRedirect, error) {
+	query := `
+		SELECT ` + redirectColumns + `
+		FROM domain_redirects
+		WHERE domain_id = $1
+		ORDER BY position, id
+	`
+
+	rows, err := database.DB.Query(ctx, query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var redirects []DomainRedirect
+	for rows.Next() {
+		var r DomainRedirect
+		if err := scanRedirect(rows, &r); err != nil {
+			log.Printf("WARN: Failed to scan redirect row: %v", err)
+			continue
+		}
+		redirects = append(redirects, r)
+	}
+
+	return redirects, nil
+}
+
+// GetRedirectByID returns a redirect rule of a domain
+func GetRedirectByID(ctx context.Context, id, domainID int) (*DomainRedirect, error) {
+	query := `
+		SELECT ` + redirectColumns + `
+		FROM domain_redirects
+		WHERE id = $1 AND domain_id = $2
+	`
+
+	var r DomainRedirect
+	if err := scanRedirect(database.DB.QueryRow(ctx, query, id, domainID), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateRedirect appends a redirect rule to the end of the domain's list
+func CreateRedirect(ctx context.Context, r *DomainRedirect) (*DomainRedirect, error) {
+	query := `
+		INSERT INTO domain_redirects
+			(domain_id, source_host, source_path, match_type, target, status_code, preserve_query, position)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM domain_redirects WHERE domain_id = $1))
+		RETURNING ` + redirectColumns
+
+	var created DomainRedirect
+	err := scanRedirect(database.DB.QueryRow(ctx, query,
+		r.DomainID, r.SourceHost, r.SourcePath, r.MatchType, r.Target, r.StatusCode, r.PreserveQuery,
+	), &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateRedirect replaces the fields of a redirect rule, keeping its position
+func UpdateRedirect(ctx context.Context, r *DomainRedirect) (*DomainRedirect, error) {
+	query := `
+		UPDATE domain_redirects
+		SET source_host = NULLIF($1, ''), source_path = $2, match_type = $3, target = $4,
+		    status_code = $5, preserve_query = $6, updated_at = NOW()
+		WHERE id = $7 AND domain_id = $8
+		RETURNING ` + redirectColumns
+
+	var updated DomainRedirect
+	err := scanRedirect(database.DB.QueryRow(ctx, query,
+		r.SourceHost, r.SourcePath, r.MatchType, r.Target, r.StatusCode, r.PreserveQuery, r.ID, r.DomainID,
+	), &updated)
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteRedirect deletes a redirect rule
+func DeleteRedirect(ctx context.Context, id, domainID int) error {
+	tag, err := database.DB.Exec(ctx, `DELETE FROM domain_redirects WHERE id = $1 AND domain_id = $2`, id, domainID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("redirect %d not found", id)
+	}
+	return nil
+}
+
+// ReorderRedirects sets the evaluation order of a domain's redirect rules.
+// ids must list every rule of the domain exactly once
+func ReorderRedirects(ctx context.Context, domainID int, ids []int) error {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM domain_redirects WHERE domain_id = $1`, domainID).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(ids) {
+		return fmt.Errorf("expected %d redirect IDs, got %d", count, len(ids))
+	}
+
+	seen := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("redirect %d listed twice", id)
+		}
+		seen[id] = true
+
+		tag, err := tx.Exec(ctx,
+			`UPDATE domain_redirects SET position = $1, updated_at = NOW() WHERE id = $2 AND domain_id = $3`,
+			i+1, id, domainID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("redirect %d not found", id)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// Domain statuses
+const (
+	DomainStatusPending       = "pending"
+	DomainStatusActive        = "active"
+	DomainStatusSuspended     = "suspended"
+	DomainStatusMaintenance   = "maintenance"
+	DomainStatusMisconfigured = "misconfigured"
+)
+
+// ErrInvalidStatusTransition is returned for a status change the state
+// machine does not allow
+var ErrInvalidStatusTransition = errors.New("invalid domain status transition")
+
+// domainStatusTransitions lists the statuses each status may move to.
+// Suspension is reachable from everywhere and only left via an admin
+var domainStatusTransitions = map[string][]string{
+	DomainStatusPending:       {DomainStatusActive, DomainStatusSuspended},
+	DomainStatusActive:        {DomainStatusMaintenance, DomainStatusMisconfigured, DomainStatusSuspended},
+	DomainStatusMaintenance:   {DomainStatusActive, DomainStatusSuspended},
+	DomainStatusMisconfigured: {DomainStatusActive, DomainStatusMaintenance, DomainStatusSuspended},
+	DomainStatusSuspended:     {DomainStatusActive, DomainStatusPending},
+}
+
+// CanTransitionDomainStatus reports whether a domain may move between two
+// statuses
+func CanTransitionDomainStatus(from, to string) bool {
+	for _, allowed := range domainStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanUserTransitionDomainStatus reports whether a domain owner may make the
+// change themselves: only entering and leaving maintenance. Everything else
+// is driven by verification, the monitor or an administrator
+func CanUserTransitionDomainStatus(from, to string) bool {
+	if !CanTransitionDomainStatus(from, to) {
+		return false
+	}
+	return to == DomainStatusMaintenance || (from == DomainStatusMaintenance && to == DomainStatusActive)
+}
+
+// DomainStatusChange is one entry of a domain's status history
+type DomainStatusChange struct {
+	ID        int       `json:"id"`
+	DomainID  int       `json:"domain_id"`
+	OldStatus *string   `json:"old_status"`
+	NewStatus string    `json:"new_status"`
+	Reason    *string   `json:"reason"`
+	ChangedBy *int      `json:"changed_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChangeDomainStatus moves a domain to a new status, enforcing the state
+// machine, and records the reason and the change in its history in one
+// transaction. actorID is nil for system changes. It reports whether
+// anything changed
+func ChangeDomainStatus(ctx context.Context, domainID int, newStatus, reason string, actorID *int) (bool, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldStatus string
+	if err := tx.QueryRow(ctx, `SELECT status FROM domains WHERE id = $1 FOR UPDATE`, domainID).Scan(&oldStatus); err != nil {
+		return false, err
+	}
+	if oldStatus == newStatus {
+		return false, nil
+	}
+	if !CanTransitionDomainStatus(oldStatus, newStatus) {
+		return false, fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, oldStatus, newStatus)
+	}
+
+	if err := setDomainStatus(ctx, tx, domainID, oldStatus, newStatus, reason, actorID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// setDomainStatus writes a new status with its reason and timestamp and
+// records the change in the history
+func setDomainStatus(ctx context.Context, q database.Querier, domainID int, oldStatus, newStatus, reason string, actorID *int) error {
+	query := `
+		UPDATE domains
+		SET status = $1, status_reason = NULLIF($2, ''), status_changed_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`
+	if _, err := q.Exec(ctx, query, newStatus, reason, domainID); err != nil {
+		return err
+	}
+	return recordStatusChange(ctx, q, domainID, oldStatus, newStatus, reason, actorID)
+}
+
+// recordStatusChange inserts a status history entry
+func recordStatusChange(ctx context.Context, q database.Querier, domainID int, oldStatus, newStatus, reason string, actorID *int) error {
+	query := `
+		INSERT INTO domain_status_history (domain_id, old_status, new_status, reason, changed_by)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5)
+	`
+	_, err := q.Exec(ctx, query, domainID, oldStatus, newStatus, reason, actorID)
+	return err
+}
+
+// GetDomainStatusHistory returns the most recent status changes of a domain
+func GetDomainStatusHistory(ctx context.Context, domainID, limit int) ([]DomainStatusChange, error) {
+	query := `
+		SELECT id, domain_id, old_status, new_status, reason, changed_by, created_at
+		FROM domain_status_history
+		WHERE domain_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := database.DB.Query(ctx, query, domainID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []DomainStatusChange
+	for rows.Next() {
+		var h DomainStatusChange
+		if err := rows.Scan(&h.ID, &h.DomainID, &h.OldStatus, &h.NewStatus, &h.Reason, &h.ChangedBy, &h.CreatedAt); err != nil {
+			log.Printf("WARN: Failed to scan domain status history row: %v", err)
+			continue
+		}
+		history = append(history, h)
+	}
+
+	return history, nil
+}
+
+// MonitoredDomain is a domain due for a background DNS check
+type MonitoredDomain struct {
+	Domain
+	CheckFailures int
+}
+
+// GetDomainsDueForCheck returns domains whose next background check is due,
+// oldest schedule first
+func GetDomainsDueForCheck(ctx context.Context, limit int) ([]MonitoredDomain, error) {
+	query := `
+		SELECT ` + domainColumns + `, COALESCE(check_failures, 0)
+		FROM domains
+		WHERE status IN ($1, $2, $3)
+		AND (next_check_at IS NULL OR next_check_at <= NOW())
+		ORDER BY next_check_at ASC NULLS FIRST
+		LIMIT $4
+	`
+
+	rows, err := database.DB.Query(ctx, query,
+		DomainStatusPending, DomainStatusActive, DomainStatusMisconfigured, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []MonitoredDomain
+	for rows.Next() {
+		var d MonitoredDomain
+		if err := scanDomain(rows, &d.Domain, &d.CheckFailures); err != nil {
+			log.Printf("WARN: Failed to scan monitored domain row: %v", err)
+			continue
+		}
+		domains = append(domains, d)
+	}
+
+	return domains, nil
+}
+
+// ScheduleDomainCheck stores the result of a background check and when the
+// next one is due
+func ScheduleDomainCheck(ctx context.Context, domainID, failures int, nextCheck time.Time) error {
+	query := `
+		UPDATE domains
+		SET last_checked_at = NOW(), check_failures = $1, next_check_at = $2
+		WHERE id = $3
+	`
+	_, err := database.DB.Exec(ctx, query, failures, nextCheck, domainID)
+	return err
+}
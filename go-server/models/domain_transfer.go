@@ -0,0 +1,280 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Domain transfer statuses
+const (
+	TransferStatusPending   = "pending"
+	TransferStatusAccepted  = "accepted"
+	TransferStatusDeclined  = "declined"
+	TransferStatusCancelled = "cancelled"
+	TransferStatusExpired   = "expired"
+)
+
+// Domain transfer audit events
+const (
+	TransferEventInitiated  = "initiated"
+	TransferEventCancelled  = "cancelled"
+	TransferEventDeclined   = "declined"
+	TransferEventExpired    = "expired"
+	TransferEventFilesMoved = "files_moved"
+	TransferEventAccepted   = "accepted"
+)
+
+// ErrTransferNotPending is returned when a transfer was already completed,
+// cancelled or declined
+var ErrTransferNotPending = errors.New("transfer is no longer pending")
+
+// DomainTransfer moves a domain from one user to another once the
+// recipient accepts with the transfer token
+type DomainTransfer struct {
+	ID          int                   `json:"id"`
+	DomainID    *int                  `json:"domain_id"`
+	DomainName  string                `json:"domain_name"`
+	FromUserID  *int                  `json:"from_user_id"`
+	ToUserID    *int                  `json:"to_user_id"`
+	ToEmail     string                `json:"to_email"`
+	MoveFiles   bool                  `json:"move_files"`
+	Status      string                `json:"status"`
+	ExpiresAt   time.Time             `json:"expires_at"`
+	CreatedAt   time.Time             `json:"created_at"`
+	CompletedAt *time.Time            `json:"completed_at"`
+	Events      []DomainTransferEvent `json:"events,omitempty"`
+}
+
+// DomainTransferEvent is one entry of a transfer's audit trail
+type DomainTransferEvent struct {
+	ID          int       `json:"id"`
+	TransferID  int       `json:"transfer_id"`
+	ActorUserID *int      `json:"actor_user_id"`
+	Event       string    `json:"event"`
+	Details     *string   `json:"details"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const transferColumns = `id, domain_id, domain_name, from_user_id, to_user_id, to_email,
+		       move_files, status, expires_at, created_at, completed_at`
+
+func scanTransfer(row pgx.Row, t *DomainTransfer) error {
+	return row.Scan(&t.ID, &t.DomainID, &t.DomainName, &t.FromUserID, &t.ToUserID, &t.ToEmail,
+		&t.MoveFiles, &t.Status, &t.ExpiresAt, &t.CreatedAt, &t.CompletedAt)
+}
+
+// queryTransfers runs a transfer query and collects the rows
+func queryTransfers(ctx context.Context, query string, args ...interface{}) ([]DomainTransfer, error) {
+	rows, err := database.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []DomainTransfer
+	for rows.Next() {
+		var t DomainTransfer
+		if err := scanTransfer(rows, &t); err != nil {
+			log.Printf("WARN: Failed to scan domain transfer row: %v", err)
+			continue
+		}
+		transfers = append(transfers, t)
+	}
+
+	return transfers, nil
+}
+
+// CreateDomainTransfer starts a transfer, cancelling any transfer of the
+// same domain that is still pending
+func CreateDomainTransfer(ctx context.Context, t *DomainTransfer, tokenHash string) (*DomainTransfer, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE domain_transfers SET status = $1, completed_at = NOW()
+		WHERE domain_id = $2 AND status = $3
+		RETURNING id
+	`, TransferStatusCancelled, t.DomainID, TransferStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	var superseded []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		superseded = append(superseded, id)
+	}
+	rows.Close()
+	for _, id := range superseded {
+		if err := RecordTransferEvent(ctx, tx, id, t.FromUserID, TransferEventCancelled, "Superseded by a new transfer"); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		INSERT INTO domain_transfers
+			(domain_id, domain_name, from_user_id, to_user_id, to_email, token_hash, move_files, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + transferColumns
+
+	var created DomainTransfer
+	err = scanTransfer(tx.QueryRow(ctx, query,
+		t.DomainID, t.DomainName, t.FromUserID, t.ToUserID, t.ToEmail, tokenHash, t.MoveFiles, t.ExpiresAt,
+	), &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RecordTransferEvent(ctx, tx, created.ID, t.FromUserID, TransferEventInitiated, "Transfer to "+t.ToEmail); err != nil {
+		return nil, err
+	}
+
+	return &created, tx.Commit(ctx)
+}
+
+// GetTransferByID returns a transfer
+func GetTransferByID(ctx context.Context, id int) (*DomainTransfer, error) {
+	query := `SELECT ` + transferColumns + ` FROM domain_transfers WHERE id = $1`
+
+	var t DomainTransfer
+	if err := scanTransfer(database.DB.QueryRow(ctx, query, id), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTransfersByDomainID returns every transfer of a domain with its audit
+// trail, newest first
+func GetTransfersByDomainID(ctx context.Context, domainID int) ([]DomainTransfer, error) {
+	query := `
+		SELECT ` + transferColumns + `
+		FROM domain_transfers
+		WHERE domain_id = $1
+		ORDER BY created_at DESC
+	`
+
+	transfers, err := queryTransfers(ctx, query, domainID)
+	if err != nil {
+		return nil, err
+	}
+	return transfers, attachTransferEvents(ctx, transfers)
+}
+
+// GetIncomingTransfers returns the pending, unexpired transfers addressed to
+// a user
+func GetIncomingTransfers(ctx context.Context, userID int) ([]DomainTransfer, error) {
+	query := `
+		SELECT ` + transferColumns + `
+		FROM domain_transfers
+		WHERE to_user_id = $1 AND status = $2 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`
+	return queryTransfers(ctx, query, userID, TransferStatusPending)
+}
+
+// attachTransferEvents loads the audit trail of several transfers in one query
+func attachTransferEvents(ctx context.Context, transfers []DomainTransfer) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(transfers))
+	index := make(map[int]int, len(transfers))
+	for i, t := range transfers {
+		ids[i] = t.ID
+		index[t.ID] = i
+	}
+
+	rows, err := database.DB.Query(ctx, `
+		SELECT id, transfer_id, actor_user_id, event, details, created_at
+		FROM domain_transfer_events
+		WHERE transfer_id = ANY($1)
+		ORDER BY created_at, id
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e DomainTransferEvent
+		if err := rows.Scan(&e.ID, &e.TransferID, &e.ActorUserID, &e.Event, &e.Details, &e.CreatedAt); err != nil {
+			log.Printf("WARN: Failed to scan domain transfer event row: %v", err)
+			continue
+		}
+		i := index[e.TransferID]
+		transfers[i].Events = append(transfers[i].Events, e)
+	}
+
+	return nil
+}
+
+// RecordTransferEvent appends an entry to a transfer's audit trail
+func RecordTransferEvent(ctx context.Context, q database.Querier, transferID int, actorID *int, event, details string) error {
+	query := `
+		INSERT INTO domain_transfer_events (transfer_id, actor_user_id, event, details)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+	`
+	_, err := q.Exec(ctx, query, transferID, actorID, event, details)
+	return err
+}
+
+// CloseDomainTransfer moves a pending transfer to a final status and records
+// the matching audit event
+func CloseDomainTransfer(ctx context.Context, q database.Querier, transferID int, status string, actorID *int, event, details string) error {
+	tag, err := q.Exec(ctx, `
+		UPDATE domain_transfers SET status = $1, completed_at = NOW()
+		WHERE id = $2 AND status = $3
+	`, status, transferID, TransferStatusPending)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTransferNotPending
+	}
+	return RecordTransferEvent(ctx, q, transferID, actorID, event, details)
+}
+
+// LockTransferByTokenHash loads a transfer by its token hash and locks it
+// for the rest of the transaction
+func LockTransferByTokenHash(ctx context.Context, q database.Querier, tokenHash string) (*DomainTransfer, error) {
+	query := `SELECT ` + transferColumns + ` FROM domain_transfers WHERE token_hash = $1 FOR UPDATE`
+
+	var t DomainTransfer
+	if err := scanTransfer(q.QueryRow(ctx, query, tokenHash), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ChangeDomainOwner hands a domain to a new user. DNS records, aliases,
+// subdomains and redirects hang off the domain and move with it. DynDNS
+// update tokens belong to the previous owner's clients and are revoked. It
+// fails if the domain no longer belongs to fromUserID
+func ChangeDomainOwner(ctx context.Context, q database.Querier, domainID, fromUserID, toUserID int) (*Domain, error) {
+	query := `
+		UPDATE domains SET user_id = $1, updated_at = NOW()
+		WHERE id = $2 AND user_id = $3
+		RETURNING ` + domainColumns
+
+	var d Domain
+	if err := scanDomain(q.QueryRow(ctx, query, toUserID, domainID, fromUserID), &d); err != nil {
+		return nil, err
+	}
+	if _, err := q.Exec(ctx, `DELETE FROM dns_update_tokens WHERE domain_id = $1`, domainID); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
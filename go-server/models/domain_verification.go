@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// DomainVerificationAttempt records one ownership verification attempt
+type DomainVerificationAttempt struct {
+	ID        int            `json:"id"`
+	DomainID  int            `json:"domain_id"`
+	Method    string         `json:"method"`
+	Success   bool           `json:"success"`
+	Details   sql.NullString `json:"-"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// DomainVerificationAttemptResponse is the API response structure
+type DomainVerificationAttemptResponse struct {
+	ID        int       `json:"id"`
+	Method    string    `json:"method"`
+	Success   bool      `json:"success"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ToResponse converts DomainVerificationAttempt to its response structure
+func (a *DomainVerificationAttempt) ToResponse() DomainVerificationAttemptResponse {
+	return DomainVerificationAttemptResponse{
+		ID:        a.ID,
+		Method:    a.Method,
+		Success:   a.Success,
+		Details:   a.Details.String,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+// SetVerificationToken stores the ownership token of a domain, keeping an
+// existing token so instructions already given to the user stay valid
+func SetVerificationToken(ctx context.Context, domainID int, token string) (string, error) {
+	query := `
+		UPDATE domains SET verification_token = COALESCE(verification_token, $1)
+		WHERE id = $2
+		RETURNING verification_token
+	`
+	var stored string
+	err := database.DB.QueryRow(ctx, query, token, domainID).Scan(&stored)
+	return stored, err
+}
+
+// RecordVerificationAttempt logs a verification attempt
+func RecordVerificationAttempt(ctx context.Context, domainID int, method string, success bool, details string) error {
+	query := `
+		INSERT INTO domain_verification_attempts (domain_id, method, success, details)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := database.DB.Exec(ctx, query, domainID, method, success, details)
+	return err
+}
+
+// GetVerificationAttempts returns the most recent verification attempts
+func GetVerificationAttempts(ctx context.Context, domainID, limit int) ([]DomainVerificationAttempt, error) {
+	query := `
+		SELECT id, domain_id, method, success, details, created_at
+		FROM domain_verification_attempts
+		WHERE domain_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := database.DB.Query(ctx, query, domainID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []DomainVerificationAttempt
+	for rows.Next() {
+		var a DomainVerificationAttempt
+		if err := rows.Scan(&a.ID, &a.DomainID, &a.Method, &a.Success, &a.Details, &a.CreatedAt); err != nil {
+			log.Printf("WARN: Failed to scan verification attempt row: %v", err)
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, nil
+}
+
+// MarkDomainVerified records a successful verification: verified_at is set
+// and a pending or misconfigured domain becomes active
+func MarkDomainVerified(ctx context.Context, domainID int, reason string) error {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldStatus string
+	if err := tx.QueryRow(ctx, `SELECT status FROM domains WHERE id = $1 FOR UPDATE`, domainID).Scan(&oldStatus); err != nil {
+		return err
+	}
+
+	query := `UPDATE domains SET verified_at = NOW(), check_failures = 0, updated_at = NOW() WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, domainID); err != nil {
+		return err
+	}
+
+	if oldStatus == DomainStatusPending || oldStatus == DomainStatusMisconfigured {
+		if err := setDomainStatus(ctx, tx, domainID, oldStatus, DomainStatusActive, reason, nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
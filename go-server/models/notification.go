@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// Notification types
+const (
+	NotificationSSLRenewalFailed = "ssl_renewal_failed"
+	NotificationSSLRenewed       = "ssl_renewed"
+)
+
+// Notification is a message for a user about something that happened in
+// the background
+type Notification struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	DomainID  *int       `json:"domain_id"`
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Message   string     `json:"message"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateNotification adds a notification for a user. domainID is nil when
+// it is not about a domain
+func CreateNotification(ctx context.Context, userID int, domainID *int, notificationType, title, message string) error {
+	query := `
+		INSERT INTO notifications (user_id, domain_id, type, title, message)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := database.DB.Exec(ctx, query, userID, domainID, notificationType, title, message)
+	return err
+}
+
+// GetNotificationsByUserID returns the latest notifications of a user,
+// newest first, optionally only the unread ones
+func GetNotificationsByUserID(ctx context.Context, userID int, unreadOnly bool, limit int) ([]Notification, error) {
+	query := `
+		SELECT id, user_id, domain_id, type, title, message, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+	rows, err := database.DB.Query(ctx, query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.DomainID, &n.Type, &n.Title, &n.Message, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// CountUnreadNotifications counts the unread notifications of a user
+func CountUnreadNotifications(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+	var count int
+	err := database.DB.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks a notification of a user as read
+func MarkNotificationRead(ctx context.Context, id, userID int) error {
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, NOW()) WHERE id = $1 AND user_id = $2`
+	result, err := database.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of a user as
+// read and returns how many there were
+func MarkAllNotificationsRead(ctx context.Context, userID int) (int64, error) {
+	query := `UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`
+	result, err := database.DB.Exec(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
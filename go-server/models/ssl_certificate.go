@@ -0,0 +1,295 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// Certificate statuses, derived from the domain and the expiry date
+const (
+	SSLCertificateActive   = "active"
+	SSLCertificateExpiring = "expiring" // expires within SSLExpiringDays
+	SSLCertificateExpired  = "expired"
+	SSLCertificateDisabled = "disabled" // SSL was turned off for the domain
+)
+
+// SSLExpiringDays is how close to its expiry a certificate counts as
+// expiring
+const SSLExpiringDays = 30
+
+// SSLCertificateSortFields are the fields the inventory can be sorted by
+var SSLCertificateSortFields = []string{"domain", "not_before", "not_after", "issuer", "provider", "key_type"}
+
+var sslCertificateSortColumns = map[string]string{
+	"domain":     "d.domain_name",
+	"not_before": "c.not_before",
+	"not_after":  "c.not_after",
+	"issuer":     "c.issuer",
+	"provider":   "c.provider",
+	"key_type":   "c.key_type",
+}
+
+// sslCertificateStatus computes the status of an inventory row
+var sslCertificateStatus = `
+	CASE
+		WHEN NOT COALESCE(d.ssl_enabled, false) THEN '` + SSLCertificateDisabled + `'
+		WHEN c.not_after <= NOW() THEN '` + SSLCertificateExpired + `'
+		WHEN c.not_after < NOW() + INTERVAL '` + strconv.Itoa(SSLExpiringDays) + ` days' THEN '` + SSLCertificateExpiring + `'
+		ELSE '` + SSLCertificateActive + `'
+	END`
+
+// SSLCertificate is the certificate installed for a domain, as recorded in
+// the certificate inventory
+type SSLCertificate struct {
+	ID                int       `json:"id"`
+	DomainID          int       `json:"domain_id"`
+	DomainName        string    `json:"domain_name"`
+	SANs              []string  `json:"sans"`
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	KeyType           string    `json:"key_type"`
+	Provider          string    `json:"provider"`
+	Wildcard          bool      `json:"wildcard"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	AutoRenew         bool      `json:"auto_renew"`
+	Status            string    `json:"status"`
+	DaysUntilExpiry   int       `json:"days_until_expiry"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+const sslCertificateColumns = `
+	c.id, c.domain_id, d.domain_name, c.sans, COALESCE(c.subject, ''), COALESCE(c.issuer, ''),
+	c.serial_number, c.fingerprint_sha256, COALESCE(c.key_type, ''), c.provider,
+	COALESCE(c.wildcard, false), c.not_before, c.not_after, COALESCE(d.auto_renew_ssl, false),
+	c.created_at, c.updated_at`
+
+// UpsertSSLCertificate records the certificate now installed for a domain,
+// replacing the previous one
+func UpsertSSLCertificate(ctx context.Context, c *SSLCertificate) error {
+	query := `
+		INSERT INTO ssl_certificates (domain_id, sans, subject, issuer, serial_number, fingerprint_sha256,
+		                              key_type, provider, wildcard, not_before, not_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (domain_id) DO UPDATE
+		SET sans = EXCLUDED.sans, subject = EXCLUDED.subject, issuer = EXCLUDED.issuer,
+		    serial_number = EXCLUDED.serial_number, fingerprint_sha256 = EXCLUDED.fingerprint_sha256,
+		    key_type = EXCLUDED.key_type, provider = EXCLUDED.provider, wildcard = EXCLUDED.wildcard,
+		    not_before = EXCLUDED.not_before, not_after = EXCLUDED.not_after, updated_at = NOW()
+		RETURNING id, created_at, updated_at
+	`
+	return database.DB.QueryRow(ctx, query,
+		c.DomainID, c.SANs, c.Subject, c.Issuer, c.SerialNumber, c.FingerprintSHA256,
+		c.KeyType, c.Provider, c.Wildcard, c.NotBefore, c.NotAfter,
+	).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// SSLCertificateFilter selects and orders inventory rows. Empty fields do
+// not filter; Search matches the domain name or any SAN
+type SSLCertificateFilter struct {
+	Status   string
+	Provider string
+	Search   string
+	// Sort is one of SSLCertificateSortFields, not_after by default
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+// ListSSLCertificates returns a page of the certificate inventory of a user
+// and the number of rows matching the filter
+func ListSSLCertificates(ctx context.Context, userID int, f SSLCertificateFilter) ([]SSLCertificate, int, error) {
+	column, ok := sslCertificateSortColumns[f.Sort]
+	if f.Sort == "" {
+		column, ok = sslCertificateSortColumns["not_after"], true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort field %q", f.Sort)
+	}
+	direction := "ASC"
+	if f.Desc {
+		direction = "DESC"
+	}
+
+	search := ""
+	if f.Search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Search)
+		search = "%" + escaped + "%"
+	}
+
+	where := `
+		FROM ssl_certificates c
+		JOIN domains d ON d.id = c.domain_id
+		WHERE d.user_id = $1
+		AND ($2::text = '' OR ` + sslCertificateStatus + ` = $2)
+		AND ($3::text = '' OR c.provider = $3)
+		AND ($4::text = '' OR d.domain_name ILIKE $4 OR EXISTS (SELECT 1 FROM unnest(c.sans) san WHERE san ILIKE $4))
+	`
+	args := []any{userID, f.Status, f.Provider, search}
+
+	var total int
+	if err := database.DB.QueryRow(ctx, `SELECT COUNT(*) `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + sslCertificateColumns + `, ` + sslCertificateStatus + where + `
+		ORDER BY ` + column + ` ` + direction + `, c.id
+		LIMIT $5 OFFSET $6
+	`
+	rows, err := database.DB.Query(ctx, query, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	certs := []SSLCertificate{}
+	now := time.Now()
+	for rows.Next() {
+		var c SSLCertificate
+		if err := rows.Scan(&c.ID, &c.DomainID, &c.DomainName, &c.SANs, &c.Subject, &c.Issuer,
+			&c.SerialNumber, &c.FingerprintSHA256, &c.KeyType, &c.Provider,
+			&c.Wildcard, &c.NotBefore, &c.NotAfter, &c.AutoRenew,
+			&c.CreatedAt, &c.UpdatedAt, &c.Status); err != nil {
+			return nil, 0, err
+		}
+		c.DaysUntilExpiry = int(c.NotAfter.Sub(now).Hours() / 24)
+		certs = append(certs, c)
+	}
+	return certs, total, rows.Err()
+}
+
+// GetDomainsWithoutCertificateRecord returns domains with SSL enabled that
+// have no inventory row yet, e.g. certificates installed before the
+// inventory existed
+func GetDomainsWithoutCertificateRecord(ctx context.Context) ([]Domain, error) {
+	query := `
+		SELECT ` + domainColumns + `
+		FROM domains
+		WHERE ssl_enabled = true
+		AND NOT EXISTS (SELECT 1 FROM ssl_certificates c WHERE c.domain_id = domains.id)
+		ORDER BY id
+	`
+	rows, err := database.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []Domain
+	for rows.Next() {
+		var d Domain
+		if err := scanDomain(rows, &d); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// SSLStats summarizes the SSL state of a user's domains
+type SSLStats struct {
+	TotalDomains int `json:"totalDomains"`
+	SSLEnabled   int `json:"sslEnabled"`
+	// Secured counts domains with SSL on and a certificate that has not
+	// expired
+	Secured           int     `json:"secured"`
+	ExpiringSoon      int     `json:"expiringSoon"`
+	Expired           int     `json:"expired"`
+	AutoRenew         int     `json:"autoRenew"`
+	RenewalFailing    int     `json:"renewalFailing"`
+	PercentageSecured float64 `json:"percentageSecured"`
+	// NextExpiry is the earliest expiry of a valid certificate
+	NextExpiry *time.Time     `json:"nextExpiry"`
+	ByProvider map[string]int `json:"byProvider"`
+	// ByStatus counts the certificate inventory by status
+	ByStatus map[string]int `json:"byStatus"`
+}
+
+// GetSSLStatsByUserID computes the SSL statistics of a user. An account
+// without domains has all counts zero
+func GetSSLStatsByUserID(ctx context.Context, userID int) (*SSLStats, error) {
+	stats := &SSLStats{
+		ByProvider: map[string]int{},
+		ByStatus: map[string]int{
+			SSLCertificateActive:   0,
+			SSLCertificateExpiring: 0,
+			SSLCertificateExpired:  0,
+			SSLCertificateDisabled: 0,
+		},
+	}
+
+	query := `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE ssl_enabled),
+		       COUNT(*) FILTER (WHERE ssl_enabled AND ssl_expires_at > NOW()),
+		       COUNT(*) FILTER (WHERE ssl_enabled AND ssl_expires_at > NOW()
+		                        AND ssl_expires_at < NOW() + INTERVAL '1 day' * $2),
+		       COUNT(*) FILTER (WHERE ssl_enabled AND ssl_expires_at <= NOW()),
+		       COUNT(*) FILTER (WHERE ssl_enabled AND auto_renew_ssl AND ssl_provider = $3),
+		       COUNT(*) FILTER (WHERE ssl_enabled AND COALESCE(ssl_renew_failures, 0) > 0),
+		       MIN(ssl_expires_at) FILTER (WHERE ssl_enabled AND ssl_expires_at > NOW())
+		FROM domains
+		WHERE user_id = $1
+	`
+	err := database.DB.QueryRow(ctx, query, userID, SSLExpiringDays, SSLProviderLetsEncrypt).Scan(
+		&stats.TotalDomains, &stats.SSLEnabled, &stats.Secured, &stats.ExpiringSoon,
+		&stats.Expired, &stats.AutoRenew, &stats.RenewalFailing, &stats.NextExpiry)
+	if err != nil {
+		return nil, err
+	}
+	if stats.TotalDomains > 0 {
+		percentage := float64(stats.Secured) / float64(stats.TotalDomains) * 100
+		stats.PercentageSecured = float64(int(percentage*10+0.5)) / 10
+	}
+
+	query = `
+		SELECT COALESCE(ssl_provider, 'unknown'), COUNT(*)
+		FROM domains
+		WHERE user_id = $1 AND ssl_enabled = true
+		GROUP BY 1
+	`
+	if err := scanCounts(ctx, stats.ByProvider, query, userID); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + sslCertificateStatus + `, COUNT(*)
+		FROM ssl_certificates c
+		JOIN domains d ON d.id = c.domain_id
+		WHERE d.user_id = $1
+		GROUP BY 1
+	`
+	if err := scanCounts(ctx, stats.ByStatus, query, userID); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// scanCounts fills counts from a query returning (key, count) rows
+func scanCounts(ctx context.Context, counts map[string]int, query string, args ...any) error {
+	rows, err := database.DB.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		counts[key] = count
+	}
+	return rows.Err()
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+)
+
+// SSL renewal triggers
+const (
+	SSLRenewalTriggerAuto   = "auto"   // background renewal worker
+	SSLRenewalTriggerManual = "manual" // POST /ssl/:domainId/renew
+)
+
+// SSLRenewalAttempt is one attempt to renew the certificate of a domain
+type SSLRenewalAttempt struct {
+	ID         int        `json:"id"`
+	DomainID   int        `json:"domain_id"`
+	Trigger    string     `json:"trigger"`
+	Challenge  string     `json:"challenge"`
+	Success    bool       `json:"success"`
+	Error      *string    `json:"error"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+}
+
+// CreateSSLRenewalAttempt records a renewal attempt
+func CreateSSLRenewalAttempt(ctx context.Context, a *SSLRenewalAttempt) error {
+	query := `
+		INSERT INTO ssl_renewal_attempts (domain_id, trigger, challenge, success, error, expires_at, started_at, finished_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	return database.DB.QueryRow(ctx, query,
+		a.DomainID, a.Trigger, a.Challenge, a.Success, a.Error, a.ExpiresAt, a.StartedAt, a.FinishedAt,
+	).Scan(&a.ID)
+}
+
+// GetSSLRenewalAttempts returns the latest renewal attempts of a domain,
+// newest first
+func GetSSLRenewalAttempts(ctx context.Context, domainID, limit int) ([]SSLRenewalAttempt, error) {
+	query := `
+		SELECT id, domain_id, trigger, COALESCE(challenge, ''), success, error, expires_at, started_at, finished_at
+		FROM ssl_renewal_attempts
+		WHERE domain_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+	rows, err := database.DB.Query(ctx, query, domainID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []SSLRenewalAttempt{}
+	for rows.Next() {
+		var a SSLRenewalAttempt
+		if err := rows.Scan(&a.ID, &a.DomainID, &a.Trigger, &a.Challenge, &a.Success,
+			&a.Error, &a.ExpiresAt, &a.StartedAt, &a.FinishedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// RenewalDomain is a domain whose certificate is due for renewal
+type RenewalDomain struct {
+	Domain
+	RenewFailures int
+	NextRenewalAt sql.NullTime
+}
+
+// GetDomainsDueForRenewal returns domains with auto-renewal on whose ACME
+// certificate expires within renewBefore and whose scheduled renewal is
+// due (or not scheduled yet), soonest expiry first
+func GetDomainsDueForRenewal(ctx context.Context, renewBefore time.Duration, limit int) ([]RenewalDomain, error) {
+	query := `
+		SELECT ` + domainColumns + `, COALESCE(ssl_renew_failures, 0), ssl_next_renewal_at
+		FROM domains
+		WHERE ssl_enabled = true AND auto_renew_ssl = true AND ssl_provider = $1
+		AND ssl_expires_at < $2
+		AND (ssl_next_renewal_at IS NULL OR ssl_next_renewal_at <= NOW())
+		ORDER BY ssl_expires_at ASC
+		LIMIT $3
+	`
+
+	rows, err := database.DB.Query(ctx, query, SSLProviderLetsEncrypt, time.Now().Add(renewBefore), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []RenewalDomain
+	for rows.Next() {
+		var d RenewalDomain
+		if err := scanDomain(rows, &d.Domain, &d.RenewFailures, &d.NextRenewalAt); err != nil {
+			log.Printf("WARN: Failed to scan renewal domain row: %v", err)
+			continue
+		}
+		domains = append(domains, d)
+	}
+
+	return domains, nil
+}
+
+// ScheduleSSLRenewal sets when the certificate of a domain is renewed next
+func ScheduleSSLRenewal(ctx context.Context, domainID int, next time.Time) error {
+	query := `UPDATE domains SET ssl_next_renewal_at = $1 WHERE id = $2`
+	_, err := database.DB.Exec(ctx, query, next, domainID)
+	return err
+}
+
+// RecordSSLRenewalFailure stores a failed renewal: the consecutive failure
+// count, the error shown to the user and when to retry
+func RecordSSLRenewalFailure(ctx context.Context, domainID, failures int, next time.Time, renewalErr string) error {
+	query := `
+		UPDATE domains
+		SET ssl_renew_failures = $1, ssl_next_renewal_at = $2, ssl_renewal_error = $3
+		WHERE id = $4
+	`
+	_, err := database.DB.Exec(ctx, query, failures, next, renewalErr, domainID)
+	return err
+}
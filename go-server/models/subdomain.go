@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"cloudku-server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Subdomain is a host under a parent domain with its own document root.
+// Its DNS record lives in the parent zone
+type Subdomain struct {
+	ID           int           `json:"id"`
+	DomainID     int           `json:"domain_id"`
+	Name         string        `json:"name"`
+	FullName     string        `json:"full_name"`
+	DocumentRoot string        `json:"document_root"`
+	DNSRecordID  sql.NullInt32 `json:"-"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// subdomainColumns is the column list shared by every query returning a
+// Subdomain; queries must join domains as d
+const subdomainColumns = `s.id, s.domain_id, s.name, s.name || '.' || d.domain_name,
+		       s.document_root, s.dns_record_id, s.created_at`
+
+func scanSubdomain(row pgx.Row, s *Subdomain) error {
+	return row.Scan(&s.ID, &s.DomainID, &s.Name, &s.FullName, &s.DocumentRoot, &s.DNSRecordID, &s.CreatedAt)
+}
+
+// querySubdomains runs a subdomain query and collects the rows
+func querySubdomains(ctx context.Context, query string, args ...interface{}) ([]Subdomain, error) {
+	rows, err := database.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subdomains []Subdomain
+	for rows.Next() {
+		var s Subdomain
+		if err := scanSubdomain(rows, &s); err != nil {
+			log.Printf("WARN: Failed to scan subdomain row: %v", err)
+			continue
+		}
+		subdomains = append(subdomains, s)
+	}
+
+	return subdomains, nil
+}
+
+// GetSubdomainsByDomainID gets all subdomains of a domain
+func GetSubdomainsByDomainID(ctx context.Context, domainID int) ([]Subdomain, error) {
+	query := `
+		SELECT ` + subdomainColumns + `
+		FROM subdomains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE s.domain_id = $1
+		ORDER BY s.name
+	`
+	return querySubdomains(ctx, query, domainID)
+}
+
+// GetSubdomainsByUserID gets the subdomains of every domain a user owns in
+// a single query, so domain lists don't need one query per domain
+func GetSubdomainsByUserID(ctx context.Context, userID int) ([]Subdomain, error) {
+	query := `
+		SELECT ` + subdomainColumns + `
+		FROM subdomains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE d.user_id = $1
+		ORDER BY s.domain_id, s.name
+	`
+	return querySubdomains(ctx, query, userID)
+}
+
+// GetSubdomainByID gets a subdomain belonging to a domain
+func GetSubdomainByID(ctx context.Context, id, domainID int) (*Subdomain, error) {
+	query := `
+		SELECT ` + subdomainColumns + `
+		FROM subdomains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE s.id = $1 AND s.domain_id = $2
+	`
+
+	var s Subdomain
+	if err := scanSubdomain(database.DB.QueryRow(ctx, query, id, domainID), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// HostRecordExists checks whether the parent zone already has an address
+// or alias record (A, AAAA, CNAME) at the given name
+func HostRecordExists(ctx context.Context, domainID int, name string) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM dns_records
+			WHERE domain_id = $1 AND alias_id IS NULL AND name = $2
+			AND record_type IN ('A', 'AAAA', 'CNAME')
+		)
+	`
+	var exists bool
+	err := database.DB.QueryRow(ctx, query, domainID, name).Scan(&exists)
+	return exists, err
+}
+
+// CreateSubdomain creates a subdomain together with its A or CNAME record in
+// the parent zone, in a single transaction
+func CreateSubdomain(ctx context.Context, actor DNSChangeActor, domainID int, name, documentRoot, recordType, recordValue string) (*Subdomain, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	record, err := InsertDNSRecord(ctx, tx, actor, domainID, recordType, name, recordValue, 3600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int
+	query := `
+		INSERT INTO subdomains (domain_id, name, document_root, dns_record_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	if err := tx.QueryRow(ctx, query, domainID, name, documentRoot, record.ID).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	if _, err := BumpZoneSerial(ctx, tx, domainID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return GetSubdomainByID(ctx, id, domainID)
+}
+
+// UpdateSubdomainDocumentRoot changes the document root of a subdomain
+func UpdateSubdomainDocumentRoot(ctx context.Context, id, domainID int, documentRoot string) error {
+	query := `UPDATE subdomains SET document_root = $1 WHERE id = $2 AND domain_id = $3`
+	result, err := database.DB.Exec(ctx, query, documentRoot, id, domainID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteSubdomain deletes a subdomain and the DNS record created for it,
+// in a single transaction
+func DeleteSubdomain(ctx context.Context, actor DNSChangeActor, id, domainID int) (string, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	var recordID sql.NullInt32
+	query := `DELETE FROM subdomains WHERE id = $1 AND domain_id = $2 RETURNING name, dns_record_id`
+	if err := tx.QueryRow(ctx, query, id, domainID).Scan(&name, &recordID); err != nil {
+		return "", err
+	}
+
+	if recordID.Valid {
+		if err := DeleteDNSRecord(ctx, tx, actor, int(recordID.Int32), domainID); err != nil {
+			return "", err
+		}
+		if _, err := BumpZoneSerial(ctx, tx, domainID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
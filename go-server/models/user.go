@@ -35,6 +35,7 @@ type User struct {
 	GithubID       sql.NullString `json:"-"`
 	EmailVerified  bool           `json:"email_verified"`
 	IsActive       bool           `json:"is_active"`
+	IsAdmin        bool           `json:"is_admin"`
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	LastLogin      sql.NullTime   `json:"last_login"`
@@ -49,6 +50,7 @@ type UserResponse struct {
 	AuthProvider   AuthProvider `json:"auth_provider"`
 	EmailVerified  bool         `json:"email_verified"`
 	IsActive       bool         `json:"is_active"`
+	IsAdmin        bool         `json:"is_admin"`
 	CreatedAt      time.Time    `json:"created_at"`
 }
 
@@ -67,6 +69,7 @@ func (u *User) ToResponse() UserResponse {
 		AuthProvider:   u.AuthProvider,
 		EmailVerified:  u.EmailVerified,
 		IsActive:       u.IsActive,
+		IsAdmin:        u.IsAdmin,
 		CreatedAt:      u.CreatedAt,
 	}
 }
@@ -81,7 +84,7 @@ func FindUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, email, name, password_hash, profile_picture, auth_provider,
 		       google_id, facebook_id, github_id, email_verified, is_active,
-		       created_at, updated_at, last_login
+		       created_at, updated_at, last_login, COALESCE(is_admin, false)
 		FROM users
 		WHERE email = $1
 	`
@@ -92,6 +95,7 @@ func FindUserByEmail(ctx context.Context, email string) (*User, error) {
 		&user.ProfilePicture, &user.AuthProvider, &user.GoogleID,
 		&user.FacebookID, &user.GithubID, &user.EmailVerified,
 		&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLogin,
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -110,7 +114,7 @@ func FindUserByID(ctx context.Context, id int) (*User, error) {
 	query := `
 		SELECT id, email, name, password_hash, profile_picture, auth_provider,
 		       google_id, facebook_id, github_id, email_verified, is_active,
-		       created_at, updated_at, last_login
+		       created_at, updated_at, last_login, COALESCE(is_admin, false)
 		FROM users
 		WHERE id = $1
 	`
@@ -121,6 +125,7 @@ func FindUserByID(ctx context.Context, id int) (*User, error) {
 		&user.ProfilePicture, &user.AuthProvider, &user.GoogleID,
 		&user.FacebookID, &user.GithubID, &user.EmailVerified,
 		&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLogin,
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -139,7 +144,7 @@ func FindUserByGoogleID(ctx context.Context, googleID string) (*User, error) {
 	query := `
 		SELECT id, email, name, password_hash, profile_picture, auth_provider,
 		       google_id, facebook_id, github_id, email_verified, is_active,
-		       created_at, updated_at, last_login
+		       created_at, updated_at, last_login, COALESCE(is_admin, false)
 		FROM users
 		WHERE google_id = $1
 	`
@@ -150,6 +155,7 @@ func FindUserByGoogleID(ctx context.Context, googleID string) (*User, error) {
 		&user.ProfilePicture, &user.AuthProvider, &user.GoogleID,
 		&user.FacebookID, &user.GithubID, &user.EmailVerified,
 		&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLogin,
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -168,7 +174,7 @@ func FindUserByGithubID(ctx context.Context, githubID string) (*User, error) {
 	query := `
 		SELECT id, email, name, password_hash, profile_picture, auth_provider,
 		       google_id, facebook_id, github_id, email_verified, is_active,
-		       created_at, updated_at, last_login
+		       created_at, updated_at, last_login, COALESCE(is_admin, false)
 		FROM users
 		WHERE github_id = $1
 	`
@@ -179,6 +185,7 @@ func FindUserByGithubID(ctx context.Context, githubID string) (*User, error) {
 		&user.ProfilePicture, &user.AuthProvider, &user.GoogleID,
 		&user.FacebookID, &user.GithubID, &user.EmailVerified,
 		&user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLogin,
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -369,3 +376,15 @@ func UpdateEmailVerified(ctx context.Context, userID int, verified bool) error {
 
 	return nil
 }
+
+// GrantAdminByEmail marks the users with the given emails as administrators
+// and returns how many accounts were updated
+func GrantAdminByEmail(ctx context.Context, emails []string) (int64, error) {
+	query := `UPDATE users SET is_admin = true, updated_at = NOW() WHERE email = ANY($1) AND NOT is_admin`
+
+	tag, err := database.DB.Exec(ctx, query, emails)
+	if err != nil {
+		return 0, fmt.Errorf("failed to grant admin: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
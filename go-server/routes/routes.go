@@ -5,7 +5,9 @@ import (
 	"strings"
 	"time"
 
+	"cloudku-server/controllers"
 	v1 "cloudku-server/routes/v1"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -31,6 +33,7 @@ type APIVersion struct {
 //	/health        - Health check (version agnostic)
 //	/api           - API info
 //	/api/versions  - List available API versions
+//	/nic/update    - DynDNS update protocol (update token auth, not JWT)
 //	/api/v1/*      - V1 API endpoints
 //
 // Future versions can be added without breaking existing clients:
@@ -48,6 +51,11 @@ func SetupRoutes(r *gin.Engine) {
 	r.GET("/api", apiInfoHandler)
 	r.GET("/api/versions", apiVersionsHandler)
 
+	// DynDNS update protocol - routers and clients such as ddclient expect
+	// the dyndns2 path on the server root
+	dyndns := controllers.NewDynDNSController(services.NewPowerDNSSyncService())
+	r.GET("/nic/update", dyndns.Update)
+
 	// ==========================================
 	// VERSIONED API ROUTES
 	// ==========================================
@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"cloudku-server/controllers"
+	"cloudku-server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterAdminRoutes sets up administrative routes
+//
+// # All routes require authentication and an administrator account
+//
+// ENDPOINTS:
+//   - POST /admin/domains/:id/suspend   - Suspend domain (reason required)
+//   - POST /admin/domains/:id/unsuspend - Lift suspension
+//   - GET  /admin/dns/drift             - Compare every zone with PowerDNS
+//   - POST /admin/dns/reconcile         - Push drifted zones to PowerDNS (?delete_orphans=true)
+func RegisterAdminRoutes(rg *gin.RouterGroup, ctrl *controllers.AdminController) {
+	admin := rg.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	{
+		// Domain Status
+		admin.POST("/domains/:id/suspend", ctrl.SuspendDomain)
+		admin.POST("/domains/:id/unsuspend", ctrl.UnsuspendDomain)
+
+		// PowerDNS
+		admin.GET("/dns/drift", ctrl.GetDNSDrift)
+		admin.POST("/dns/reconcile", ctrl.ReconcileDNS)
+	}
+}
@@ -12,12 +12,29 @@ import (
 // # All routes require authentication
 //
 // ENDPOINTS:
-//   - GET  /dns/stats                      - Get DNS statistics
-//   - GET  /dns/powerdns/status            - Get PowerDNS server status
-//   - POST /dns/powerdns/reload            - Reload PowerDNS configuration
-//   - GET  /dns/:domainId/records          - Get PowerDNS records for domain
+//   - GET  /dns/stats                      - Get record counts by type, in total and per domain
+//   - GET  /dns/powerdns/status            - Get PowerDNS server status (version, uptime, queries, zones)
+//   - POST /dns/powerdns/reload            - Push the user's zones to PowerDNS
+//   - GET  /dns/templates                  - List template presets and the user's templates
+//   - POST /dns/templates                  - Create a template ({domain}, {server_ip} and custom placeholders)
+//   - GET  /dns/templates/:templateId      - Get a template
+//   - PUT  /dns/templates/:templateId      - Update a template
+//   - DELETE /dns/templates/:templateId    - Delete a template
+//   - GET  /dns/:domainId/records          - Get records for domain, with PowerDNS drift when enabled
 //   - GET  /dns/:domainId/export           - Export zone file
+//   - POST /dns/:domainId/import           - Import BIND zone file (?mode=merge|replace, ?dry_run=true for diff preview)
+//   - GET  /dns/:domainId/history          - Zone changelog: before/after, actor and source per change (?limit=&before=)
+//   - POST /dns/:domainId/rollback         - Restore records to a change_id or point in time (?dry_run=true for the plan)
+//   - GET  /dns/:domainId/propagation      - Compare records with public resolvers and the zone's nameservers (?name=&type=)
+//   - GET  /dns/:domainId/soa              - Get zone SOA (primary NS, hostmaster, timers, serial)
+//   - PUT  /dns/:domainId/soa              - Update zone SOA fields
 //   - POST /dns/:domainId/increment-serial - Increment SOA serial number
+//   - POST /dns/:domainId/templates/apply  - Apply a preset or template (?dry_run=true for preview; conflicts reported)
+//   - GET  /dns/:domainId/dnssec           - Get DNSSEC state, keys and the DS records for the registrar
+//   - POST /dns/:domainId/dnssec/enable    - Enable DNSSEC (generates a KSK and a ZSK)
+//   - POST /dns/:domainId/dnssec/disable   - Disable DNSSEC and delete the keys
+//   - POST /dns/:domainId/dnssec/rollover  - Start a key rollover (key_type: KSK|ZSK)
+//   - POST /dns/:domainId/dnssec/rollover/complete - Retire the old KSK once the new DS is at the registrar
 func RegisterDNSRoutes(rg *gin.RouterGroup, ctrl *controllers.DNSController) {
 	dns := rg.Group("/dns")
 	dns.Use(middleware.AuthMiddleware())
@@ -29,9 +46,30 @@ func RegisterDNSRoutes(rg *gin.RouterGroup, ctrl *controllers.DNSController) {
 		// PowerDNS Management
 		dns.POST("/powerdns/reload", ctrl.ReloadPowerDNS)
 
+		// DNS Templates
+		dns.GET("/templates", ctrl.GetDNSTemplates)
+		dns.POST("/templates", ctrl.CreateDNSTemplate)
+		dns.GET("/templates/:templateId", ctrl.GetDNSTemplate)
+		dns.PUT("/templates/:templateId", ctrl.UpdateDNSTemplate)
+		dns.DELETE("/templates/:templateId", ctrl.DeleteDNSTemplate)
+
 		// Domain-specific DNS Operations
 		dns.GET("/:domainId/records", ctrl.GetPowerDNSRecords)
 		dns.GET("/:domainId/export", ctrl.ExportZone)
+		dns.POST("/:domainId/import", ctrl.ImportZone)
+		dns.GET("/:domainId/history", ctrl.GetDNSHistory)
+		dns.POST("/:domainId/rollback", ctrl.RollbackZone)
+		dns.GET("/:domainId/propagation", ctrl.CheckPropagation)
+		dns.GET("/:domainId/soa", ctrl.GetSOA)
+		dns.PUT("/:domainId/soa", ctrl.UpdateSOA)
 		dns.POST("/:domainId/increment-serial", ctrl.IncrementSOASerial)
+		dns.POST("/:domainId/templates/apply", ctrl.ApplyDNSTemplate)
+
+		// DNSSEC
+		dns.GET("/:domainId/dnssec", ctrl.GetDNSSEC)
+		dns.POST("/:domainId/dnssec/enable", ctrl.EnableDNSSEC)
+		dns.POST("/:domainId/dnssec/disable", ctrl.DisableDNSSEC)
+		dns.POST("/:domainId/dnssec/rollover", ctrl.StartDNSSECRollover)
+		dns.POST("/:domainId/dnssec/rollover/complete", ctrl.CompleteDNSSECRollover)
 	}
 }
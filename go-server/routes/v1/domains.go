@@ -17,12 +17,58 @@ import (
 //   - POST   /domains           - Create new domain
 //   - PUT    /domains/:id       - Update domain
 //   - DELETE /domains/:id       - Delete domain
-//   - POST   /domains/:id/verify - Verify domain DNS
+//   - POST   /domains/import    - Bulk import from CSV/JSON (?format=, ?mode=all_or_nothing|best_effort, ?dry_run=true)
+//   - GET    /domains/export    - Export all domains with DNS records (?format=csv|json)
+//   - POST   /domains/:id/verify - Verify domain ownership (txt, http, dns or auto)
+//   - GET    /domains/:id/verification - Get verification token, instructions and attempts
+//   - GET    /domains/:id/status-history - Get status changes (verification, DNS monitoring)
+//   - POST   /domains/:id/maintenance    - Enable/disable maintenance mode (serves maintenance page)
+//
+// Transfers between users (recipient accepts with the token given to the sender):
+//   - POST   /domains/:id/transfer                  - Start transfer to a user's email
+//   - DELETE /domains/:id/transfer                  - Cancel pending transfer
+//   - GET    /domains/:id/transfers                 - Transfer history and audit trail
+//   - GET    /domains/transfers/incoming            - Pending transfers addressed to me
+//   - POST   /domains/transfers/accept              - Accept transfer with token
+//   - POST   /domains/transfers/:transferId/decline - Decline transfer
+//
+// Web Server:
+//   - GET    /domains/:id/vhost         - Preview rendered vhost config
+//   - POST   /domains/:id/vhost/rebuild - Render, test and install vhost
+//
+// Redirect rules (compiled into the vhost, first match wins):
+//   - GET    /domains/:id/redirects              - Get redirects in evaluation order
+//   - POST   /domains/:id/redirects              - Add redirect
+//   - POST   /domains/:id/redirects/reorder      - Set evaluation order
+//   - PUT    /domains/:id/redirects/:redirectId  - Update redirect
+//   - DELETE /domains/:id/redirects/:redirectId  - Delete redirect
 //
 // DNS Records (nested under domain):
 //   - GET    /domains/:id/dns           - Get DNS records
-//   - POST   /domains/:id/dns           - Create DNS record
+//   - POST   /domains/:id/dns           - Create DNS record (validated per type)
+//   - PUT    /domains/:id/dns/:recordId - Update DNS record
 //   - DELETE /domains/:id/dns/:recordId - Delete DNS record
+//
+// DynDNS update tokens (A/AAAA records; clients call /nic/update with them):
+//   - GET    /domains/:id/dns/:recordId/update-tokens          - Get update tokens
+//   - POST   /domains/:id/dns/:recordId/update-tokens          - Create update token (secret shown once)
+//   - DELETE /domains/:id/dns/:recordId/update-tokens/:tokenId - Revoke update token
+//
+// Aliases / parked domains (nested under domain):
+//   - GET    /domains/:id/aliases                        - Get aliases
+//   - POST   /domains/:id/aliases                        - Add alias
+//   - PUT    /domains/:id/aliases/:aliasId               - Update alias (SSL coverage)
+//   - DELETE /domains/:id/aliases/:aliasId               - Delete alias
+//   - GET    /domains/:id/aliases/:aliasId/dns           - Get alias zone records
+//   - POST   /domains/:id/aliases/:aliasId/dns           - Create alias zone record
+//   - PUT    /domains/:id/aliases/:aliasId/dns/:recordId - Update alias zone record
+//   - DELETE /domains/:id/aliases/:aliasId/dns/:recordId - Delete alias zone record
+//
+// Subdomains (nested under domain, DNS record managed automatically):
+//   - GET    /domains/:id/subdomains              - Get subdomains
+//   - POST   /domains/:id/subdomains              - Create subdomain
+//   - PUT    /domains/:id/subdomains/:subdomainId - Update document root
+//   - DELETE /domains/:id/subdomains/:subdomainId - Delete subdomain
 func RegisterDomainRoutes(rg *gin.RouterGroup, ctrl *controllers.DomainController) {
 	domains := rg.Group("/domains")
 	domains.Use(middleware.AuthMiddleware())
@@ -34,12 +80,60 @@ func RegisterDomainRoutes(rg *gin.RouterGroup, ctrl *controllers.DomainControlle
 		domains.PUT("/:id", ctrl.UpdateDomain)
 		domains.DELETE("/:id", ctrl.DeleteDomain)
 
+		// Bulk Import / Export
+		domains.POST("/import", ctrl.ImportDomains)
+		domains.GET("/export", ctrl.ExportDomains)
+
 		// Domain Verification
 		domains.POST("/:id/verify", ctrl.VerifyDomain)
+		domains.GET("/:id/verification", ctrl.GetVerification)
+		domains.GET("/:id/status-history", ctrl.GetStatusHistory)
+		domains.POST("/:id/maintenance", ctrl.SetMaintenance)
+
+		// Domain Transfers
+		domains.POST("/:id/transfer", ctrl.InitiateTransfer)
+		domains.DELETE("/:id/transfer", ctrl.CancelTransfer)
+		domains.GET("/:id/transfers", ctrl.GetDomainTransfers)
+		domains.GET("/transfers/incoming", ctrl.GetIncomingTransfers)
+		domains.POST("/transfers/accept", ctrl.AcceptTransfer)
+		domains.POST("/transfers/:transferId/decline", ctrl.DeclineTransfer)
+
+		// Web Server Vhost
+		domains.GET("/:id/vhost", ctrl.GetVhostConfig)
+		domains.POST("/:id/vhost/rebuild", ctrl.RebuildVhost)
+
+		// Redirect Rules
+		domains.GET("/:id/redirects", ctrl.GetRedirects)
+		domains.POST("/:id/redirects", ctrl.CreateRedirect)
+		domains.POST("/:id/redirects/reorder", ctrl.ReorderRedirects)
+		domains.PUT("/:id/redirects/:redirectId", ctrl.UpdateRedirect)
+		domains.DELETE("/:id/redirects/:redirectId", ctrl.DeleteRedirect)
 
 		// DNS Records (nested under domain for RESTful design)
 		domains.GET("/:id/dns", ctrl.GetDNSRecords)
 		domains.POST("/:id/dns", ctrl.CreateDNSRecord)
+		domains.PUT("/:id/dns/:recordId", ctrl.UpdateDNSRecord)
 		domains.DELETE("/:id/dns/:recordId", ctrl.DeleteDNSRecord)
+
+		// DynDNS Update Tokens
+		domains.GET("/:id/dns/:recordId/update-tokens", ctrl.GetUpdateTokens)
+		domains.POST("/:id/dns/:recordId/update-tokens", ctrl.CreateUpdateToken)
+		domains.DELETE("/:id/dns/:recordId/update-tokens/:tokenId", ctrl.DeleteUpdateToken)
+
+		// Aliases / Parked Domains
+		domains.GET("/:id/aliases", ctrl.GetAliases)
+		domains.POST("/:id/aliases", ctrl.CreateAlias)
+		domains.PUT("/:id/aliases/:aliasId", ctrl.UpdateAlias)
+		domains.DELETE("/:id/aliases/:aliasId", ctrl.DeleteAlias)
+		domains.GET("/:id/aliases/:aliasId/dns", ctrl.GetAliasDNSRecords)
+		domains.POST("/:id/aliases/:aliasId/dns", ctrl.CreateAliasDNSRecord)
+		domains.PUT("/:id/aliases/:aliasId/dns/:recordId", ctrl.UpdateAliasDNSRecord)
+		domains.DELETE("/:id/aliases/:aliasId/dns/:recordId", ctrl.DeleteAliasDNSRecord)
+
+		// Subdomains
+		domains.GET("/:id/subdomains", ctrl.GetSubdomains)
+		domains.POST("/:id/subdomains", ctrl.CreateSubdomain)
+		domains.PUT("/:id/subdomains/:subdomainId", ctrl.UpdateSubdomain)
+		domains.DELETE("/:id/subdomains/:subdomainId", ctrl.DeleteSubdomain)
 	}
 }
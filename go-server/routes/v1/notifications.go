@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"cloudku-server/controllers"
+	"cloudku-server/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterNotificationRoutes sets up user notification routes, e.g. failed
+// SSL renewals reported by the background worker
+//
+// # All routes require authentication
+//
+// ENDPOINTS:
+//   - GET  /notifications          - List notifications (?unread=true, ?limit)
+//   - POST /notifications/read-all - Mark all notifications as read
+//   - POST /notifications/:id/read - Mark a notification as read
+func RegisterNotificationRoutes(rg *gin.RouterGroup, ctrl *controllers.NotificationController) {
+	notifications := rg.Group("/notifications")
+	notifications.Use(middleware.AuthMiddleware())
+	{
+		notifications.GET("", ctrl.GetNotifications)
+		notifications.POST("/read-all", ctrl.MarkAllNotificationsRead)
+		notifications.POST("/:id/read", ctrl.MarkNotificationRead)
+	}
+}
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"cloudku-server/controllers"
+	"cloudku-server/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,13 +10,19 @@ import (
 // RegisterRoutes sets up all V1 API routes
 // This is the main entry point for V1 versioned API
 func RegisterRoutes(rg *gin.RouterGroup) {
+	// Shared services, created once for every controller
+	vhostService := services.NewVhostService()
+	dnsSync := services.NewPowerDNSSyncService()
+
 	// Initialize all controllers once for better performance
 	authController := controllers.NewAuthController()
 	fileController := controllers.NewFileController()
-	domainController := controllers.NewDomainController()
-	dnsController := controllers.NewDNSController()
-	sslController := controllers.NewSSLController()
+	domainController := controllers.NewDomainController(vhostService, dnsSync)
+	dnsController := controllers.NewDNSController(dnsSync, services.NewDNSSECService())
+	sslController := controllers.NewSSLController(vhostService, dnsSync)
 	databaseController := controllers.NewDatabaseController()
+	adminController := controllers.NewAdminController(vhostService, dnsSync)
+	notificationController := controllers.NewNotificationController()
 
 	// Register route groups - order matters for readability
 	RegisterAuthRoutes(rg, authController)
@@ -24,5 +31,7 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	RegisterDNSRoutes(rg, dnsController)
 	RegisterSSLRoutes(rg, sslController)
 	RegisterDatabaseRoutes(rg, databaseController)
+	RegisterAdminRoutes(rg, adminController)
+	RegisterNotificationRoutes(rg, notificationController)
 	RegisterPlaceholderRoutes(rg)
 }
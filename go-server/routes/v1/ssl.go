@@ -1,8 +1,6 @@
 package v1
 
 import (
-	"net/http"
-
 	"cloudku-server/controllers"
 	"cloudku-server/middleware"
 
@@ -14,21 +12,33 @@ import (
 // # All routes require authentication
 //
 // ENDPOINTS:
-//   - GET  /ssl                    - List all SSL certificates
+//   - GET  /ssl                    - List the certificate inventory
 //   - GET  /ssl/stats              - Get SSL statistics
-//   - GET  /ssl/expiring           - Get expiring certificates
-//   - POST /ssl/:domainId/enable   - Enable SSL for domain
+//   - GET  /ssl/expiring           - Get expiring certificates and failed renewals
+//   - POST /ssl/:domainId/enable   - Issue an ACME certificate and enable SSL
 //   - POST /ssl/:domainId/disable  - Disable SSL for domain
-//   - POST /ssl/:domainId/renew    - Renew SSL certificate
+//   - POST /ssl/:domainId/renew    - Re-issue the ACME certificate
+//   - POST /ssl/:domainId/upload   - Install a custom certificate (PEM)
 //   - GET  /ssl/:domainId/info     - Get SSL certificate info
+//   - GET  /ssl/:domainId/renewals - Get certificate renewal attempts
+//
+// enable and renew accept {"challenge": "http-01" | "dns-01", "wildcard":
+// bool}: HTTP-01 serves the token from the document root, DNS-01 publishes
+// a TXT record in the hosted zone. A wildcard certificate covers *.domain
+// and every subdomain, and is always validated with DNS-01. Renewals
+// default to the challenge and kind used last time.
+// Certificates with auto_renew_ssl are also renewed by a background worker.
+//
+// GET /ssl lists the installed certificates with query params status
+// (active, expiring, expired, disabled), provider, q (domain or SAN),
+// sort (domain, not_before, not_after, issuer, provider, key_type), order
+// (asc, desc), limit (default 50, max 200) and offset
 func RegisterSSLRoutes(rg *gin.RouterGroup, ctrl *controllers.SSLController) {
 	ssl := rg.Group("/ssl")
 	ssl.Use(middleware.AuthMiddleware())
 	{
 		// List & Stats
-		ssl.GET("", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{"success": true, "data": []interface{}{}})
-		})
+		ssl.GET("", ctrl.ListCertificates)
 		ssl.GET("/stats", ctrl.GetSSLStats)
 		ssl.GET("/expiring", ctrl.GetExpiringCertificates)
 
@@ -36,6 +46,8 @@ func RegisterSSLRoutes(rg *gin.RouterGroup, ctrl *controllers.SSLController) {
 		ssl.POST("/:domainId/enable", ctrl.EnableSSL)
 		ssl.POST("/:domainId/disable", ctrl.DisableSSL)
 		ssl.POST("/:domainId/renew", ctrl.RenewSSL)
+		ssl.POST("/:domainId/upload", ctrl.UploadCertificate)
 		ssl.GET("/:domainId/info", ctrl.GetSSLInfo)
+		ssl.GET("/:domainId/renewals", ctrl.GetRenewalHistory)
 	}
 }
@@ -0,0 +1,586 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ACME challenge types
+const (
+	ACMEChallengeHTTP = "http-01" // token file in the document root
+	ACMEChallengeDNS  = "dns-01"  // TXT record in the hosted zone
+)
+
+var (
+	// ErrACMEChallenge is returned for an unsupported challenge type
+	ErrACMEChallenge = errors.New("challenge must be http-01 or dns-01")
+	// ErrACMEInProgress is returned while another order for the same
+	// domain is running
+	ErrACMEInProgress = errors.New("a certificate order for this domain is already in progress")
+	// ErrACMEWildcardChallenge is returned when a wildcard certificate is
+	// requested with a challenge other than dns-01
+	ErrACMEWildcardChallenge = errors.New("wildcard certificates require the dns-01 challenge")
+)
+
+// acmeDNSPollInterval is how often the nameservers are asked for DNS-01
+// records while waiting for them
+const acmeDNSPollInterval = 2 * time.Second
+
+// acmeToken matches ACME challenge tokens (base64url). Tokens become file
+// names, so anything else is refused
+var acmeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ACMEOptions configures an ACMEService
+type ACMEOptions struct {
+	DirectoryURL string
+	Email        string
+	// HTTPClient talks to the ACME directory; nil uses http.DefaultClient
+	HTTPClient *http.Client
+	CertDir    string
+	// Challenge is used when a request does not name one
+	Challenge string
+	// DNSPropagationTimeout bounds the wait for DNS-01 records to be served
+	// by the zone's nameservers, before the CA is asked to look them up
+	DNSPropagationTimeout time.Duration
+	// DNSChecker queries the zone's nameservers for DNS-01 records; nil
+	// resolves the zone's NS host names
+	DNSChecker *DNSPropagationService
+}
+
+// ACMERequest selects how a certificate is obtained. Empty fields keep the
+// settings of the current certificate or fall back to the defaults
+type ACMERequest struct {
+	// Challenge is http-01 or dns-01
+	Challenge string
+	// Wildcard requests *.domain in place of www.domain, covering every
+	// subdomain; it needs dns-01
+	Wildcard *bool
+}
+
+// IssuedCertificate describes a certificate obtained for a domain
+type IssuedCertificate struct {
+	CertificateInfo
+	Challenge string `json:"challenge"`
+	Wildcard  bool   `json:"wildcard"`
+}
+
+// ACMEService obtains certificates for domains from an ACME CA (Let's
+// Encrypt by default), proving control with HTTP-01 through the document
+// root or DNS-01 through the zone we serve. Certificates are installed
+// through the certificate store; the account key is stored encrypted in
+// the certificate directory
+type ACMEService struct {
+	opts  ACMEOptions
+	certs *SSLCertificateService
+	dns   *PowerDNSSyncService
+
+	// mu guards client, which is registered on first use
+	mu     sync.Mutex
+	client *acme.Client
+}
+
+// acmeOrders holds the IDs of domains with an order in progress. It is
+// shared by every ACMEService in the process, so a manual renewal and the
+// renewal worker cannot run orders for the same domain at once
+var acmeOrders sync.Map
+
+// NewACMEService creates an ACME service from the application config
+func NewACMEService(vhost *VhostService, dns *PowerDNSSyncService) *ACMEService {
+	cfg := config.AppConfig
+	opts := ACMEOptions{
+		DirectoryURL:          cfg.ACMEDirectoryURL,
+		Email:                 cfg.ACMEEmail,
+		CertDir:               cfg.SSLCertDir,
+		Challenge:             cfg.ACMEChallenge,
+		DNSPropagationTimeout: cfg.ACMEDNSPropagationTimeout,
+		DNSChecker: NewDNSPropagationServiceWith(DNSPropagationOptions{
+			Nameservers: ParsePropagationResolvers(cfg.ACMEDNSNameservers),
+			Timeout:     cfg.DNSPropagationTimeout,
+		}),
+	}
+	if cfg.ACMECAFile != "" {
+		client, err := acmeHTTPClient(cfg.ACMECAFile)
+		if err != nil {
+			log.Printf("WARN: Ignoring ACME_CA_FILE: %v", err)
+		} else {
+			opts.HTTPClient = client
+		}
+	}
+	return NewACMEServiceWith(opts, NewSSLCertificateService(vhost), dns)
+}
+
+// NewACMEServiceWith creates an ACME service with explicit options
+func NewACMEServiceWith(opts ACMEOptions, certs *SSLCertificateService, dns *PowerDNSSyncService) *ACMEService {
+	if opts.Challenge == "" {
+		opts.Challenge = ACMEChallengeHTTP
+	}
+	if opts.DNSPropagationTimeout <= 0 {
+		opts.DNSPropagationTimeout = 2 * time.Minute
+	}
+	if opts.DNSChecker == nil {
+		opts.DNSChecker = NewDNSPropagationServiceWith(DNSPropagationOptions{})
+	}
+	return &ACMEService{opts: opts, certs: certs, dns: dns}
+}
+
+// acmeHTTPClient trusts caFile in addition to the system roots, for test
+// CAs such as Pebble
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s holds no PEM certificate", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// accountKeyPath is where the account key for the configured directory is
+// stored. Each directory (production, staging, Pebble) has its own account
+func (s *ACMEService) accountKeyPath() string {
+	sum := sha256.Sum256([]byte(s.opts.DirectoryURL))
+	return filepath.Join(s.opts.CertDir, ".acme", hex.EncodeToString(sum[:8])+".key")
+}
+
+// loadAccountKey reads the encrypted account key, creating one on first use
+func (s *ACMEService) loadAccountKey() (*ecdsa.PrivateKey, error) {
+	path := s.accountKeyPath()
+	data, err := os.ReadFile(path)
+	if err == nil {
+		der, err := utils.DecryptSecret(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt account key: %w", err)
+		}
+		return x509.ParseECPrivateKey(der)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.EncryptSecret(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create account key directory: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(encrypted), 0600); err != nil {
+		return nil, fmt.Errorf("write account key: %w", err)
+	}
+	return key, nil
+}
+
+// accountClient returns an ACME client with a registered account
+func (s *ACMEService) accountClient(ctx context.Context) (*acme.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	key, err := s.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: s.opts.DirectoryURL,
+		HTTPClient:   s.opts.HTTPClient,
+		UserAgent:    "cloudku",
+	}
+	account := &acme.Account{}
+	if s.opts.Email != "" {
+		account.Contact = []string{"mailto:" + s.opts.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("register ACME account: %w", err)
+	}
+	s.client = client
+	return client, nil
+}
+
+// ACMEChallengeName returns the name of the DNS-01 TXT record for hostname
+// relative to zone, and false when hostname lies outside the zone
+func ACMEChallengeName(hostname, zone string) (string, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	switch {
+	case hostname == zone:
+		return "_acme-challenge", true
+	case strings.HasSuffix(hostname, "."+zone):
+		return "_acme-challenge." + strings.TrimSuffix(hostname, "."+zone), true
+	}
+	return "", false
+}
+
+// resolve fills in a request from the current certificate: an empty
+// challenge reuses the one it was issued with (dns-01 for a wildcard) or
+// the configured default, an unset wildcard keeps its kind
+func (s *ACMEService) resolve(d *models.Domain, req ACMERequest) (challenge string, wildcard bool, err error) {
+	wildcard = d.SSLWildcard
+	if req.Wildcard != nil {
+		wildcard = *req.Wildcard
+	}
+	switch {
+	case req.Challenge != "":
+		challenge = req.Challenge
+	case wildcard:
+		challenge = ACMEChallengeDNS
+	case d.SSLChallenge.Valid:
+		challenge = d.SSLChallenge.String
+	default:
+		challenge = s.opts.Challenge
+	}
+
+	if challenge != ACMEChallengeHTTP && challenge != ACMEChallengeDNS {
+		return "", false, ErrACMEChallenge
+	}
+	if wildcard && challenge != ACMEChallengeDNS {
+		return "", false, ErrACMEWildcardChallenge
+	}
+	return challenge, wildcard, nil
+}
+
+// CertificateHostnames returns the names a certificate of the domain
+// covers. A wildcard certificate has *.domain in place of www.domain
+func CertificateHostnames(ctx context.Context, d *models.Domain, wildcard bool) ([]string, error) {
+	hostnames, err := models.GetSSLHostnames(ctx, d)
+	if err != nil || !wildcard {
+		return hostnames, err
+	}
+	for i, h := range hostnames {
+		if h == "www."+d.DomainName {
+			hostnames[i] = "*." + d.DomainName
+		}
+	}
+	return hostnames, nil
+}
+
+// Issue obtains a certificate for every SSL hostname of a domain, installs
+// it, records its expiry and reloads the web server. With DNS-01, hostnames
+// outside the zone (aliases, whose zones are not served by us) are
+// validated with HTTP-01
+func (s *ACMEService) Issue(ctx context.Context, actor models.DNSChangeActor, d *models.Domain, req ACMERequest) (*IssuedCertificate, error) {
+	challenge, wildcard, err := s.resolve(d, req)
+	if err != nil {
+		return nil, err
+	}
+	if _, running := acmeOrders.LoadOrStore(d.ID, true); running {
+		return nil, ErrACMEInProgress
+	}
+	defer acmeOrders.Delete(d.ID)
+
+	hostnames, err := CertificateHostnames(ctx, d, wildcard)
+	if err != nil {
+		return nil, fmt.Errorf("load hostnames: %w", err)
+	}
+	client, err := s.accountClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(hostnames...))
+	if err != nil {
+		return nil, fmt.Errorf("create order: %w", err)
+	}
+	if err := s.authorizeOrder(ctx, client, actor, d, order.AuthzURLs, challenge); err != nil {
+		return nil, err
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: hostnames}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create CSR: %w", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var fullchain []byte
+	for _, der := range chain {
+		fullchain = append(fullchain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := s.certs.Install(d.DomainName, fullchain, keyPEM); err != nil {
+		return nil, err
+	}
+	if err := s.certs.Record(ctx, d, leaf, models.SSLProviderLetsEncrypt, challenge, wildcard); err != nil {
+		return nil, err
+	}
+	s.certs.Activate(ctx, d.ID)
+
+	return &IssuedCertificate{CertificateInfo: DescribeCertificate(leaf), Challenge: challenge, Wildcard: wildcard}, nil
+}
+
+// Renew is Issue for a domain that has a certificate, recording the
+// attempt in the domain's renewal history
+func (s *ACMEService) Renew(ctx context.Context, actor models.DNSChangeActor, d *models.Domain, req ACMERequest, trigger string) (*IssuedCertificate, error) {
+	challenge, _, _ := s.resolve(d, req)
+	attempt := models.SSLRenewalAttempt{
+		DomainID:  d.ID,
+		Trigger:   trigger,
+		Challenge: challenge,
+		StartedAt: time.Now(),
+	}
+	cert, err := s.Issue(ctx, actor, d, req)
+	if errors.Is(err, ErrACMEInProgress) {
+		// Not an attempt of its own; the running order is recorded
+		return nil, err
+	}
+
+	attempt.FinishedAt = time.Now()
+	if err != nil {
+		msg := err.Error()
+		attempt.Error = &msg
+	} else {
+		attempt.Success = true
+		attempt.ExpiresAt = &cert.NotAfter
+	}
+	if err := models.CreateSSLRenewalAttempt(context.WithoutCancel(ctx), &attempt); err != nil {
+		log.Printf("WARN: Failed to record SSL renewal attempt for %s: %v", d.DomainName, err)
+	}
+	return cert, err
+}
+
+// acmePending is a challenge presented for one authorization of an order
+type acmePending struct {
+	// label names the authorization in errors, *.domain for a wildcard
+	label    string
+	authzURI string
+	chal     *acme.Challenge
+}
+
+// authorizeOrder completes the authorizations of an order. Every challenge
+// is presented first and the DNS-01 records are awaited on the nameservers
+// once, since a wildcard and its base domain share one _acme-challenge
+// name; then the CA validates them all. Presented challenges are removed
+// whatever the outcome
+func (s *ACMEService) authorizeOrder(ctx context.Context, client *acme.Client, actor models.DNSChangeActor, d *models.Domain, urls []string, challenge string) error {
+	var cleanups []func()
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+
+	var pending []acmePending
+	txt := make(map[string][]string)
+	for _, url := range urls {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return fmt.Errorf("fetch authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		hostname := authz.Identifier.Value
+		label := hostname
+		if authz.Wildcard {
+			label = "*." + hostname
+		}
+		typ := challenge
+		name, inZone := ACMEChallengeName(hostname, d.DomainName)
+		if typ == ACMEChallengeDNS && !inZone {
+			typ = ACMEChallengeHTTP
+		}
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == typ {
+				chal = c
+				break
+			}
+		}
+		if chal == nil {
+			return fmt.Errorf("%s: the CA offers no %s challenge", label, typ)
+		}
+		if !acmeToken.MatchString(chal.Token) {
+			return fmt.Errorf("%s: invalid challenge token", label)
+		}
+
+		if typ == ACMEChallengeDNS {
+			value, cleanup, err := s.presentDNS01(ctx, client, actor, d, name, chal)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+			cleanups = append(cleanups, cleanup)
+			txt[name] = append(txt[name], value)
+		} else {
+			cleanup, err := s.presentHTTP01(client, d, chal)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+			cleanups = append(cleanups, cleanup)
+		}
+		pending = append(pending, acmePending{label: label, authzURI: authz.URI, chal: chal})
+	}
+
+	if len(txt) > 0 {
+		s.syncZone(ctx, d.ID)
+		if err := s.waitForTXT(ctx, d.DomainName, txt); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pending {
+		if _, err := client.Accept(ctx, p.chal); err != nil {
+			return fmt.Errorf("%s: accept %s challenge: %w", p.label, p.chal.Type, err)
+		}
+	}
+	for _, p := range pending {
+		if _, err := client.WaitAuthorization(ctx, p.authzURI); err != nil {
+			return fmt.Errorf("%s: %s validation failed: %w", p.label, p.chal.Type, err)
+		}
+	}
+	return nil
+}
+
+// waitForTXT polls the zone's nameservers until each of them serves every
+// challenge value, keyed by zone-relative record name, or the propagation
+// timeout passes
+func (s *ACMEService) waitForTXT(ctx context.Context, zone string, txt map[string][]string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.DNSPropagationTimeout)
+	defer cancel()
+
+	for {
+		var lastErr error
+		for name, values := range txt {
+			if ok, err := s.opts.DNSChecker.TXTPresent(ctx, zone, recordOwner(name, zone), values); !ok {
+				lastErr = err
+				break
+			}
+		}
+		if lastErr == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("challenge records not served by the nameservers after %s: %w", s.opts.DNSPropagationTimeout, lastErr)
+		case <-time.After(acmeDNSPollInterval):
+		}
+	}
+}
+
+// presentHTTP01 writes the key authorization to
+// /.well-known/acme-challenge/<token> in the domain's document root; the
+// vhost serves that path for every hostname of the site, even while the
+// domain shows a status page
+func (s *ACMEService) presentHTTP01(client *acme.Client, d *models.Domain, chal *acme.Challenge) (func(), error) {
+	docRoot, err := absoluteDocumentRoot(d.UserID, d.DocumentRoot)
+	if err != nil {
+		return nil, fmt.Errorf("document root: %w", err)
+	}
+	body, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(docRoot, ".well-known", "acme-challenge")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create challenge directory: %w", err)
+	}
+	path := filepath.Join(dir, chal.Token)
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		return nil, fmt.Errorf("write challenge file: %w", err)
+	}
+	return func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("WARN: Failed to remove ACME challenge file %s: %v", path, err)
+		}
+	}, nil
+}
+
+// presentDNS01 adds a TXT record at name (relative to the zone) holding
+// the challenge value and returns that value. The zone is pushed once all
+// records are in place; the record is removed again by the returned
+// cleanup
+func (s *ACMEService) presentDNS01(ctx context.Context, client *acme.Client, actor models.DNSChangeActor, d *models.Domain, name string, chal *acme.Challenge) (string, func(), error) {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	record, err := models.CreateDNSRecord(ctx, actor, d.ID, "TXT", name, value, MinDNSTTL, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("create challenge record: %w", err)
+	}
+
+	return value, func() {
+		// The order may have timed out; the record must go regardless
+		ctx := context.WithoutCancel(ctx)
+		err := models.UpdateZone(ctx, d.ID, func(q database.Querier) error {
+			return models.DeleteDNSRecord(ctx, q, actor, record.ID, d.ID)
+		})
+		if err != nil {
+			log.Printf("WARN: Failed to remove ACME challenge record %s of %s: %v", name, d.DomainName, err)
+			return
+		}
+		s.syncZone(ctx, d.ID)
+	}, nil
+}
+
+// syncZone pushes a changed zone to PowerDNS; the embedded server picks it
+// up on its own
+func (s *ACMEService) syncZone(ctx context.Context, domainID int) {
+	if s.dns == nil {
+		return
+	}
+	if err := s.dns.SyncDomainID(ctx, domainID); err != nil {
+		log.Printf("WARN: Failed to sync zone %d to PowerDNS: %v", domainID, err)
+	}
+}
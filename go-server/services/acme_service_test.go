@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudku-server/models"
+
+	"golang.org/x/crypto/acme"
+)
+
+// fakeAuthz is an authorization held by fakeACME. Its challenges are
+// offered with the token "token<index>" unless token is set
+type fakeAuthz struct {
+	hostname   string
+	wildcard   bool
+	status     string
+	challenges []string
+	token      string
+	// fail makes the validation of an accepted challenge fail
+	fail bool
+}
+
+// fakeACME is a minimal RFC 8555 CA. It does not check request signatures;
+// accepting a challenge validates it at once, recording the HTTP-01 file
+// the domain served at that moment
+type fakeACME struct {
+	mu       sync.Mutex
+	url      string
+	docRoot  string
+	authzs   []*fakeAuthz
+	accounts int
+	accepted []string
+	served   map[string]string
+	nonce    int
+}
+
+func newFakeACME(t *testing.T, docRoot string, authzs ...*fakeAuthz) *fakeACME {
+	t.Helper()
+	f := &fakeACME{docRoot: docRoot, authzs: authzs, served: make(map[string]string)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+	return f
+}
+
+func (f *fakeACME) authzURL(i int) string {
+	return fmt.Sprintf("%s/authz/%d", f.url, i)
+}
+
+func (f *fakeACME) token(i int) string {
+	if f.authzs[i].token != "" {
+		return f.authzs[i].token
+	}
+	return fmt.Sprintf("token%d", i)
+}
+
+func (f *fakeACME) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeACME) authzJSON(i int) map[string]any {
+	a := f.authzs[i]
+	var challenges []map[string]string
+	for _, typ := range a.challenges {
+		challenges = append(challenges, map[string]string{
+			"type":   typ,
+			"url":    fmt.Sprintf("%s/chal/%d/%s", f.url, i, typ),
+			"token":  f.token(i),
+			"status": "pending",
+		})
+	}
+	return map[string]any{
+		"status":     a.status,
+		"identifier": map[string]string{"type": "dns", "value": a.hostname},
+		"wildcard":   a.wildcard,
+		"challenges": challenges,
+	}
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce%d", f.nonce))
+
+	var i int
+	var typ string
+	switch path := r.URL.Path; {
+	case path == "/directory":
+		f.reply(w, http.StatusOK, map[string]string{
+			"newNonce":   f.url + "/nonce",
+			"newAccount": f.url + "/account",
+			"newOrder":   f.url + "/order",
+		})
+	case path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case path == "/account":
+		f.accounts++
+		w.Header().Set("Location", f.url+"/account/1")
+		status := http.StatusCreated
+		if f.accounts > 1 {
+			status = http.StatusOK // the key is registered already
+		}
+		f.reply(w, status, map[string]string{"status": "valid"})
+	case sscanPath(path, "/authz/%d", &i) && i < len(f.authzs):
+		f.reply(w, http.StatusOK, f.authzJSON(i))
+	case sscanPath(path, "/chal/%d/%s", &i, &typ) && i < len(f.authzs):
+		f.accepted = append(f.accepted, f.authzs[i].hostname+" "+typ)
+		if typ == ACMEChallengeHTTP {
+			data, _ := os.ReadFile(filepath.Join(f.docRoot, ".well-known", "acme-challenge", f.token(i)))
+			f.served[f.token(i)] = string(data)
+		}
+		f.authzs[i].status = acme.StatusValid
+		if f.authzs[i].fail {
+			f.authzs[i].status = acme.StatusInvalid
+		}
+		f.reply(w, http.StatusOK, map[string]string{"type": typ, "url": f.url + path, "token": f.token(i), "status": "processing"})
+	default:
+		f.reply(w, http.StatusNotFound, map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": "not found"})
+	}
+}
+
+func sscanPath(path, format string, args ...any) bool {
+	n, err := fmt.Sscanf(path, format, args...)
+	return err == nil && n == len(args)
+}
+
+// newTestACME creates an ACME service talking to f that keeps its account
+// key in certDir
+func newTestACME(t *testing.T, f *fakeACME, certDir string) *ACMEService {
+	t.Helper()
+	return NewACMEServiceWith(ACMEOptions{
+		DirectoryURL: f.url + "/directory",
+		Email:        "admin@example.com",
+		CertDir:      certDir,
+	}, nil, nil)
+}
+
+// acmeTestDomain is example.com owned by user 1, with its document root
+// under a temporary user files directory
+func acmeTestDomain(t *testing.T) (*models.Domain, string) {
+	t.Helper()
+	base := t.TempDir()
+	t.Setenv("USER_FILES_BASE_PATH", base)
+	return &models.Domain{ID: 1, UserID: 1, DomainName: "example.com", DocumentRoot: "/public_html"},
+		filepath.Join(base, "1", "public_html")
+}
+
+func TestACMEChallengeName(t *testing.T) {
+	cases := []struct {
+		hostname, zone, want string
+		ok                   bool
+	}{
+		{"example.com", "example.com", "_acme-challenge", true},
+		{"www.example.com", "example.com", "_acme-challenge.www", true},
+		{"A.B.Example.COM.", "example.com.", "_acme-challenge.a.b", true},
+		{"example.net", "example.com", "", false},
+		{"badexample.com", "example.com", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := ACMEChallengeName(tc.hostname, tc.zone)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ACMEChallengeName(%q, %q) = %q, %v, want %q, %v", tc.hostname, tc.zone, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestACMEResolve(t *testing.T) {
+	yes, no := true, false
+	s := NewACMEServiceWith(ACMEOptions{Challenge: ACMEChallengeHTTP}, nil, nil)
+	issuedDNS := models.Domain{SSLChallenge: sql.NullString{String: ACMEChallengeDNS, Valid: true}}
+	wildcard := models.Domain{SSLWildcard: true, SSLChallenge: sql.NullString{String: ACMEChallengeDNS, Valid: true}}
+
+	cases := []struct {
+		name         string
+		domain       models.Domain
+		req          ACMERequest
+		challenge    string
+		wantWildcard bool
+		err          error
+	}{
+		{"default", models.Domain{}, ACMERequest{}, ACMEChallengeHTTP, false, nil},
+		{"reuses the issued challenge", issuedDNS, ACMERequest{}, ACMEChallengeDNS, false, nil},
+		{"request overrides", issuedDNS, ACMERequest{Challenge: ACMEChallengeHTTP}, ACMEChallengeHTTP, false, nil},
+		{"wildcard implies dns-01", models.Domain{}, ACMERequest{Wildcard: &yes}, ACMEChallengeDNS, true, nil},
+		{"keeps the wildcard", wildcard, ACMERequest{}, ACMEChallengeDNS, true, nil},
+		{"drops the wildcard", wildcard, ACMERequest{Wildcard: &no}, ACMEChallengeDNS, false, nil},
+		{"wildcard over http-01", models.Domain{}, ACMERequest{Challenge: ACMEChallengeHTTP, Wildcard: &yes}, "", false, ErrACMEWildcardChallenge},
+		{"unknown challenge", models.Domain{}, ACMERequest{Challenge: "tls-alpn-01"}, "", false, ErrACMEChallenge},
+	}
+	for _, tc := range cases {
+		challenge, wildcard, err := s.resolve(&tc.domain, tc.req)
+		if challenge != tc.challenge || wildcard != tc.wantWildcard || !errors.Is(err, tc.err) {
+			t.Errorf("%s: resolve = %q, %v, %v, want %q, %v, %v", tc.name, challenge, wildcard, err, tc.challenge, tc.wantWildcard, tc.err)
+		}
+	}
+}
+
+func TestACMEIssueRejectsConcurrentOrder(t *testing.T) {
+	d := &models.Domain{ID: 7, DomainName: "example.com"}
+	acmeOrders.Store(d.ID, true)
+	defer acmeOrders.Delete(d.ID)
+
+	// The order runs in another service, e.g. the renewal worker's
+	s := NewACMEServiceWith(ACMEOptions{}, nil, nil)
+	if _, err := s.Issue(context.Background(), models.DNSChangeActor{}, d, ACMERequest{}); !errors.Is(err, ErrACMEInProgress) {
+		t.Errorf("Issue = %v, want %v", err, ErrACMEInProgress)
+	}
+}
+
+func TestACMEAccountKeyPersisted(t *testing.T) {
+	f := newFakeACME(t, "")
+	certDir := t.TempDir()
+	ctx := context.Background()
+
+	first := newTestACME(t, f, certDir)
+	client, err := first.accountClient(ctx)
+	if err != nil {
+		t.Fatalf("accountClient: %v", err)
+	}
+	if again, _ := first.accountClient(ctx); again != client || f.accounts != 1 {
+		t.Errorf("account registered %d times, want the client reused", f.accounts)
+	}
+
+	data, err := os.ReadFile(first.accountKeyPath())
+	if err != nil {
+		t.Fatalf("account key not stored: %v", err)
+	}
+	if strings.Contains(string(data), "PRIVATE KEY") {
+		t.Error("account key stored unencrypted")
+	}
+
+	// A restart loads the same key; the CA reports the account as existing
+	second := newTestACME(t, f, certDir)
+	reloaded, err := second.accountClient(ctx)
+	if err != nil {
+		t.Fatalf("accountClient after restart: %v", err)
+	}
+	if !reloaded.Key.(*ecdsa.PrivateKey).Equal(client.Key) {
+		t.Error("restart created a new account key")
+	}
+
+	other := NewACMEServiceWith(ACMEOptions{DirectoryURL: "https://staging.example/directory", CertDir: certDir}, nil, nil)
+	if other.accountKeyPath() == first.accountKeyPath() {
+		t.Error("two directories share one account key")
+	}
+}
+
+func TestACMEAuthorizeHTTP01(t *testing.T) {
+	d, docRoot := acmeTestDomain(t)
+	f := newFakeACME(t, docRoot,
+		&fakeAuthz{hostname: "example.com", status: acme.StatusPending, challenges: []string{ACMEChallengeDNS, ACMEChallengeHTTP}},
+		&fakeAuthz{hostname: "www.example.com", status: acme.StatusPending, challenges: []string{ACMEChallengeHTTP}},
+		&fakeAuthz{hostname: "old.example.com", status: acme.StatusValid, challenges: []string{ACMEChallengeHTTP}},
+	)
+	s := newTestACME(t, f, t.TempDir())
+	ctx := context.Background()
+	client, err := s.accountClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []string{f.authzURL(0), f.authzURL(1), f.authzURL(2)}
+	if err := s.authorizeOrder(ctx, client, models.DNSChangeActor{}, d, urls, ACMEChallengeHTTP); err != nil {
+		t.Fatalf("authorizeOrder: %v", err)
+	}
+
+	if got := strings.Join(f.accepted, ","); got != "example.com http-01,www.example.com http-01" {
+		t.Errorf("accepted challenges = %s, want http-01 for the two pending authorizations", got)
+	}
+	for _, token := range []string{"token0", "token1"} {
+		want, _ := client.HTTP01ChallengeResponse(token)
+		if f.served[token] != want {
+			t.Errorf("challenge file %s held %q when accepted, want %q", token, f.served[token], want)
+		}
+		if _, err := os.Stat(filepath.Join(docRoot, ".well-known", "acme-challenge", token)); !os.IsNotExist(err) {
+			t.Errorf("challenge file %s left behind: %v", token, err)
+		}
+	}
+}
+
+func TestACMEAuthorizeAliasFallsBackToHTTP01(t *testing.T) {
+	d, docRoot := acmeTestDomain(t)
+	f := newFakeACME(t, docRoot,
+		&fakeAuthz{hostname: "example.net", status: acme.StatusPending, challenges: []string{ACMEChallengeDNS, ACMEChallengeHTTP}},
+	)
+	s := newTestACME(t, f, t.TempDir())
+	ctx := context.Background()
+	client, err := s.accountClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.authorizeOrder(ctx, client, models.DNSChangeActor{}, d, []string{f.authzURL(0)}, ACMEChallengeDNS); err != nil {
+		t.Fatalf("authorizeOrder: %v", err)
+	}
+	if got := strings.Join(f.accepted, ","); got != "example.net http-01" {
+		t.Errorf("accepted challenges = %s, want http-01 for a hostname outside the zone", got)
+	}
+}
+
+func TestACMEAuthorizeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		authz *fakeAuthz
+		want  string
+	}{
+		{"no matching challenge", &fakeAuthz{hostname: "example.com", challenges: []string{"tls-alpn-01"}}, "example.com: the CA offers no http-01 challenge"},
+		{"unsafe token", &fakeAuthz{hostname: "example.com", challenges: []string{ACMEChallengeHTTP}, token: "../passwd"}, "example.com: invalid challenge token"},
+		{"failed validation", &fakeAuthz{hostname: "example.com", challenges: []string{ACMEChallengeHTTP}, fail: true}, "example.com: http-01 validation failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, docRoot := acmeTestDomain(t)
+			tc.authz.status = acme.StatusPending
+			f := newFakeACME(t, docRoot, tc.authz)
+			s := newTestACME(t, f, t.TempDir())
+			ctx := context.Background()
+			client, err := s.accountClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.authorizeOrder(ctx, client, models.DNSChangeActor{}, d, []string{f.authzURL(0)}, ACMEChallengeHTTP)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("authorizeOrder = %v, want %q", err, tc.want)
+			}
+			entries, _ := os.ReadDir(filepath.Join(docRoot, ".well-known", "acme-challenge"))
+			if len(entries) != 0 {
+				t.Errorf("challenge files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestACMEWaitForTXT(t *testing.T) {
+	_, ns := propagationZone(t, 2026010101, "203.0.113.10", true)
+	s := NewACMEServiceWith(ACMEOptions{
+		DNSPropagationTimeout: 200 * time.Millisecond,
+		DNSChecker: NewDNSPropagationServiceWith(DNSPropagationOptions{
+			Nameservers: []PropagationResolver{{Name: "ns1", Address: ns.Addr()}},
+			Timeout:     time.Second,
+		}),
+	}, nil, nil)
+	ctx := context.Background()
+
+	if err := s.waitForTXT(ctx, "example.com", map[string][]string{"_acme-challenge": {"token-value"}}); err != nil {
+		t.Errorf("waitForTXT = %v, want the served record found", err)
+	}
+	err := s.waitForTXT(ctx, "example.com", map[string][]string{"_acme-challenge": {"token-value", "wildcard-value"}})
+	if err == nil || !strings.Contains(err.Error(), "not served by the nameservers") {
+		t.Errorf("waitForTXT = %v, want a propagation timeout", err)
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"sort"
+
+	"cloudku-server/database"
+	"cloudku-server/models"
+)
+
+// ZoneRollbackMode is the ZoneDiff mode of a rollback
+const ZoneRollbackMode = "rollback"
+
+// snapshotRecord converts a changelog snapshot to dns_records form
+func snapshotRecord(s models.DNSRecordSnapshot) ZoneRecord {
+	return ZoneRecord{RecordType: s.RecordType, Name: s.Name, Value: s.Value, TTL: s.TTL, Priority: s.Priority}
+}
+
+// PlanZoneRollback works out how to restore the records of a zone to their
+// state before changes (newest first) were made. Records re-created by the
+// rollback get new IDs
+func PlanZoneRollback(current []models.DNSRecord, changes []models.DNSRecordChange) *ZoneDiff {
+	target := make(map[int]models.DNSRecordSnapshot, len(current))
+	for i := range current {
+		target[current[i].ID] = current[i].Snapshot()
+	}
+	for _, c := range changes {
+		switch c.Action {
+		case models.DNSChangeCreate:
+			delete(target, c.RecordID)
+		case models.DNSChangeUpdate, models.DNSChangeDelete:
+			if c.Before != nil {
+				target[c.RecordID] = *c.Before
+			}
+		}
+	}
+
+	diff := &ZoneDiff{
+		Mode:   ZoneRollbackMode,
+		Add:    []ZoneRecord{},
+		Update: []ZoneRecordChange{},
+		Remove: []models.DNSRecordResponse{},
+	}
+	existing := make(map[int]bool, len(current))
+	for i := range current {
+		r := &current[i]
+		existing[r.ID] = true
+		want, ok := target[r.ID]
+		switch {
+		case !ok:
+			diff.Remove = append(diff.Remove, r.ToResponse())
+		case !want.Equal(r.Snapshot()):
+			diff.Update = append(diff.Update, ZoneRecordChange{Before: r.ToResponse(), After: snapshotRecord(want)})
+		default:
+			diff.Unchanged++
+		}
+	}
+
+	var restored []int
+	for id := range target {
+		if !existing[id] {
+			restored = append(restored, id)
+		}
+	}
+	sort.Ints(restored)
+	for _, id := range restored {
+		diff.Add = append(diff.Add, snapshotRecord(target[id]))
+	}
+	return diff
+}
+
+// RollbackZone restores the records of a zone to their state right after
+// the change toChangeID (0 undoes every logged change). The plan is worked
+// out again inside the transaction that applies it, with the zone locked,
+// so concurrent changes are not lost halfway. The rollback itself is logged
+// like any other change
+func RollbackZone(ctx context.Context, actor models.DNSChangeActor, domainID int, toChangeID int64, dryRun bool) (*ZoneDiff, error) {
+	current, err := models.GetDNSRecordsByDomainID(ctx, domainID)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := models.GetDNSRecordChangesAfter(ctx, database.DB, domainID, toChangeID)
+	if err != nil {
+		return nil, err
+	}
+	diff := PlanZoneRollback(current, changes)
+	if dryRun || diff.Empty() {
+		return diff, nil
+	}
+
+	err = models.UpdateZone(ctx, domainID, func(q database.Querier) error {
+		if err := models.LockDNSZone(ctx, q, domainID); err != nil {
+			return err
+		}
+		current, err := models.GetDNSRecordsForUpdate(ctx, q, domainID)
+		if err != nil {
+			return err
+		}
+		changes, err := models.GetDNSRecordChangesAfter(ctx, q, domainID, toChangeID)
+		if err != nil {
+			return err
+		}
+		diff = PlanZoneRollback(current, changes)
+		return applyZoneDiff(ctx, q, actor, domainID, diff)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+
+	"cloudku-server/models"
+)
+
+func historyRecord(id int, recordType, name, value string) models.DNSRecord {
+	return models.DNSRecord{ID: id, RecordType: recordType, Name: name, Value: value, TTL: 3600}
+}
+
+func historySnapshot(recordType, name, value string) *models.DNSRecordSnapshot {
+	return &models.DNSRecordSnapshot{RecordType: recordType, Name: name, Value: value, TTL: 3600}
+}
+
+func TestPlanZoneRollback(t *testing.T) {
+	// The zone after these changes, newest first:
+	//   4 created mail A, 3 deleted ftp A, 2 changed www, 1 changed www again
+	current := []models.DNSRecord{
+		historyRecord(1, "A", "@", "203.0.113.10"),
+		historyRecord(2, "A", "www", "203.0.113.30"),
+		historyRecord(4, "A", "mail", "203.0.113.40"),
+	}
+	changes := []models.DNSRecordChange{
+		{ID: 14, RecordID: 4, Action: models.DNSChangeCreate, After: historySnapshot("A", "mail", "203.0.113.40")},
+		{ID: 13, RecordID: 3, Action: models.DNSChangeDelete, Before: historySnapshot("A", "ftp", "203.0.113.10")},
+		{ID: 12, RecordID: 2, Action: models.DNSChangeUpdate, Before: historySnapshot("A", "www", "203.0.113.20"), After: historySnapshot("A", "www", "203.0.113.30")},
+		{ID: 11, RecordID: 2, Action: models.DNSChangeUpdate, Before: historySnapshot("A", "www", "203.0.113.10"), After: historySnapshot("A", "www", "203.0.113.20")},
+	}
+
+	diff := PlanZoneRollback(current, changes)
+
+	if diff.Mode != ZoneRollbackMode || diff.Unchanged != 1 {
+		t.Errorf("mode=%s unchanged=%d, want rollback with the apex A unchanged", diff.Mode, diff.Unchanged)
+	}
+	if len(diff.Remove) != 1 || diff.Remove[0].ID != 4 {
+		t.Errorf("remove = %+v, want the created mail record", diff.Remove)
+	}
+	if len(diff.Update) != 1 || diff.Update[0].Before.ID != 2 || diff.Update[0].After.Value != "203.0.113.10" {
+		t.Errorf("update = %+v, want www restored to its oldest value", diff.Update)
+	}
+	if len(diff.Add) != 1 || diff.Add[0].Name != "ftp" || diff.Add[0].Value != "203.0.113.10" {
+		t.Errorf("add = %+v, want the deleted ftp record re-created", diff.Add)
+	}
+}
+
+func TestPlanZoneRollbackNoChanges(t *testing.T) {
+	current := []models.DNSRecord{historyRecord(1, "A", "@", "203.0.113.10")}
+
+	diff := PlanZoneRollback(current, nil)
+	if !diff.Empty() || diff.Unchanged != 1 {
+		t.Errorf("diff = %+v, want nothing to do", diff)
+	}
+}
+
+func TestPlanZoneRollbackCreatedThenDeleted(t *testing.T) {
+	// A record created and deleted after the target point is not restored
+	changes := []models.DNSRecordChange{
+		{ID: 2, RecordID: 5, Action: models.DNSChangeDelete, Before: historySnapshot("TXT", "tmp", "x")},
+		{ID: 1, RecordID: 5, Action: models.DNSChangeCreate, After: historySnapshot("TXT", "tmp", "x")},
+	}
+
+	if diff := PlanZoneRollback(nil, changes); !diff.Empty() {
+		t.Errorf("diff = %+v, want nothing to do", diff)
+	}
+}
+
+func TestPlanZoneRollbackPriority(t *testing.T) {
+	ten, twenty := 10, 20
+	mx := historyRecord(1, "MX", "@", "mail.example.com")
+	mx.Priority = sql.NullInt32{Int32: 20, Valid: true}
+	before := historySnapshot("MX", "@", "mail.example.com")
+	before.Priority = &ten
+	after := historySnapshot("MX", "@", "mail.example.com")
+	after.Priority = &twenty
+	changes := []models.DNSRecordChange{
+		{ID: 1, RecordID: 1, Action: models.DNSChangeUpdate, Before: before, After: after},
+	}
+
+	diff := PlanZoneRollback([]models.DNSRecord{mx}, changes)
+	if len(diff.Update) != 1 || diff.Update[0].After.Priority == nil || *diff.Update[0].After.Priority != 10 {
+		t.Errorf("update = %+v, want the MX priority restored to 10", diff.Update)
+	}
+}
+
+func TestPlanZoneRollbackAddsInRecordOrder(t *testing.T) {
+	changes := []models.DNSRecordChange{
+		{ID: 3, RecordID: 9, Action: models.DNSChangeDelete, Before: historySnapshot("A", "c", "203.0.113.3")},
+		{ID: 2, RecordID: 7, Action: models.DNSChangeDelete, Before: historySnapshot("A", "a", "203.0.113.1")},
+		{ID: 1, RecordID: 8, Action: models.DNSChangeDelete, Before: historySnapshot("A", "b", "203.0.113.2")},
+	}
+
+	diff := PlanZoneRollback(nil, changes)
+	var names []string
+	for _, r := range diff.Add {
+		names = append(names, r.Name)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Errorf("added %v, want the records in the order of their old IDs", names)
+	}
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+
+	"github.com/miekg/dns"
+)
+
+// Propagation statuses of one rrset at one resolver
+const (
+	PropagationMatch    = "match"    // the resolver returns exactly the stored records
+	PropagationMismatch = "mismatch" // the resolver returns other records
+	PropagationMissing  = "missing"  // the resolver returns no records (NXDOMAIN/NODATA)
+	PropagationError    = "error"    // the query failed or timed out
+)
+
+// defaultPropagationResolvers are used when DNS_PROPAGATION_RESOLVERS is
+// empty
+var defaultPropagationResolvers = []string{
+	"Google=8.8.8.8", "Cloudflare=1.1.1.1", "Quad9=9.9.9.9", "OpenDNS=208.67.222.222",
+}
+
+// maxPropagationQueries caps the number of lookups in flight
+const maxPropagationQueries = 16
+
+// PropagationResolver is a DNS server a zone is checked against
+type PropagationResolver struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Authoritative servers are queried without recursion
+	Authoritative bool `json:"authoritative"`
+}
+
+// PropagationAnswer is what one resolver returns for an rrset
+type PropagationAnswer struct {
+	Resolver      string   `json:"resolver"`
+	Address       string   `json:"address"`
+	Authoritative bool     `json:"authoritative"`
+	Status        string   `json:"status"`
+	Values        []string `json:"values"`
+	TTL           uint32   `json:"ttl,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	RTTMillis     int64    `json:"rtt_ms"`
+}
+
+// PropagationRecord is the state of one stored rrset across resolvers
+type PropagationRecord struct {
+	Name     string              `json:"name"`
+	Type     string              `json:"type"`
+	Expected []string            `json:"expected"`
+	Answers  []PropagationAnswer `json:"answers"`
+	// Propagated is set when every resolver returns the stored records
+	Propagated bool `json:"propagated"`
+	Mismatches int  `json:"mismatches"`
+}
+
+// PropagationSerial is the SOA serial a resolver returns for the zone
+type PropagationSerial struct {
+	Resolver string `json:"resolver"`
+	Serial   uint32 `json:"serial,omitempty"`
+	Current  bool   `json:"current"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PropagationReport is the outcome of a propagation check of a zone
+type PropagationReport struct {
+	Domain     string                `json:"domain"`
+	CheckedAt  time.Time             `json:"checked_at"`
+	Serial     int64                 `json:"serial"`
+	Resolvers  []PropagationResolver `json:"resolvers"`
+	Serials    []PropagationSerial   `json:"serials"`
+	Records    []PropagationRecord   `json:"records"`
+	Propagated bool                  `json:"propagated"`
+	Summary    struct {
+		Records    int `json:"records"`
+		Propagated int `json:"propagated"`
+		Checks     int `json:"checks"`
+		Matches    int `json:"matches"`
+		Mismatches int `json:"mismatches"`
+		Missing    int `json:"missing"`
+		Errors     int `json:"errors"`
+	} `json:"summary"`
+}
+
+// DNSPropagationOptions configures propagation checks
+type DNSPropagationOptions struct {
+	// Resolvers are the recursive resolvers to query
+	Resolvers []PropagationResolver
+	// Nameservers replaces the zone's authoritative servers; when empty
+	// they are found by resolving the zone's NS host names with HostResolver
+	Nameservers  []PropagationResolver
+	HostResolver Resolver
+	// Timeout limits each query
+	Timeout time.Duration
+}
+
+// DNSPropagationService checks whether the stored records of a zone are
+// visible at public resolvers and at the zone's own nameservers
+type DNSPropagationService struct {
+	opts DNSPropagationOptions
+}
+
+// NewDNSPropagationService creates a propagation checker from config
+func NewDNSPropagationService() *DNSPropagationService {
+	entries := config.AppConfig.DNSPropagationResolvers
+	if len(entries) == 0 {
+		entries = defaultPropagationResolvers
+	}
+	return NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Resolvers:    ParsePropagationResolvers(entries),
+		HostResolver: net.DefaultResolver,
+		Timeout:      config.AppConfig.DNSPropagationTimeout,
+	})
+}
+
+// NewDNSPropagationServiceWith creates a propagation checker with explicit
+// resolvers, e.g. local fake DNS servers
+func NewDNSPropagationServiceWith(opts DNSPropagationOptions) *DNSPropagationService {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	if opts.HostResolver == nil {
+		opts.HostResolver = net.DefaultResolver
+	}
+	return &DNSPropagationService{opts: opts}
+}
+
+// ParsePropagationResolvers parses "name=ip[:port]" or "ip[:port]" entries
+func ParsePropagationResolvers(entries []string) []PropagationResolver {
+	var out []PropagationResolver
+	for _, entry := range entries {
+		name, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, addr = entry, entry
+		}
+		out = append(out, PropagationResolver{Name: strings.TrimSpace(name), Address: withDNSPort(strings.TrimSpace(addr))})
+	}
+	return out
+}
+
+// withDNSPort adds port 53 to an address without a port
+func withDNSPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+}
+
+// nameservers returns the authoritative servers of a zone
+func (s *DNSPropagationService) nameservers(ctx context.Context, zone string) []PropagationResolver {
+	if len(s.opts.Nameservers) > 0 {
+		out := make([]PropagationResolver, len(s.opts.Nameservers))
+		for i, ns := range s.opts.Nameservers {
+			ns.Authoritative = true
+			out[i] = ns
+		}
+		return out
+	}
+
+	var out []PropagationResolver
+	for _, host := range ZoneNameservers(zone) {
+		lookupCtx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+		addrs, err := s.opts.HostResolver.LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			// Reported as an erroring resolver so a broken NS is visible
+			out = append(out, PropagationResolver{Name: host, Authoritative: true})
+			continue
+		}
+		out = append(out, PropagationResolver{Name: host, Address: withDNSPort(addrs[0].IP.String()), Authoritative: true})
+	}
+	return out
+}
+
+// rdataKey returns the comparable record data of a record: lower-cased, with
+// TXT strings joined so differently split values compare equal
+func rdataKey(rr dns.RR) string {
+	if txt, ok := rr.(*dns.TXT); ok {
+		return `"` + strings.Join(txt.Txt, "") + `"`
+	}
+	return strings.ToLower(strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
+
+// expectedValues parses the stored contents of an rrset into comparable form
+func expectedValues(set *PDNSRRSet) []string {
+	out := make([]string, 0, len(set.Records))
+	for _, rec := range set.Records {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", set.Name, set.TTL, set.Type, rec.Content))
+		if err != nil || rr == nil {
+			out = append(out, strings.ToLower(rec.Content))
+			continue
+		}
+		out = append(out, rdataKey(rr))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// query sends one question to a resolver
+func (s *DNSPropagationService) query(ctx context.Context, r PropagationResolver, name string, qtype uint16) (*dns.Msg, time.Duration, error) {
+	if r.Address == "" {
+		return nil, 0, errors.New("nameserver address could not be resolved")
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = !r.Authoritative
+	m.SetEdns0(1232, false)
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	client := &dns.Client{Timeout: s.opts.Timeout}
+	resp, rtt, err := client.ExchangeContext(ctx, m, r.Address)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, rtt, err = client.ExchangeContext(ctx, m, r.Address)
+	}
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, rtt, fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	return resp, rtt, nil
+}
+
+// check queries one resolver for one rrset and compares the answer
+func (s *DNSPropagationService) check(ctx context.Context, r PropagationResolver, set *PDNSRRSet, expected []string) PropagationAnswer {
+	answer := PropagationAnswer{Resolver: r.Name, Address: r.Address, Authoritative: r.Authoritative, Values: []string{}}
+	qtype := dns.StringToType[set.Type]
+
+	resp, rtt, err := s.query(ctx, r, set.Name, qtype)
+	answer.RTTMillis = rtt.Milliseconds()
+	if err != nil {
+		answer.Status, answer.Error = PropagationError, err.Error()
+		return answer
+	}
+
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != qtype || !strings.EqualFold(rr.Header().Name, set.Name) {
+			continue
+		}
+		answer.Values = append(answer.Values, rdataKey(rr))
+		answer.TTL = rr.Header().Ttl
+	}
+	sort.Strings(answer.Values)
+
+	switch {
+	case len(answer.Values) == 0:
+		answer.Status = PropagationMissing
+	case strings.Join(answer.Values, "\n") == strings.Join(expected, "\n"):
+		answer.Status = PropagationMatch
+	default:
+		answer.Status = PropagationMismatch
+	}
+	return answer
+}
+
+// CheckZone queries every resolver and nameserver for every stored rrset of
+// a domain, concurrently. name and recordType optionally limit the check to
+// one owner (zone-relative, "@" for the apex) and type
+func (s *DNSPropagationService) CheckZone(ctx context.Context, d *models.Domain, name, recordType string) (*PropagationReport, error) {
+	state, err := desiredZone(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	return s.checkZone(ctx, d.DomainName, state, name, recordType), nil
+}
+
+// checkZone is CheckZone for a loaded zone state
+func (s *DNSPropagationService) checkZone(ctx context.Context, zone string, state *zoneState, name, recordType string) *PropagationReport {
+	report := &PropagationReport{
+		Domain:    zone,
+		CheckedAt: time.Now(),
+		Serial:    state.Serial,
+		Records:   []PropagationRecord{},
+		Serials:   []PropagationSerial{},
+	}
+	report.Resolvers = append(append([]PropagationResolver{}, s.opts.Resolvers...), s.nameservers(ctx, zone)...)
+
+	owner := ""
+	if name != "" {
+		owner = recordOwner(name, zone)
+	}
+	var sets []PDNSRRSet
+	for _, set := range state.RRSets {
+		if set.Type == "SOA" {
+			continue
+		}
+		if (owner != "" && set.Name != owner) || (recordType != "" && !strings.EqualFold(set.Type, recordType)) {
+			continue
+		}
+		sets = append(sets, set)
+	}
+
+	report.Records = make([]PropagationRecord, len(sets))
+	report.Serials = make([]PropagationSerial, len(report.Resolvers))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxPropagationQueries)
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn()
+		}()
+	}
+
+	for i := range sets {
+		set := &sets[i]
+		rec := &report.Records[i]
+		rec.Name, rec.Type = set.Name, set.Type
+		rec.Expected = expectedValues(set)
+		rec.Answers = make([]PropagationAnswer, len(report.Resolvers))
+		for j, r := range report.Resolvers {
+			run(func() { rec.Answers[j] = s.check(ctx, r, set, rec.Expected) })
+		}
+	}
+
+	// The SOA serial shows whether a resolver has the latest zone version
+	apex := canonicalZone(zone)
+	for j, r := range report.Resolvers {
+		run(func() {
+			serial := PropagationSerial{Resolver: r.Name}
+			resp, _, err := s.query(ctx, r, apex, dns.TypeSOA)
+			if err == nil {
+				for _, rr := range resp.Answer {
+					if soa, ok := rr.(*dns.SOA); ok {
+						serial.Serial = soa.Serial
+					}
+				}
+				if serial.Serial == 0 {
+					err = errors.New("no SOA record returned")
+				}
+			}
+			if err != nil {
+				serial.Error = err.Error()
+			}
+			serial.Current = serial.Serial != 0 && int64(serial.Serial) == state.Serial
+			report.Serials[j] = serial
+		})
+	}
+	wg.Wait()
+
+	report.Summary.Records = len(report.Records)
+	for i := range report.Records {
+		rec := &report.Records[i]
+		for _, a := range rec.Answers {
+			report.Summary.Checks++
+			switch a.Status {
+			case PropagationMatch:
+				report.Summary.Matches++
+			case PropagationMismatch:
+				report.Summary.Mismatches++
+			case PropagationMissing:
+				report.Summary.Missing++
+			case PropagationError:
+				report.Summary.Errors++
+			}
+			if a.Status != PropagationMatch {
+				rec.Mismatches++
+			}
+		}
+		rec.Propagated = rec.Mismatches == 0
+		if rec.Propagated {
+			report.Summary.Propagated++
+		}
+	}
+	report.Propagated = report.Summary.Propagated == report.Summary.Records
+	return report
+}
+
+// TXTPresent reports whether every authoritative nameserver of zone serves
+// all of values in the TXT rrset at name (a fully qualified owner). When
+// not, the error says which server is behind
+func (s *DNSPropagationService) TXTPresent(ctx context.Context, zone, name string, values []string) (bool, error) {
+	servers := s.nameservers(ctx, zone)
+	if len(servers) == 0 {
+		return false, errors.New("the zone has no nameservers")
+	}
+	for _, ns := range servers {
+		resp, _, err := s.query(ctx, ns, name, dns.TypeTXT)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", ns.Name, err)
+		}
+		served := make(map[string]bool)
+		for _, rr := range resp.Answer {
+			if rr.Header().Rrtype == dns.TypeTXT {
+				served[rdataKey(rr)] = true
+			}
+		}
+		for _, v := range values {
+			if !served[`"`+v+`"`] {
+				return false, fmt.Errorf("%s does not serve the record yet", ns.Name)
+			}
+		}
+	}
+	return true, nil
+}
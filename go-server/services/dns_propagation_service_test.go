@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudku-server/models"
+)
+
+// propagationZone serves example.com at serial with www pointing at wwwIP,
+// optionally with a TXT record, on a local port
+func propagationZone(t *testing.T, serial int64, wwwIP string, withTXT bool) (*zoneState, *DNSServer) {
+	t.Helper()
+	records := []models.DNSRecord{
+		{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600},
+		{RecordType: "A", Name: "www", Value: wwwIP, TTL: 3600},
+	}
+	if withTXT {
+		records = append(records, models.DNSRecord{RecordType: "TXT", Name: "_acme-challenge", Value: "token-value", TTL: 60})
+	}
+	state := &zoneState{Serial: serial, RRSets: DesiredRRSets("example.com", testSOA(serial), records)}
+
+	s := NewDNSServerWithOptions(DNSServerOptions{Addr: "127.0.0.1:0"})
+	if err := s.ServeZone(1, "example.com", serial, state.RRSets, nil); err != nil {
+		t.Fatalf("ServeZone: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return state, s
+}
+
+// closedAddr returns a local UDP address nothing listens on
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func answerFor(t *testing.T, rec *PropagationRecord, resolver string) PropagationAnswer {
+	t.Helper()
+	for _, a := range rec.Answers {
+		if a.Resolver == resolver {
+			return a
+		}
+	}
+	t.Fatalf("no answer from %s for %s %s", resolver, rec.Name, rec.Type)
+	return PropagationAnswer{}
+}
+
+func TestPropagationCheckZone(t *testing.T) {
+	state, current := propagationZone(t, 2026010101, "203.0.113.10", true)
+	_, stale := propagationZone(t, 2026010100, "198.51.100.1", false)
+
+	s := NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Resolvers: []PropagationResolver{
+			{Name: "Current", Address: current.Addr()},
+			{Name: "Stale", Address: stale.Addr()},
+			{Name: "Down", Address: closedAddr(t)},
+		},
+		Nameservers: []PropagationResolver{{Name: "ns1.example.com", Address: current.Addr()}},
+		Timeout:     time.Second,
+	})
+
+	report := s.checkZone(context.Background(), "example.com", state, "", "")
+	if report.Propagated || report.Serial != 2026010101 || len(report.Resolvers) != 4 {
+		t.Fatalf("report = %+v, want an unpropagated check against four servers", report)
+	}
+	if !report.Resolvers[3].Authoritative {
+		t.Error("nameserver not marked authoritative")
+	}
+
+	records := make(map[string]*PropagationRecord)
+	for i := range report.Records {
+		records[report.Records[i].Name+" "+report.Records[i].Type] = &report.Records[i]
+	}
+	if _, ok := records["example.com. SOA"]; ok {
+		t.Error("SOA checked as a record; its serial is reported separately")
+	}
+
+	www := records["www.example.com. A"]
+	if www == nil {
+		t.Fatalf("www A not checked: %v", records)
+	}
+	for resolver, want := range map[string]string{
+		"Current":         PropagationMatch,
+		"ns1.example.com": PropagationMatch,
+		"Stale":           PropagationMismatch,
+		"Down":            PropagationError,
+	} {
+		if got := answerFor(t, www, resolver); got.Status != want {
+			t.Errorf("www A at %s = %s (%v), want %s", resolver, got.Status, got.Values, want)
+		}
+	}
+	if got := answerFor(t, www, "Stale"); strings.Join(got.Values, ",") != "198.51.100.1" || got.TTL != 3600 {
+		t.Errorf("stale answer = %+v", got)
+	}
+
+	txt := records["_acme-challenge.example.com. TXT"]
+	if txt == nil || answerFor(t, txt, "Stale").Status != PropagationMissing {
+		t.Errorf("TXT absent from the stale server not reported missing: %+v", txt)
+	}
+
+	serials := make(map[string]PropagationSerial)
+	for _, serial := range report.Serials {
+		serials[serial.Resolver] = serial
+	}
+	if !serials["Current"].Current || serials["Stale"].Current || serials["Stale"].Serial != 2026010100 {
+		t.Errorf("serials = %+v", serials)
+	}
+	if serials["Down"].Error == "" {
+		t.Errorf("unreachable resolver serial = %+v, want an error", serials["Down"])
+	}
+
+	sum := report.Summary
+	if sum.Checks != sum.Matches+sum.Mismatches+sum.Missing+sum.Errors || sum.Records != len(report.Records) {
+		t.Errorf("summary = %+v does not add up", sum)
+	}
+}
+
+func TestPropagationCheckZoneFilter(t *testing.T) {
+	state, current := propagationZone(t, 2026010101, "203.0.113.10", true)
+	s := NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Resolvers:   []PropagationResolver{{Name: "Current", Address: current.Addr()}},
+		Nameservers: []PropagationResolver{{Name: "ns1.example.com", Address: current.Addr()}},
+	})
+
+	report := s.checkZone(context.Background(), "example.com", state, "www", "a")
+	if len(report.Records) != 1 || report.Records[0].Name != "www.example.com." {
+		t.Fatalf("records = %+v, want only www A", report.Records)
+	}
+	if !report.Propagated || report.Summary.Matches != 2 {
+		t.Errorf("report = %+v, want www A propagated to both servers", report)
+	}
+}
+
+func TestPropagationUnresolvableNameserver(t *testing.T) {
+	state, current := propagationZone(t, 2026010101, "203.0.113.10", false)
+	s := NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Resolvers:    []PropagationResolver{{Name: "Current", Address: current.Addr()}},
+		HostResolver: &fakeResolver{},
+	})
+
+	report := s.checkZone(context.Background(), "example.com", state, "@", "A")
+	if len(report.Resolvers) != 3 {
+		t.Fatalf("resolvers = %+v, want the resolver and both zone nameservers", report.Resolvers)
+	}
+	for _, a := range report.Records[0].Answers[1:] {
+		if a.Status != PropagationError || !strings.Contains(a.Error, "could not be resolved") {
+			t.Errorf("answer from %s = %+v, want an unresolved nameserver error", a.Resolver, a)
+		}
+	}
+}
+
+func TestPropagationTXTPresent(t *testing.T) {
+	_, current := propagationZone(t, 2026010101, "203.0.113.10", true)
+	_, stale := propagationZone(t, 2026010100, "203.0.113.10", false)
+	ctx := context.Background()
+
+	s := NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Nameservers: []PropagationResolver{{Name: "ns1", Address: current.Addr()}},
+	})
+	if ok, err := s.TXTPresent(ctx, "example.com", "_acme-challenge.example.com.", []string{"token-value"}); !ok || err != nil {
+		t.Errorf("TXTPresent = %v, %v, want the served token found", ok, err)
+	}
+	if ok, _ := s.TXTPresent(ctx, "example.com", "_acme-challenge.example.com.", []string{"token-value", "other"}); ok {
+		t.Error("TXTPresent reported a value the server does not serve")
+	}
+
+	s = NewDNSPropagationServiceWith(DNSPropagationOptions{
+		Nameservers: []PropagationResolver{{Name: "ns1", Address: current.Addr()}, {Name: "ns2", Address: stale.Addr()}},
+	})
+	ok, err := s.TXTPresent(ctx, "example.com", "_acme-challenge.example.com.", []string{"token-value"})
+	if ok || err == nil || !strings.Contains(err.Error(), "ns2") {
+		t.Errorf("TXTPresent = %v, %v, want ns2 reported as behind", ok, err)
+	}
+}
+
+func TestParsePropagationResolvers(t *testing.T) {
+	got := ParsePropagationResolvers([]string{"Google=8.8.8.8", "1.1.1.1:5353", "v6=2001:db8::1", " Local = [::1]:53 "})
+	want := []PropagationResolver{
+		{Name: "Google", Address: "8.8.8.8:53"},
+		{Name: "1.1.1.1:5353", Address: "1.1.1.1:5353"},
+		{Name: "v6", Address: "[2001:db8::1]:53"},
+		{Name: "Local", Address: "[::1]:53"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParsePropagationResolvers = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloudku-server/models"
+)
+
+// TTL bounds for DNS records
+const (
+	MinDNSTTL     = 60
+	MaxDNSTTL     = 604800
+	DefaultDNSTTL = 3600
+)
+
+// maxTXTLength caps the total length of a TXT value. Longer values than one
+// 255-byte character-string are split when the zone is rendered
+const maxTXTLength = 4096
+
+// DNS record validation errors
+var (
+	ErrInvalidDNSRecord   = errors.New("invalid DNS record")
+	ErrDuplicateDNSRecord = errors.New("DNS record already exists")
+	ErrCNAMEConflict      = errors.New("CNAME cannot coexist with other records at the same name")
+)
+
+// SupportedDNSRecordTypes are the record types that can be managed
+var SupportedDNSRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT", "NS", "SRV", "CAA", "PTR"}
+
+var (
+	// dnsLabel matches one label of an owner name; underscores are allowed
+	// for service labels such as _dmarc and _sip._tcp
+	dnsLabel = regexp.MustCompile(`^(?:_?[a-z0-9](?:[a-z0-9_-]{0,61}[a-z0-9])?|_[a-z0-9_-]+)$`)
+	// srvOwner matches the _service._proto prefix SRV records require
+	srvOwner = regexp.MustCompile(`^_[a-z0-9-]+\._(?:tcp|udp|tls|sctp)(?:\.|$)`)
+	// caaTag matches a CAA property tag
+	caaTag = regexp.MustCompile(`^[a-z0-9]{1,15}$`)
+)
+
+// caaKnownTags are the CAA tags defined by RFC 8659 and RFC 8657
+var caaKnownTags = map[string]bool{
+	"issue": true, "issuewild": true, "iodef": true, "contactemail": true, "contactphone": true, "issuemail": true,
+}
+
+// DNSRecordInput is a record as submitted by a user, before it is stored
+type DNSRecordInput struct {
+	RecordType string
+	Name       string
+	Value      string
+	TTL        int
+	Priority   *int
+}
+
+// NormalizeDNSRecord cleans up a submitted record: upper-case type,
+// zone-relative lower-case name ("@" for the apex), default TTL and the
+// canonical value form of its type
+func NormalizeDNSRecord(in *DNSRecordInput, zone string) {
+	in.RecordType = strings.ToUpper(strings.TrimSpace(in.RecordType))
+	in.Name = relativeOwnerName(in.Name, zone)
+	in.Value = strings.TrimSpace(in.Value)
+	if in.TTL == 0 {
+		in.TTL = DefaultDNSTTL
+	}
+	if in.RecordType != "MX" && in.RecordType != "SRV" {
+		// Clients often send a priority for every type; only MX and SRV use it
+		in.Priority = nil
+	}
+
+	switch in.RecordType {
+	case "CNAME", "MX", "NS", "PTR":
+		in.Value = strings.TrimSuffix(strings.ToLower(in.Value), ".")
+	case "SRV":
+		fields := strings.Fields(in.Value)
+		if len(fields) == 3 {
+			fields[2] = strings.TrimSuffix(strings.ToLower(fields[2]), ".")
+			if fields[2] == "" {
+				fields[2] = "."
+			}
+			in.Value = strings.Join(fields, " ")
+		}
+	case "TXT":
+		if strings.HasPrefix(in.Value, `"`) {
+			if chunks, err := parseQuotedStrings(in.Value); err == nil {
+				in.Value = strings.Join(chunks, "")
+			}
+		}
+	case "CAA":
+		if flags, tag, value, err := parseCAA(in.Value); err == nil {
+			in.Value = fmt.Sprintf("%d %s %s", flags, tag, strconv.Quote(value))
+		}
+	}
+}
+
+// relativeOwnerName converts an owner name to the zone-relative form
+// records are stored in
+func relativeOwnerName(name, zone string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	zone = strings.ToLower(zone)
+	switch {
+	case name == "" || name == "@" || name == zone || name == zone+".":
+		return "@"
+	case strings.HasSuffix(name, "."+zone+"."):
+		return strings.TrimSuffix(name, "."+zone+".")
+	case strings.HasSuffix(name, "."+zone):
+		return strings.TrimSuffix(name, "."+zone)
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+// ValidateDNSRecord checks a normalized record against the rules of its type
+// and against the other records of the zone. existing should not contain the
+// record being updated
+func ValidateDNSRecord(in *DNSRecordInput, existing []models.DNSRecord) error {
+	if err := validateOwnerName(in.Name); err != nil {
+		return err
+	}
+	if in.TTL < MinDNSTTL || in.TTL > MaxDNSTTL {
+		return fmt.Errorf("%w: ttl must be between %d and %d", ErrInvalidDNSRecord, MinDNSTTL, MaxDNSTTL)
+	}
+	if in.Value == "" {
+		return fmt.Errorf("%w: value is required", ErrInvalidDNSRecord)
+	}
+	if err := validateRecordData(in); err != nil {
+		return err
+	}
+
+	for _, r := range existing {
+		if !strings.EqualFold(r.Name, in.Name) {
+			continue
+		}
+		if strings.EqualFold(r.RecordType, in.RecordType) && sameRecordValue(in.RecordType, r.Value, in.Value) {
+			return fmt.Errorf("%w: %s %s %s", ErrDuplicateDNSRecord, in.Name, in.RecordType, in.Value)
+		}
+		if in.RecordType == "CNAME" || strings.EqualFold(r.RecordType, "CNAME") {
+			return fmt.Errorf("%w: %s already has a %s record", ErrCNAMEConflict, in.Name, strings.ToUpper(r.RecordType))
+		}
+	}
+	return nil
+}
+
+// sameRecordValue reports whether two values of a record type hold the same
+// data. Host names compare case-insensitively and addresses by value; TXT,
+// CAA and other data are case-sensitive, e.g. verification tokens and DKIM
+// keys
+func sameRecordValue(recordType, a, b string) bool {
+	switch strings.ToUpper(recordType) {
+	case "CNAME", "MX", "NS", "PTR":
+		return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+	case "SRV":
+		fa, fb := strings.Fields(a), strings.Fields(b)
+		if len(fa) != 3 || len(fb) != 3 {
+			return a == b
+		}
+		return fa[0] == fb[0] && fa[1] == fb[1] && sameRecordValue("CNAME", fa[2], fb[2])
+	case "A", "AAAA":
+		ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+		if ipA == nil || ipB == nil {
+			return a == b
+		}
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
+
+// validateOwnerName checks a zone-relative owner name; "*" is allowed as the
+// first label of a wildcard
+func validateOwnerName(name string) error {
+	if name == "@" {
+		return nil
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("%w: name is too long", ErrInvalidDNSRecord)
+	}
+	for i, label := range strings.Split(name, ".") {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if !dnsLabel.MatchString(label) {
+			return fmt.Errorf("%w: invalid name %q", ErrInvalidDNSRecord, name)
+		}
+	}
+	return nil
+}
+
+// validHostname reports whether v is a fully-qualified host name usable as
+// a record target
+func validHostname(v string) bool {
+	if net.ParseIP(v) != nil || len(v) > 253 || !strings.Contains(v, ".") {
+		return false
+	}
+	for _, label := range strings.Split(v, ".") {
+		if !dnsLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRecordData checks the value (and priority) of a record by type
+func validateRecordData(in *DNSRecordInput) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidDNSRecord, fmt.Sprintf(format, args...))
+	}
+
+	if in.RecordType == "MX" || in.RecordType == "SRV" {
+		if in.Priority == nil {
+			return invalid("%s record requires a priority", in.RecordType)
+		}
+		if *in.Priority < 0 || *in.Priority > 65535 {
+			return invalid("priority must be between 0 and 65535")
+		}
+	}
+
+	switch in.RecordType {
+	case "A":
+		if ip := net.ParseIP(in.Value); ip == nil || ip.To4() == nil {
+			return invalid("%q is not an IPv4 address", in.Value)
+		}
+
+	case "AAAA":
+		if ip := net.ParseIP(in.Value); ip == nil || ip.To4() != nil {
+			return invalid("%q is not an IPv6 address", in.Value)
+		}
+
+	case "CNAME":
+		if in.Name == "@" {
+			return invalid("CNAME is not allowed at the zone apex")
+		}
+		if !validHostname(in.Value) {
+			return invalid("CNAME target %q is not a host name", in.Value)
+		}
+
+	case "MX", "NS", "PTR":
+		if !validHostname(in.Value) {
+			return invalid("%s target %q is not a host name", in.RecordType, in.Value)
+		}
+
+	case "SRV":
+		if !srvOwner.MatchString(in.Name) {
+			return invalid("SRV name must start with _service._proto")
+		}
+		fields := strings.Fields(in.Value)
+		if len(fields) != 3 {
+			return invalid(`SRV value must be "weight port target"`)
+		}
+		if w, err := strconv.Atoi(fields[0]); err != nil || w < 0 || w > 65535 {
+			return invalid("SRV weight must be between 0 and 65535")
+		}
+		if p, err := strconv.Atoi(fields[1]); err != nil || p < 0 || p > 65535 {
+			return invalid("SRV port must be between 0 and 65535")
+		}
+		if fields[2] != "." && !validHostname(fields[2]) {
+			return invalid("SRV target %q is not a host name", fields[2])
+		}
+
+	case "TXT":
+		if len(in.Value) > maxTXTLength {
+			return invalid("TXT value exceeds %d characters", maxTXTLength)
+		}
+		for _, r := range in.Value {
+			if r < 0x20 || r == 0x7f {
+				return invalid("TXT value contains control characters")
+			}
+		}
+
+	case "CAA":
+		_, tag, value, err := parseCAA(in.Value)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		if (tag == "issue" || tag == "issuewild") && value != ";" && value != "" {
+			domain := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+			if domain != "" && !validHostname(domain) {
+				return invalid("CAA %s value %q is not a CA domain", tag, domain)
+			}
+		}
+		if tag == "iodef" && !strings.HasPrefix(value, "mailto:") && !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+			return invalid("CAA iodef value must be a mailto: or http(s): URL")
+		}
+
+	default:
+		return invalid("unsupported record type %q (supported: %s)", in.RecordType, strings.Join(SupportedDNSRecordTypes, ", "))
+	}
+	return nil
+}
+
+// parseCAA splits a CAA value of the form: flags tag "value"
+func parseCAA(v string) (int, string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(v), " ", 3)
+	if len(parts) != 3 {
+		return 0, "", "", errors.New(`CAA value must be "flags tag value"`)
+	}
+	flags, err := strconv.Atoi(parts[0])
+	if err != nil || flags < 0 || flags > 255 {
+		return 0, "", "", errors.New("CAA flags must be between 0 and 255")
+	}
+	tag := strings.ToLower(parts[1])
+	if !caaTag.MatchString(tag) {
+		return 0, "", "", fmt.Errorf("invalid CAA tag %q", parts[1])
+	}
+	// Unknown tags are only acceptable when a CA may ignore them
+	if !caaKnownTags[tag] && flags&128 != 0 {
+		return 0, "", "", fmt.Errorf("unknown CAA tag %q cannot be marked critical", tag)
+	}
+
+	value := strings.TrimSpace(parts[2])
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return 0, "", "", errors.New("CAA value has unbalanced quotes")
+		}
+		value = unquoted
+	}
+	return flags, tag, value, nil
+}
+
+// parseQuotedStrings splits a zone-file style list of quoted strings,
+// undoing the \\ and \" escapes ZoneRecordData writes
+func parseQuotedStrings(v string) ([]string, error) {
+	var chunks []string
+	rest := strings.TrimSpace(v)
+	for rest != "" {
+		if rest[0] != '"' {
+			return nil, errors.New("expected quoted string")
+		}
+
+		var chunk strings.Builder
+		end := -1
+		for i := 1; i < len(rest); i++ {
+			c := rest[i]
+			if c == '"' {
+				end = i
+				break
+			}
+			if c == '\\' && i+1 < len(rest) && (rest[i+1] == '\\' || rest[i+1] == '"') {
+				i++
+				c = rest[i]
+			}
+			chunk.WriteByte(c)
+		}
+		if end < 0 {
+			return nil, errors.New("unterminated quoted string")
+		}
+
+		chunks = append(chunks, chunk.String())
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	return chunks, nil
+}
+
+// SplitTXT splits a TXT value into the 255-byte character-strings a TXT
+// record is made of
+func SplitTXT(v string) []string {
+	if v == "" {
+		return []string{""}
+	}
+	var chunks []string
+	for len(v) > 255 {
+		chunks = append(chunks, v[:255])
+		v = v[255:]
+	}
+	return append(chunks, v)
+}
+
+// ZoneRecordData renders the data part of a record as it appears in a zone
+// file: priority first for MX/SRV, absolute target names and quoted,
+// split TXT strings
+func ZoneRecordData(r *models.DNSRecord) string {
+	fqdn := func(host string) string {
+		if host == "." || strings.HasSuffix(host, ".") {
+			return host
+		}
+		return host + "."
+	}
+	priority := ""
+	if r.Priority.Valid {
+		priority = strconv.Itoa(int(r.Priority.Int32)) + " "
+	}
+
+	switch strings.ToUpper(r.RecordType) {
+	case "CNAME", "NS", "PTR":
+		return fqdn(r.Value)
+	case "MX":
+		return priority + fqdn(r.Value)
+	case "SRV":
+		fields := strings.Fields(r.Value)
+		if len(fields) == 3 {
+			fields[2] = fqdn(fields[2])
+		}
+		return priority + strings.Join(fields, " ")
+	case "TXT":
+		chunks := SplitTXT(r.Value)
+		for i, c := range chunks {
+			chunks[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(c) + `"`
+		}
+		return strings.Join(chunks, " ")
+	}
+	return r.Value
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"cloudku-server/models"
+)
+
+func TestParseQuotedStrings(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{`"v=spf1 -all"`, []string{"v=spf1 -all"}},
+		{`"a" "b"`, []string{"a", "b"}},
+		{`"say \"hi\""`, []string{`say "hi"`}},
+		{`"C:\\temp"`, []string{`C:\temp`}},
+		{`"ends with \\" "next"`, []string{`ends with \`, "next"}},
+		{`"\d"`, []string{`\d`}},
+	}
+	for _, tc := range cases {
+		got, err := parseQuotedStrings(tc.in)
+		if err != nil {
+			t.Errorf("parseQuotedStrings(%s): %v", tc.in, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("parseQuotedStrings(%s) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{`"unterminated`, `"ends in escape\"`, `unquoted`} {
+		if _, err := parseQuotedStrings(in); err == nil {
+			t.Errorf("parseQuotedStrings(%s) accepted a malformed value", in)
+		}
+	}
+}
+
+func TestTXTZoneDataRoundTrip(t *testing.T) {
+	values := []string{
+		`plain`,
+		`say "hi"`,
+		`C:\temp\new`,
+		`both \"escaped\" and \\ doubled`,
+		`trailing backslash \`,
+		strings.Repeat(`a\"b`, 100),
+	}
+	for _, value := range values {
+		data := ZoneRecordData(&models.DNSRecord{RecordType: "TXT", Value: value})
+
+		in := &DNSRecordInput{RecordType: "TXT", Name: "@", Value: data, TTL: 300}
+		NormalizeDNSRecord(in, "example.com")
+		if in.Value != value {
+			t.Errorf("TXT %q rendered as %s and parsed back as %q", value, data, in.Value)
+		}
+	}
+}
+
+func TestSameRecordValue(t *testing.T) {
+	cases := []struct {
+		recordType, a, b string
+		same             bool
+	}{
+		{"TXT", "google-site-verification=AbC", "google-site-verification=abc", false},
+		{"TXT", "v=spf1 -all", "v=spf1 -all", true},
+		{"CAA", `0 issue "LetsEncrypt.org"`, `0 issue "letsencrypt.org"`, false},
+		{"CNAME", "Target.Example.com", "target.example.com.", true},
+		{"MX", "MAIL.example.com", "mail.example.com", true},
+		{"NS", "ns1.example.com", "ns2.example.com", false},
+		{"SRV", "5 5060 SIP.example.com", "5 5060 sip.example.com", true},
+		{"SRV", "5 5060 sip.example.com", "5 5061 sip.example.com", false},
+		{"AAAA", "2001:DB8::1", "2001:db8:0::1", true},
+		{"A", "192.0.2.1", "192.0.2.10", false},
+	}
+	for _, tc := range cases {
+		if got := sameRecordValue(tc.recordType, tc.a, tc.b); got != tc.same {
+			t.Errorf("sameRecordValue(%s, %q, %q) = %v, want %v", tc.recordType, tc.a, tc.b, got, tc.same)
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+
+	"github.com/miekg/dns"
+)
+
+// DNSServerOptions configures the embedded authoritative DNS server
+type DNSServerOptions struct {
+	// Addr is the UDP and TCP listen address, e.g. ":53". With port 0 a free
+	// port is picked (the same one for UDP and TCP), see DNSServer.Addr
+	Addr string
+	// Secondaries may transfer zones (AXFR) and are sent a NOTIFY when a
+	// zone changes. Entries are IPs, ip:port (the NOTIFY target) or CIDRs
+	// (transfer only)
+	Secondaries []string
+	// ReloadInterval is how often zone serials are checked for changes
+	ReloadInterval time.Duration
+}
+
+// servedZone is a zone held in memory by the DNS server
+type servedZone struct {
+	domainID int
+	origin   string
+	serial   int64
+	soa      *dns.SOA
+	// rrsets maps owner name and type to records
+	rrsets map[string]map[uint16][]dns.RR
+	// names holds every owner and empty non-terminal of the zone
+	names map[string]bool
+	all   []dns.RR
+	// dnssec is set for signed zones
+	dnssec *zoneSignature
+}
+
+// DNSServer is an authoritative DNS server answering from the domains and
+// dns_records tables over UDP and TCP. It serves the same RRsets that are
+// pushed to PowerDNS, so either can be used
+type DNSServer struct {
+	opts   DNSServerOptions
+	allow  []*net.IPNet
+	notify []string
+
+	mu    sync.RWMutex
+	zones map[string]*servedZone
+	byID  map[int]*servedZone
+	// aliases holds the alias zones of each domain
+	aliases map[int][]*servedZone
+
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+// NewDNSServer creates a DNS server from config
+func NewDNSServer() *DNSServer {
+	cfg := config.AppConfig
+	return NewDNSServerWithOptions(DNSServerOptions{
+		Addr:           cfg.DNSServerAddr,
+		Secondaries:    cfg.DNSServerSecondaries,
+		ReloadInterval: cfg.DNSServerReloadInterval,
+	})
+}
+
+// NewDNSServerWithOptions creates a DNS server with explicit options
+func NewDNSServerWithOptions(opts DNSServerOptions) *DNSServer {
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = 5 * time.Second
+	}
+
+	s := &DNSServer{
+		opts:    opts,
+		zones:   make(map[string]*servedZone),
+		byID:    make(map[int]*servedZone),
+		aliases: make(map[int][]*servedZone),
+	}
+	for _, entry := range opts.Secondaries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			s.allow = append(s.allow, network)
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, port = entry, "53"
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			log.Printf("⚠️ Ignoring invalid DNS secondary %q", entry)
+			continue
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		s.allow = append(s.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		s.notify = append(s.notify, net.JoinHostPort(host, port))
+	}
+	return s
+}
+
+// Listen binds the UDP and TCP sockets and starts answering queries
+func (s *DNSServer) Listen() error {
+	tcp, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	// Bind UDP to the port TCP got, so port 0 yields one address
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		tcp.Close()
+		return err
+	}
+
+	s.tcp = &dns.Server{Listener: tcp, Handler: s}
+	s.udp = &dns.Server{PacketConn: udp, Handler: s}
+	go s.serve(s.tcp)
+	go s.serve(s.udp)
+	return nil
+}
+
+func (s *DNSServer) serve(srv *dns.Server) {
+	if err := srv.ActivateAndServe(); err != nil {
+		log.Printf("WARN: DNS server stopped: %v", err)
+	}
+}
+
+// Addr returns the address the server listens on
+func (s *DNSServer) Addr() string {
+	if s.tcp == nil {
+		return s.opts.Addr
+	}
+	return s.tcp.Listener.Addr().String()
+}
+
+// Shutdown stops answering queries
+func (s *DNSServer) Shutdown() {
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		if srv != nil {
+			srv.Shutdown()
+		}
+	}
+}
+
+// Run loads every zone, then reloads changed zones every reload interval
+// until the context is cancelled, and shuts the server down
+func (s *DNSServer) Run(ctx context.Context) {
+	log.Printf("🌐 DNS server listening on %s (udp/tcp)", s.Addr())
+	if err := s.LoadAll(ctx); err != nil {
+		log.Printf("WARN: DNS server failed to load zones: %v", err)
+	}
+
+	ticker := time.NewTicker(s.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Shutdown()
+			log.Println("🌐 DNS server stopped")
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Printf("WARN: DNS server failed to reload zones: %v", err)
+			}
+		}
+	}
+}
+
+// LoadAll loads the zone of every domain
+func (s *DNSServer) LoadAll(ctx context.Context) error {
+	domains, err := models.GetAllDomains(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range domains {
+		if err := s.loadDomain(ctx, &domains[i]); err != nil {
+			log.Printf("WARN: DNS server failed to load %s: %v", domains[i].DomainName, err)
+		}
+	}
+	return nil
+}
+
+// Reload reloads zones whose serial changed, loads new zones and drops the
+// zones of deleted domains. Changed zones are announced to the secondaries
+func (s *DNSServer) Reload(ctx context.Context) error {
+	serials, err := models.GetZoneSerials(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	var changed, removed, resign []int
+	for id, z := range s.byID {
+		if _, ok := serials[id]; !ok {
+			removed = append(removed, id)
+		} else if z.serial != serials[id] {
+			changed = append(changed, id)
+		} else if z.dnssec != nil && time.Until(z.dnssec.expires) < rrsigRefresh {
+			resign = append(resign, id)
+		}
+	}
+	for id := range serials {
+		if _, ok := s.byID[id]; !ok {
+			changed = append(changed, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range removed {
+		s.RemoveZone(id)
+	}
+	// Signatures are refreshed under a new serial so secondaries pick them
+	// up; the zone is reloaded on the next pass
+	for _, id := range resign {
+		if err := models.UpdateZone(ctx, id, func(database.Querier) error { return nil }); err != nil {
+			log.Printf("WARN: DNS server failed to re-sign zone %d: %v", id, err)
+		}
+	}
+	for _, id := range changed {
+		d, err := models.FindDomainByID(ctx, id)
+		if err != nil {
+			log.Printf("WARN: DNS server failed to load zone %d: %v", id, err)
+			continue
+		}
+		if err := s.loadDomain(ctx, d); err != nil {
+			log.Printf("WARN: DNS server failed to load %s: %v", d.DomainName, err)
+		}
+	}
+	return nil
+}
+
+func (s *DNSServer) loadDomain(ctx context.Context, d *models.Domain) error {
+	state, err := desiredZone(ctx, d)
+	if err != nil {
+		return err
+	}
+	var keys []ZoneSigningKey
+	if state.DNSSEC {
+		if keys, err = SigningKeys(d.DomainName, state.Keys); err != nil {
+			return err
+		}
+	}
+	if err := s.ServeZone(d.ID, d.DomainName, state.Serial, state.RRSets, keys); err != nil {
+		return err
+	}
+	return s.ServeAliasZones(d.ID, state.Serial, state.Aliases)
+}
+
+// ServeZone installs or replaces a zone, signing it when keys are given.
+// Secondaries are sent a NOTIFY when an already served zone gets a new
+// serial
+func (s *DNSServer) ServeZone(domainID int, zone string, serial int64, sets []PDNSRRSet, keys []ZoneSigningKey) error {
+	z, err := buildServedZone(domainID, zone, serial, sets)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		if err := z.sign(keys, time.Now()); err != nil {
+			return fmt.Errorf("sign zone %s: %w", zone, err)
+		}
+	}
+
+	s.mu.Lock()
+	previous := s.byID[domainID]
+	if previous != nil {
+		delete(s.zones, previous.origin)
+	}
+	s.zones[z.origin] = z
+	s.byID[domainID] = z
+	s.mu.Unlock()
+
+	if previous != nil && previous.serial != z.serial {
+		go s.sendNotify(z.origin)
+	}
+	return nil
+}
+
+// ServeAliasZones installs the zones of a domain's aliases, replacing the
+// ones served before, so zones of removed aliases stop being served
+func (s *DNSServer) ServeAliasZones(domainID int, serial int64, aliases []AliasZone) error {
+	zones := make([]*servedZone, 0, len(aliases))
+	for _, a := range aliases {
+		z, err := buildServedZone(domainID, a.Name, serial, a.RRSets)
+		if err != nil {
+			return err
+		}
+		zones = append(zones, z)
+	}
+
+	s.mu.Lock()
+	previous := make(map[string]*servedZone, len(s.aliases[domainID]))
+	for _, z := range s.aliases[domainID] {
+		previous[z.origin] = z
+		delete(s.zones, z.origin)
+	}
+	for _, z := range zones {
+		s.zones[z.origin] = z
+	}
+	s.aliases[domainID] = zones
+	s.mu.Unlock()
+
+	for _, z := range zones {
+		if p := previous[z.origin]; p != nil && p.serial != z.serial {
+			go s.sendNotify(z.origin)
+		}
+	}
+	return nil
+}
+
+// RemoveZone stops serving the zone of a domain and its alias zones
+func (s *DNSServer) RemoveZone(domainID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if z, ok := s.byID[domainID]; ok {
+		delete(s.zones, z.origin)
+		delete(s.byID, domainID)
+	}
+	for _, z := range s.aliases[domainID] {
+		delete(s.zones, z.origin)
+	}
+	delete(s.aliases, domainID)
+}
+
+// buildServedZone parses the RRsets of a zone. Records that fail to parse
+// are logged and skipped so one bad record does not take the zone down
+func buildServedZone(domainID int, zone string, serial int64, sets []PDNSRRSet) (*servedZone, error) {
+	z := &servedZone{
+		domainID: domainID,
+		origin:   canonicalZone(zone),
+		serial:   serial,
+		rrsets:   make(map[string]map[uint16][]dns.RR),
+		names:    make(map[string]bool),
+	}
+
+	for _, set := range sets {
+		for _, rec := range set.Records {
+			if rec.Disabled {
+				continue
+			}
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", set.Name, set.TTL, set.Type, rec.Content))
+			if err != nil || rr == nil {
+				log.Printf("WARN: DNS server skipped %s %s %q: %v", set.Name, set.Type, rec.Content, err)
+				continue
+			}
+			owner := strings.ToLower(rr.Header().Name)
+			if !dns.IsSubDomain(z.origin, owner) {
+				continue
+			}
+			if soa, ok := rr.(*dns.SOA); ok {
+				if owner == z.origin {
+					z.soa = soa
+				}
+				continue
+			}
+			if z.rrsets[owner] == nil {
+				z.rrsets[owner] = make(map[uint16][]dns.RR)
+			}
+			z.rrsets[owner][rr.Header().Rrtype] = append(z.rrsets[owner][rr.Header().Rrtype], rr)
+			z.all = append(z.all, rr)
+
+			// Ancestors up to the apex exist as empty non-terminals
+			for name := owner; ; {
+				z.names[name] = true
+				if name == z.origin {
+					break
+				}
+				name = parentName(name)
+			}
+		}
+	}
+
+	if z.soa == nil {
+		return nil, fmt.Errorf("zone %s has no SOA", zone)
+	}
+	z.names[z.origin] = true
+	return z, nil
+}
+
+// parentName strips the first label of a name
+func parentName(name string) string {
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// findZone returns the most specific zone containing name
+func (s *DNSServer) findZone(name string) *servedZone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for {
+		if z, ok := s.zones[name]; ok {
+			return z
+		}
+		if name == "." {
+			return nil
+		}
+		name = parentName(name)
+	}
+}
+
+// ServeDNS answers a query
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeFormatError)
+		w.WriteMsg(m)
+		return
+	}
+	q := r.Question[0]
+	qname := strings.ToLower(q.Name)
+
+	z := s.findZone(qname)
+	if z == nil || (q.Qclass != dns.ClassINET && q.Qclass != dns.ClassANY) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+		s.transfer(w, r, z)
+		return
+	}
+
+	m.Authoritative = true
+	proof := z.answer(qname, q.Qtype, m)
+
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = min(max(int(opt.UDPSize()), dns.MinMsgSize), 1232)
+		// Signatures and denial proofs are only sent when asked for (DO bit)
+		do := opt.Do() && z.dnssec != nil
+		if do {
+			z.addDNSSEC(m, proof)
+		}
+		m.SetEdns0(uint16(size), do)
+	}
+	if w.LocalAddr().Network() == "udp" {
+		m.Truncate(size)
+	}
+	w.WriteMsg(m)
+}
+
+// answer fills m with the records for qname and qtype: a referral below a
+// delegation, the matching (or wildcard-synthesized) records, a CNAME chain
+// within the zone, or a NODATA/NXDOMAIN response carrying the SOA. It
+// returns what the response has to prove when signed
+func (z *servedZone) answer(qname string, qtype uint16, m *dns.Msg) (d denial) {
+	// Names at or below a delegation are answered with a referral
+	for name := qname; name != z.origin; name = parentName(name) {
+		if ns := z.rrsets[name][dns.TypeNS]; len(ns) > 0 && !(name == qname && qtype == dns.TypeDS) {
+			m.Authoritative = false
+			m.Ns = append(m.Ns, ns...)
+			for _, rr := range ns {
+				m.Extra = append(m.Extra, z.glue(rr.(*dns.NS).Ns)...)
+			}
+			d.referral = name
+			return d
+		}
+	}
+
+	owner := qname
+	if !z.names[qname] {
+		wildcard := "*." + z.closestEncloser(qname)
+		if _, ok := z.rrsets[wildcard]; !ok {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, z.negativeSOA())
+			d.nxdomain = qname
+			return d
+		}
+		owner = wildcard
+		d.wildcard = qname
+	}
+
+	seen := map[string]bool{}
+	for range 8 {
+		records := z.records(owner, qname, qtype)
+		if len(records) > 0 {
+			m.Answer = append(m.Answer, records...)
+			for _, rr := range records {
+				m.Extra = append(m.Extra, z.additional(rr)...)
+			}
+			return d
+		}
+
+		cname := z.records(owner, qname, dns.TypeCNAME)
+		if len(cname) == 0 || qtype == dns.TypeCNAME {
+			break
+		}
+		m.Answer = append(m.Answer, cname...)
+
+		// Follow the alias while it stays inside the zone
+		target := strings.ToLower(cname[0].(*dns.CNAME).Target)
+		if seen[target] || !dns.IsSubDomain(z.origin, target) {
+			return d
+		}
+		seen[target] = true
+		qname, owner = target, target
+		if !z.names[target] {
+			if _, ok := z.rrsets["*."+z.closestEncloser(target)]; !ok {
+				return d
+			}
+			owner = "*." + z.closestEncloser(target)
+			d.wildcard = target
+		}
+	}
+
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, z.negativeSOA())
+		d.nodata = owner
+	}
+	return d
+}
+
+// records returns the records of owner for qtype, renamed to qname when
+// synthesized from a wildcard
+func (z *servedZone) records(owner, qname string, qtype uint16) []dns.RR {
+	var out []dns.RR
+	if qtype == dns.TypeSOA && owner == z.origin {
+		out = []dns.RR{z.soa}
+	} else if qtype == dns.TypeANY {
+		if owner == z.origin {
+			out = append(out, z.soa)
+		}
+		for _, rrs := range z.rrsets[owner] {
+			out = append(out, rrs...)
+		}
+	} else {
+		out = z.rrsets[owner][qtype]
+	}
+
+	if owner == qname {
+		return out
+	}
+	renamed := make([]dns.RR, len(out))
+	for i, rr := range out {
+		renamed[i] = dns.Copy(rr)
+		renamed[i].Header().Name = qname
+	}
+	return renamed
+}
+
+// closestEncloser returns the longest existing ancestor of a name
+func (z *servedZone) closestEncloser(name string) string {
+	for name != z.origin {
+		name = parentName(name)
+		if z.names[name] {
+			return name
+		}
+	}
+	return z.origin
+}
+
+// negativeSOA returns the SOA for negative answers, with its TTL capped at
+// the SOA minimum as RFC 2308 requires
+func (z *servedZone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	return soa
+}
+
+// glue returns the in-zone addresses of a host name
+func (z *servedZone) glue(host string) []dns.RR {
+	host = strings.ToLower(host)
+	if !dns.IsSubDomain(z.origin, host) {
+		return nil
+	}
+	return append(append([]dns.RR{}, z.rrsets[host][dns.TypeA]...), z.rrsets[host][dns.TypeAAAA]...)
+}
+
+// additional returns the addresses of the host an answer points to
+func (z *servedZone) additional(rr dns.RR) []dns.RR {
+	switch v := rr.(type) {
+	case *dns.MX:
+		return z.glue(v.Mx)
+	case *dns.NS:
+		return z.glue(v.Ns)
+	case *dns.SRV:
+		return z.glue(v.Target)
+	}
+	return nil
+}
+
+// transferAllowed reports whether a remote address is a secondary
+func (s *DNSServer) transferAllowed(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range s.allow {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// transfer answers an AXFR (IXFR is answered with a full transfer, as RFC
+// 1995 allows) over TCP to a configured secondary
+func (s *DNSServer) transfer(w dns.ResponseWriter, r *dns.Msg, z *servedZone) {
+	if w.LocalAddr().Network() != "tcp" || !s.transferAllowed(w.RemoteAddr()) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	ch := make(chan *dns.Envelope)
+	tr := new(dns.Transfer)
+	done := make(chan struct{})
+	go func() {
+		if err := tr.Out(w, r, ch); err != nil {
+			log.Printf("WARN: Zone transfer of %s to %s failed: %v", z.origin, w.RemoteAddr(), err)
+			// Drain the rest so the sender below does not block
+			for range ch {
+			}
+		}
+		close(done)
+	}()
+
+	records := append([]dns.RR{z.soa}, z.all...)
+	records = append(records, z.soa)
+	for len(records) > 0 {
+		n := min(len(records), 100)
+		ch <- &dns.Envelope{RR: records[:n]}
+		records = records[n:]
+	}
+	close(ch)
+	<-done
+	w.Close()
+}
+
+// sendNotify tells the secondaries that a zone changed
+func (s *DNSServer) sendNotify(zone string) {
+	client := &dns.Client{Timeout: 5 * time.Second}
+	for _, target := range s.notify {
+		m := new(dns.Msg)
+		m.SetNotify(zone)
+		m.Authoritative = true
+		if _, _, err := client.Exchange(m, target); err != nil {
+			log.Printf("WARN: Failed to send NOTIFY for %s to %s: %v", zone, target, err)
+		}
+	}
+}
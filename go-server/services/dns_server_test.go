@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudku-server/models"
+
+	"github.com/miekg/dns"
+)
+
+// testServer returns a DNS server serving example.com, not listening
+func testServer(t *testing.T) *DNSServer {
+	t.Helper()
+	s := NewDNSServerWithOptions(DNSServerOptions{})
+	sets := DesiredRRSets("example.com", testSOA(2026010100), testZoneRecords())
+	if err := s.ServeZone(1, "example.com", 2026010100, sets, nil); err != nil {
+		t.Fatalf("ServeZone: %v", err)
+	}
+	return s
+}
+
+func testAliasZone(name, ip string) AliasZone {
+	return AliasZone{
+		Name: name,
+		RRSets: DesiredRRSets(name, testSOA(2026010100), []models.DNSRecord{
+			{RecordType: "A", Name: "@", Value: ip, TTL: 3600},
+		}),
+	}
+}
+
+func TestDNSServerServesAliasZones(t *testing.T) {
+	s := testServer(t)
+
+	if err := s.ServeAliasZones(1, 2026010100, []AliasZone{testAliasZone("example.net", "203.0.113.20")}); err != nil {
+		t.Fatalf("ServeAliasZones: %v", err)
+	}
+	z := s.findZone("www.example.net.")
+	if z == nil || z.origin != "example.net." || z.domainID != 1 {
+		t.Fatalf("zone for www.example.net = %+v, want the example.net alias zone", z)
+	}
+	m := new(dns.Msg)
+	z.answer("example.net.", dns.TypeA, m)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "203.0.113.20" {
+		t.Errorf("example.net A = %v", m.Answer)
+	}
+
+	// Reloading the domain replaces its alias zones, dropping removed ones
+	if err := s.ServeAliasZones(1, 2026010101, []AliasZone{testAliasZone("example.org", "203.0.113.30")}); err != nil {
+		t.Fatalf("ServeAliasZones: %v", err)
+	}
+	if s.findZone("example.net.") != nil {
+		t.Error("removed alias zone example.net is still served")
+	}
+	if s.findZone("example.org.") == nil {
+		t.Error("new alias zone example.org is not served")
+	}
+
+	s.RemoveZone(1)
+	for _, name := range []string{"example.com.", "example.org."} {
+		if s.findZone(name) != nil {
+			t.Errorf("%s still served after the domain was removed", name)
+		}
+	}
+}
+
+// listeningServer serves a zone with a CNAME, a wildcard, a delegation with
+// glue and an MX on a local port
+func listeningServer(t *testing.T, opts DNSServerOptions, keys []ZoneSigningKey) *DNSServer {
+	t.Helper()
+	opts.Addr = "127.0.0.1:0"
+	s := NewDNSServerWithOptions(opts)
+
+	priority := sql.NullInt32{Int32: 10, Valid: true}
+	records := []models.DNSRecord{
+		{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600},
+		{RecordType: "A", Name: "www", Value: "203.0.113.10", TTL: 3600},
+		{RecordType: "CNAME", Name: "blog", Value: "www.example.com", TTL: 3600},
+		{RecordType: "CNAME", Name: "cdn", Value: "cdn.example.net", TTL: 3600},
+		{RecordType: "A", Name: "*.apps", Value: "203.0.113.50", TTL: 300},
+		{RecordType: "NS", Name: "sub", Value: "ns.sub.example.com", TTL: 3600},
+		{RecordType: "A", Name: "ns.sub", Value: "203.0.113.60", TTL: 3600},
+		{RecordType: "MX", Name: "@", Value: "mail.example.com", TTL: 3600, Priority: priority},
+		{RecordType: "A", Name: "mail", Value: "203.0.113.70", TTL: 3600},
+		{RecordType: "TXT", Name: "@", Value: strings.Repeat("x", 2000), TTL: 3600},
+	}
+	sets := DesiredRRSets("example.com", testSOA(2026010100), records)
+	if err := s.ServeZone(1, "example.com", 2026010100, sets, keys); err != nil {
+		t.Fatalf("ServeZone: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func query(t *testing.T, s *DNSServer, network, name string, qtype uint16, do bool) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	if do {
+		m.SetEdns0(1232, true)
+	}
+	client := &dns.Client{Net: network, Timeout: 2 * time.Second}
+	resp, _, err := client.Exchange(m, s.Addr())
+	if err != nil {
+		t.Fatalf("query %s %s over %s: %v", name, dns.TypeToString[qtype], network, err)
+	}
+	return resp
+}
+
+func TestDNSServerAnswers(t *testing.T) {
+	s := listeningServer(t, DNSServerOptions{}, nil)
+
+	resp := query(t, s, "udp", "WWW.Example.com.", dns.TypeA, false)
+	if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative || len(resp.Answer) != 1 {
+		t.Fatalf("www A = %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); a.A.String() != "203.0.113.10" {
+		t.Errorf("www A = %s", a.A)
+	}
+
+	resp = query(t, s, "udp", "mail.example.com.", dns.TypeAAAA, false)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Errorf("NODATA response = %v, want no answer and the SOA", resp)
+	} else if soa := resp.Ns[0].(*dns.SOA); soa.Hdr.Ttl != soa.Minttl || soa.Serial != 2026010100 {
+		t.Errorf("negative SOA = %v, want its TTL capped at the minimum", soa)
+	}
+
+	resp = query(t, s, "udp", "missing.example.com.", dns.TypeA, false)
+	if resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 {
+		t.Errorf("NXDOMAIN response = %v", resp)
+	}
+
+	resp = query(t, s, "udp", "example.org.", dns.TypeA, false)
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("query outside served zones rcode = %s, want REFUSED", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDNSServerCNAMEAndWildcard(t *testing.T) {
+	s := listeningServer(t, DNSServerOptions{}, nil)
+
+	resp := query(t, s, "udp", "blog.example.com.", dns.TypeA, false)
+	if len(resp.Answer) != 2 {
+		t.Fatalf("blog A = %v, want the CNAME and the in-zone target", resp.Answer)
+	}
+	if _, ok := resp.Answer[0].(*dns.CNAME); !ok {
+		t.Errorf("first answer = %v, want the CNAME", resp.Answer[0])
+	}
+	if a, ok := resp.Answer[1].(*dns.A); !ok || a.Hdr.Name != "www.example.com." {
+		t.Errorf("second answer = %v, want the A record of www", resp.Answer[1])
+	}
+
+	// Targets outside the zone are left to the resolver
+	resp = query(t, s, "udp", "cdn.example.com.", dns.TypeA, false)
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Errorf("cdn A = %v, want only the CNAME", resp.Answer)
+	}
+
+	resp = query(t, s, "udp", "shop.apps.example.com.", dns.TypeA, false)
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Name != "shop.apps.example.com." {
+		t.Errorf("wildcard answer = %v, want a record synthesized for the query name", resp.Answer)
+	}
+}
+
+func TestDNSServerReferralAndAdditional(t *testing.T) {
+	s := listeningServer(t, DNSServerOptions{}, nil)
+
+	resp := query(t, s, "udp", "host.sub.example.com.", dns.TypeA, false)
+	if resp.Authoritative || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("referral = %v, want a non-authoritative NS referral", resp)
+	}
+	if len(resp.Extra) != 1 || resp.Extra[0].(*dns.A).A.String() != "203.0.113.60" {
+		t.Errorf("referral glue = %v", resp.Extra)
+	}
+
+	resp = query(t, s, "udp", "example.com.", dns.TypeMX, false)
+	if len(resp.Answer) != 1 || len(resp.Extra) != 1 || resp.Extra[0].Header().Name != "mail.example.com." {
+		t.Errorf("MX response = %v, want the mail host address as additional data", resp)
+	}
+}
+
+func TestDNSServerTruncatesOverUDP(t *testing.T) {
+	s := listeningServer(t, DNSServerOptions{}, nil)
+
+	resp := query(t, s, "udp", "example.com.", dns.TypeTXT, false)
+	if !resp.Truncated {
+		t.Errorf("large TXT over UDP not truncated: %d answers", len(resp.Answer))
+	}
+	resp = query(t, s, "tcp", "example.com.", dns.TypeTXT, false)
+	if resp.Truncated || len(resp.Answer) != 1 {
+		t.Errorf("large TXT over TCP = truncated %v, %d answers", resp.Truncated, len(resp.Answer))
+	}
+}
+
+func TestDNSServerZoneTransfer(t *testing.T) {
+	transfer := func(s *DNSServer) ([]dns.RR, error) {
+		m := new(dns.Msg)
+		m.SetAxfr("example.com.")
+		env, err := new(dns.Transfer).In(m, s.Addr())
+		if err != nil {
+			return nil, err
+		}
+		var rrs []dns.RR
+		for e := range env {
+			if e.Error != nil {
+				return nil, e.Error
+			}
+			rrs = append(rrs, e.RR...)
+		}
+		return rrs, nil
+	}
+
+	if rrs, err := transfer(listeningServer(t, DNSServerOptions{}, nil)); err == nil {
+		t.Errorf("AXFR to a host that is not a secondary returned %d records", len(rrs))
+	}
+
+	rrs, err := transfer(listeningServer(t, DNSServerOptions{Secondaries: []string{"127.0.0.0/8"}}, nil))
+	if err != nil {
+		t.Fatalf("AXFR from a secondary: %v", err)
+	}
+	if len(rrs) < 3 || rrs[0].Header().Rrtype != dns.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("AXFR = %d records, want the zone framed by its SOA", len(rrs))
+	}
+}
+
+func TestDNSServerSignsWhenAsked(t *testing.T) {
+	var keys []ZoneSigningKey
+	for _, flags := range []uint16{257, 256} {
+		dnskey := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DefaultDNSTTL},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := dnskey.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, ZoneSigningKey{DNSKEY: dnskey, Signer: priv.(crypto.Signer), Active: true})
+	}
+	s := listeningServer(t, DNSServerOptions{}, keys)
+
+	resp := query(t, s, "udp", "www.example.com.", dns.TypeA, false)
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			t.Error("RRSIG sent without the DO bit")
+		}
+	}
+
+	resp = query(t, s, "udp", "www.example.com.", dns.TypeA, true)
+	var sig *dns.RRSIG
+	for _, rr := range resp.Answer {
+		if s, ok := rr.(*dns.RRSIG); ok {
+			sig = s
+		}
+	}
+	if sig == nil {
+		t.Fatalf("no RRSIG with the DO bit: %v", resp.Answer)
+	}
+	if err := sig.Verify(keys[1].DNSKEY, []dns.RR{resp.Answer[0]}); err != nil {
+		t.Errorf("RRSIG does not verify with the ZSK: %v", err)
+	}
+
+	resp = query(t, s, "udp", "missing.example.com.", dns.TypeA, true)
+	hasNSEC := false
+	for _, rr := range resp.Ns {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			hasNSEC = true
+		}
+	}
+	if resp.Rcode != dns.RcodeNameError || !hasNSEC {
+		t.Errorf("signed NXDOMAIN = %v, want an NSEC denial proof", resp)
+	}
+}
+
+func TestDNSServerNotifiesSecondaries(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan string, 4)
+	secondary := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeNotify {
+			notified <- r.Question[0].Name
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})}
+	go secondary.ActivateAndServe()
+	t.Cleanup(func() { secondary.Shutdown() })
+
+	s := NewDNSServerWithOptions(DNSServerOptions{Secondaries: []string{conn.LocalAddr().String()}})
+	sets := DesiredRRSets("example.com", testSOA(2026010100), testZoneRecords())
+	if err := s.ServeZone(1, "example.com", 2026010100, sets, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeAliasZones(1, 2026010100, []AliasZone{testAliasZone("example.net", "203.0.113.20")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-notified:
+		t.Fatalf("NOTIFY for %s on the first load", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sets = DesiredRRSets("example.com", testSOA(2026010101), testZoneRecords())
+	if err := s.ServeZone(1, "example.com", 2026010101, sets, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeAliasZones(1, 2026010101, []AliasZone{testAliasZone("example.net", "203.0.113.20")}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case name := <-notified:
+			got[name] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("NOTIFY received for %v, want example.com. and example.net.", got)
+		}
+	}
+	if !got["example.com."] || !got["example.net."] {
+		t.Errorf("NOTIFY received for %v, want example.com. and example.net.", got)
+	}
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+)
+
+// DNS template errors
+var (
+	ErrDNSTemplateNotFound = errors.New("DNS template not found")
+	ErrInvalidDNSTemplate  = errors.New("invalid DNS template")
+	ErrDNSTemplateExists   = errors.New("a DNS template with this name already exists")
+	// ErrTemplateVariables is returned when placeholders have no value
+	ErrTemplateVariables = errors.New("missing template variables")
+)
+
+// maxTemplateRecords caps the records of a user template
+const maxTemplateRecords = 100
+
+// templatePlaceholder matches a {placeholder} in a template record
+var templatePlaceholder = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+
+// builtinTemplateVariables are filled in for every domain:
+//   - {domain}: the domain name
+//   - {domain_dashed}: the domain with dots replaced by dashes
+//   - {server_ip}: the hosting server's IPv4 address
+var builtinTemplateVariables = map[string]bool{"domain": true, "domain_dashed": true, "server_ip": true}
+
+// DNSTemplateVariable is a placeholder a template needs besides the built-in
+// ones
+type DNSTemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// DNSTemplatePreset is a template shipped with the panel
+type DNSTemplatePreset struct {
+	Slug        string                     `json:"slug"`
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Variables   []DNSTemplateVariable      `json:"variables"`
+	Records     []models.DNSTemplateRecord `json:"records"`
+}
+
+func templatePriority(p int) *int {
+	return &p
+}
+
+// dnsTemplatePresets are the shipped templates, "default" being the records
+// new domains get
+var dnsTemplatePresets = []DNSTemplatePreset{
+	{
+		Slug:        "default",
+		Name:        "Web hosting",
+		Description: "Website on this server with www and ftp hosts, mail at mail.{domain}",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "A", Name: "@", Value: "{server_ip}"},
+			{RecordType: "A", Name: "www", Value: "{server_ip}"},
+			{RecordType: "CNAME", Name: "ftp", Value: "{domain}"},
+			{RecordType: "MX", Name: "@", Value: "mail.{domain}", Priority: templatePriority(10)},
+		},
+	},
+	{
+		Slug:        "mail",
+		Name:        "Mail on this server",
+		Description: "Mail host, MX, SPF and DMARC for mail handled by this server",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "A", Name: "mail", Value: "{server_ip}"},
+			{RecordType: "MX", Name: "@", Value: "mail.{domain}", Priority: templatePriority(10)},
+			{RecordType: "TXT", Name: "@", Value: "v=spf1 a mx ip4:{server_ip} ~all"},
+			{RecordType: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=quarantine; rua=mailto:postmaster@{domain}"},
+		},
+	},
+	{
+		Slug:        "google-workspace",
+		Name:        "Google Workspace",
+		Description: "Gmail MX and SPF for Google Workspace",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "MX", Name: "@", Value: "smtp.google.com", Priority: templatePriority(1)},
+			{RecordType: "TXT", Name: "@", Value: "v=spf1 include:_spf.google.com ~all"},
+		},
+	},
+	{
+		Slug:        "google-site-verification",
+		Name:        "Google site verification",
+		Description: "TXT record proving ownership to Google Search Console and Workspace",
+		Variables:   []DNSTemplateVariable{{Name: "verification_code", Description: "The code after google-site-verification="}},
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "TXT", Name: "@", Value: "google-site-verification={verification_code}"},
+		},
+	},
+	{
+		Slug:        "microsoft-365",
+		Name:        "Microsoft 365",
+		Description: "Exchange Online MX, SPF and Outlook autodiscover",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "MX", Name: "@", Value: "{domain_dashed}.mail.protection.outlook.com", Priority: templatePriority(0)},
+			{RecordType: "TXT", Name: "@", Value: "v=spf1 include:spf.protection.outlook.com -all"},
+			{RecordType: "CNAME", Name: "autodiscover", Value: "autodiscover.outlook.com"},
+		},
+	},
+	{
+		Slug:        "microsoft-365-verification",
+		Name:        "Microsoft 365 domain verification",
+		Description: "TXT record proving ownership to Microsoft 365",
+		Variables:   []DNSTemplateVariable{{Name: "verification_code", Description: "The value after MS=, e.g. ms12345678"}},
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "TXT", Name: "@", Value: "MS={verification_code}"},
+		},
+	},
+	{
+		Slug:        "zoho-mail",
+		Name:        "Zoho Mail",
+		Description: "Zoho Mail MX and SPF",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "MX", Name: "@", Value: "mx.zoho.com", Priority: templatePriority(10)},
+			{RecordType: "MX", Name: "@", Value: "mx2.zoho.com", Priority: templatePriority(20)},
+			{RecordType: "MX", Name: "@", Value: "mx3.zoho.com", Priority: templatePriority(50)},
+			{RecordType: "TXT", Name: "@", Value: "v=spf1 include:zoho.com ~all"},
+		},
+	},
+	{
+		Slug:        "fastmail",
+		Name:        "Fastmail",
+		Description: "Fastmail MX, SPF and DKIM",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "MX", Name: "@", Value: "in1-smtp.messagingengine.com", Priority: templatePriority(10)},
+			{RecordType: "MX", Name: "@", Value: "in2-smtp.messagingengine.com", Priority: templatePriority(20)},
+			{RecordType: "TXT", Name: "@", Value: "v=spf1 include:spf.messagingengine.com ?all"},
+			{RecordType: "CNAME", Name: "fm1._domainkey", Value: "fm1.{domain}.dkim.fmhosted.com"},
+			{RecordType: "CNAME", Name: "fm2._domainkey", Value: "fm2.{domain}.dkim.fmhosted.com"},
+			{RecordType: "CNAME", Name: "fm3._domainkey", Value: "fm3.{domain}.dkim.fmhosted.com"},
+		},
+	},
+	{
+		Slug:        "letsencrypt-caa",
+		Name:        "Let's Encrypt only (CAA)",
+		Description: "Only allow Let's Encrypt to issue certificates for the domain",
+		Records: []models.DNSTemplateRecord{
+			{RecordType: "CAA", Name: "@", Value: `0 issue "letsencrypt.org"`},
+			{RecordType: "CAA", Name: "@", Value: `0 issuewild "letsencrypt.org"`},
+		},
+	},
+}
+
+// DNSTemplatePresets returns the shipped templates
+func DNSTemplatePresets() []DNSTemplatePreset {
+	return dnsTemplatePresets
+}
+
+// GetDNSTemplatePreset returns a shipped template by slug
+func GetDNSTemplatePreset(slug string) (*DNSTemplatePreset, bool) {
+	for i := range dnsTemplatePresets {
+		if dnsTemplatePresets[i].Slug == slug {
+			return &dnsTemplatePresets[i], true
+		}
+	}
+	return nil, false
+}
+
+// TemplateVariables returns the non built-in placeholders used by records,
+// sorted
+func TemplateVariables(records []models.DNSTemplateRecord) []string {
+	seen := map[string]bool{}
+	for _, r := range records {
+		for _, m := range templatePlaceholder.FindAllStringSubmatch(r.Name+" "+r.Value, -1) {
+			if !builtinTemplateVariables[m[1]] {
+				seen[m[1]] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateValues merges the built-in variables of a domain with the
+// user-supplied ones; built-ins cannot be overridden
+func templateValues(domainName, serverIP string, vars map[string]string) map[string]string {
+	values := make(map[string]string, len(vars)+3)
+	for k, v := range vars {
+		values[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	values["domain"] = domainName
+	values["domain_dashed"] = strings.ReplaceAll(domainName, ".", "-")
+	values["server_ip"] = serverIP
+	return values
+}
+
+// RenderDNSTemplate fills in the placeholders of template records for a
+// domain and validates the result. vars holds the template's own variables
+func RenderDNSTemplate(records []models.DNSTemplateRecord, domainName, serverIP string, vars map[string]string) ([]ZoneRecord, error) {
+	values := templateValues(domainName, serverIP, vars)
+
+	var missing []string
+	for _, name := range TemplateVariables(records) {
+		if values[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateVariables, strings.Join(missing, ", "))
+	}
+
+	fill := func(s string) string {
+		return templatePlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+			return values[m[1:len(m)-1]]
+		})
+	}
+
+	out := make([]ZoneRecord, 0, len(records))
+	var rendered []models.DNSRecord
+	for i, r := range records {
+		in := DNSRecordInput{
+			RecordType: r.RecordType,
+			Name:       fill(r.Name),
+			Value:      fill(r.Value),
+			TTL:        r.TTL,
+			Priority:   r.Priority,
+		}
+		NormalizeDNSRecord(&in, domainName)
+		if err := ValidateDNSRecord(&in, rendered); err != nil {
+			return nil, fmt.Errorf("%w: record %d: %v", ErrInvalidDNSTemplate, i+1, err)
+		}
+		out = append(out, ZoneRecord{RecordType: in.RecordType, Name: in.Name, Value: in.Value, TTL: in.TTL, Priority: in.Priority})
+		rendered = append(rendered, models.DNSRecord{RecordType: in.RecordType, Name: in.Name, Value: in.Value})
+	}
+	return out, nil
+}
+
+// ValidateDNSTemplate checks the records of a user template. Records are
+// rendered for a sample domain; records with custom placeholders are only
+// checked for their type and TTL since their final value is unknown
+func ValidateDNSTemplate(records []models.DNSTemplateRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("%w: at least one record is required", ErrInvalidDNSTemplate)
+	}
+	if len(records) > maxTemplateRecords {
+		return fmt.Errorf("%w: at most %d records are allowed", ErrInvalidDNSTemplate, maxTemplateRecords)
+	}
+
+	var plain []models.DNSTemplateRecord
+	for i := range records {
+		r := &records[i]
+		r.RecordType = strings.ToUpper(strings.TrimSpace(r.RecordType))
+		if !isSupportedRecordType(r.RecordType) {
+			return fmt.Errorf("%w: record %d: unsupported type %q", ErrInvalidDNSTemplate, i+1, r.RecordType)
+		}
+		if r.TTL == 0 {
+			r.TTL = DefaultDNSTTL
+		}
+		if r.TTL < MinDNSTTL || r.TTL > MaxDNSTTL {
+			return fmt.Errorf("%w: record %d: ttl must be between %d and %d", ErrInvalidDNSTemplate, i+1, MinDNSTTL, MaxDNSTTL)
+		}
+		if strings.TrimSpace(r.Value) == "" {
+			return fmt.Errorf("%w: record %d: value is required", ErrInvalidDNSTemplate, i+1)
+		}
+		if len(TemplateVariables([]models.DNSTemplateRecord{*r})) == 0 {
+			plain = append(plain, *r)
+		}
+	}
+
+	_, err := RenderDNSTemplate(plain, "example.com", "192.0.2.1", nil)
+	return err
+}
+
+func isSupportedRecordType(t string) bool {
+	for _, supported := range SupportedDNSRecordTypes {
+		if t == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// isSPF and isDMARC detect policy TXT records of which a name may only have
+// one
+func isSPF(recordType, value string) bool {
+	return recordType == "TXT" && strings.HasPrefix(strings.ToLower(value), "v=spf1")
+}
+
+func isDMARC(recordType, value string) bool {
+	return recordType == "TXT" && strings.HasPrefix(strings.ToLower(value), "v=dmarc1")
+}
+
+// templateConflict explains why a stored record clashes with the records of
+// a template, or returns "" when both can coexist
+func templateConflict(r *models.DNSRecord, records []ZoneRecord) string {
+	for _, t := range records {
+		if !strings.EqualFold(r.Name, t.Name) {
+			continue
+		}
+		switch {
+		case t.RecordType == "CNAME" || strings.EqualFold(r.RecordType, "CNAME"):
+			return "CNAME cannot coexist with other records at the same name"
+		case t.RecordType == "MX" && strings.EqualFold(r.RecordType, "MX"):
+			return "MX points to another mail provider"
+		case isSPF(t.RecordType, t.Value) && isSPF(strings.ToUpper(r.RecordType), r.Value):
+			return "a name may only have one SPF record"
+		case isDMARC(t.RecordType, t.Value) && isDMARC(strings.ToUpper(r.RecordType), r.Value):
+			return "a name may only have one DMARC record"
+		}
+	}
+	return ""
+}
+
+// DiffDNSTemplate compares rendered template records with the stored records
+// of a domain. Records already present are left alone. Stored records that
+// clash with the template (another provider's MX, a second SPF record, a
+// CNAME) are reported as conflicts, or removed when replaceConflicting is
+// set
+func DiffDNSTemplate(existing []models.DNSRecord, records []ZoneRecord, replaceConflicting bool) *ZoneDiff {
+	diff := &ZoneDiff{
+		Mode:   "template",
+		Add:    []ZoneRecord{},
+		Update: []ZoneRecordChange{},
+		Remove: []models.DNSRecordResponse{},
+	}
+
+	stored := make(map[string]*models.DNSRecord, len(existing))
+	for i := range existing {
+		r := &existing[i]
+		stored[zoneRecordKey(r.RecordType, r.Name, r.Value)] = r
+	}
+
+	matched := make(map[int]bool)
+	for _, in := range records {
+		r, ok := stored[zoneRecordKey(in.RecordType, in.Name, in.Value)]
+		if !ok {
+			diff.Add = append(diff.Add, in)
+			continue
+		}
+		matched[r.ID] = true
+		if r.TTL != in.TTL || !samePriority(r, in.Priority) {
+			diff.Update = append(diff.Update, ZoneRecordChange{Before: r.ToResponse(), After: in})
+		} else {
+			diff.Unchanged++
+		}
+	}
+
+	var kept []models.DNSRecord
+	for i := range existing {
+		r := &existing[i]
+		reason := ""
+		if !matched[r.ID] {
+			reason = templateConflict(r, records)
+		}
+		switch {
+		case reason == "":
+			kept = append(kept, *r)
+		case replaceConflicting:
+			diff.Remove = append(diff.Remove, r.ToResponse())
+		default:
+			kept = append(kept, *r)
+			diff.Conflicts = append(diff.Conflicts, ZoneFileIssue{
+				Record: r.Name + " " + r.RecordType + " " + r.Value,
+				Reason: reason,
+			})
+		}
+	}
+
+	// Anything else the kept records do not allow, such as a CNAME next to
+	// a record the template does not conflict with by type
+	if len(diff.Conflicts) == 0 {
+		for _, add := range diff.Add {
+			in := DNSRecordInput{RecordType: add.RecordType, Name: add.Name, Value: add.Value, TTL: add.TTL, Priority: add.Priority}
+			if err := ValidateDNSRecord(&in, kept); err != nil {
+				diff.Conflicts = append(diff.Conflicts, ZoneFileIssue{
+					Record: add.Name + " " + add.RecordType + " " + add.Value,
+					Reason: err.Error(),
+				})
+			}
+		}
+	}
+	return diff
+}
+
+// ResolveDNSTemplate returns the records of a preset (by slug) or of one of
+// the user's templates (by ID)
+func ResolveDNSTemplate(ctx context.Context, userID int, preset string, templateID int) ([]models.DNSTemplateRecord, error) {
+	if preset != "" {
+		p, ok := GetDNSTemplatePreset(preset)
+		if !ok {
+			return nil, ErrDNSTemplateNotFound
+		}
+		return p.Records, nil
+	}
+	t, err := models.GetDNSTemplateByID(ctx, templateID, userID)
+	if err != nil {
+		return nil, ErrDNSTemplateNotFound
+	}
+	return t.Records, nil
+}
+
+// CreateDefaultDNSRecords gives a new domain the records of the configured
+// default template
+func CreateDefaultDNSRecords(ctx context.Context, actor models.DNSChangeActor, domainID int, domainName, serverIP string) error {
+	return models.UpdateZone(ctx, domainID, func(q database.Querier) error {
+		return InsertDefaultDNSRecords(ctx, q, actor, domainID, domainName, serverIP)
+	})
+}
+
+// InsertDefaultDNSRecords creates the default records using q, so they can
+// take part in a caller's transaction. An unknown DNS_DEFAULT_TEMPLATE falls
+// back to the "default" preset
+func InsertDefaultDNSRecords(ctx context.Context, q database.Querier, actor models.DNSChangeActor, domainID int, domainName, serverIP string) error {
+	preset, ok := GetDNSTemplatePreset(config.AppConfig.DNSDefaultTemplate)
+	if !ok || len(TemplateVariables(preset.Records)) > 0 {
+		log.Printf("WARN: DNS template %q cannot be applied to new domains, using default", config.AppConfig.DNSDefaultTemplate)
+		preset, _ = GetDNSTemplatePreset("default")
+	}
+
+	records, err := RenderDNSTemplate(preset.Records, domainName, serverIP, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if _, err := models.InsertDNSRecord(ctx, q, actor, domainID, r.RecordType, r.Name, r.Value, r.TTL, r.Priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+)
+
+// ErrInvalidSOA is returned for SOA values that are malformed or outside
+// sane bounds
+var ErrInvalidSOA = errors.New("invalid SOA values")
+
+// ZoneSOA is the SOA of a zone with configured defaults applied
+type ZoneSOA struct {
+	PrimaryNS  string `json:"primary_ns"`
+	Hostmaster string `json:"hostmaster"`
+	Serial     int64  `json:"serial"`
+	Refresh    int    `json:"refresh"`
+	Retry      int    `json:"retry"`
+	Expire     int    `json:"expire"`
+	Minimum    int    `json:"minimum"`
+}
+
+// ZoneNameservers returns the nameservers a hosted zone is served by:
+// DNS_NAMESERVERS, or ns1/ns2 under the zone itself
+func ZoneNameservers(zone string) []string {
+	if ns := config.AppConfig.DNSNameservers; len(ns) > 0 {
+		out := make([]string, len(ns))
+		for i, n := range ns {
+			out[i] = strings.TrimSuffix(strings.ToLower(n), ".")
+		}
+		return out
+	}
+	return []string{"ns1." + zone, "ns2." + zone}
+}
+
+// ResolveSOA applies the configured primary nameserver and hostmaster to
+// the stored SOA fields of a zone
+func ResolveSOA(zone string, z *models.DNSZone) ZoneSOA {
+	soa := ZoneSOA{
+		PrimaryNS:  ZoneNameservers(zone)[0],
+		Hostmaster: "hostmaster." + zone,
+		Serial:     z.Serial,
+		Refresh:    z.Refresh,
+		Retry:      z.Retry,
+		Expire:     z.Expire,
+		Minimum:    z.Minimum,
+	}
+	if h := config.AppConfig.DNSHostmaster; h != "" {
+		soa.Hostmaster = h
+	}
+	if z.PrimaryNS.Valid {
+		soa.PrimaryNS = z.PrimaryNS.String
+	}
+	if z.Hostmaster.Valid {
+		soa.Hostmaster = z.Hostmaster.String
+	}
+	soa.Hostmaster = hostmasterName(soa.Hostmaster)
+	return soa
+}
+
+// GetZoneSOA loads the resolved SOA of a domain's zone
+func GetZoneSOA(ctx context.Context, d *models.Domain) (ZoneSOA, error) {
+	z, err := models.GetDNSZone(ctx, database.DB, d.ID)
+	if err != nil {
+		return ZoneSOA{}, err
+	}
+	return ResolveSOA(d.DomainName, z), nil
+}
+
+// hostmasterName converts a contact email to the SOA RNAME form, escaping
+// dots in the local part: "john.doe@example.com" -> "john\.doe.example.com"
+func hostmasterName(contact string) string {
+	contact = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(contact)), ".")
+	local, domain, ok := strings.Cut(contact, "@")
+	if !ok {
+		return contact
+	}
+	return strings.ReplaceAll(local, ".", `\.`) + "." + domain
+}
+
+// ValidateSOAFields checks an SOA update against the stored values it will
+// be combined with
+func ValidateSOAFields(current *models.DNSZone, f *models.SOAFields) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSOA, fmt.Sprintf(format, args...))
+	}
+
+	if f.PrimaryNS != nil {
+		*f.PrimaryNS = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(*f.PrimaryNS)), ".")
+		if *f.PrimaryNS != "" && !validHostname(*f.PrimaryNS) {
+			return invalid("primary nameserver %q is not a host name", *f.PrimaryNS)
+		}
+	}
+	if f.Hostmaster != nil {
+		*f.Hostmaster = strings.TrimSpace(*f.Hostmaster)
+		if h := *f.Hostmaster; h != "" {
+			local, domain, isEmail := strings.Cut(h, "@")
+			if isEmail && (local == "" || !validHostname(strings.ToLower(domain))) {
+				return invalid("hostmaster %q is not an email address", h)
+			}
+			if !isEmail && !validHostname(strings.ToLower(strings.TrimSuffix(h, "."))) {
+				return invalid("hostmaster %q is neither an email address nor an SOA mailbox name", h)
+			}
+		}
+	}
+
+	refresh, retry, expire, minimum := current.Refresh, current.Retry, current.Expire, current.Minimum
+	for _, v := range []struct {
+		dst *int
+		src *int
+	}{{&refresh, f.Refresh}, {&retry, f.Retry}, {&expire, f.Expire}, {&minimum, f.Minimum}} {
+		if v.src != nil {
+			*v.dst = *v.src
+		}
+	}
+
+	switch {
+	case refresh < 300 || refresh > 86400:
+		return invalid("refresh must be between 300 and 86400 seconds")
+	case retry < 60 || retry >= refresh:
+		return invalid("retry must be at least 60 seconds and less than refresh")
+	case expire < refresh+retry || expire > 2419200:
+		return invalid("expire must cover refresh plus retry and be at most 2419200 seconds")
+	case minimum < MinDNSTTL || minimum > 86400:
+		return invalid("minimum must be between %d and 86400 seconds", MinDNSTTL)
+	}
+	return nil
+}
+
+// RenderZoneFile writes a zone in BIND format: the SOA, the apex NS set
+// and the stored records
+func RenderZoneFile(zone string, soa ZoneSOA, records []models.DNSRecord) string {
+	var b strings.Builder
+	b.WriteString("; Zone file for " + zone + "\n")
+	b.WriteString("; Exported from CloudKu\n")
+	b.WriteString("$ORIGIN " + zone + ".\n")
+	b.WriteString("$TTL " + strconv.Itoa(DefaultDNSTTL) + "\n\n")
+
+	fmt.Fprintf(&b, "@ IN SOA %s. %s. (\n", soa.PrimaryNS, soa.Hostmaster)
+	fmt.Fprintf(&b, "    %-10d ; Serial\n", soa.Serial)
+	fmt.Fprintf(&b, "    %-10d ; Refresh\n", soa.Refresh)
+	fmt.Fprintf(&b, "    %-10d ; Retry\n", soa.Retry)
+	fmt.Fprintf(&b, "    %-10d ; Expire\n", soa.Expire)
+	fmt.Fprintf(&b, "    %-10d ; Minimum TTL\n)\n\n", soa.Minimum)
+
+	for _, ns := range ZoneNameservers(zone) {
+		b.WriteString("@ IN NS " + ns + ".\n")
+	}
+	b.WriteString("\n")
+
+	for i := range records {
+		r := &records[i]
+		name := r.Name
+		if name == "@" {
+			name = zone + "."
+		}
+		b.WriteString(name + " " + strconv.Itoa(r.TTL) + " IN " + r.RecordType + " " + ZoneRecordData(r) + "\n")
+	}
+	return b.String()
+}
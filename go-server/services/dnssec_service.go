@@ -0,0 +1,522 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// ErrDNSSECEnabled is returned when enabling DNSSEC on a signed zone
+	ErrDNSSECEnabled = errors.New("DNSSEC is already enabled for this zone")
+	// ErrDNSSECDisabled is returned for key operations on an unsigned zone
+	ErrDNSSECDisabled = errors.New("DNSSEC is not enabled for this zone")
+	// ErrRolloverInProgress is returned when a rollover of the same key type
+	// has not finished yet
+	ErrRolloverInProgress = errors.New("a rollover of this key type is already in progress")
+	// ErrNoKSKRollover is returned when completing a KSK rollover that was
+	// never started
+	ErrNoKSKRollover = errors.New("no KSK rollover is in progress")
+)
+
+// dnssecAlgorithm is the signing algorithm of every generated key
+const dnssecAlgorithm = dns.ECDSAP256SHA256
+
+// DSRecord is a delegation signer record to give to the registrar
+type DSRecord struct {
+	KeyTag     int    `json:"key_tag"`
+	Algorithm  int    `json:"algorithm"`
+	DigestType int    `json:"digest_type"`
+	Digest     string `json:"digest"`
+	// Record is the DS in zone file form
+	Record string `json:"record"`
+}
+
+// DNSSECKeyInfo is a key as shown to the user
+type DNSSECKeyInfo struct {
+	models.DNSSECKey
+	DNSKEY string `json:"dnskey"`
+}
+
+// DNSSECStatus is the DNSSEC state of a zone
+type DNSSECStatus struct {
+	Enabled bool            `json:"enabled"`
+	Keys    []DNSSECKeyInfo `json:"keys"`
+	// DS lists the DS records of every KSK; during a KSK rollover it holds
+	// the old and the new one
+	DS []DSRecord `json:"ds"`
+	// KSKRollover is set while the registrar still has to be given the DS of
+	// a new KSK; complete it once the new DS is published
+	KSKRollover bool `json:"ksk_rollover"`
+}
+
+// ZoneSigningKey is a decrypted key used to sign a zone
+type ZoneSigningKey struct {
+	DNSKEY *dns.DNSKEY
+	Signer crypto.Signer
+	// Active keys sign; inactive ones are only published
+	Active bool
+}
+
+// DNSSECOptions tunes key rollover
+type DNSSECOptions struct {
+	// ZSKLifetime and KSKLifetime are how long a key signs before a rollover
+	// starts; 0 disables automatic rollover
+	ZSKLifetime time.Duration
+	KSKLifetime time.Duration
+	// Propagation is how long a new key is published before it signs and an
+	// old key stays published after it stopped signing
+	Propagation time.Duration
+}
+
+// DNSSECService manages the signing keys of zones. The zones are signed by
+// PowerDNS (keys are uploaded through its cryptokeys API) or by the
+// embedded DNS server
+type DNSSECService struct {
+	opts DNSSECOptions
+	now  func() time.Time
+}
+
+// NewDNSSECService creates a DNSSEC service with the rollover settings from
+// config
+func NewDNSSECService() *DNSSECService {
+	cfg := config.AppConfig
+	return NewDNSSECServiceWith(DNSSECOptions{
+		ZSKLifetime: cfg.DNSSECZSKLifetime,
+		KSKLifetime: cfg.DNSSECKSKLifetime,
+		Propagation: cfg.DNSSECPropagation,
+	})
+}
+
+// NewDNSSECServiceWith creates a DNSSEC service with explicit options
+func NewDNSSECServiceWith(opts DNSSECOptions) *DNSSECService {
+	if opts.Propagation <= 0 {
+		opts.Propagation = 48 * time.Hour
+	}
+	return &DNSSECService{opts: opts, now: time.Now}
+}
+
+// DNSKEYFor returns the DNSKEY record of a stored key
+func DNSKEYFor(zone string, k *models.DNSSECKey) *dns.DNSKEY {
+	return &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: canonicalZone(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DefaultDNSTTL},
+		Flags:     uint16(k.Flags),
+		Protocol:  3,
+		Algorithm: uint8(k.Algorithm),
+		PublicKey: k.PublicKey,
+	}
+}
+
+// DNSSECPrivateKey decrypts the private key of a stored key, in the BIND
+// private key format PowerDNS accepts
+func DNSSECPrivateKey(k *models.DNSSECKey) (string, error) {
+	data, err := utils.DecryptSecret(k.PrivateKeyEncrypted)
+	if err != nil {
+		return "", fmt.Errorf("decrypt key %d: %w", k.KeyTag, err)
+	}
+	return string(data), nil
+}
+
+// generateDNSSECKey creates an ECDSA P-256 key for a zone
+func generateDNSSECKey(domainID int, zone, keyType, state string) (*models.DNSSECKey, error) {
+	flags := 256
+	if keyType == models.DNSSECKeyKSK {
+		flags = 257
+	}
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: canonicalZone(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DefaultDNSTTL},
+		Flags:     uint16(flags),
+		Protocol:  3,
+		Algorithm: dnssecAlgorithm,
+	}
+	priv, err := dnskey.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.EncryptSecret([]byte(dnskey.PrivateKeyString(priv)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DNSSECKey{
+		DomainID:            domainID,
+		KeyType:             keyType,
+		Algorithm:           int(dnskey.Algorithm),
+		Flags:               flags,
+		KeyTag:              int(dnskey.KeyTag()),
+		PublicKey:           dnskey.PublicKey,
+		PrivateKeyEncrypted: encrypted,
+		State:               state,
+	}, nil
+}
+
+// SigningKeys decrypts the published keys of a zone for signing
+func SigningKeys(zone string, keys []models.DNSSECKey) ([]ZoneSigningKey, error) {
+	out := make([]ZoneSigningKey, 0, len(keys))
+	for i := range keys {
+		k := &keys[i]
+		dnskey := DNSKEYFor(zone, k)
+		private, err := DNSSECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		priv, err := dnskey.NewPrivateKey(private)
+		if err != nil {
+			return nil, fmt.Errorf("parse key %d: %w", k.KeyTag, err)
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %d cannot sign", k.KeyTag)
+		}
+		out = append(out, ZoneSigningKey{DNSKEY: dnskey, Signer: signer, Active: k.State == models.DNSSECKeyActive})
+	}
+	return out, nil
+}
+
+// DSRecordsFor returns the SHA-256 DS records of the KSKs of a zone
+func DSRecordsFor(zone string, keys []models.DNSSECKey) []DSRecord {
+	out := []DSRecord{}
+	for i := range keys {
+		k := &keys[i]
+		if k.KeyType != models.DNSSECKeyKSK || k.State == models.DNSSECKeyRetired {
+			continue
+		}
+		ds := DNSKEYFor(zone, k).ToDS(dns.SHA256)
+		if ds == nil {
+			continue
+		}
+		out = append(out, DSRecord{
+			KeyTag:     int(ds.KeyTag),
+			Algorithm:  int(ds.Algorithm),
+			DigestType: int(ds.DigestType),
+			Digest:     strings.ToUpper(ds.Digest),
+			Record:     fmt.Sprintf("%s IN DS %d %d %d %s", ds.Hdr.Name, ds.KeyTag, ds.Algorithm, ds.DigestType, strings.ToUpper(ds.Digest)),
+		})
+	}
+	return out
+}
+
+// Status returns the keys and DS records of a domain's zone
+func (s *DNSSECService) Status(ctx context.Context, d *models.Domain) (*DNSSECStatus, error) {
+	zone, err := models.GetDNSZone(ctx, database.DB, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := models.GetDNSSECKeys(ctx, database.DB, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &DNSSECStatus{Enabled: zone.DNSSECEnabled, Keys: []DNSSECKeyInfo{}, DS: DSRecordsFor(d.DomainName, keys)}
+	for i := range keys {
+		k := keys[i]
+		dnskey := DNSKEYFor(d.DomainName, &k)
+		status.Keys = append(status.Keys, DNSSECKeyInfo{
+			DNSSECKey: k,
+			DNSKEY:    fmt.Sprintf("%d %d %d %s", dnskey.Flags, dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey),
+		})
+	}
+	status.KSKRollover = len(status.DS) > 1
+	return status, nil
+}
+
+// Enable turns on signing for a domain's zone with a fresh KSK and ZSK
+func (s *DNSSECService) Enable(ctx context.Context, d *models.Domain) error {
+	ksk, err := generateDNSSECKey(d.ID, d.DomainName, models.DNSSECKeyKSK, models.DNSSECKeyActive)
+	if err != nil {
+		return err
+	}
+	zsk, err := generateDNSSECKey(d.ID, d.DomainName, models.DNSSECKeyZSK, models.DNSSECKeyActive)
+	if err != nil {
+		return err
+	}
+
+	return models.UpdateZone(ctx, d.ID, func(q database.Querier) error {
+		zone, err := models.GetDNSZone(ctx, q, d.ID)
+		if err != nil {
+			return err
+		}
+		if zone.DNSSECEnabled {
+			return ErrDNSSECEnabled
+		}
+		if err := models.SetZoneDNSSEC(ctx, q, d.ID, true); err != nil {
+			return err
+		}
+		if err := models.InsertDNSSECKey(ctx, q, ksk); err != nil {
+			return err
+		}
+		return models.InsertDNSSECKey(ctx, q, zsk)
+	})
+}
+
+// Disable turns off signing and deletes the keys. The DS record must be
+// removed at the registrar first, or resolvers will fail to validate
+func (s *DNSSECService) Disable(ctx context.Context, d *models.Domain) error {
+	return models.UpdateZone(ctx, d.ID, func(q database.Querier) error {
+		zone, err := models.GetDNSZone(ctx, q, d.ID)
+		if err != nil {
+			return err
+		}
+		if !zone.DNSSECEnabled {
+			return ErrDNSSECDisabled
+		}
+		return models.SetZoneDNSSEC(ctx, q, d.ID, false)
+	})
+}
+
+// StartRollover begins replacing the ZSK or KSK of a zone.
+//
+// A ZSK rollover pre-publishes the new key: it is published now, signs once
+// the propagation delay passed, and the old key stays published as long
+// again. A KSK rollover uses double signatures: the new KSK signs right away
+// next to the old one, and the old one is retired by CompleteKSKRollover
+// once the new DS record is at the registrar
+func (s *DNSSECService) StartRollover(ctx context.Context, d *models.Domain, keyType string) (*models.DNSSECKey, error) {
+	state := models.DNSSECKeyPublished
+	if keyType == models.DNSSECKeyKSK {
+		state = models.DNSSECKeyActive
+	}
+	key, err := generateDNSSECKey(d.ID, d.DomainName, keyType, state)
+	if err != nil {
+		return nil, err
+	}
+	if keyType == models.DNSSECKeyZSK {
+		key.ActivateAt.Time, key.ActivateAt.Valid = s.now().Add(s.opts.Propagation), true
+	}
+
+	err = models.UpdateZone(ctx, d.ID, func(q database.Querier) error {
+		zone, err := models.GetDNSZone(ctx, q, d.ID)
+		if err != nil {
+			return err
+		}
+		if !zone.DNSSECEnabled {
+			return ErrDNSSECDisabled
+		}
+		keys, err := models.GetDNSSECKeys(ctx, q, d.ID)
+		if err != nil {
+			return err
+		}
+		if rolloverInProgress(keys, keyType) {
+			return ErrRolloverInProgress
+		}
+		return models.InsertDNSSECKey(ctx, q, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// rolloverInProgress reports whether a rollover of keyType is under way: a
+// ZSK waiting to sign, or more than one signing KSK
+func rolloverInProgress(keys []models.DNSSECKey, keyType string) bool {
+	signing := 0
+	for _, k := range keys {
+		if k.KeyType != keyType {
+			continue
+		}
+		if k.State == models.DNSSECKeyPublished {
+			return true
+		}
+		if k.State == models.DNSSECKeyActive {
+			signing++
+		}
+	}
+	return keyType == models.DNSSECKeyKSK && signing > 1
+}
+
+// CompleteKSKRollover retires every KSK but the newest once the user has
+// published the new DS record at the registrar
+func (s *DNSSECService) CompleteKSKRollover(ctx context.Context, d *models.Domain) error {
+	return models.UpdateZone(ctx, d.ID, func(q database.Querier) error {
+		keys, err := models.GetDNSSECKeys(ctx, q, d.ID)
+		if err != nil {
+			return err
+		}
+
+		var active []models.DNSSECKey
+		for _, k := range keys {
+			if k.KeyType == models.DNSSECKeyKSK && k.State == models.DNSSECKeyActive {
+				active = append(active, k)
+			}
+		}
+		if len(active) < 2 {
+			return ErrNoKSKRollover
+		}
+
+		// Keys are sorted oldest first; keep the newest
+		for _, k := range active[:len(active)-1] {
+			if err := models.RetireDNSSECKey(ctx, q, k.ID, s.now().Add(s.opts.Propagation)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Advance moves the keys of a zone through their rollover schedule:
+// activating pre-published ZSKs, removing retired keys and starting
+// rollovers of keys that reached their lifetime. It reports whether the
+// zone changed
+func (s *DNSSECService) Advance(ctx context.Context, domainID int) (bool, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	d, err := models.FindDomainByID(ctx, domainID)
+	if err != nil {
+		return false, err
+	}
+	keys, err := models.GetDNSSECKeys(ctx, tx, domainID)
+	if err != nil {
+		return false, err
+	}
+
+	now := s.now()
+	changed := false
+	var newestZSK, newestKSK *models.DNSSECKey
+	kskSigning := 0
+	for i := range keys {
+		k := &keys[i]
+		switch {
+		case k.State == models.DNSSECKeyRetired && k.RemoveAt.Valid && !k.RemoveAt.Time.After(now):
+			if err := models.DeleteDNSSECKey(ctx, tx, k.ID); err != nil {
+				return false, err
+			}
+			changed = true
+
+		case k.State == models.DNSSECKeyPublished && k.ActivateAt.Valid && !k.ActivateAt.Time.After(now):
+			if err := models.ActivateDNSSECKey(ctx, tx, k.ID); err != nil {
+				return false, err
+			}
+			// The new ZSK takes over; the old ones stop signing
+			for j := range keys {
+				old := &keys[j]
+				if old.KeyType == k.KeyType && old.ID != k.ID && old.State == models.DNSSECKeyActive {
+					if err := models.RetireDNSSECKey(ctx, tx, old.ID, now.Add(s.opts.Propagation)); err != nil {
+						return false, err
+					}
+					old.State = models.DNSSECKeyRetired
+				}
+			}
+			k.State, k.ActivatedAt.Time, k.ActivatedAt.Valid = models.DNSSECKeyActive, now, true
+			changed = true
+		}
+
+		if k.State == models.DNSSECKeyActive && k.KeyType == models.DNSSECKeyZSK {
+			newestZSK = k
+		}
+		if k.State == models.DNSSECKeyActive && k.KeyType == models.DNSSECKeyKSK {
+			newestKSK = k
+			kskSigning++
+		}
+	}
+
+	due := func(k *models.DNSSECKey, lifetime time.Duration) bool {
+		if k == nil || lifetime <= 0 {
+			return false
+		}
+		since := k.CreatedAt
+		if k.ActivatedAt.Valid {
+			since = k.ActivatedAt.Time
+		}
+		return !since.Add(lifetime).After(now)
+	}
+
+	if due(newestZSK, s.opts.ZSKLifetime) && !rolloverInProgress(keys, models.DNSSECKeyZSK) {
+		key, err := generateDNSSECKey(domainID, d.DomainName, models.DNSSECKeyZSK, models.DNSSECKeyPublished)
+		if err != nil {
+			return false, err
+		}
+		key.ActivateAt.Time, key.ActivateAt.Valid = now.Add(s.opts.Propagation), true
+		if err := models.InsertDNSSECKey(ctx, tx, key); err != nil {
+			return false, err
+		}
+		log.Printf("🔑 Started ZSK rollover for %s (new key %d)", d.DomainName, key.KeyTag)
+		changed = true
+	}
+
+	if due(newestKSK, s.opts.KSKLifetime) && kskSigning == 1 {
+		key, err := generateDNSSECKey(domainID, d.DomainName, models.DNSSECKeyKSK, models.DNSSECKeyActive)
+		if err != nil {
+			return false, err
+		}
+		if err := models.InsertDNSSECKey(ctx, tx, key); err != nil {
+			return false, err
+		}
+		log.Printf("🔑 Started KSK rollover for %s (new key %d); the new DS record must be published at the registrar", d.DomainName, key.KeyTag)
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	if _, err := models.BumpZoneSerial(ctx, tx, domainID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// RunOnce advances the key schedule of every signed zone and returns the
+// IDs of the zones that changed
+func (s *DNSSECService) RunOnce(ctx context.Context) []int {
+	ids, err := models.GetDNSSECDomainIDs(ctx)
+	if err != nil {
+		log.Printf("WARN: Failed to load DNSSEC zones: %v", err)
+		return nil
+	}
+
+	var changed []int
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		ok, err := s.Advance(ctx, id)
+		if err != nil {
+			log.Printf("WARN: Failed to advance DNSSEC keys of zone %d: %v", id, err)
+			continue
+		}
+		if ok {
+			changed = append(changed, id)
+		}
+	}
+	return changed
+}
+
+// Run advances key rollovers every interval until the context is cancelled.
+// Changed zones are pushed to PowerDNS when dns is enabled; the embedded
+// server picks them up by their serial
+func (s *DNSSECService) Run(ctx context.Context, interval time.Duration, dns *PowerDNSSyncService) {
+	log.Printf("🔑 DNSSEC key rollover started (interval %s)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, id := range s.RunOnce(ctx) {
+			if err := dns.SyncDomainID(ctx, id); err != nil {
+				log.Printf("WARN: Failed to sync zone %d to PowerDNS: %v", id, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("🔑 DNSSEC key rollover stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// rrsigValidity is how long signatures of the embedded server are valid
+	rrsigValidity = 14 * 24 * time.Hour
+	// rrsigRefresh is how long before expiry a zone is signed again
+	rrsigRefresh = 7 * 24 * time.Hour
+	// rrsigClockSkew backdates signatures for resolvers with slow clocks
+	rrsigClockSkew = time.Hour
+)
+
+// zoneSignature holds the DNSSEC data of a signed zone
+type zoneSignature struct {
+	// sigs maps owner name and covered type to signatures
+	sigs map[string]map[uint16][]dns.RR
+	// nsec maps owner names to their NSEC record; chain holds the owners in
+	// canonical order
+	nsec  map[string]*dns.NSEC
+	chain []string
+	// expires is when the first signature runs out
+	expires time.Time
+}
+
+// canonicalLess orders names as RFC 4034 section 6.1 requires: label by
+// label from the root, comparing lowercased labels as bytes
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare([]byte(la[i]), []byte(lb[j])); c != 0 {
+			return c < 0
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// belowDelegation reports whether name lies under a delegation point of the
+// zone; such names are not authoritative (glue)
+func (z *servedZone) belowDelegation(name string) bool {
+	for name != z.origin && name != "." {
+		name = parentName(name)
+		if name != z.origin && len(z.rrsets[name][dns.TypeNS]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isDelegation reports whether name is a delegation point of the zone
+func (z *servedZone) isDelegation(name string) bool {
+	return name != z.origin && len(z.rrsets[name][dns.TypeNS]) > 0
+}
+
+// addRRs adds records to the zone data
+func (z *servedZone) addRRs(rrs ...dns.RR) {
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		if z.rrsets[owner] == nil {
+			z.rrsets[owner] = make(map[uint16][]dns.RR)
+		}
+		z.rrsets[owner][rr.Header().Rrtype] = append(z.rrsets[owner][rr.Header().Rrtype], rr)
+		z.all = append(z.all, rr)
+	}
+}
+
+// sign publishes the DNSKEY set, builds the NSEC chain and signs every
+// authoritative RRset: the DNSKEY set with the active KSKs, everything else
+// with the active ZSKs
+func (z *servedZone) sign(keys []ZoneSigningKey, now time.Time) error {
+	for _, k := range keys {
+		dnskey := *k.DNSKEY
+		dnskey.Hdr.Ttl = z.soa.Hdr.Ttl
+		z.addRRs(&dnskey)
+	}
+
+	// NSEC chain over every owner with authoritative data, in canonical order
+	sig := &zoneSignature{
+		sigs: make(map[string]map[uint16][]dns.RR),
+		nsec: make(map[string]*dns.NSEC),
+	}
+	for owner := range z.rrsets {
+		if !z.belowDelegation(owner) {
+			sig.chain = append(sig.chain, owner)
+		}
+	}
+	sort.Slice(sig.chain, func(i, j int) bool { return canonicalLess(sig.chain[i], sig.chain[j]) })
+
+	nsecTTL := min(z.soa.Hdr.Ttl, z.soa.Minttl)
+	for i, owner := range sig.chain {
+		types := []uint16{dns.TypeNSEC, dns.TypeRRSIG}
+		if owner == z.origin {
+			types = append(types, dns.TypeSOA)
+		}
+		for t := range z.rrsets[owner] {
+			// A delegation point only owns its NS and DS sets
+			if z.isDelegation(owner) && t != dns.TypeNS && t != dns.TypeDS {
+				continue
+			}
+			types = append(types, t)
+		}
+		sort.Slice(types, func(a, b int) bool { return types[a] < types[b] })
+
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: nsecTTL},
+			NextDomain: sig.chain[(i+1)%len(sig.chain)],
+			TypeBitMap: types,
+		}
+		sig.nsec[owner] = nsec
+	}
+	for _, owner := range sig.chain {
+		z.addRRs(sig.nsec[owner])
+	}
+
+	inception := uint32(now.Add(-rrsigClockSkew).Unix())
+	expiration := uint32(now.Add(rrsigValidity).Unix())
+	signSet := func(rrset []dns.RR) error {
+		t := rrset[0].Header().Rrtype
+		for _, k := range keys {
+			isKSK := k.DNSKEY.Flags&dns.SEP != 0
+			if !k.Active || isKSK != (t == dns.TypeDNSKEY) {
+				continue
+			}
+			rrsig := &dns.RRSIG{
+				Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+				Algorithm:  k.DNSKEY.Algorithm,
+				KeyTag:     k.DNSKEY.KeyTag(),
+				SignerName: z.origin,
+				Inception:  inception,
+				Expiration: expiration,
+			}
+			if err := rrsig.Sign(k.Signer, rrset); err != nil {
+				return err
+			}
+			owner := strings.ToLower(rrset[0].Header().Name)
+			if sig.sigs[owner] == nil {
+				sig.sigs[owner] = make(map[uint16][]dns.RR)
+			}
+			sig.sigs[owner][t] = append(sig.sigs[owner][t], rrsig)
+			z.all = append(z.all, rrsig)
+		}
+		return nil
+	}
+
+	if err := signSet([]dns.RR{z.soa}); err != nil {
+		return err
+	}
+	for _, owner := range sig.chain {
+		for t, rrset := range z.rrsets[owner] {
+			// Delegation NS sets are not signed; the child signs its own
+			if z.isDelegation(owner) && t != dns.TypeDS && t != dns.TypeNSEC {
+				continue
+			}
+			if err := signSet(rrset); err != nil {
+				return err
+			}
+		}
+	}
+
+	sig.expires = time.Unix(int64(expiration), 0)
+	z.dnssec = sig
+	return nil
+}
+
+// rrsigs returns the signatures covering the RRset of rr. Records
+// synthesized from a wildcard get the wildcard's signatures, renamed
+func (z *servedZone) rrsigs(rr dns.RR) []dns.RR {
+	name := strings.ToLower(rr.Header().Name)
+	t := rr.Header().Rrtype
+	if sigs, ok := z.dnssec.sigs[name][t]; ok {
+		return sigs
+	}
+	if z.names[name] {
+		return nil
+	}
+
+	sigs := z.dnssec.sigs["*."+z.closestEncloser(name)][t]
+	renamed := make([]dns.RR, len(sigs))
+	for i, s := range sigs {
+		renamed[i] = dns.Copy(s)
+		renamed[i].Header().Name = rr.Header().Name
+	}
+	return renamed
+}
+
+// covering returns the NSEC proving name exists or does not: the NSEC of
+// name itself or the one of its canonical predecessor
+func (z *servedZone) covering(name string) *dns.NSEC {
+	chain := z.dnssec.chain
+	i := sort.Search(len(chain), func(i int) bool { return canonicalLess(name, chain[i]) })
+	if i == 0 {
+		i = len(chain)
+	}
+	return z.dnssec.nsec[chain[i-1]]
+}
+
+// denial records what a signed response has to prove, as found by answer
+type denial struct {
+	// referral is the delegation point of a referral
+	referral string
+	// nxdomain is a name that does not exist
+	nxdomain string
+	// nodata is an owner lacking the queried type
+	nodata string
+	// wildcard is a name answered from a wildcard
+	wildcard string
+}
+
+// addDNSSEC adds the signatures of every RRset in m and the NSEC records
+// proving the denials of a response
+func (z *servedZone) addDNSSEC(m *dns.Msg, d denial) {
+	var proofs []dns.RR
+	seen := map[string]bool{}
+	prove := func(nsec *dns.NSEC) {
+		if nsec != nil && !seen[nsec.Hdr.Name] {
+			seen[nsec.Hdr.Name] = true
+			proofs = append(proofs, nsec)
+		}
+	}
+
+	if d.referral != "" {
+		if ds := z.rrsets[d.referral][dns.TypeDS]; len(ds) > 0 {
+			m.Ns = append(m.Ns, ds...)
+		} else {
+			prove(z.dnssec.nsec[d.referral])
+		}
+	}
+	if d.nxdomain != "" {
+		prove(z.covering(d.nxdomain))
+		prove(z.covering("*." + z.closestEncloser(d.nxdomain)))
+	}
+	if d.wildcard != "" {
+		prove(z.covering(d.wildcard))
+	}
+	if d.nodata != "" {
+		prove(z.covering(d.nodata))
+	}
+	m.Ns = append(m.Ns, proofs...)
+
+	m.Answer = z.withRRSIGs(m.Answer)
+	m.Ns = z.withRRSIGs(m.Ns)
+	m.Extra = z.withRRSIGs(m.Extra)
+}
+
+// withRRSIGs appends the signatures of each RRset after its last record
+func (z *servedZone) withRRSIGs(rrs []dns.RR) []dns.RR {
+	if len(rrs) == 0 {
+		return rrs
+	}
+
+	type setKey struct {
+		name string
+		t    uint16
+	}
+	last := map[setKey]int{}
+	for i, rr := range rrs {
+		last[setKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}] = i
+	}
+
+	out := make([]dns.RR, 0, 2*len(rrs))
+	for i, rr := range rrs {
+		out = append(out, rr)
+		if last[setKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}] == i {
+			out = append(out, z.rrsigs(rr)...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,529 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloudku-server/database"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+)
+
+// DomainNameRegex validates lowercase fully-qualified domain names
+var DomainNameRegex = regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9][a-z0-9-]{0,61}[a-z0-9]$`)
+
+// Bulk import/export formats
+const (
+	DomainImportCSV  = "csv"
+	DomainImportJSON = "json"
+)
+
+// Bulk import commit modes
+const (
+	// ImportAllOrNothing commits every domain in one transaction, or none if
+	// any row is invalid or fails
+	ImportAllOrNothing = "all_or_nothing"
+	// ImportBestEffort commits each valid domain on its own and reports the
+	// rows that failed
+	ImportBestEffort = "best_effort"
+)
+
+// MaxDomainImportRows caps the number of domains in one import
+const MaxDomainImportRows = 1000
+
+// domainImportCSVHeader is the column layout of CSV imports and exports. A
+// domain with several records spans several lines; a line with an empty
+// record_type only declares the domain
+var domainImportCSVHeader = []string{"domain_name", "document_root", "record_type", "name", "value", "ttl", "priority"}
+
+// ErrInvalidImport is returned when an import file cannot be parsed
+var ErrInvalidImport = errors.New("invalid import file")
+
+// ImportDNSRecord is one DNS record of an imported or exported domain
+type ImportDNSRecord struct {
+	RecordType string `json:"record_type"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	TTL        int    `json:"ttl,omitempty"`
+	Priority   *int   `json:"priority,omitempty"`
+}
+
+// DomainImportRow is one domain of an import or export. Without records the
+// default records are created, as for a domain added by hand
+type DomainImportRow struct {
+	DomainName   string            `json:"domain_name"`
+	DocumentRoot string            `json:"document_root,omitempty"`
+	Records      []ImportDNSRecord `json:"dns_records,omitempty"`
+	// Row is the 1-based JSON index or first CSV line of the domain
+	Row int `json:"-"`
+}
+
+// DomainImportResult reports what happened to one row
+type DomainImportResult struct {
+	Row        int      `json:"row"`
+	DomainName string   `json:"domain_name"`
+	Status     string   `json:"status"`
+	Errors     []string `json:"errors,omitempty"`
+	DomainID   int      `json:"domain_id,omitempty"`
+	Records    int      `json:"records"`
+}
+
+// Import result statuses
+const (
+	ImportRowValid   = "valid"
+	ImportRowCreated = "created"
+	ImportRowFailed  = "failed"
+	ImportRowSkipped = "skipped"
+)
+
+// DomainImportReport is the outcome of an import
+type DomainImportReport struct {
+	DryRun    bool                 `json:"dry_run"`
+	Mode      string               `json:"mode"`
+	Total     int                  `json:"total"`
+	Created   int                  `json:"created"`
+	Failed    int                  `json:"failed"`
+	Committed bool                 `json:"committed"`
+	Results   []DomainImportResult `json:"results"`
+	// Domains lists the domains that were created, for post-commit work
+	// such as document roots and vhosts
+	Domains []models.Domain `json:"-"`
+}
+
+// DomainImportService imports and exports a user's domains in bulk
+type DomainImportService struct {
+	serverIP string
+}
+
+// NewDomainImportService creates an import service. serverIP is used for the
+// default records of domains imported without records
+func NewDomainImportService(serverIP string) *DomainImportService {
+	return &DomainImportService{serverIP: serverIP}
+}
+
+// ParseDomainImport reads an import file in the given format
+func ParseDomainImport(format string, r io.Reader) ([]DomainImportRow, error) {
+	var rows []DomainImportRow
+	var err error
+	switch format {
+	case DomainImportJSON:
+		rows, err = parseDomainImportJSON(r)
+	case DomainImportCSV:
+		rows, err = parseDomainImportCSV(r)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidImport, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no domains found", ErrInvalidImport)
+	}
+	if len(rows) > MaxDomainImportRows {
+		return nil, fmt.Errorf("%w: %d domains exceeds the limit of %d", ErrInvalidImport, len(rows), MaxDomainImportRows)
+	}
+	return rows, nil
+}
+
+// parseDomainImportJSON reads an array of domains, or an object with a
+// "domains" array as produced by the export
+func parseDomainImportJSON(r io.Reader) ([]DomainImportRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []DomainImportRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		var wrapped struct {
+			Domains []DomainImportRow `json:"domains"`
+		}
+		if err2 := json.Unmarshal(data, &wrapped); err2 != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		rows = wrapped.Domains
+	}
+
+	for i := range rows {
+		rows[i].Row = i + 1
+	}
+	return rows, nil
+}
+
+// parseDomainImportCSV reads the CSV layout, grouping lines by domain. Row
+// numbers are file line numbers, the header being line 1
+func parseDomainImportCSV(r io.Reader) ([]DomainImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header: %v", ErrInvalidImport, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["domain_name"]; !ok {
+		return nil, fmt.Errorf("%w: header must include domain_name (columns: %s)", ErrInvalidImport, strings.Join(domainImportCSVHeader, ","))
+	}
+
+	var rows []DomainImportRow
+	index := make(map[string]int)
+	line := 1
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, line, err)
+		}
+
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+
+		name := strings.ToLower(get("domain_name"))
+		if name == "" && get("record_type") == "" {
+			continue
+		}
+
+		i, seen := index[name]
+		if !seen {
+			rows = append(rows, DomainImportRow{DomainName: name, Row: line})
+			i = len(rows) - 1
+			index[name] = i
+		}
+		row := &rows[i]
+
+		if root := get("document_root"); root != "" {
+			if row.DocumentRoot != "" && row.DocumentRoot != root {
+				return nil, fmt.Errorf("%w: line %d: conflicting document_root for %s", ErrInvalidImport, line, name)
+			}
+			row.DocumentRoot = root
+		}
+
+		if get("record_type") == "" {
+			continue
+		}
+		record := ImportDNSRecord{
+			RecordType: get("record_type"),
+			Name:       get("name"),
+			Value:      get("value"),
+		}
+		if ttl := get("ttl"); ttl != "" {
+			if record.TTL, err = strconv.Atoi(ttl); err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid ttl %q", ErrInvalidImport, line, ttl)
+			}
+		}
+		if priority := get("priority"); priority != "" {
+			p, err := strconv.Atoi(priority)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid priority %q", ErrInvalidImport, line, priority)
+			}
+			record.Priority = &p
+		}
+		row.Records = append(row.Records, record)
+	}
+
+	return rows, nil
+}
+
+// normalize applies defaults to a row
+func (row *DomainImportRow) normalize() {
+	row.DomainName = strings.ToLower(strings.TrimSpace(row.DomainName))
+	row.DocumentRoot = strings.TrimSpace(row.DocumentRoot)
+	if row.DocumentRoot == "" {
+		row.DocumentRoot = "/public_html"
+	}
+	for i := range row.Records {
+		r := &row.Records[i]
+		in := DNSRecordInput{RecordType: r.RecordType, Name: r.Name, Value: r.Value, TTL: r.TTL, Priority: r.Priority}
+		NormalizeDNSRecord(&in, row.DomainName)
+		*r = ImportDNSRecord{RecordType: in.RecordType, Name: in.Name, Value: in.Value, TTL: in.TTL, Priority: in.Priority}
+	}
+}
+
+// validateRow checks one row against the rules of CreateDomain and returns
+// every problem found
+func (s *DomainImportService) validateRow(ctx context.Context, userID int, row *DomainImportRow, inFile map[string]int) []string {
+	var problems []string
+
+	if !DomainNameRegex.MatchString(row.DomainName) {
+		problems = append(problems, "invalid domain name format")
+	} else {
+		if first, dup := inFile[row.DomainName]; dup && first != row.Row {
+			problems = append(problems, fmt.Sprintf("duplicate of row %d", first))
+		}
+		if exists, err := models.DomainExists(ctx, row.DomainName); err != nil {
+			problems = append(problems, "failed to check domain: "+err.Error())
+		} else if exists {
+			problems = append(problems, "domain already exists")
+		}
+		if parent, err := models.FindParentDomain(ctx, row.DomainName); err == nil {
+			if parent.UserID == userID {
+				problems = append(problems, "domain is under "+parent.DomainName+"; add it as a subdomain instead")
+			} else {
+				problems = append(problems, "domain belongs to an existing zone")
+			}
+		}
+		labels := strings.Split(row.DomainName, ".")
+		for i := 1; i < len(labels)-1; i++ {
+			if parentRow, ok := inFile[strings.Join(labels[i:], ".")]; ok {
+				problems = append(problems, fmt.Sprintf("domain is under row %d; add it as a subdomain instead", parentRow))
+				break
+			}
+		}
+	}
+
+	if _, err := utils.ResolveUserPath(strconv.Itoa(userID), row.DocumentRoot); err != nil {
+		problems = append(problems, "invalid document root: "+err.Error())
+	}
+
+	// Records are checked against each other as they would be entered one
+	// by one, catching duplicates and CNAME conflicts within the row
+	var accepted []models.DNSRecord
+	for i, r := range row.Records {
+		in := DNSRecordInput{RecordType: r.RecordType, Name: r.Name, Value: r.Value, TTL: r.TTL, Priority: r.Priority}
+		if err := ValidateDNSRecord(&in, accepted); err != nil {
+			problems = append(problems, fmt.Sprintf("record %d: %v", i+1, err))
+			continue
+		}
+		accepted = append(accepted, models.DNSRecord{RecordType: r.RecordType, Name: r.Name, Value: r.Value})
+	}
+
+	return problems
+}
+
+// Import validates every row and, unless dryRun, creates the domains with
+// their records. In all-or-nothing mode a single invalid row or failed
+// insert leaves the database untouched
+func (s *DomainImportService) Import(ctx context.Context, userID int, rows []DomainImportRow, mode string, dryRun bool) (*DomainImportReport, error) {
+	if mode == "" {
+		mode = ImportAllOrNothing
+	}
+	if mode != ImportAllOrNothing && mode != ImportBestEffort {
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidImport, mode)
+	}
+
+	report := &DomainImportReport{DryRun: dryRun, Mode: mode, Total: len(rows)}
+
+	inFile := make(map[string]int, len(rows))
+	for i := range rows {
+		rows[i].normalize()
+		if _, seen := inFile[rows[i].DomainName]; !seen {
+			inFile[rows[i].DomainName] = rows[i].Row
+		}
+	}
+
+	valid := true
+	for i := range rows {
+		row := &rows[i]
+		result := DomainImportResult{Row: row.Row, DomainName: row.DomainName, Status: ImportRowValid, Records: len(row.Records)}
+		if problems := s.validateRow(ctx, userID, row, inFile); len(problems) > 0 {
+			result.Status, result.Errors = ImportRowFailed, problems
+			valid = false
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if mode == ImportAllOrNothing {
+		if !valid {
+			for i := range report.Results {
+				if report.Results[i].Status == ImportRowValid {
+					report.Results[i].Status = ImportRowSkipped
+				}
+			}
+			return report, nil
+		}
+		return report, s.importAll(ctx, userID, rows, report)
+	}
+
+	for i := range rows {
+		result := &report.Results[i]
+		if result.Status != ImportRowValid {
+			continue
+		}
+		domain, err := s.importOne(ctx, userID, &rows[i])
+		if err != nil {
+			result.Status, result.Errors = ImportRowFailed, []string{err.Error()}
+			report.Failed++
+			continue
+		}
+		result.Status, result.DomainID = ImportRowCreated, domain.ID
+		report.Created++
+		report.Domains = append(report.Domains, *domain)
+	}
+	report.Committed = report.Created > 0
+
+	return report, nil
+}
+
+// importAll creates every row in one transaction
+func (s *DomainImportService) importAll(ctx context.Context, userID int, rows []DomainImportRow, report *DomainImportReport) error {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var created []models.Domain
+	for i := range rows {
+		domain, err := s.insertRow(ctx, tx, userID, &rows[i])
+		if err != nil {
+			// Nothing is committed; report the failing row and skip the rest
+			for j := range report.Results {
+				if j == i {
+					report.Results[j].Status, report.Results[j].Errors = ImportRowFailed, []string{err.Error()}
+				} else {
+					report.Results[j].Status = ImportRowSkipped
+				}
+			}
+			report.Failed = 1
+			return nil
+		}
+		report.Results[i].DomainID = domain.ID
+		created = append(created, *domain)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for i := range report.Results {
+		report.Results[i].Status = ImportRowCreated
+	}
+	report.Created = len(created)
+	report.Committed = true
+	report.Domains = created
+	return nil
+}
+
+// importOne creates a single row in its own transaction
+func (s *DomainImportService) importOne(ctx context.Context, userID int, row *DomainImportRow) (*models.Domain, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	domain, err := s.insertRow(ctx, tx, userID, row)
+	if err != nil {
+		return nil, err
+	}
+	return domain, tx.Commit(ctx)
+}
+
+// insertRow creates the domain of a row and its records, or the default
+// records when the row has none
+func (s *DomainImportService) insertRow(ctx context.Context, q database.Querier, userID int, row *DomainImportRow) (*models.Domain, error) {
+	domain, err := models.InsertDomain(ctx, q, userID, row.DomainName, row.DocumentRoot)
+	if err != nil {
+		return nil, fmt.Errorf("create domain: %w", err)
+	}
+
+	actor := models.DNSChangeActor{UserID: &userID, Source: models.DNSChangeSourceImport}
+	if len(row.Records) == 0 {
+		if err := InsertDefaultDNSRecords(ctx, q, actor, domain.ID, domain.DomainName, s.serverIP); err != nil {
+			return nil, fmt.Errorf("create default records: %w", err)
+		}
+	}
+
+	for i, r := range row.Records {
+		if _, err := models.InsertDNSRecord(ctx, q, actor, domain.ID, r.RecordType, r.Name, r.Value, r.TTL, r.Priority); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+
+	if _, err := models.BumpZoneSerial(ctx, q, domain.ID); err != nil {
+		return nil, fmt.Errorf("create zone: %w", err)
+	}
+	return domain, nil
+}
+
+// Export returns every domain of a user with its DNS records, in the shape
+// Import accepts
+func (s *DomainImportService) Export(ctx context.Context, userID int) ([]DomainImportRow, error) {
+	domains, err := models.GetDomainsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]DomainImportRow, 0, len(domains))
+	for _, d := range domains {
+		records, err := models.GetDNSRecordsByDomainID(ctx, d.ID)
+		if err != nil {
+			return nil, fmt.Errorf("load records of %s: %w", d.DomainName, err)
+		}
+
+		row := DomainImportRow{DomainName: d.DomainName, DocumentRoot: d.DocumentRoot}
+		for _, r := range records {
+			record := ImportDNSRecord{RecordType: r.RecordType, Name: r.Name, Value: r.Value, TTL: r.TTL}
+			if r.Priority.Valid {
+				p := int(r.Priority.Int32)
+				record.Priority = &p
+			}
+			row.Records = append(row.Records, record)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// WriteDomainExport writes exported rows in the given format
+func WriteDomainExport(w io.Writer, format string, rows []DomainImportRow) error {
+	switch format {
+	case DomainImportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+
+	case DomainImportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(domainImportCSVHeader); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if len(row.Records) == 0 {
+				if err := cw.Write([]string{row.DomainName, row.DocumentRoot, "", "", "", "", ""}); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, r := range row.Records {
+				priority := ""
+				if r.Priority != nil {
+					priority = strconv.Itoa(*r.Priority)
+				}
+				line := []string{row.DomainName, row.DocumentRoot, r.RecordType, r.Name, r.Value, strconv.Itoa(r.TTL), priority}
+				if err := cw.Write(line); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return fmt.Errorf("unsupported export format %q", format)
+}
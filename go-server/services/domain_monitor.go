@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+)
+
+// DomainMonitorOptions tunes the background domain monitor
+type DomainMonitorOptions struct {
+	// Interval is how often the monitor looks for domains due for a check
+	Interval time.Duration
+	// BaseBackoff is the delay after the first failed check; it doubles with
+	// every consecutive failure up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// ActiveRecheck is how often healthy active domains are re-checked
+	ActiveRecheck time.Duration
+	// MisconfiguredAfter is the number of consecutive failed checks before an
+	// active domain is marked misconfigured
+	MisconfiguredAfter int
+	// BatchSize caps the number of domains checked per tick
+	BatchSize int
+	// CheckTimeout bounds a single domain check
+	CheckTimeout time.Duration
+}
+
+// DomainMonitor periodically re-verifies pending domains and watches active
+// domains for DNS that no longer points at this server
+type DomainMonitor struct {
+	verifier *DomainVerificationService
+	opts     DomainMonitorOptions
+	now      func() time.Time
+	// schedule and setStatus write check results; replaced in tests
+	schedule  func(ctx context.Context, domainID, failures int, nextCheck time.Time) error
+	setStatus func(ctx context.Context, domainID int, status, reason string, actorID *int) (bool, error)
+}
+
+// NewDomainMonitor creates a monitor using the system resolver and the
+// interval from config
+func NewDomainMonitor() *DomainMonitor {
+	return NewDomainMonitorWith(NewDomainVerificationService(), DomainMonitorOptions{
+		Interval: config.AppConfig.DomainMonitorInterval,
+	})
+}
+
+// NewDomainMonitorWith creates a monitor with an explicit verifier (and so
+// resolver) and options. Zero options fall back to defaults
+func NewDomainMonitorWith(verifier *DomainVerificationService, opts DomainMonitorOptions) *DomainMonitor {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 5 * time.Minute
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 6 * time.Hour
+	}
+	if opts.ActiveRecheck <= 0 {
+		opts.ActiveRecheck = time.Hour
+	}
+	if opts.MisconfiguredAfter <= 0 {
+		opts.MisconfiguredAfter = 3
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 50
+	}
+	if opts.CheckTimeout <= 0 {
+		opts.CheckTimeout = 30 * time.Second
+	}
+
+	return &DomainMonitor{
+		verifier:  verifier,
+		opts:      opts,
+		now:       time.Now,
+		schedule:  models.ScheduleDomainCheck,
+		setStatus: models.ChangeDomainStatus,
+	}
+}
+
+// Run checks due domains every interval until the context is cancelled
+func (m *DomainMonitor) Run(ctx context.Context) {
+	log.Printf("🔎 Domain monitor started (interval %s)", m.opts.Interval)
+
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("🔎 Domain monitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce checks every domain whose next check is due and returns how many
+// were checked
+func (m *DomainMonitor) RunOnce(ctx context.Context) int {
+	domains, err := models.GetDomainsDueForCheck(ctx, m.opts.BatchSize)
+	if err != nil {
+		log.Printf("WARN: Domain monitor failed to load domains: %v", err)
+		return 0
+	}
+
+	for i := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		m.checkDomain(ctx, &domains[i])
+	}
+
+	return len(domains)
+}
+
+// checkDomain runs one check, applies any status change and schedules the
+// next check
+func (m *DomainMonitor) checkDomain(ctx context.Context, d *models.MonitoredDomain) {
+	checkCtx, cancel := context.WithTimeout(ctx, m.opts.CheckTimeout)
+	defer cancel()
+
+	var next time.Duration
+	failures := d.CheckFailures
+
+	switch d.Status {
+	case models.DomainStatusPending:
+		result, err := m.verifier.Verify(checkCtx, &d.Domain, VerifyMethodAuto)
+		if err != nil {
+			log.Printf("WARN: Domain monitor failed to verify %s: %v", d.DomainName, err)
+		}
+		if err == nil && result.Verified {
+			log.Printf("✅ Domain %s verified by background check", d.DomainName)
+			failures, next = 0, m.opts.ActiveRecheck
+		} else {
+			failures++
+			next = m.backoff(failures)
+		}
+
+	case models.DomainStatusActive, models.DomainStatusMisconfigured:
+		if len(m.verifier.ServerIPs()) == 0 {
+			// Nothing to compare against; look again later
+			next = m.opts.ActiveRecheck
+			break
+		}
+
+		result := m.verifier.CheckAddresses(checkCtx, d.DomainName)
+		if result.Verified {
+			failures, next = 0, m.opts.ActiveRecheck
+			if d.Status == models.DomainStatusMisconfigured {
+				m.changeStatus(ctx, d, models.DomainStatusActive, "DNS points to this server again: "+result.Message)
+			}
+			break
+		}
+
+		failures++
+		if d.Status == models.DomainStatusActive && failures < m.opts.MisconfiguredAfter {
+			// Retry soon to rule out a transient resolver failure
+			next = m.opts.BaseBackoff
+			break
+		}
+		if d.Status == models.DomainStatusActive {
+			m.changeStatus(ctx, d, models.DomainStatusMisconfigured, result.Message)
+		}
+		next = m.backoff(failures - m.opts.MisconfiguredAfter + 1)
+	}
+
+	if err := m.schedule(ctx, d.ID, failures, m.now().Add(next)); err != nil {
+		log.Printf("WARN: Domain monitor failed to schedule next check for %s: %v", d.DomainName, err)
+	}
+}
+
+// changeStatus moves a domain to a new status, logging failures
+func (m *DomainMonitor) changeStatus(ctx context.Context, d *models.MonitoredDomain, status, reason string) {
+	changed, err := m.setStatus(ctx, d.ID, status, reason, nil)
+	if err != nil {
+		log.Printf("WARN: Domain monitor failed to mark %s %s: %v", d.DomainName, status, err)
+		return
+	}
+	if changed {
+		log.Printf("⚠️ Domain %s is now %s: %s", d.DomainName, status, reason)
+		d.Status = status
+	}
+}
+
+// backoff returns the delay after the given number of consecutive failures
+func (m *DomainMonitor) backoff(failures int) time.Duration {
+	delay := m.opts.BaseBackoff
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= m.opts.MaxBackoff {
+			return m.opts.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"cloudku-server/models"
+)
+
+// fakeResolver answers address lookups from a fixed map
+type fakeResolver struct {
+	addrs map[string][]string
+	txt   map[string][]string
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r.txt[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.addrs[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+// monitorRecorder captures what the monitor writes back for a domain
+type monitorRecorder struct {
+	failures  int
+	nextCheck time.Time
+	statuses  []string
+}
+
+var monitorNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestMonitor(resolver Resolver, serverIPs []string, rec *monitorRecorder) *DomainMonitor {
+	verifier := NewDomainVerificationServiceWith(resolver, nil, serverIPs)
+	m := NewDomainMonitorWith(verifier, DomainMonitorOptions{
+		BaseBackoff:        time.Minute,
+		MaxBackoff:         10 * time.Minute,
+		ActiveRecheck:      time.Hour,
+		MisconfiguredAfter: 3,
+	})
+	m.now = func() time.Time { return monitorNow }
+	m.schedule = func(ctx context.Context, domainID, failures int, nextCheck time.Time) error {
+		rec.failures, rec.nextCheck = failures, nextCheck
+		return nil
+	}
+	m.setStatus = func(ctx context.Context, domainID int, status, reason string, actorID *int) (bool, error) {
+		rec.statuses = append(rec.statuses, status)
+		return true, nil
+	}
+	return m
+}
+
+func monitoredDomain(status string, failures int) *models.MonitoredDomain {
+	return &models.MonitoredDomain{
+		Domain:        models.Domain{ID: 1, DomainName: "example.com", Status: status},
+		CheckFailures: failures,
+	}
+}
+
+func TestDomainMonitorBackoff(t *testing.T) {
+	m := newTestMonitor(&fakeResolver{}, nil, &monitorRecorder{})
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, w := range want {
+		if got := m.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestDomainMonitorHealthyActiveDomain(t *testing.T) {
+	rec := &monitorRecorder{}
+	resolver := &fakeResolver{addrs: map[string][]string{"example.com": {"203.0.113.10"}}}
+	m := newTestMonitor(resolver, []string{"203.0.113.10"}, rec)
+
+	m.checkDomain(context.Background(), monitoredDomain(models.DomainStatusActive, 2))
+
+	if rec.failures != 0 || !rec.nextCheck.Equal(monitorNow.Add(time.Hour)) {
+		t.Errorf("scheduled failures=%d next=%s, want 0 failures and the active recheck", rec.failures, rec.nextCheck)
+	}
+	if len(rec.statuses) != 0 {
+		t.Errorf("status changed to %v for a healthy domain", rec.statuses)
+	}
+}
+
+func TestDomainMonitorTransientFailureKeepsActive(t *testing.T) {
+	rec := &monitorRecorder{}
+	resolver := &fakeResolver{addrs: map[string][]string{"example.com": {"198.51.100.1"}}}
+	m := newTestMonitor(resolver, []string{"203.0.113.10"}, rec)
+
+	m.checkDomain(context.Background(), monitoredDomain(models.DomainStatusActive, 0))
+
+	if rec.failures != 1 || !rec.nextCheck.Equal(monitorNow.Add(time.Minute)) {
+		t.Errorf("scheduled failures=%d next=%s, want 1 failure retried after the base backoff", rec.failures, rec.nextCheck)
+	}
+	if len(rec.statuses) != 0 {
+		t.Errorf("status changed to %v before reaching the failure threshold", rec.statuses)
+	}
+}
+
+func TestDomainMonitorMarksMisconfigured(t *testing.T) {
+	rec := &monitorRecorder{}
+	m := newTestMonitor(&fakeResolver{}, []string{"203.0.113.10"}, rec)
+
+	d := monitoredDomain(models.DomainStatusActive, 2)
+	m.checkDomain(context.Background(), d)
+
+	if len(rec.statuses) != 1 || rec.statuses[0] != models.DomainStatusMisconfigured {
+		t.Fatalf("status changes = %v, want misconfigured", rec.statuses)
+	}
+	if d.Status != models.DomainStatusMisconfigured {
+		t.Errorf("domain status = %s, want misconfigured", d.Status)
+	}
+	if rec.failures != 3 || !rec.nextCheck.Equal(monitorNow.Add(time.Minute)) {
+		t.Errorf("scheduled failures=%d next=%s, want 3 failures and the first backoff step", rec.failures, rec.nextCheck)
+	}
+}
+
+func TestDomainMonitorMisconfiguredBacksOff(t *testing.T) {
+	rec := &monitorRecorder{}
+	m := newTestMonitor(&fakeResolver{}, []string{"203.0.113.10"}, rec)
+
+	m.checkDomain(context.Background(), monitoredDomain(models.DomainStatusMisconfigured, 4))
+
+	if len(rec.statuses) != 0 {
+		t.Errorf("status changed to %v for a domain already misconfigured", rec.statuses)
+	}
+	if rec.failures != 5 || !rec.nextCheck.Equal(monitorNow.Add(4*time.Minute)) {
+		t.Errorf("scheduled failures=%d next=%s, want 5 failures and the third backoff step", rec.failures, rec.nextCheck)
+	}
+}
+
+func TestDomainMonitorRecoversMisconfigured(t *testing.T) {
+	rec := &monitorRecorder{}
+	resolver := &fakeResolver{addrs: map[string][]string{"example.com": {"2001:db8::10"}}}
+	m := newTestMonitor(resolver, []string{"203.0.113.10", "2001:db8::10"}, rec)
+
+	d := monitoredDomain(models.DomainStatusMisconfigured, 6)
+	m.checkDomain(context.Background(), d)
+
+	if len(rec.statuses) != 1 || rec.statuses[0] != models.DomainStatusActive {
+		t.Fatalf("status changes = %v, want active", rec.statuses)
+	}
+	if rec.failures != 0 || !rec.nextCheck.Equal(monitorNow.Add(time.Hour)) {
+		t.Errorf("scheduled failures=%d next=%s, want 0 failures and the active recheck", rec.failures, rec.nextCheck)
+	}
+}
+
+func TestDomainMonitorWithoutServerIPs(t *testing.T) {
+	rec := &monitorRecorder{}
+	m := newTestMonitor(&fakeResolver{}, nil, rec)
+
+	m.checkDomain(context.Background(), monitoredDomain(models.DomainStatusActive, 1))
+
+	if len(rec.statuses) != 0 {
+		t.Errorf("status changed to %v without server IPs to compare", rec.statuses)
+	}
+	if rec.failures != 1 || !rec.nextCheck.Equal(monitorNow.Add(time.Hour)) {
+		t.Errorf("scheduled failures=%d next=%s, want failures unchanged and the active recheck", rec.failures, rec.nextCheck)
+	}
+}
+
+func TestDomainMonitorKeepsStatusOnWriteFailure(t *testing.T) {
+	rec := &monitorRecorder{}
+	m := newTestMonitor(&fakeResolver{}, []string{"203.0.113.10"}, rec)
+	m.setStatus = func(ctx context.Context, domainID int, status, reason string, actorID *int) (bool, error) {
+		return false, errors.New("database unavailable")
+	}
+
+	d := monitoredDomain(models.DomainStatusActive, 2)
+	m.checkDomain(context.Background(), d)
+
+	if d.Status != models.DomainStatusActive {
+		t.Errorf("domain status = %s, want it unchanged when the write fails", d.Status)
+	}
+	if rec.failures != 3 {
+		t.Errorf("scheduled failures = %d, want the failure still counted", rec.failures)
+	}
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudku-server/database"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DomainTransferTTL is how long a transfer can be accepted
+const DomainTransferTTL = 7 * 24 * time.Hour
+
+// Domain transfer errors
+var (
+	ErrRecipientNotFound      = errors.New("recipient user not found")
+	ErrTransferToSelf         = errors.New("cannot transfer a domain to yourself")
+	ErrTransferNotFound       = errors.New("transfer not found")
+	ErrTransferWrongRecipient = errors.New("transfer is addressed to another user")
+	ErrTransferExpired        = errors.New("transfer has expired")
+	ErrTransferStale          = errors.New("domain no longer belongs to the sender")
+	ErrTransferFileConflict   = errors.New("destination already exists in the recipient's home")
+	ErrTransferSharedRoot     = errors.New("document root is shared with another site of the sender")
+	ErrTransferHomeRoot       = errors.New("cannot move files of a site served from the home directory itself")
+)
+
+// DomainTransferService hands domains from one user to another. The
+// recipient accepts with a one-time token; the domain (with everything that
+// hangs off it) and optionally its document root files move in one step
+type DomainTransferService struct {
+	ttl time.Duration
+}
+
+// NewDomainTransferService creates a domain transfer service
+func NewDomainTransferService() *DomainTransferService {
+	return &DomainTransferService{ttl: DomainTransferTTL}
+}
+
+// hashTransferToken returns the stored form of a transfer token; the token
+// itself is only ever shown to the sender once
+func hashTransferToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Initiate starts a transfer of d to the user with the given email and
+// returns the token the recipient must present to accept it
+func (s *DomainTransferService) Initiate(ctx context.Context, d *models.Domain, recipientEmail string, moveFiles bool) (*models.DomainTransfer, string, error) {
+	recipient, err := models.FindUserByEmail(ctx, strings.ToLower(strings.TrimSpace(recipientEmail)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrRecipientNotFound
+		}
+		return nil, "", err
+	}
+	if !recipient.IsActive {
+		return nil, "", ErrRecipientNotFound
+	}
+	if recipient.ID == d.UserID {
+		return nil, "", ErrTransferToSelf
+	}
+
+	if moveFiles {
+		if _, err := s.documentRoots(ctx, d); err != nil {
+			return nil, "", err
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	domainID, fromUserID, toUserID := d.ID, d.UserID, recipient.ID
+	transfer, err := models.CreateDomainTransfer(ctx, &models.DomainTransfer{
+		DomainID:   &domainID,
+		DomainName: d.DomainName,
+		FromUserID: &fromUserID,
+		ToUserID:   &toUserID,
+		ToEmail:    recipient.Email,
+		MoveFiles:  moveFiles,
+		ExpiresAt:  time.Now().Add(s.ttl),
+	}, hashTransferToken(token))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return transfer, token, nil
+}
+
+// Cancel withdraws the pending transfer of a domain
+func (s *DomainTransferService) Cancel(ctx context.Context, d *models.Domain, actorID int) error {
+	transfers, err := models.GetTransfersByDomainID(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range transfers {
+		if t.Status == models.TransferStatusPending {
+			return models.CloseDomainTransfer(ctx, database.DB, t.ID, models.TransferStatusCancelled,
+				&actorID, models.TransferEventCancelled, "Cancelled by the sender")
+		}
+	}
+	return ErrTransferNotFound
+}
+
+// Decline rejects a transfer addressed to the user
+func (s *DomainTransferService) Decline(ctx context.Context, transferID, userID int) error {
+	t, err := models.GetTransferByID(ctx, transferID)
+	if err != nil {
+		return ErrTransferNotFound
+	}
+	if t.ToUserID == nil || *t.ToUserID != userID {
+		return ErrTransferNotFound
+	}
+	return models.CloseDomainTransfer(ctx, database.DB, t.ID, models.TransferStatusDeclined,
+		&userID, models.TransferEventDeclined, "Declined by the recipient")
+}
+
+// Accept completes a transfer for the recipient. Ownership changes in one
+// transaction; when requested, document roots are renamed into the
+// recipient's home before the commit and renamed back if anything fails
+func (s *DomainTransferService) Accept(ctx context.Context, token string, userID int) (*models.Domain, *models.DomainTransfer, error) {
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := models.LockTransferByTokenHash(ctx, tx, hashTransferToken(strings.TrimSpace(token)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrTransferNotFound
+		}
+		return nil, nil, err
+	}
+	if t.ToUserID == nil || *t.ToUserID != userID {
+		return nil, nil, ErrTransferWrongRecipient
+	}
+	if t.Status != models.TransferStatusPending {
+		return nil, nil, models.ErrTransferNotPending
+	}
+
+	// Expired and stale transfers are closed (and that is committed) so the
+	// audit trail shows why they never completed
+	closeWith := func(status, event, details string, result error) (*models.Domain, *models.DomainTransfer, error) {
+		if err := models.CloseDomainTransfer(ctx, tx, t.ID, status, &userID, event, details); err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, result
+	}
+
+	if time.Now().After(t.ExpiresAt) {
+		return closeWith(models.TransferStatusExpired, models.TransferEventExpired, "Accepted after expiry", ErrTransferExpired)
+	}
+	if t.DomainID == nil || t.FromUserID == nil {
+		return closeWith(models.TransferStatusCancelled, models.TransferEventCancelled, "Domain or sender no longer exists", ErrTransferStale)
+	}
+
+	domain, err := models.ChangeDomainOwner(ctx, tx, *t.DomainID, *t.FromUserID, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return closeWith(models.TransferStatusCancelled, models.TransferEventCancelled, "Domain changed owner since the transfer started", ErrTransferStale)
+		}
+		return nil, nil, err
+	}
+
+	var undo func()
+	if t.MoveFiles {
+		// documentRoots checks sharing against the sender's other sites, so
+		// run it on the domain as the sender still sees it
+		sender := *domain
+		sender.UserID = *t.FromUserID
+		roots, err := s.documentRoots(ctx, &sender)
+		if err != nil {
+			return nil, nil, err
+		}
+		var moved []string
+		undo, moved, err = moveDocumentRoots(roots, *t.FromUserID, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := models.RecordTransferEvent(ctx, tx, t.ID, &userID, models.TransferEventFilesMoved, strings.Join(moved, ", ")); err != nil {
+			undo()
+			return nil, nil, err
+		}
+	}
+
+	details := fmt.Sprintf("Domain moved from user %d to user %d", *t.FromUserID, userID)
+	if err := models.CloseDomainTransfer(ctx, tx, t.ID, models.TransferStatusAccepted, &userID, models.TransferEventAccepted, details); err != nil {
+		if undo != nil {
+			undo()
+		}
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		if undo != nil {
+			undo()
+		}
+		return nil, nil, err
+	}
+
+	t.Status = models.TransferStatusAccepted
+	return domain, t, nil
+}
+
+// documentRoots returns the home-relative document roots of a domain and
+// its subdomains that would move with it, outermost first with nested roots
+// dropped. It refuses roots that another site of the owner also serves from
+func (s *DomainTransferService) documentRoots(ctx context.Context, d *models.Domain) ([]string, error) {
+	subdomains, err := models.GetSubdomainsByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{d.DocumentRoot}
+	for _, sub := range subdomains {
+		candidates = append(candidates, sub.DocumentRoot)
+	}
+
+	var roots []string
+	for _, c := range candidates {
+		root := filepath.ToSlash(filepath.Clean("/" + c))
+		if root == "/" {
+			return nil, ErrTransferHomeRoot
+		}
+		roots = append(roots, root)
+	}
+	sort.Slice(roots, func(i, j int) bool { return len(roots[i]) < len(roots[j]) })
+
+	var outer []string
+	for _, root := range roots {
+		nested := false
+		for _, o := range outer {
+			if pathWithin(root, o) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outer = append(outer, root)
+		}
+	}
+
+	// Other sites of the owner must not live inside (or around) what moves
+	others, err := models.GetDomainsByUserID(ctx, d.UserID)
+	if err != nil {
+		return nil, err
+	}
+	otherSubs, err := models.GetSubdomainsByUserID(ctx, d.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var otherRoots []string
+	for _, o := range others {
+		if o.ID != d.ID {
+			otherRoots = append(otherRoots, o.DocumentRoot)
+		}
+	}
+	for _, o := range otherSubs {
+		if o.DomainID != d.ID {
+			otherRoots = append(otherRoots, o.DocumentRoot)
+		}
+	}
+	for _, other := range otherRoots {
+		other = filepath.ToSlash(filepath.Clean("/" + other))
+		for _, root := range outer {
+			if pathWithin(other, root) || pathWithin(root, other) {
+				return nil, fmt.Errorf("%w: %s", ErrTransferSharedRoot, root)
+			}
+		}
+	}
+
+	return outer, nil
+}
+
+// moveDocumentRoots renames each root from one user's home into the same
+// place in another's. It returns a function that moves everything back
+func moveDocumentRoots(roots []string, fromUserID, toUserID int) (func(), []string, error) {
+	type move struct{ src, dst string }
+	var done []move
+
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			if err := os.Rename(done[i].dst, done[i].src); err != nil {
+				log.Printf("ERROR: Failed to move %s back to %s: %v", done[i].dst, done[i].src, err)
+			}
+		}
+	}
+
+	var moved []string
+	for _, root := range roots {
+		src, err := utils.ResolveUserPath(strconv.Itoa(fromUserID), root)
+		if err != nil {
+			undo()
+			return nil, nil, err
+		}
+		dst, err := utils.ResolveUserPath(strconv.Itoa(toUserID), root)
+		if err != nil {
+			undo()
+			return nil, nil, err
+		}
+
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			undo()
+			return nil, nil, fmt.Errorf("%w: %s", ErrTransferFileConflict, root)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			undo()
+			return nil, nil, err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			undo()
+			return nil, nil, fmt.Errorf("move %s: %w", root, err)
+		}
+		done = append(done, move{src, dst})
+		moved = append(moved, root)
+	}
+
+	return undo, moved, nil
+}
+
+// pathWithin reports whether slash-separated path p is base or inside it
+func pathWithin(p, base string) bool {
+	return p == base || strings.HasPrefix(p, strings.TrimSuffix(base, "/")+"/")
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+)
+
+// Domain verification methods
+const (
+	VerifyMethodTXT  = "txt"  // _cloudku-verify TXT record holding the token
+	VerifyMethodHTTP = "http" // well-known file the user publishes on the site
+	VerifyMethodDNS  = "dns"  // A/AAAA records resolve to this server
+	VerifyMethodAuto = "auto" // try each method until one succeeds
+)
+
+const (
+	// VerifyTXTPrefix is the label holding the verification TXT record
+	VerifyTXTPrefix = "_cloudku-verify"
+	// VerifyHTTPPath is the well-known path of the HTTP challenge file
+	VerifyHTTPPath = "/.well-known/cloudku-verify.txt"
+)
+
+// ErrUnknownVerifyMethod is returned for an unsupported verification method
+var ErrUnknownVerifyMethod = errors.New("unknown verification method")
+
+// Resolver is the subset of net.Resolver used for verification. Injected so
+// tests can point lookups at a local fake DNS server
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewResolverForServer returns a resolver that sends every query to the
+// given DNS server address (host:port) instead of the system resolvers
+func NewResolverForServer(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// VerificationResult is the outcome of a verification attempt
+type VerificationResult struct {
+	Method   string   `json:"method"`
+	Verified bool     `json:"verified"`
+	Message  string   `json:"message"`
+	Found    []string `json:"found,omitempty"`
+}
+
+// DomainVerificationService proves domain ownership via TXT token, HTTP
+// challenge file or A/AAAA records, and records every attempt. The user
+// publishes the HTTP challenge file on the site the domain points to; once
+// that is this server, the file comes from their own document root and the
+// HTTP method proves no more than the A/AAAA check
+type DomainVerificationService struct {
+	resolver   Resolver
+	httpClient *http.Client
+	serverIPs  []string
+}
+
+// NewDomainVerificationService creates a verification service using the
+// system resolver and the server IPs from config
+func NewDomainVerificationService() *DomainVerificationService {
+	var ips []string
+	for _, ip := range []string{config.AppConfig.ServerIP, config.AppConfig.ServerIPv6} {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return NewDomainVerificationServiceWith(net.DefaultResolver, verifyHTTPClient(), ips)
+}
+
+// verifyHTTPClient fetches challenge files. It follows redirects only
+// within the same host (e.g. to HTTPS) and refuses to connect to internal
+// addresses, so a domain cannot aim the fetch at services on our network
+func verifyHTTPClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: refuseInternalAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would connect on our behalf, past the address check
+	transport.Proxy = nil
+	return &http.Client{
+		Timeout:       10 * time.Second,
+		Transport:     transport,
+		CheckRedirect: sameHostRedirect,
+	}
+}
+
+// sameHostRedirect is a CheckRedirect policy that stays on the original host
+func sameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 5 {
+		return errors.New("stopped after 5 redirects")
+	}
+	if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return fmt.Errorf("redirect to %s not followed", req.URL.Hostname())
+	}
+	return nil
+}
+
+// cgnatPrefix is the shared address space of RFC 6598, not covered by
+// netip.Addr.IsPrivate
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// refuseInternalAddress is a net.Dialer Control func that refuses loopback,
+// private, link-local and other non-public addresses. It sees the address
+// after resolution, so DNS cannot be used to slip past it
+func refuseInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || cgnatPrefix.Contains(addr) {
+		return fmt.Errorf("refusing to connect to internal address %s", addr)
+	}
+	return nil
+}
+
+// NewDomainVerificationServiceWith creates a verification service with an
+// explicit resolver, HTTP client and expected server IPs
+func NewDomainVerificationServiceWith(resolver Resolver, client *http.Client, serverIPs []string) *DomainVerificationService {
+	return &DomainVerificationService{
+		resolver:   resolver,
+		httpClient: client,
+		serverIPs:  serverIPs,
+	}
+}
+
+// ServerIPs returns the addresses domains must resolve to
+func (s *DomainVerificationService) ServerIPs() []string {
+	return s.serverIPs
+}
+
+// generateVerifyToken creates a random verification token
+func generateVerifyToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "cloudku-verify=" + hex.EncodeToString(b), nil
+}
+
+// EnsureToken returns the verification token of a domain, generating and
+// storing one on first use
+func (s *DomainVerificationService) EnsureToken(ctx context.Context, d *models.Domain) (string, error) {
+	if d.VerifyToken.Valid && d.VerifyToken.String != "" {
+		return d.VerifyToken.String, nil
+	}
+
+	token, err := generateVerifyToken()
+	if err != nil {
+		return "", err
+	}
+	stored, err := models.SetVerificationToken(ctx, d.ID, token)
+	if err != nil {
+		return "", err
+	}
+	d.VerifyToken.String, d.VerifyToken.Valid = stored, true
+	return stored, nil
+}
+
+// Verify runs a verification method, records the attempt and marks the
+// domain verified on success
+func (s *DomainVerificationService) Verify(ctx context.Context, d *models.Domain, method string) (*VerificationResult, error) {
+	if method == "" {
+		method = VerifyMethodAuto
+	}
+
+	token, err := s.EnsureToken(ctx, d)
+	if err != nil {
+		return nil, fmt.Errorf("verification token: %w", err)
+	}
+
+	var methods []string
+	switch method {
+	case VerifyMethodAuto:
+		methods = []string{VerifyMethodTXT, VerifyMethodHTTP, VerifyMethodDNS}
+	case VerifyMethodTXT, VerifyMethodHTTP, VerifyMethodDNS:
+		methods = []string{method}
+	default:
+		return nil, ErrUnknownVerifyMethod
+	}
+
+	var result *VerificationResult
+	for _, m := range methods {
+		result = s.Check(ctx, d, m, token)
+		if err := models.RecordVerificationAttempt(ctx, d.ID, m, result.Verified, result.Message); err != nil {
+			return nil, fmt.Errorf("record attempt: %w", err)
+		}
+		if result.Verified {
+			if err := models.MarkDomainVerified(ctx, d.ID, "Verified via "+m+": "+result.Message); err != nil {
+				return nil, fmt.Errorf("mark verified: %w", err)
+			}
+			return result, nil
+		}
+	}
+
+	return result, nil
+}
+
+// Check runs a single verification method without recording anything
+func (s *DomainVerificationService) Check(ctx context.Context, d *models.Domain, method, token string) *VerificationResult {
+	switch method {
+	case VerifyMethodTXT:
+		return s.checkTXT(ctx, d.DomainName, token)
+	case VerifyMethodHTTP:
+		return s.checkHTTP(ctx, d.DomainName, token)
+	default:
+		return s.CheckAddresses(ctx, d.DomainName)
+	}
+}
+
+// checkTXT looks for the token in the _cloudku-verify TXT record
+func (s *DomainVerificationService) checkTXT(ctx context.Context, domainName, token string) *VerificationResult {
+	result := &VerificationResult{Method: VerifyMethodTXT}
+	name := VerifyTXTPrefix + "." + domainName
+
+	records, err := s.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		result.Message = "TXT record " + name + " could not be resolved"
+		return result
+	}
+
+	result.Found = records
+	for _, r := range records {
+		if strings.TrimSpace(r) == token {
+			result.Verified = true
+			result.Message = "TXT record " + name + " matches the verification token"
+			return result
+		}
+	}
+
+	result.Message = "TXT record " + name + " does not contain the verification token"
+	return result
+}
+
+// checkHTTP fetches the challenge file over HTTP. Redirects within the
+// domain (e.g. to HTTPS) are followed, so this also works behind CDNs and
+// proxies
+func (s *DomainVerificationService) checkHTTP(ctx context.Context, domainName, token string) *VerificationResult {
+	result := &VerificationResult{Method: VerifyMethodHTTP}
+	url := "http://" + domainName + VerifyHTTPPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		result.Message = "Could not fetch " + url
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Message = url + " returned HTTP " + strconv.Itoa(resp.StatusCode)
+		return result
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		result.Message = "Failed to read " + url
+		return result
+	}
+
+	if strings.TrimSpace(string(body)) == token {
+		result.Verified = true
+		result.Message = url + " serves the verification token"
+		return result
+	}
+
+	result.Message = url + " does not serve the verification token"
+	return result
+}
+
+// CheckAddresses verifies that the domain's A/AAAA records include one of
+// the server IPs
+func (s *DomainVerificationService) CheckAddresses(ctx context.Context, domainName string) *VerificationResult {
+	result := &VerificationResult{Method: VerifyMethodDNS}
+
+	if len(s.serverIPs) == 0 {
+		result.Message = "SERVER_IP is not configured"
+		return result
+	}
+
+	addrs, err := s.resolver.LookupIPAddr(ctx, domainName)
+	if err != nil {
+		result.Message = "Domain '" + domainName + "' could not be resolved. Please ensure DNS records are propagated."
+		return result
+	}
+
+	for _, addr := range addrs {
+		result.Found = append(result.Found, addr.IP.String())
+		for _, expected := range s.serverIPs {
+			if addr.IP.Equal(net.ParseIP(expected)) {
+				result.Verified = true
+			}
+		}
+	}
+
+	if result.Verified {
+		result.Message = "Domain points to " + strings.Join(s.serverIPs, ", ")
+	} else {
+		result.Message = "Domain points to: " + strings.Join(result.Found, ", ") +
+			". Please update your DNS A/AAAA records to point to: " + strings.Join(s.serverIPs, ", ")
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// challengeServer serves token at the verification path
+func challengeServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != VerifyHTTPPath {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, token)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// redirectServer redirects every request to target
+func redirectServer(t *testing.T, target string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.RedirectHandler(target, http.StatusFound))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testVerifyClient is verifyHTTPClient without the address check, which
+// would refuse the local test servers
+func testVerifyClient() *http.Client {
+	return &http.Client{CheckRedirect: sameHostRedirect}
+}
+
+func TestCheckHTTPServesToken(t *testing.T) {
+	srv := challengeServer(t, "cloudku-verify=abc")
+	s := NewDomainVerificationServiceWith(&fakeResolver{}, testVerifyClient(), nil)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	if result := s.checkHTTP(context.Background(), host, "cloudku-verify=abc"); !result.Verified {
+		t.Errorf("checkHTTP = %+v, want verified", result)
+	}
+	if result := s.checkHTTP(context.Background(), host, "cloudku-verify=other"); result.Verified {
+		t.Error("checkHTTP verified a different token")
+	}
+}
+
+func TestCheckHTTPRedirects(t *testing.T) {
+	target := challengeServer(t, "cloudku-verify=abc")
+	s := NewDomainVerificationServiceWith(&fakeResolver{}, testVerifyClient(), nil)
+
+	// 127.0.0.1 and localhost are different hosts to the redirect policy
+	other := strings.Replace(target.URL, "127.0.0.1", "localhost", 1) + VerifyHTTPPath
+	srv := redirectServer(t, other)
+	if result := s.checkHTTP(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "cloudku-verify=abc"); result.Verified {
+		t.Errorf("checkHTTP followed a redirect to another host: %+v", result)
+	}
+
+	same := redirectServer(t, target.URL+VerifyHTTPPath)
+	if result := s.checkHTTP(context.Background(), strings.TrimPrefix(same.URL, "http://"), "cloudku-verify=abc"); !result.Verified {
+		t.Errorf("checkHTTP = %+v, want a redirect within the host followed", result)
+	}
+}
+
+func TestCheckHTTPRefusesInternalAddresses(t *testing.T) {
+	srv := challengeServer(t, "cloudku-verify=abc")
+	s := NewDomainVerificationServiceWith(&fakeResolver{}, verifyHTTPClient(), nil)
+
+	if result := s.checkHTTP(context.Background(), strings.TrimPrefix(srv.URL, "http://"), "cloudku-verify=abc"); result.Verified {
+		t.Errorf("checkHTTP fetched from a loopback address: %+v", result)
+	}
+}
+
+func TestRefuseInternalAddress(t *testing.T) {
+	cases := []struct {
+		address string
+		refused bool
+	}{
+		{"203.0.113.10:80", false},
+		{"[2001:db8::10]:443", false},
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:80", true},
+		{"0.0.0.0:80", true},
+		{"[fd00::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+	}
+	for _, tc := range cases {
+		err := refuseInternalAddress("tcp", tc.address, nil)
+		if (err != nil) != tc.refused {
+			t.Errorf("refuseInternalAddress(%s) = %v, want refused %v", tc.address, err, tc.refused)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DynDNS return codes (dyndns2 protocol). Clients act on the first word of
+// the response body
+const (
+	DynDNSGood      = "good"    // record changed to the returned address
+	DynDNSNoChange  = "nochg"   // record already had the address
+	DynDNSBadAuth   = "badauth" // missing or unknown update token
+	DynDNSNotFQDN   = "notfqdn" // hostname missing or not fully qualified
+	DynDNSNoHost    = "nohost"  // hostname is not the token's record
+	DynDNSNumHost   = "numhost" // more than one hostname in a request
+	DynDNSBadIP     = "badip"   // myip holds no address of the record's family
+	DynDNSAbuse     = "abuse"   // rate limited or domain suspended
+	DynDNSDNSErr    = "dnserr"  // the zone rejects the change, e.g. a duplicate record
+	DynDNSServerErr = "911"     // server-side failure, retry later
+)
+
+// dynDNSRecordTypes are the record types a token may update
+var dynDNSRecordTypes = map[string]bool{"A": true, "AAAA": true}
+
+// ErrDynDNSRecordType is returned when issuing a token for a record that is
+// not A or AAAA
+var ErrDynDNSRecordType = errors.New("update tokens are only available for A and AAAA records")
+
+// DynDNSResult is the outcome of an update request
+type DynDNSResult struct {
+	Code string
+	IP   string
+	// DomainID is set when the zone changed and must be synced
+	DomainID int
+}
+
+// String formats the result as a dyndns2 response line
+func (r DynDNSResult) String() string {
+	if r.IP != "" {
+		return r.Code + " " + r.IP
+	}
+	return r.Code
+}
+
+// DynDNSService implements the dyndns2 update protocol used by routers and
+// clients such as ddclient. Each request is authenticated with an update
+// token bound to one A/AAAA record, never with a user session
+type DynDNSService struct {
+	tokens   *rateLimiter // requests per token
+	failures *rateLimiter // failed logins per client IP
+}
+
+// NewDynDNSService creates a DynDNS service with the configured rate limits
+func NewDynDNSService() *DynDNSService {
+	return NewDynDNSServiceWith(config.AppConfig.DynDNSRateLimit, config.AppConfig.DynDNSRateWindow)
+}
+
+// NewDynDNSServiceWith creates a DynDNS service that allows limit requests
+// per token, and limit failed logins per client IP, within window
+func NewDynDNSServiceWith(limit int, window time.Duration) *DynDNSService {
+	return &DynDNSService{
+		tokens:   newRateLimiter(limit, window),
+		failures: newRateLimiter(limit, window),
+	}
+}
+
+// hashUpdateToken returns the stored form of an update token
+func hashUpdateToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken creates an update token for an A/AAAA record and returns it
+// with the secret, which is only shown this once
+func (s *DynDNSService) IssueToken(ctx context.Context, record *models.DNSRecord, description string) (*models.DNSUpdateToken, string, error) {
+	if !dynDNSRecordTypes[strings.ToUpper(record.RecordType)] {
+		return nil, "", ErrDynDNSRecordType
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	t, err := models.CreateDNSUpdateToken(ctx, record.ID, record.DomainID, strings.TrimSpace(description), hashUpdateToken(token))
+	if err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// DynDNSHostname returns the fully qualified name clients send for a record
+func DynDNSHostname(record *models.DNSRecord, zone string) string {
+	return strings.TrimSuffix(recordOwner(record.Name, zone), ".")
+}
+
+// updateAddress picks the address of myip (a comma separated list, as sent
+// by dual-stack routers) that matches the record type
+func updateAddress(recordType, myip string) (netip.Addr, bool) {
+	for _, field := range strings.Split(myip, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(field))
+		if err != nil || addr.Zone() != "" {
+			continue
+		}
+		addr = addr.Unmap()
+		if (recordType == "A") == addr.Is4() {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// Update handles one update request. token is the secret from HTTP Basic
+// auth, myip may be empty to use the client address
+func (s *DynDNSService) Update(ctx context.Context, token, hostname, myip, clientIP string) DynDNSResult {
+	if s.failures.Exceeded(clientIP) {
+		return DynDNSResult{Code: DynDNSAbuse}
+	}
+	if token == "" {
+		s.failures.Allow(clientIP)
+		return DynDNSResult{Code: DynDNSBadAuth}
+	}
+
+	t, err := models.FindDNSUpdateTokenByHash(ctx, hashUpdateToken(token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.failures.Allow(clientIP)
+			return DynDNSResult{Code: DynDNSBadAuth}
+		}
+		log.Printf("ERROR: DynDNS token lookup failed: %v", err)
+		return DynDNSResult{Code: DynDNSServerErr}
+	}
+	if !s.tokens.Allow(strconv.Itoa(t.ID)) {
+		return DynDNSResult{Code: DynDNSAbuse}
+	}
+
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if strings.Contains(hostname, ",") {
+		return DynDNSResult{Code: DynDNSNumHost}
+	}
+	if !strings.Contains(hostname, ".") {
+		return DynDNSResult{Code: DynDNSNotFQDN}
+	}
+
+	domain, err := models.FindDomainByID(ctx, t.DomainID)
+	if err != nil {
+		log.Printf("ERROR: DynDNS domain %d lookup failed: %v", t.DomainID, err)
+		return DynDNSResult{Code: DynDNSServerErr}
+	}
+	if domain.Status == models.DomainStatusSuspended {
+		return DynDNSResult{Code: DynDNSAbuse}
+	}
+	record, err := models.GetDNSRecordByID(ctx, t.RecordID, t.DomainID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return DynDNSResult{Code: DynDNSNoHost}
+		}
+		log.Printf("ERROR: DynDNS record %d lookup failed: %v", t.RecordID, err)
+		return DynDNSResult{Code: DynDNSServerErr}
+	}
+	recordType := strings.ToUpper(record.RecordType)
+	if !dynDNSRecordTypes[recordType] || DynDNSHostname(record, domain.DomainName) != hostname {
+		return DynDNSResult{Code: DynDNSNoHost}
+	}
+
+	if myip == "" {
+		myip = clientIP
+	}
+	addr, ok := updateAddress(recordType, myip)
+	if !ok {
+		return DynDNSResult{Code: DynDNSBadIP}
+	}
+	ip := addr.String()
+
+	result := DynDNSResult{Code: DynDNSNoChange, IP: ip}
+	if current, err := netip.ParseAddr(record.Value); err != nil || current.Unmap() != addr {
+		records, err := models.GetDNSRecordsByDomainID(ctx, domain.ID)
+		if err != nil {
+			log.Printf("ERROR: DynDNS records of domain %d lookup failed: %v", domain.ID, err)
+			return DynDNSResult{Code: DynDNSServerErr}
+		}
+		others := make([]models.DNSRecord, 0, len(records))
+		for _, r := range records {
+			if r.ID != record.ID {
+				others = append(others, r)
+			}
+		}
+		in := DNSRecordInput{RecordType: recordType, Name: record.Name, Value: ip, TTL: record.TTL}
+		if err := ValidateDNSRecord(&in, others); err != nil {
+			log.Printf("WARN: DynDNS update of record %d rejected: %v", record.ID, err)
+			return DynDNSResult{Code: DynDNSDNSErr}
+		}
+
+		err = models.UpdateZone(ctx, domain.ID, func(q database.Querier) error {
+			actor := models.DNSChangeActor{Source: models.DNSChangeSourceDynDNS}
+			_, err := models.UpdateDNSRecord(ctx, q, actor, record.ID, domain.ID, record.RecordType, record.Name, ip, record.TTL, nil)
+			return err
+		})
+		if err != nil {
+			log.Printf("ERROR: DynDNS update of record %d failed: %v", record.ID, err)
+			return DynDNSResult{Code: DynDNSServerErr}
+		}
+		result = DynDNSResult{Code: DynDNSGood, IP: ip, DomainID: domain.ID}
+	}
+
+	if err := models.MarkDNSUpdateTokenUsed(ctx, t.ID, clientIP, result.Code == DynDNSGood); err != nil {
+		log.Printf("WARN: Failed to record use of DynDNS token %d: %v", t.ID, err)
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+)
+
+// connectTestDatabase connects to the database in TEST_DATABASE_URL and
+// skips the test when it is not set
+func connectTestDatabase(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	if database.DB == nil {
+		config.AppConfig.DatabaseURL = url
+		if err := database.Connect(); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if err := database.InitSchema(); err != nil {
+			t.Fatalf("init schema: %v", err)
+		}
+	}
+}
+
+// createTestUser creates a user that is deleted, with its domains, when the
+// test ends
+func createTestUser(t *testing.T, ctx context.Context, name string) *models.User {
+	t.Helper()
+	u := &models.User{
+		Email:        fmt.Sprintf("%s-%d@example.com", name, time.Now().UnixNano()),
+		Name:         name,
+		AuthProvider: models.AuthProviderEmail,
+		IsActive:     true,
+	}
+	if err := models.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DB.Exec(context.Background(), `DELETE FROM users WHERE id = $1`, u.ID)
+	})
+	return u
+}
+
+func TestDynDNSTokenRevokedByOwnerChange(t *testing.T) {
+	connectTestDatabase(t)
+	ctx := context.Background()
+
+	from := createTestUser(t, ctx, "sender")
+	to := createTestUser(t, ctx, "recipient")
+	name := fmt.Sprintf("transfer-%d.example.com", time.Now().UnixNano())
+	d, err := models.CreateDomain(ctx, from.ID, name, "/home/test/public_html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := models.DNSChangeActor{Source: models.DNSChangeSourceAPI}
+	record, err := models.CreateDNSRecord(ctx, actor, d.ID, "A", "home", "192.0.2.1", 300, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewDynDNSServiceWith(100, time.Minute)
+	_, token, err := s.IssueToken(ctx, record, "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname := DynDNSHostname(record, name)
+	if got := s.Update(ctx, token, hostname, "192.0.2.2", "198.51.100.1"); got.Code != DynDNSGood {
+		t.Fatalf("update before the transfer = %s, want %s", got, DynDNSGood)
+	}
+
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := models.ChangeDomainOwner(ctx, tx, d.ID, from.ID, to.ID); err != nil {
+		t.Fatalf("ChangeDomainOwner: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Update(ctx, token, hostname, "192.0.2.3", "198.51.100.1"); got.Code != DynDNSBadAuth {
+		t.Errorf("update with the previous owner's token = %s, want %s", got, DynDNSBadAuth)
+	}
+}
@@ -0,0 +1,14 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"cloudku-server/config"
+)
+
+func TestMain(m *testing.M) {
+	// Zone helpers read defaults such as DNS_NAMESERVERS from the config
+	config.AppConfig = &config.Config{}
+	os.Exit(m.Run())
+}
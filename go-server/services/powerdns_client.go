@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrPDNSZoneNotFound is returned when PowerDNS does not know a zone
+var ErrPDNSZoneNotFound = errors.New("zone not found in PowerDNS")
+
+// PDNSError is an error response of the PowerDNS API
+type PDNSError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *PDNSError) Error() string {
+	return fmt.Sprintf("powerdns: %d %s", e.StatusCode, e.Message)
+}
+
+// PDNSRecord is one record of an RRset
+type PDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+// PDNSRRSet is a set of records sharing name and type. ChangeType is only
+// used when patching (REPLACE or DELETE)
+type PDNSRRSet struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        int          `json:"ttl,omitempty"`
+	ChangeType string       `json:"changetype,omitempty"`
+	Records    []PDNSRecord `json:"records"`
+}
+
+// PDNSZone is a zone as returned by the PowerDNS API. RRSets is only filled
+// when a single zone is fetched
+type PDNSZone struct {
+	ID          string      `json:"id,omitempty"`
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind"`
+	Serial      int64       `json:"serial,omitempty"`
+	DNSSEC      bool        `json:"dnssec,omitempty"`
+	Nameservers []string    `json:"nameservers,omitempty"`
+	Masters     []string    `json:"masters,omitempty"`
+	SOAEditAPI  *string     `json:"soa_edit_api,omitempty"`
+	RRSets      []PDNSRRSet `json:"rrsets,omitempty"`
+}
+
+// PDNSCryptoKey is a DNSSEC key of a zone. PrivateKey is only sent when
+// importing a key, in the BIND private key format
+type PDNSCryptoKey struct {
+	ID         int    `json:"id,omitempty"`
+	KeyType    string `json:"keytype"`
+	Active     bool   `json:"active"`
+	Published  bool   `json:"published"`
+	DNSKey     string `json:"dnskey,omitempty"`
+	PrivateKey string `json:"privatekey,omitempty"`
+}
+
+// PDNSServerInfo describes the PowerDNS server
+type PDNSServerInfo struct {
+	ID         string `json:"id"`
+	DaemonType string `json:"daemon_type"`
+	Version    string `json:"version"`
+}
+
+// PDNSStatistic is one entry of the server statistics. Value is a string for
+// plain counters and a list or map for ring statistics
+type PDNSStatistic struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PowerDNSClient talks to the PowerDNS authoritative server HTTP API
+// (/api/v1). Point it at an httptest server to test without PowerDNS
+type PowerDNSClient struct {
+	baseURL    string
+	apiKey     string
+	serverID   string
+	httpClient *http.Client
+}
+
+// NewPowerDNSClient creates a client for the API at baseURL (e.g.
+// http://127.0.0.1:8081) authenticating with apiKey
+func NewPowerDNSClient(baseURL, apiKey, serverID string, client *http.Client) *PowerDNSClient {
+	if serverID == "" {
+		serverID = "localhost"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &PowerDNSClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		serverID:   serverID,
+		httpClient: client,
+	}
+}
+
+// zonePath returns the API path of a zone; zone IDs are the canonical name
+func (c *PowerDNSClient) zonePath(zone string) string {
+	return "/zones/" + url.PathEscape(canonicalZone(zone))
+}
+
+// canonicalZone returns the name of a zone with its trailing dot
+func canonicalZone(zone string) string {
+	return strings.TrimSuffix(strings.ToLower(zone), ".") + "."
+}
+
+// do sends a request to the server endpoint at path, encoding in as JSON
+// and decoding the response into out when both are non-nil
+func (c *PowerDNSClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1/servers/"+url.PathEscape(c.serverID)+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("powerdns: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return fmt.Errorf("powerdns: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &PDNSError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var payload struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &payload) == nil && payload.Error != "" {
+			apiErr.Message = payload.Error
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("powerdns: decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServerInfo returns the version and type of the server
+func (c *PowerDNSClient) ServerInfo(ctx context.Context) (*PDNSServerInfo, error) {
+	var info PDNSServerInfo
+	if err := c.do(ctx, http.MethodGet, "", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Statistics returns the server statistics
+func (c *PowerDNSClient) Statistics(ctx context.Context) ([]PDNSStatistic, error) {
+	var stats []PDNSStatistic
+	err := c.do(ctx, http.MethodGet, "/statistics", nil, &stats)
+	return stats, err
+}
+
+// ListZones returns every zone of the server, without records
+func (c *PowerDNSClient) ListZones(ctx context.Context) ([]PDNSZone, error) {
+	var zones []PDNSZone
+	err := c.do(ctx, http.MethodGet, "/zones", nil, &zones)
+	return zones, err
+}
+
+// GetZone returns a zone with its RRsets, or ErrPDNSZoneNotFound
+func (c *PowerDNSClient) GetZone(ctx context.Context, zone string) (*PDNSZone, error) {
+	var z PDNSZone
+	err := c.do(ctx, http.MethodGet, c.zonePath(zone), nil, &z)
+	var apiErr *PDNSError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnprocessableEntity) {
+		return nil, ErrPDNSZoneNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+// CreateZone creates a zone, including any RRsets it carries
+func (c *PowerDNSClient) CreateZone(ctx context.Context, zone *PDNSZone) (*PDNSZone, error) {
+	var created PDNSZone
+	if err := c.do(ctx, http.MethodPost, "/zones", zone, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteZone removes a zone and all its records
+func (c *PowerDNSClient) DeleteZone(ctx context.Context, zone string) error {
+	err := c.do(ctx, http.MethodDelete, c.zonePath(zone), nil, nil)
+	var apiErr *PDNSError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return ErrPDNSZoneNotFound
+	}
+	return err
+}
+
+// PatchRRSets replaces or deletes RRsets of a zone in one atomic request
+func (c *PowerDNSClient) PatchRRSets(ctx context.Context, zone string, rrsets []PDNSRRSet) error {
+	if len(rrsets) == 0 {
+		return nil
+	}
+	return c.do(ctx, http.MethodPatch, c.zonePath(zone), map[string]any{"rrsets": rrsets}, nil)
+}
+
+// NotifyZone sends a NOTIFY for the zone to its secondaries
+func (c *PowerDNSClient) NotifyZone(ctx context.Context, zone string) error {
+	return c.do(ctx, http.MethodPut, c.zonePath(zone)+"/notify", nil, nil)
+}
+
+// ListCryptoKeys returns the DNSSEC keys of a zone
+func (c *PowerDNSClient) ListCryptoKeys(ctx context.Context, zone string) ([]PDNSCryptoKey, error) {
+	var keys []PDNSCryptoKey
+	err := c.do(ctx, http.MethodGet, c.zonePath(zone)+"/cryptokeys", nil, &keys)
+	return keys, err
+}
+
+// CreateCryptoKey imports a DNSSEC key into a zone
+func (c *PowerDNSClient) CreateCryptoKey(ctx context.Context, zone string, key *PDNSCryptoKey) (*PDNSCryptoKey, error) {
+	var created PDNSCryptoKey
+	if err := c.do(ctx, http.MethodPost, c.zonePath(zone)+"/cryptokeys", key, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateCryptoKey changes whether a key signs and whether it is published
+func (c *PowerDNSClient) UpdateCryptoKey(ctx context.Context, zone string, id int, active, published bool) error {
+	path := fmt.Sprintf("%s/cryptokeys/%d", c.zonePath(zone), id)
+	return c.do(ctx, http.MethodPut, path, map[string]bool{"active": active, "published": published}, nil)
+}
+
+// DeleteCryptoKey removes a DNSSEC key from a zone
+func (c *PowerDNSClient) DeleteCryptoKey(ctx context.Context, zone string, id int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/cryptokeys/%d", c.zonePath(zone), id), nil, nil)
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloudku-server/models"
+)
+
+// fakePowerDNS is an in-memory stand-in for the PowerDNS HTTP API
+type fakePowerDNS struct {
+	mu       sync.Mutex
+	zones    map[string]*PDNSZone
+	requests []string
+}
+
+func newFakePowerDNS(t *testing.T) (*fakePowerDNS, *PowerDNSClient) {
+	t.Helper()
+	f := &fakePowerDNS{zones: make(map[string]*PDNSZone)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewPowerDNSClient(srv.URL, "secret", "", srv.Client())
+}
+
+func (f *fakePowerDNS) fail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (f *fakePowerDNS) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (f *fakePowerDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/servers/localhost")
+	f.requests = append(f.requests, r.Method+" "+path)
+	if !ok {
+		f.fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if r.Header.Get("X-API-Key") != "secret" {
+		f.fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	rest, isZone := strings.CutPrefix(path, "/zones/")
+	name, sub, _ := strings.Cut(rest, "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		f.reply(w, http.StatusOK, PDNSServerInfo{ID: "localhost", DaemonType: "authoritative", Version: "4.9.0"})
+
+	case path == "/zones" && r.Method == http.MethodGet:
+		zones := []PDNSZone{}
+		for _, z := range f.zones {
+			zones = append(zones, PDNSZone{ID: z.Name, Name: z.Name, Kind: z.Kind})
+		}
+		f.reply(w, http.StatusOK, zones)
+
+	case path == "/zones" && r.Method == http.MethodPost:
+		var z PDNSZone
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			f.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, exists := f.zones[z.Name]; exists {
+			f.fail(w, http.StatusConflict, "Conflict")
+			return
+		}
+		z.ID = z.Name
+		f.zones[z.Name] = &z
+		f.reply(w, http.StatusCreated, z)
+
+	case isZone && f.zones[name] == nil:
+		f.fail(w, http.StatusNotFound, "Not Found")
+
+	case isZone && sub == "" && r.Method == http.MethodGet:
+		f.reply(w, http.StatusOK, f.zones[name])
+
+	case isZone && sub == "" && r.Method == http.MethodPatch:
+		var patch struct {
+			RRSets []PDNSRRSet `json:"rrsets"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			f.fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		z := f.zones[name]
+		for _, change := range patch.RRSets {
+			kept := z.RRSets[:0]
+			for _, set := range z.RRSets {
+				if set.Name != change.Name || set.Type != change.Type {
+					kept = append(kept, set)
+				}
+			}
+			z.RRSets = kept
+			if change.ChangeType == "REPLACE" {
+				change.ChangeType = ""
+				z.RRSets = append(z.RRSets, change)
+			}
+		}
+		f.reply(w, http.StatusNoContent, nil)
+
+	case isZone && sub == "" && r.Method == http.MethodDelete:
+		delete(f.zones, name)
+		f.reply(w, http.StatusNoContent, nil)
+
+	case isZone && sub == "cryptokeys" && r.Method == http.MethodGet:
+		f.reply(w, http.StatusOK, []PDNSCryptoKey{})
+
+	case isZone && sub == "notify" && r.Method == http.MethodPut:
+		f.reply(w, http.StatusOK, map[string]string{"result": "Notification queued"})
+
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+func testZoneRecords() []models.DNSRecord {
+	return []models.DNSRecord{
+		{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600},
+		{RecordType: "A", Name: "www", Value: "203.0.113.10", TTL: 300},
+		{RecordType: "A", Name: "www", Value: "203.0.113.11", TTL: 3600},
+		{RecordType: "NS", Name: "@", Value: "ignored.example.net", TTL: 3600},
+		{RecordType: "TXT", Name: "@", Value: `v=spf1 "quoted" -all`, TTL: 3600},
+	}
+}
+
+func testSOA(serial int64) ZoneSOA {
+	return ZoneSOA{PrimaryNS: "ns1.example.com", Hostmaster: "hostmaster.example.com", Serial: serial,
+		Refresh: 10800, Retry: 3600, Expire: 604800, Minimum: 3600}
+}
+
+func TestPowerDNSClientErrors(t *testing.T) {
+	_, client := newFakePowerDNS(t)
+	ctx := context.Background()
+
+	if _, err := client.GetZone(ctx, "missing.com"); !errors.Is(err, ErrPDNSZoneNotFound) {
+		t.Errorf("GetZone of an unknown zone = %v, want ErrPDNSZoneNotFound", err)
+	}
+	if err := client.DeleteZone(ctx, "missing.com"); !errors.Is(err, ErrPDNSZoneNotFound) {
+		t.Errorf("DeleteZone of an unknown zone = %v, want ErrPDNSZoneNotFound", err)
+	}
+
+	client.apiKey = "wrong"
+	_, err := client.ServerInfo(ctx)
+	var apiErr *PDNSError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Unauthorized" {
+		t.Errorf("ServerInfo with a bad key = %v, want a 401 PDNSError carrying the API message", err)
+	}
+}
+
+func TestPowerDNSClientZoneLifecycle(t *testing.T) {
+	f, client := newFakePowerDNS(t)
+	ctx := context.Background()
+
+	sets := DesiredRRSets("example.com", testSOA(2026010100), testZoneRecords())
+	if _, err := client.CreateZone(ctx, &PDNSZone{Name: "example.com.", Kind: "Native", RRSets: sets}); err != nil {
+		t.Fatalf("CreateZone: %v", err)
+	}
+
+	err := client.PatchRRSets(ctx, "Example.COM", []PDNSRRSet{
+		{Name: "www.example.com.", Type: "A", TTL: 60, ChangeType: "REPLACE", Records: []PDNSRecord{{Content: "198.51.100.7"}}},
+		{Name: "example.com.", Type: "TXT", ChangeType: "DELETE", Records: []PDNSRecord{}},
+	})
+	if err != nil {
+		t.Fatalf("PatchRRSets: %v", err)
+	}
+
+	z, err := client.GetZone(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("GetZone: %v", err)
+	}
+	var www *PDNSRRSet
+	for i := range z.RRSets {
+		switch {
+		case z.RRSets[i].Type == "TXT":
+			t.Error("deleted TXT RRset still present")
+		case z.RRSets[i].Name == "www.example.com." && z.RRSets[i].Type == "A":
+			www = &z.RRSets[i]
+		}
+	}
+	if www == nil || www.TTL != 60 || len(www.Records) != 1 || www.Records[0].Content != "198.51.100.7" {
+		t.Errorf("www A RRset = %+v, want the replaced set", www)
+	}
+
+	zones, err := client.ListZones(ctx)
+	if err != nil || len(zones) != 1 || zones[0].Name != "example.com." {
+		t.Errorf("ListZones = %+v, %v", zones, err)
+	}
+	if err := client.DeleteZone(ctx, "example.com"); err != nil {
+		t.Errorf("DeleteZone: %v", err)
+	}
+	if len(f.zones) != 0 {
+		t.Errorf("zones left after delete: %v", f.zones)
+	}
+}
+
+func TestDesiredRRSets(t *testing.T) {
+	sets := DesiredRRSets("example.com", testSOA(2026010105), testZoneRecords())
+
+	byKey := make(map[string]PDNSRRSet)
+	for _, set := range sets {
+		byKey[set.Name+"|"+set.Type] = set
+	}
+
+	soa := byKey["example.com.|SOA"]
+	if len(soa.Records) != 1 || soa.Records[0].Content != "ns1.example.com. hostmaster.example.com. 2026010105 10800 3600 604800 3600" {
+		t.Errorf("SOA = %+v", soa)
+	}
+	ns := rrsetContents(&PDNSRRSet{Type: "NS", Records: byKey["example.com.|NS"].Records})
+	if strings.Join(ns, " ") != "ns1.example.com. ns2.example.com." {
+		t.Errorf("apex NS = %v, want the zone nameservers instead of stored apex NS records", ns)
+	}
+	www := byKey["www.example.com.|A"]
+	if len(www.Records) != 2 || www.TTL != 300 {
+		t.Errorf("www A = %+v, want both records at the lowest TTL", www)
+	}
+	txt := byKey["example.com.|TXT"]
+	if len(txt.Records) != 1 || txt.Records[0].Content != `"v=spf1 \"quoted\" -all"` {
+		t.Errorf("TXT = %+v, want escaped zone file data", txt)
+	}
+}
+
+func TestDiffRRSets(t *testing.T) {
+	desired := DesiredRRSets("example.com", testSOA(2026010100), testZoneRecords())
+
+	if drift := diffRRSets(desired, desired); len(drift) != 0 {
+		t.Errorf("identical RRsets drift: %+v", drift)
+	}
+
+	actual := append([]PDNSRRSet{}, desired...)
+	for i := range actual {
+		if actual[i].Name == "www.example.com." {
+			actual[i] = PDNSRRSet{Name: "WWW.example.com.", Type: "A", TTL: 300, Records: []PDNSRecord{{Content: "203.0.113.10"}}}
+		}
+	}
+	actual = append(actual,
+		PDNSRRSet{Name: "old.example.com.", Type: "CNAME", TTL: 300, Records: []PDNSRecord{{Content: "example.com."}}},
+		PDNSRRSet{Name: "example.com.", Type: "DNSKEY", TTL: 3600, Records: []PDNSRecord{{Content: "257 3 13 abc"}}},
+	)
+
+	drift := diffRRSets(desired, actual)
+	if len(drift) != 2 {
+		t.Fatalf("drift = %+v, want the changed www set and the extra CNAME", drift)
+	}
+	if drift[0].Name != "old.example.com." || len(drift[0].Expected) != 0 {
+		t.Errorf("drift[0] = %+v, want the CNAME only PowerDNS has", drift[0])
+	}
+	if drift[1].Name != "www.example.com." || len(drift[1].Expected) != 2 || len(drift[1].Actual) != 1 {
+		t.Errorf("drift[1] = %+v, want the www set missing a record", drift[1])
+	}
+}
+
+func TestPowerDNSSyncPushesZoneAndAlias(t *testing.T) {
+	f, client := newFakePowerDNS(t)
+	s := NewPowerDNSSyncServiceWith(client, "Master")
+	ctx := context.Background()
+
+	zones := map[string][]PDNSRRSet{
+		"example.com": DesiredRRSets("example.com", testSOA(2026010100), testZoneRecords()),
+		// Alias zones share the serial of their domain
+		"example.net": DesiredRRSets("example.net", testSOA(2026010100), []models.DNSRecord{
+			{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600},
+		}),
+	}
+
+	for name, desired := range zones {
+		drift, err := s.diffZone(ctx, name, desired)
+		if err != nil {
+			t.Fatalf("diffZone %s: %v", name, err)
+		}
+		if !drift.Missing || drift.InSync() {
+			t.Fatalf("%s drift = %+v, want a missing zone", name, drift)
+		}
+		if err := s.push(ctx, name, desired, drift); err != nil {
+			t.Fatalf("push %s: %v", name, err)
+		}
+		if f.zones[canonicalZone(name)] == nil {
+			t.Fatalf("zone %s was not created", name)
+		}
+	}
+
+	// A record change is patched into the existing zone
+	changed := DesiredRRSets("example.net", testSOA(2026010101), []models.DNSRecord{
+		{RecordType: "A", Name: "@", Value: "198.51.100.7", TTL: 3600},
+	})
+	drift, err := s.diffZone(ctx, "example.net", changed)
+	if err != nil {
+		t.Fatalf("diffZone: %v", err)
+	}
+	if drift.Missing || len(drift.RRSets) != 2 {
+		t.Fatalf("drift = %+v, want the SOA and apex A sets", drift)
+	}
+	f.requests = nil
+	if err := s.push(ctx, "example.net", changed, drift); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if strings.Join(f.requests, ",") != "PATCH /zones/example.net.,PUT /zones/example.net./notify" {
+		t.Errorf("requests = %v, want a patch followed by a NOTIFY", f.requests)
+	}
+
+	after, err := s.diffZone(ctx, "example.net", changed)
+	if err != nil || !after.InSync() {
+		t.Errorf("drift after push = %+v, %v, want the zone in sync", after, err)
+	}
+}
+
+func TestZoneDriftInSyncIncludesAliases(t *testing.T) {
+	drift := &ZoneDrift{Zone: "example.com.", RRSets: []RRSetDrift{}}
+	if !drift.InSync() {
+		t.Fatal("empty drift is not in sync")
+	}
+
+	drift.Aliases = []ZoneDrift{{Zone: "example.net.", Missing: true}}
+	if drift.InSync() {
+		t.Error("drift with a missing alias zone reported in sync")
+	}
+}
@@ -0,0 +1,739 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/database"
+	"cloudku-server/models"
+)
+
+// pdnsManagedTypes are record types PowerDNS maintains itself (DNSSEC);
+// they are never compared or removed
+var pdnsManagedTypes = map[string]bool{
+	"DNSKEY": true, "CDS": true, "CDNSKEY": true, "RRSIG": true,
+	"NSEC": true, "NSEC3": true, "NSEC3PARAM": true,
+}
+
+// RRSetDrift is an RRset whose content in PowerDNS differs from Postgres.
+// Expected is empty for RRsets that only exist in PowerDNS, Actual for
+// RRsets missing from PowerDNS
+type RRSetDrift struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Expected    []string `json:"expected"`
+	Actual      []string `json:"actual"`
+	ExpectedTTL int      `json:"expected_ttl,omitempty"`
+	ActualTTL   int      `json:"actual_ttl,omitempty"`
+}
+
+// ZoneDrift compares the zone stored in Postgres with PowerDNS
+type ZoneDrift struct {
+	Zone string `json:"zone"`
+	// Missing is set when PowerDNS does not have the zone at all
+	Missing bool         `json:"missing"`
+	RRSets  []RRSetDrift `json:"rrsets"`
+	// Fixed is set when the drift was pushed to PowerDNS
+	Fixed bool `json:"fixed"`
+	// Aliases compares the zones of the domain's aliases
+	Aliases []ZoneDrift `json:"aliases,omitempty"`
+}
+
+// InSync reports whether PowerDNS serves exactly the stored zone and the
+// zones of its aliases
+func (d *ZoneDrift) InSync() bool {
+	for i := range d.Aliases {
+		if !d.Aliases[i].InSync() {
+			return false
+		}
+	}
+	return !d.Missing && len(d.RRSets) == 0
+}
+
+// PowerDNSReconcileReport is the outcome of comparing every zone
+type PowerDNSReconcileReport struct {
+	Checked int         `json:"checked"`
+	InSync  int         `json:"in_sync"`
+	Drifted []ZoneDrift `json:"drifted"`
+	// Orphans are zones in PowerDNS that no domain or alias owns
+	Orphans        []string          `json:"orphans"`
+	OrphansDeleted bool              `json:"orphans_deleted"`
+	Errors         map[string]string `json:"errors,omitempty"`
+}
+
+// PowerDNSStatus is the live state of the PowerDNS server
+type PowerDNSStatus struct {
+	Enabled       bool   `json:"enabled"`
+	Running       bool   `json:"running"`
+	Version       string `json:"version,omitempty"`
+	DaemonType    string `json:"daemon_type,omitempty"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Queries       int64  `json:"queries"`
+	Zones         int    `json:"zones"`
+	// PendingZones is the number of zones with changes not yet pushed
+	PendingZones int    `json:"pending_zones"`
+	Error        string `json:"error,omitempty"`
+}
+
+// PowerDNSSyncService pushes the zones stored in Postgres to PowerDNS and
+// reconciles drift. Postgres is the source of truth: PowerDNS is made to
+// match it, never the other way round
+type PowerDNSSyncService struct {
+	client   *PowerDNSClient
+	zoneKind string
+}
+
+// NewPowerDNSSyncService creates a sync service from config. It is disabled
+// when POWERDNS_API_URL is empty
+func NewPowerDNSSyncService() *PowerDNSSyncService {
+	cfg := config.AppConfig
+	if cfg.PowerDNSAPIURL == "" {
+		return &PowerDNSSyncService{}
+	}
+	client := NewPowerDNSClient(cfg.PowerDNSAPIURL, cfg.PowerDNSAPIKey, cfg.PowerDNSServerID, &http.Client{Timeout: 15 * time.Second})
+	return NewPowerDNSSyncServiceWith(client, cfg.PowerDNSZoneKind)
+}
+
+// NewPowerDNSSyncServiceWith creates a sync service with an explicit client.
+// zoneKind is the kind of newly created zones (Native, Master)
+func NewPowerDNSSyncServiceWith(client *PowerDNSClient, zoneKind string) *PowerDNSSyncService {
+	if zoneKind == "" {
+		zoneKind = "Native"
+	}
+	return &PowerDNSSyncService{client: client, zoneKind: zoneKind}
+}
+
+// Enabled reports whether a PowerDNS API is configured
+func (s *PowerDNSSyncService) Enabled() bool {
+	return s.client != nil
+}
+
+// Client returns the API client, nil when disabled
+func (s *PowerDNSSyncService) Client() *PowerDNSClient {
+	return s.client
+}
+
+// recordOwner returns the absolute owner name of a stored record name
+func recordOwner(name, zone string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case name == "" || name == "@":
+		return canonicalZone(zone)
+	case strings.HasSuffix(name, "."):
+		return name
+	case name == zone || strings.HasSuffix(name, "."+zone):
+		return name + "."
+	}
+	return name + "." + canonicalZone(zone)
+}
+
+// DesiredRRSets returns the RRsets PowerDNS should serve for a zone: the
+// SOA, the apex NS set and the stored records grouped by name and type.
+// An RRset has a single TTL, so differing record TTLs collapse to the lowest
+func DesiredRRSets(zone string, soa ZoneSOA, records []models.DNSRecord) []PDNSRRSet {
+	apex := canonicalZone(zone)
+	sets := []PDNSRRSet{{
+		Name: apex,
+		Type: "SOA",
+		TTL:  DefaultDNSTTL,
+		Records: []PDNSRecord{{Content: fmt.Sprintf("%s. %s. %d %d %d %d %d",
+			soa.PrimaryNS, soa.Hostmaster, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)}},
+	}}
+
+	ns := PDNSRRSet{Name: apex, Type: "NS", TTL: DefaultDNSTTL}
+	for _, n := range ZoneNameservers(zone) {
+		ns.Records = append(ns.Records, PDNSRecord{Content: n + "."})
+	}
+	sets = append(sets, ns)
+
+	index := make(map[string]int)
+	for i := range records {
+		r := &records[i]
+		rrtype := strings.ToUpper(r.RecordType)
+		owner := recordOwner(r.Name, zone)
+		if rrtype == "NS" && owner == apex {
+			// The apex NS set comes from DNS_NAMESERVERS
+			continue
+		}
+
+		key := owner + "|" + rrtype
+		pos, ok := index[key]
+		if !ok {
+			pos = len(sets)
+			index[key] = pos
+			sets = append(sets, PDNSRRSet{Name: owner, Type: rrtype, TTL: r.TTL})
+		}
+		if r.TTL < sets[pos].TTL {
+			sets[pos].TTL = r.TTL
+		}
+		sets[pos].Records = append(sets[pos].Records, PDNSRecord{Content: ZoneRecordData(r)})
+	}
+	return sets
+}
+
+// rrsetContents returns the sorted contents of an RRset for comparison
+func rrsetContents(set *PDNSRRSet) []string {
+	out := make([]string, 0, len(set.Records))
+	for _, r := range set.Records {
+		if r.Disabled {
+			continue
+		}
+		content := strings.TrimSpace(r.Content)
+		if set.Type != "TXT" {
+			content = strings.ToLower(content)
+		}
+		out = append(out, content)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// diffRRSets compares the desired RRsets with the ones PowerDNS has
+func diffRRSets(desired, actual []PDNSRRSet) []RRSetDrift {
+	current := make(map[string]*PDNSRRSet, len(actual))
+	for i := range actual {
+		set := &actual[i]
+		if pdnsManagedTypes[set.Type] {
+			continue
+		}
+		current[strings.ToLower(set.Name)+"|"+set.Type] = set
+	}
+
+	drift := []RRSetDrift{}
+	for i := range desired {
+		want := &desired[i]
+		key := want.Name + "|" + want.Type
+		have, ok := current[key]
+		delete(current, key)
+
+		expected := rrsetContents(want)
+		if !ok {
+			drift = append(drift, RRSetDrift{Name: want.Name, Type: want.Type, Expected: expected, Actual: []string{}, ExpectedTTL: want.TTL})
+			continue
+		}
+		actualContents := rrsetContents(have)
+		if have.TTL != want.TTL || strings.Join(expected, "\n") != strings.Join(actualContents, "\n") {
+			drift = append(drift, RRSetDrift{
+				Name: want.Name, Type: want.Type,
+				Expected: expected, Actual: actualContents,
+				ExpectedTTL: want.TTL, ActualTTL: have.TTL,
+			})
+		}
+	}
+
+	for _, have := range current {
+		drift = append(drift, RRSetDrift{Name: have.Name, Type: have.Type, Expected: []string{}, Actual: rrsetContents(have), ActualTTL: have.TTL})
+	}
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Name != drift[j].Name {
+			return drift[i].Name < drift[j].Name
+		}
+		return drift[i].Type < drift[j].Type
+	})
+	return drift
+}
+
+// AliasZone is the zone of an alias as it should be served. Alias zones are
+// unsigned and share the serial and SOA timers of their domain's zone
+type AliasZone struct {
+	Name   string
+	RRSets []PDNSRRSet
+}
+
+// zoneState is the stored zone of a domain as it should be served
+type zoneState struct {
+	Serial int64
+	RRSets []PDNSRRSet
+	DNSSEC bool
+	// Keys are the DNSSEC keys of a signed zone
+	Keys    []models.DNSSECKey
+	Aliases []AliasZone
+}
+
+// desiredZone loads the stored zone of a domain and of its aliases. The SOA
+// is read before the records, so a change committed in between leaves the
+// zone pending
+func desiredZone(ctx context.Context, d *models.Domain) (*zoneState, error) {
+	zone, err := models.GetDNSZone(ctx, database.DB, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := models.GetDNSRecordsByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &zoneState{
+		Serial: zone.Serial,
+		RRSets: DesiredRRSets(d.DomainName, ResolveSOA(d.DomainName, zone), records),
+		DNSSEC: zone.DNSSECEnabled,
+	}
+
+	aliases, err := models.GetAliasesByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range aliases {
+		aliasRecords, err := models.GetAliasDNSRecords(ctx, a.ID)
+		if err != nil {
+			return nil, err
+		}
+		state.Aliases = append(state.Aliases, AliasZone{
+			Name:   a.AliasName,
+			RRSets: DesiredRRSets(a.AliasName, ResolveSOA(a.AliasName, zone), aliasRecords),
+		})
+	}
+
+	if zone.DNSSECEnabled {
+		if state.Keys, err = models.GetDNSSECKeys(ctx, database.DB, d.ID); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// cryptoKeyDriftType is the drift entry type for DNSSEC key differences
+const cryptoKeyDriftType = "CRYPTOKEY"
+
+// cryptoKeyState describes a key as PowerDNS sees it: type, DNSKEY content
+// and whether it signs. Published, active and retired keys are all in the
+// DNSKEY set; only active ones sign
+func cryptoKeyState(keyType, dnskey string, active bool) string {
+	state := "inactive"
+	if active {
+		state = "active"
+	}
+	return strings.ToUpper(keyType) + " " + dnskey + " " + state
+}
+
+// storedKeyDNSKEY returns the DNSKEY content PowerDNS reports for a key
+func storedKeyDNSKEY(k *models.DNSSECKey) string {
+	return fmt.Sprintf("%d 3 %d %s", k.Flags, k.Algorithm, k.PublicKey)
+}
+
+// diffCryptoKeys compares the stored keys of a zone with the PowerDNS ones
+func diffCryptoKeys(zone string, keys []models.DNSSECKey, current []PDNSCryptoKey) *RRSetDrift {
+	expected := make([]string, 0, len(keys))
+	for i := range keys {
+		expected = append(expected, cryptoKeyState(keys[i].KeyType, storedKeyDNSKEY(&keys[i]), keys[i].State == models.DNSSECKeyActive))
+	}
+	actual := make([]string, 0, len(current))
+	for _, k := range current {
+		actual = append(actual, cryptoKeyState(k.KeyType, k.DNSKey, k.Active))
+	}
+	sort.Strings(expected)
+	sort.Strings(actual)
+	if strings.Join(expected, "\n") == strings.Join(actual, "\n") {
+		return nil
+	}
+	return &RRSetDrift{Name: canonicalZone(zone), Type: cryptoKeyDriftType, Expected: expected, Actual: actual}
+}
+
+// CheckZone compares a domain's zone with PowerDNS without changing anything
+func (s *PowerDNSSyncService) CheckZone(ctx context.Context, d *models.Domain) (*ZoneDrift, error) {
+	return s.reconcileZone(ctx, d, false)
+}
+
+// SyncZone makes PowerDNS serve the stored zone of a domain, creating the
+// zone if needed, and records the pushed serial. A no-op when disabled
+func (s *PowerDNSSyncService) SyncZone(ctx context.Context, d *models.Domain) (*ZoneDrift, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	return s.reconcileZone(ctx, d, true)
+}
+
+// SyncDomainID is SyncZone for a domain ID, for callers without the domain
+func (s *PowerDNSSyncService) SyncDomainID(ctx context.Context, domainID int) error {
+	if !s.Enabled() {
+		return nil
+	}
+	d, err := models.FindDomainByID(ctx, domainID)
+	if err != nil {
+		return err
+	}
+	_, err = s.SyncZone(ctx, d)
+	return err
+}
+
+func (s *PowerDNSSyncService) reconcileZone(ctx context.Context, d *models.Domain, apply bool) (*ZoneDrift, error) {
+	if !s.Enabled() {
+		return nil, errors.New("PowerDNS is not configured")
+	}
+
+	state, err := desiredZone(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	desired := state.RRSets
+
+	drift, err := s.diffZone(ctx, d.DomainName, desired)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range state.Aliases {
+		aliasDrift, err := s.diffZone(ctx, a.Name, a.RRSets)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s: %w", a.Name, err)
+		}
+		drift.Aliases = append(drift.Aliases, *aliasDrift)
+	}
+
+	var cryptoKeys []PDNSCryptoKey
+	if !drift.Missing {
+		if cryptoKeys, err = s.client.ListCryptoKeys(ctx, d.DomainName); err != nil {
+			return nil, err
+		}
+	}
+	if keyDrift := diffCryptoKeys(d.DomainName, state.Keys, cryptoKeys); keyDrift != nil {
+		drift.RRSets = append(drift.RRSets, *keyDrift)
+	}
+
+	if !apply {
+		return drift, nil
+	}
+
+	err = s.push(ctx, d.DomainName, desired, drift)
+	if err == nil {
+		err = s.pushCryptoKeys(ctx, d.DomainName, state.Keys, cryptoKeys)
+	}
+	for i := 0; err == nil && i < len(state.Aliases); i++ {
+		aliasDrift := &drift.Aliases[i]
+		if err = s.push(ctx, state.Aliases[i].Name, state.Aliases[i].RRSets, aliasDrift); err != nil {
+			err = fmt.Errorf("alias %s: %w", state.Aliases[i].Name, err)
+			break
+		}
+		aliasDrift.Fixed = !aliasDrift.InSync()
+	}
+	if markErr := models.MarkZoneSynced(ctx, d.ID, state.Serial, err); markErr != nil {
+		log.Printf("WARN: Failed to record PowerDNS sync of %s: %v", d.DomainName, markErr)
+	}
+	if err != nil {
+		return drift, err
+	}
+	drift.Fixed = !drift.InSync()
+	return drift, nil
+}
+
+// diffZone compares the desired RRsets of a zone with PowerDNS
+func (s *PowerDNSSyncService) diffZone(ctx context.Context, zone string, desired []PDNSRRSet) (*ZoneDrift, error) {
+	drift := &ZoneDrift{Zone: canonicalZone(zone), RRSets: []RRSetDrift{}}
+	current, err := s.client.GetZone(ctx, zone)
+	switch {
+	case errors.Is(err, ErrPDNSZoneNotFound):
+		drift.Missing = true
+		for i := range desired {
+			drift.RRSets = append(drift.RRSets, RRSetDrift{
+				Name: desired[i].Name, Type: desired[i].Type,
+				Expected: rrsetContents(&desired[i]), Actual: []string{}, ExpectedTTL: desired[i].TTL,
+			})
+		}
+	case err != nil:
+		return nil, err
+	default:
+		drift.RRSets = diffRRSets(desired, current.RRSets)
+	}
+	return drift, nil
+}
+
+// push writes the drift of a zone to PowerDNS
+func (s *PowerDNSSyncService) push(ctx context.Context, zone string, desired []PDNSRRSet, drift *ZoneDrift) error {
+	if drift.Missing {
+		noSOAEdit := ""
+		_, err := s.client.CreateZone(ctx, &PDNSZone{
+			Name: canonicalZone(zone),
+			Kind: s.zoneKind,
+			// The serial is managed by CloudKu, PowerDNS must not rewrite it
+			SOAEditAPI: &noSOAEdit,
+			RRSets:     desired,
+		})
+		if err != nil {
+			return fmt.Errorf("create zone: %w", err)
+		}
+		return s.notify(ctx, zone)
+	}
+	if drift.InSync() {
+		return nil
+	}
+
+	byKey := make(map[string]*PDNSRRSet, len(desired))
+	for i := range desired {
+		byKey[desired[i].Name+"|"+desired[i].Type] = &desired[i]
+	}
+
+	patch := make([]PDNSRRSet, 0, len(drift.RRSets))
+	for _, rd := range drift.RRSets {
+		if rd.Type == cryptoKeyDriftType {
+			continue
+		}
+		if want, ok := byKey[strings.ToLower(rd.Name)+"|"+rd.Type]; ok {
+			set := *want
+			set.ChangeType = "REPLACE"
+			patch = append(patch, set)
+			continue
+		}
+		patch = append(patch, PDNSRRSet{Name: rd.Name, Type: rd.Type, ChangeType: "DELETE", Records: []PDNSRecord{}})
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	if err := s.client.PatchRRSets(ctx, zone, patch); err != nil {
+		return fmt.Errorf("patch rrsets: %w", err)
+	}
+	return s.notify(ctx, zone)
+}
+
+// pushCryptoKeys makes the DNSSEC keys of a zone in PowerDNS match the
+// stored ones: missing keys are imported, states updated and unknown keys
+// deleted, which turns signing off for zones without keys
+func (s *PowerDNSSyncService) pushCryptoKeys(ctx context.Context, zone string, keys []models.DNSSECKey, current []PDNSCryptoKey) error {
+	byDNSKEY := make(map[string]PDNSCryptoKey, len(current))
+	for _, k := range current {
+		byDNSKEY[k.DNSKey] = k
+	}
+
+	for i := range keys {
+		k := &keys[i]
+		active := k.State == models.DNSSECKeyActive
+		existing, ok := byDNSKEY[storedKeyDNSKEY(k)]
+		delete(byDNSKEY, storedKeyDNSKEY(k))
+		if ok {
+			if existing.Active != active || !existing.Published {
+				if err := s.client.UpdateCryptoKey(ctx, zone, existing.ID, active, true); err != nil {
+					return fmt.Errorf("update key %d: %w", k.KeyTag, err)
+				}
+			}
+			continue
+		}
+
+		private, err := DNSSECPrivateKey(k)
+		if err != nil {
+			return err
+		}
+		_, err = s.client.CreateCryptoKey(ctx, zone, &PDNSCryptoKey{
+			KeyType:    strings.ToLower(k.KeyType),
+			Active:     active,
+			Published:  true,
+			PrivateKey: private,
+		})
+		if err != nil {
+			return fmt.Errorf("import key %d: %w", k.KeyTag, err)
+		}
+	}
+
+	for _, k := range byDNSKEY {
+		if err := s.client.DeleteCryptoKey(ctx, zone, k.ID); err != nil {
+			return fmt.Errorf("delete key %d: %w", k.ID, err)
+		}
+	}
+	return nil
+}
+
+// notify tells the secondaries of a primary zone about a change
+func (s *PowerDNSSyncService) notify(ctx context.Context, zone string) error {
+	if s.zoneKind != "Master" && s.zoneKind != "Primary" {
+		return nil
+	}
+	if err := s.client.NotifyZone(ctx, zone); err != nil {
+		// The zone itself is up to date; secondaries catch up on refresh
+		log.Printf("WARN: Failed to send NOTIFY for %s: %v", zone, err)
+	}
+	return nil
+}
+
+// RemoveZone deletes the zone of a deleted domain or alias from PowerDNS. A
+// no-op when disabled or when PowerDNS does not have the zone
+func (s *PowerDNSSyncService) RemoveZone(ctx context.Context, domainName string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	if err := s.client.DeleteZone(ctx, domainName); err != nil && !errors.Is(err, ErrPDNSZoneNotFound) {
+		return err
+	}
+	return nil
+}
+
+// ReconcileAll compares every domain's zone with PowerDNS. With apply set
+// drifted zones are pushed, and with deleteOrphans set PowerDNS zones that
+// no domain or alias owns are deleted
+func (s *PowerDNSSyncService) ReconcileAll(ctx context.Context, apply, deleteOrphans bool) (*PowerDNSReconcileReport, error) {
+	if !s.Enabled() {
+		return nil, errors.New("PowerDNS is not configured")
+	}
+
+	domains, err := models.GetAllDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	served, err := s.client.ListZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	aliasNames, err := models.GetAllAliasNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PowerDNSReconcileReport{Drifted: []ZoneDrift{}, Orphans: []string{}}
+	owned := make(map[string]bool, len(domains)+len(aliasNames))
+	for _, name := range aliasNames {
+		owned[canonicalZone(name)] = true
+	}
+	for i := range domains {
+		d := &domains[i]
+		owned[canonicalZone(d.DomainName)] = true
+		report.Checked++
+
+		drift, err := s.reconcileZone(ctx, d, apply)
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[string]string)
+			}
+			report.Errors[d.DomainName] = err.Error()
+		}
+		switch {
+		case drift == nil:
+		case drift.InSync():
+			report.InSync++
+		default:
+			report.Drifted = append(report.Drifted, *drift)
+		}
+	}
+
+	for _, z := range served {
+		if owned[strings.ToLower(z.Name)] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, z.Name)
+		if deleteOrphans {
+			if err := s.client.DeleteZone(ctx, z.Name); err != nil && !errors.Is(err, ErrPDNSZoneNotFound) {
+				if report.Errors == nil {
+					report.Errors = make(map[string]string)
+				}
+				report.Errors[z.Name] = err.Error()
+			}
+		}
+	}
+	report.OrphansDeleted = deleteOrphans && len(report.Orphans) > 0
+	sort.Strings(report.Orphans)
+	return report, nil
+}
+
+// Status queries PowerDNS for its version, uptime, query counters and zone
+// count. An unreachable server is reported in the status, not as an error
+func (s *PowerDNSSyncService) Status(ctx context.Context) *PowerDNSStatus {
+	status := &PowerDNSStatus{Enabled: s.Enabled()}
+	if !s.Enabled() {
+		return status
+	}
+	if pending, err := models.CountZonesPendingSync(ctx); err == nil {
+		status.PendingZones = pending
+	}
+
+	info, err := s.client.ServerInfo(ctx)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Running = true
+	status.Version = info.Version
+	status.DaemonType = info.DaemonType
+
+	if stats, err := s.client.Statistics(ctx); err == nil {
+		for _, st := range stats {
+			if st.Type != "StatisticItem" {
+				continue
+			}
+			var raw string
+			if err := json.Unmarshal(st.Value, &raw); err != nil {
+				continue
+			}
+			n, _ := strconv.ParseInt(raw, 10, 64)
+			switch st.Name {
+			case "uptime":
+				status.UptimeSeconds = n
+			case "udp-queries", "tcp-queries":
+				status.Queries += n
+			}
+		}
+	} else {
+		status.Error = err.Error()
+	}
+
+	if zones, err := s.client.ListZones(ctx); err == nil {
+		status.Zones = len(zones)
+	} else {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// SyncPending pushes zones with unsynced changes, such as changes made while
+// PowerDNS was unreachable, and returns how many were pushed
+func (s *PowerDNSSyncService) SyncPending(ctx context.Context, limit int) int {
+	ids, err := models.GetZonesPendingSync(ctx, limit)
+	if err != nil {
+		log.Printf("WARN: Failed to load zones pending PowerDNS sync: %v", err)
+		return 0
+	}
+
+	synced := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := s.SyncDomainID(ctx, id); err != nil {
+			log.Printf("WARN: Failed to sync zone %d to PowerDNS: %v", id, err)
+			continue
+		}
+		synced++
+	}
+	return synced
+}
+
+// Run pushes pending zones every interval and reconciles every zone every
+// reconcileInterval (0 disables the full reconcile) until the context is
+// cancelled
+func (s *PowerDNSSyncService) Run(ctx context.Context, interval, reconcileInterval time.Duration) {
+	if !s.Enabled() {
+		return
+	}
+	log.Printf("🌐 PowerDNS sync started (interval %s)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastReconcile time.Time
+	for {
+		if reconcileInterval > 0 && time.Since(lastReconcile) >= reconcileInterval {
+			lastReconcile = time.Now()
+			report, err := s.ReconcileAll(ctx, true, false)
+			switch {
+			case err != nil:
+				log.Printf("WARN: PowerDNS reconcile failed: %v", err)
+			case len(report.Drifted) > 0 || len(report.Orphans) > 0:
+				log.Printf("⚠️ PowerDNS reconcile fixed %d drifted zone(s); %d orphan zone(s) in PowerDNS", len(report.Drifted), len(report.Orphans))
+			}
+		} else {
+			s.SyncPending(ctx, 100)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("🌐 PowerDNS sync stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter counts events per key in fixed windows. It is in-memory, so
+// limits apply per server process
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	hits map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter allows limit events per key within window; a limit of 0
+// allows everything
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		hits:   make(map[string]*rateWindow),
+	}
+}
+
+// current returns the window of key, starting a new one when the last has
+// passed. The caller holds l.mu
+func (l *rateLimiter) current(key string, now time.Time) *rateWindow {
+	w, ok := l.hits[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if len(l.hits) >= 10000 {
+			l.prune(now)
+		}
+		w = &rateWindow{start: now}
+		l.hits[key] = w
+	}
+	return w
+}
+
+// prune drops the windows that have passed. The caller holds l.mu
+func (l *rateLimiter) prune(now time.Time) {
+	for key, w := range l.hits {
+		if now.Sub(w.start) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// Allow counts an event for key and reports whether it is within the limit
+func (l *rateLimiter) Allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.current(key, l.now())
+	w.count++
+	return w.count <= l.limit
+}
+
+// Exceeded reports whether key has used up its limit without counting an
+// event
+func (l *rateLimiter) Exceeded(key string) bool {
+	if l.limit <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.current(key, l.now()).count >= l.limit
+}
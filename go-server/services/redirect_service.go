@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cloudku-server/models"
+)
+
+// maxRedirectHops is how long a chain of our own redirects may get before
+// it is treated as a loop
+const maxRedirectHops = 10
+
+// acmeChallengePrefix is never redirected so HTTP-01 validation keeps working
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+var (
+	// ErrInvalidRedirect is returned for a rule that cannot be compiled
+	ErrInvalidRedirect = errors.New("invalid redirect rule")
+	// ErrRedirectLoop is returned when a set of rules redirects back onto itself
+	ErrRedirectLoop = errors.New("redirect loop")
+)
+
+var (
+	// redirectSourcePath allows decoded URL paths only. Web servers match the
+	// normalised path, so percent escapes would never match
+	redirectSourcePath = regexp.MustCompile(`^/[A-Za-z0-9._~/\-+@!,=:]*$`)
+	// redirectTarget allows a path or an absolute http(s) URL without
+	// whitespace, quotes or config metacharacters
+	redirectTarget = regexp.MustCompile(`^(?:https?://[A-Za-z0-9.\-]+(?::[0-9]{1,5})?)?(?:/[^\s"'<>\\{}|^` + "`" + `]*)?$`)
+	// redirectCaptureRef matches $1..$9 capture references in a target
+	redirectCaptureRef = regexp.MustCompile(`\$([1-9])`)
+)
+
+// RedirectStatusCodes are the HTTP status codes a redirect rule may use
+var RedirectStatusCodes = map[int]bool{301: true, 302: true, 307: true, 308: true}
+
+// VhostRedirect is a redirect rule compiled for the web server templates
+type VhostRedirect struct {
+	// HostPattern is an anchored regex for the Host header, empty for any host
+	HostPattern string
+	// PathPattern is an anchored regex for the request path
+	PathPattern string
+	// NginxPattern matches "$host$uri" in one regex, since nginx "if" cannot
+	// combine conditions
+	NginxPattern string
+	Target       string
+	// NginxTarget is Target with the request query appended when preserved
+	NginxTarget   string
+	StatusCode    int
+	PreserveQuery bool
+}
+
+// ValidateRedirect checks a single rule against the hostnames of the site it
+// belongs to
+func ValidateRedirect(r *models.DomainRedirect, hostnames []string) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidRedirect, fmt.Sprintf(format, args...))
+	}
+
+	if r.SourceHost != "" && !containsString(hostnames, r.SourceHost) {
+		return invalid("source host %s is not a hostname of this domain", r.SourceHost)
+	}
+
+	switch r.MatchType {
+	case models.RedirectMatchExact, models.RedirectMatchPrefix:
+		if !redirectSourcePath.MatchString(r.SourcePath) {
+			return invalid("source path must start with / and contain only URL path characters")
+		}
+	case models.RedirectMatchRegex:
+		if !strings.HasPrefix(r.SourcePath, "^/") {
+			return invalid("regex source must be anchored and start with ^/")
+		}
+		if strings.ContainsAny(r.SourcePath, "\"' \t\r\n") {
+			return invalid("regex source must not contain quotes or whitespace")
+		}
+		re, err := regexp.Compile(r.SourcePath)
+		if err != nil {
+			return invalid("regex source does not compile: %v", err)
+		}
+		for _, m := range redirectCaptureRef.FindAllStringSubmatch(r.Target, -1) {
+			if int(m[1][0]-'0') > re.NumSubexp() {
+				return invalid("target references $%s but the source has %d groups", m[1], re.NumSubexp())
+			}
+		}
+	default:
+		return invalid("match type must be exact, prefix or regex")
+	}
+
+	if r.Target == "" || !redirectTarget.MatchString(r.Target) {
+		return invalid("target must be a path starting with / or an http(s) URL")
+	}
+	if strings.Contains(redirectCaptureRef.ReplaceAllString(r.Target, ""), "$") {
+		return invalid("target may only use $1-$9 capture references")
+	}
+	if r.MatchType != models.RedirectMatchRegex && redirectCaptureRef.MatchString(r.Target) {
+		return invalid("capture references require a regex source")
+	}
+	if r.PreserveQuery && strings.Contains(r.Target, "?") {
+		return invalid("a target with a query string cannot preserve the request query")
+	}
+	if !RedirectStatusCodes[r.StatusCode] {
+		return invalid("status code must be 301, 302, 307 or 308")
+	}
+
+	return nil
+}
+
+// CheckRedirectLoops follows every rule from its own source and target and
+// rejects rule sets where a request is redirected back to a URL it already
+// visited, or bounces between our hostnames for more than maxRedirectHops.
+// forceHTTPS models the HTTP to HTTPS redirect in front of the rules
+func CheckRedirectLoops(rules []models.DomainRedirect, hostnames []string, forceHTTPS bool) error {
+	compiled := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		if r.MatchType == models.RedirectMatchRegex {
+			re, err := regexp.Compile(r.SourcePath)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+			}
+			compiled[i] = re
+		}
+	}
+
+	// apply returns the redirect a request receives, if any
+	apply := func(req redirectRequest) (redirectRequest, bool) {
+		if req.scheme == "http" && forceHTTPS {
+			return redirectRequest{"https", req.host, req.path}, true
+		}
+		if strings.HasPrefix(req.path, acmeChallengePrefix) {
+			return req, false
+		}
+		for i, r := range rules {
+			if r.SourceHost != "" && r.SourceHost != req.host {
+				continue
+			}
+			target := r.Target
+			switch r.MatchType {
+			case models.RedirectMatchExact:
+				if req.path != r.SourcePath {
+					continue
+				}
+			case models.RedirectMatchPrefix:
+				if !strings.HasPrefix(req.path, r.SourcePath) {
+					continue
+				}
+			case models.RedirectMatchRegex:
+				m := compiled[i].FindStringSubmatchIndex(req.path)
+				if m == nil {
+					continue
+				}
+				tmpl := redirectCaptureRef.ReplaceAllString(target, "$${$1}")
+				target = string(compiled[i].ExpandString(nil, tmpl, req.path, m))
+			}
+			return resolveRedirectTarget(req.scheme, req.host, target), true
+		}
+		return req, false
+	}
+
+	follow := func(start redirectRequest) error {
+		visited := map[redirectRequest]bool{start: true}
+		chain := []string{start.scheme + "://" + start.host + start.path}
+		current := start
+		for hop := 0; hop < maxRedirectHops; hop++ {
+			next, redirected := apply(current)
+			if !redirected || !containsString(hostnames, next.host) {
+				return nil
+			}
+			chain = append(chain, next.scheme+"://"+next.host+next.path)
+			if visited[next] {
+				return fmt.Errorf("%w: %s", ErrRedirectLoop, strings.Join(chain, " -> "))
+			}
+			visited[next] = true
+			current = next
+		}
+		return fmt.Errorf("%w: more than %d redirects starting at %s", ErrRedirectLoop, maxRedirectHops, chain[0])
+	}
+
+	var starts []redirectRequest
+	for _, r := range rules {
+		hosts := hostnames
+		if r.SourceHost != "" {
+			hosts = []string{r.SourceHost}
+		}
+
+		for _, host := range hosts {
+			if r.MatchType != models.RedirectMatchRegex {
+				starts = append(starts, redirectRequest{"https", host, r.SourcePath})
+			}
+			// Targets with captures are sampled with a placeholder value
+			target := resolveRedirectTarget("https", host, redirectCaptureRef.ReplaceAllString(r.Target, "loopcheck"))
+			if containsString(hostnames, target.host) {
+				starts = append(starts, target)
+			}
+		}
+	}
+
+	for _, start := range starts {
+		for _, scheme := range []string{"http", "https"} {
+			start.scheme = scheme
+			if err := follow(start); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// redirectRequest is a request URL as seen by the loop check; the query
+// string never affects which rule matches, so it is left out
+type redirectRequest struct{ scheme, host, path string }
+
+// resolveRedirectTarget resolves a rule target against the request it
+// redirects
+func resolveRedirectTarget(scheme, host, target string) redirectRequest {
+	result := redirectRequest{scheme, host, target}
+	if u, err := url.Parse(target); err == nil {
+		if u.Scheme != "" {
+			result.scheme = u.Scheme
+		}
+		if u.Host != "" {
+			result.host = strings.ToLower(u.Hostname())
+		}
+		result.path = u.Path
+	}
+	if result.path == "" {
+		result.path = "/"
+	}
+	return result
+}
+
+// CompileRedirects converts stored rules into template data
+func CompileRedirects(rules []models.DomainRedirect) []VhostRedirect {
+	compiled := make([]VhostRedirect, 0, len(rules))
+	for _, r := range rules {
+		var path string
+		switch r.MatchType {
+		case models.RedirectMatchExact:
+			path = "^" + regexp.QuoteMeta(r.SourcePath) + "$"
+		case models.RedirectMatchPrefix:
+			path = "^" + regexp.QuoteMeta(r.SourcePath)
+		default:
+			path = r.SourcePath
+		}
+
+		host, nginxHost := "", `[^/]*`
+		if r.SourceHost != "" {
+			host = "^" + regexp.QuoteMeta(r.SourceHost) + "$"
+			nginxHost = "(?:" + regexp.QuoteMeta(r.SourceHost) + ")"
+		}
+
+		nginxTarget := r.Target
+		if r.PreserveQuery {
+			nginxTarget += "$is_args$args"
+		}
+
+		compiled = append(compiled, VhostRedirect{
+			HostPattern:   host,
+			PathPattern:   path,
+			NginxPattern:  "^" + nginxHost + "(?:" + strings.TrimPrefix(path, "^") + ")",
+			Target:        r.Target,
+			NginxTarget:   nginxTarget,
+			StatusCode:    r.StatusCode,
+			PreserveQuery: r.PreserveQuery,
+		})
+	}
+	return compiled
+}
+
+// nginxQuote quotes a value for an nginx config. nginx unescapes \\ inside
+// quoted strings, so backslashes are doubled to survive the round trip
+func nginxQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `\`, `\\`) + `"`
+}
+
+// apacheTarget escapes "%" so Apache does not read it as a RewriteCond
+// back-reference
+func apacheTarget(target string) string {
+	return strings.ReplaceAll(target, "%", `\%`)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDomainRedirects validates a complete, ordered rule set for a
+// domain before it is saved
+func ValidateDomainRedirects(ctx context.Context, d *models.Domain, rules []models.DomainRedirect) error {
+	hostnames, err := SiteHostnames(ctx, d)
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		if err := ValidateRedirect(&rules[i], hostnames); err != nil {
+			return err
+		}
+	}
+	// Assume HTTPS enforcement is live whenever it is switched on, so enabling
+	// SSL later cannot turn an accepted rule set into a loop
+	return CheckRedirectLoops(rules, hostnames, d.ForceHTTPS)
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+)
+
+// ErrInvalidCertificate is returned for an uploaded certificate that cannot
+// be installed; the wrapped message says why
+var ErrInvalidCertificate = errors.New("invalid certificate")
+
+// maxCertificatePEM caps each PEM field of an upload
+const maxCertificatePEM = 64 * 1024
+
+// CertificateInfo describes a certificate as shown to users
+type CertificateInfo struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SANs              []string  `json:"sans"`
+	SerialNumber      string    `json:"serial_number"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	KeyType           string    `json:"key_type"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+}
+
+// DescribeCertificate extracts the details of a parsed certificate
+func DescribeCertificate(cert *x509.Certificate) CertificateInfo {
+	sum := sha256.Sum256(cert.Raw)
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return CertificateInfo{
+		Subject:           cert.Subject.CommonName,
+		Issuer:            cert.Issuer.CommonName,
+		SANs:              sans,
+		SerialNumber:      cert.SerialNumber.Text(16),
+		FingerprintSHA256: strings.ToUpper(hex.EncodeToString(sum[:])),
+		KeyType:           publicKeyType(cert.PublicKey),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+	}
+}
+
+// publicKeyType names a key algorithm and size, e.g. "RSA 2048"
+func publicKeyType(key any) string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return "unknown"
+}
+
+// parseCertificatesPEM decodes every CERTIFICATE block of a PEM bundle
+func parseCertificatesPEM(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, errors.New("trailing data after the last PEM block")
+	}
+	return certs, nil
+}
+
+// CertificateUpload is a certificate bought elsewhere, in PEM. Chain holds
+// the intermediates; they may also follow the leaf in Certificate
+type CertificateUpload struct {
+	Certificate string
+	PrivateKey  string
+	Chain       string
+}
+
+// ValidateCertificateUpload checks that the key matches the certificate,
+// the chain builds to a root in roots (nil uses the system roots) and the
+// certificate covers every hostname. It returns the leaf and the full chain
+// in PEM, leaf first, as the web server expects it
+func ValidateCertificateUpload(up CertificateUpload, hostnames []string, roots *x509.CertPool, now time.Time) (*x509.Certificate, []byte, error) {
+	invalid := func(format string, args ...any) (*x509.Certificate, []byte, error) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidCertificate, fmt.Sprintf(format, args...))
+	}
+	if len(up.Certificate) > maxCertificatePEM || len(up.PrivateKey) > maxCertificatePEM || len(up.Chain) > maxCertificatePEM {
+		return invalid("PEM data exceeds %d bytes", maxCertificatePEM)
+	}
+
+	certs, err := parseCertificatesPEM(up.Certificate)
+	if err != nil {
+		return invalid("certificate: %v", err)
+	}
+	if len(certs) == 0 {
+		return invalid("certificate: no PEM certificate found")
+	}
+	chain, err := parseCertificatesPEM(up.Chain)
+	if err != nil {
+		return invalid("chain: %v", err)
+	}
+	leaf := certs[0]
+	intermediates := append(certs[1:], chain...)
+
+	// X509KeyPair accepts PKCS#1, PKCS#8 and EC keys and checks they match
+	if strings.Contains(up.PrivateKey, "ENCRYPTED") {
+		return invalid("private key must not be password protected")
+	}
+	if _, err := tls.X509KeyPair(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), []byte(up.PrivateKey)); err != nil {
+		return invalid("private key does not match the certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return invalid("chain does not verify: %v", err)
+	}
+
+	var uncovered []string
+	for _, h := range hostnames {
+		if leaf.VerifyHostname(h) != nil {
+			uncovered = append(uncovered, h)
+		}
+	}
+	if len(uncovered) > 0 {
+		return invalid("certificate does not cover %s", strings.Join(uncovered, ", "))
+	}
+
+	fullchain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	for _, c := range intermediates {
+		// Drop a root sent along with the chain; clients already have it
+		if c.IsCA && c.CheckSignatureFrom(c) == nil {
+			continue
+		}
+		fullchain = append(fullchain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return leaf, fullchain, nil
+}
+
+// SSLCertificateService stores domain certificates where the vhost expects
+// them and activates them in the web server
+type SSLCertificateService struct {
+	certDir string
+	vhost   *VhostService
+	// roots verifies uploaded chains; nil uses the system roots
+	roots *x509.CertPool
+}
+
+// NewSSLCertificateService creates a certificate store from config
+func NewSSLCertificateService(vhost *VhostService) *SSLCertificateService {
+	return NewSSLCertificateServiceWith(config.AppConfig.SSLCertDir, vhost, nil)
+}
+
+// NewSSLCertificateServiceWith creates a certificate store with an explicit
+// directory and trusted roots
+func NewSSLCertificateServiceWith(certDir string, vhost *VhostService, roots *x509.CertPool) *SSLCertificateService {
+	return &SSLCertificateService{certDir: certDir, vhost: vhost, roots: roots}
+}
+
+// Install writes the full chain and private key of a domain. The key is
+// only readable by the owner
+func (s *SSLCertificateService) Install(domainName string, fullchain, keyPEM []byte) error {
+	if !safeConfigValue.MatchString(domainName) || strings.Contains(domainName, "..") {
+		return fmt.Errorf("invalid domain name %q", domainName)
+	}
+	certPath, keyPath := CertificatePaths(s.certDir, domainName)
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return fmt.Errorf("create certificate directory: %w", err)
+	}
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("write private key: %w", err)
+	}
+	if err := writeFileAtomic(certPath, fullchain, 0644); err != nil {
+		return fmt.Errorf("write certificate: %w", err)
+	}
+	return nil
+}
+
+// Installed describes the certificate installed for a domain
+func (s *SSLCertificateService) Installed(domainName string) (*CertificateInfo, error) {
+	certPath, _ := CertificatePaths(s.certDir, domainName)
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM certificate", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	info := DescribeCertificate(cert)
+	return &info, nil
+}
+
+// Activate re-renders the domain's vhost, which references the certificate
+// once it exists, and reloads the web server so a replaced certificate is
+// served
+func (s *SSLCertificateService) Activate(ctx context.Context, domainID int) {
+	if s.vhost == nil {
+		return
+	}
+	d, err := models.FindDomainByID(ctx, domainID)
+	if err != nil {
+		return
+	}
+	if err := s.vhost.SyncDomain(ctx, d); err != nil {
+		log.Printf("WARN: Failed to provision vhost for %s: %v", d.DomainName, err)
+		return
+	}
+	if err := s.vhost.Reload(ctx); err != nil {
+		log.Printf("WARN: Failed to reload web server for %s: %v", d.DomainName, err)
+	}
+}
+
+// Record marks SSL as enabled with the installed certificate and adds it
+// to the certificate inventory
+func (s *SSLCertificateService) Record(ctx context.Context, d *models.Domain, leaf *x509.Certificate, provider, challenge string, wildcard bool) error {
+	if err := models.SetDomainCertificate(ctx, d.ID, provider, challenge, wildcard, leaf.NotAfter); err != nil {
+		return fmt.Errorf("record certificate: %w", err)
+	}
+	entry := inventoryEntry(d.ID, DescribeCertificate(leaf), provider, wildcard)
+	if err := models.UpsertSSLCertificate(ctx, entry); err != nil {
+		// The certificate is live either way; the backfill catches up
+		log.Printf("WARN: Failed to add the certificate of %s to the inventory: %v", d.DomainName, err)
+	}
+	return nil
+}
+
+// inventoryEntry builds the inventory row of an installed certificate
+func inventoryEntry(domainID int, info CertificateInfo, provider string, wildcard bool) *models.SSLCertificate {
+	return &models.SSLCertificate{
+		DomainID:          domainID,
+		SANs:              info.SANs,
+		Subject:           info.Subject,
+		Issuer:            info.Issuer,
+		SerialNumber:      info.SerialNumber,
+		FingerprintSHA256: info.FingerprintSHA256,
+		KeyType:           info.KeyType,
+		Provider:          provider,
+		Wildcard:          wildcard,
+		NotBefore:         info.NotBefore,
+		NotAfter:          info.NotAfter,
+	}
+}
+
+// SyncInventory adds the certificates of SSL-enabled domains missing from
+// the inventory, read from the installed files, and returns how many were
+// added. It backfills certificates installed before the inventory existed
+func (s *SSLCertificateService) SyncInventory(ctx context.Context) (int, error) {
+	domains, err := models.GetDomainsWithoutCertificateRecord(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for i := range domains {
+		d := &domains[i]
+		info, err := s.Installed(d.DomainName)
+		if err != nil {
+			// SSL is on without a certificate on disk; nothing to record
+			continue
+		}
+		provider := d.SSLProvider.String
+		if provider == "" {
+			provider = "unknown"
+		}
+		if err := models.UpsertSSLCertificate(ctx, inventoryEntry(d.ID, *info, provider, d.SSLWildcard)); err != nil {
+			return added, fmt.Errorf("%s: %w", d.DomainName, err)
+		}
+		added++
+	}
+	return added, nil
+}
+
+// Upload validates and installs a custom certificate for a domain. It must
+// cover the domain, its www host and every alias included in SSL
+func (s *SSLCertificateService) Upload(ctx context.Context, d *models.Domain, up CertificateUpload) (*CertificateInfo, error) {
+	hostnames, err := models.GetSSLHostnames(ctx, d)
+	if err != nil {
+		return nil, fmt.Errorf("load hostnames: %w", err)
+	}
+	leaf, fullchain, err := ValidateCertificateUpload(up, hostnames, s.roots, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Install(d.DomainName, fullchain, []byte(strings.TrimSpace(up.PrivateKey)+"\n")); err != nil {
+		return nil, err
+	}
+	wildcard := false
+	for _, name := range leaf.DNSNames {
+		wildcard = wildcard || strings.EqualFold(name, "*."+d.DomainName)
+	}
+	if err := s.Record(ctx, d, leaf, models.SSLProviderCustom, "", wildcard); err != nil {
+		return nil, err
+	}
+	s.Activate(ctx, d.ID)
+
+	info := DescribeCertificate(leaf)
+	return &info, nil
+}
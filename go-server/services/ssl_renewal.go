@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+)
+
+// SSLRenewalOptions tunes the background certificate renewal
+type SSLRenewalOptions struct {
+	// Interval is how often the worker looks for certificates due for renewal
+	Interval time.Duration
+	// RenewBefore is how long before expiry a certificate becomes due
+	RenewBefore time.Duration
+	// Jitter spreads renewals: a certificate that becomes due is renewed at
+	// a random point within Jitter, so they do not all hit the CA at once
+	Jitter time.Duration
+	// BaseBackoff is the delay after the first failed renewal; it doubles
+	// with every consecutive failure up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BatchSize caps the number of renewals per tick
+	BatchSize int
+	// Timeout bounds a single renewal
+	Timeout time.Duration
+}
+
+// SSLRenewalWorker renews ACME certificates of domains with auto-renewal on
+// before they expire. Attempts are recorded in the renewal history; failures
+// are retried with backoff and reported to the domain owner
+type SSLRenewalWorker struct {
+	acme *ACMEService
+	opts SSLRenewalOptions
+	now  func() time.Time
+	// jitter returns a random duration in [0, max)
+	jitter func(max time.Duration) time.Duration
+}
+
+// NewSSLRenewalWorker creates a renewal worker from config
+func NewSSLRenewalWorker(acme *ACMEService) *SSLRenewalWorker {
+	cfg := config.AppConfig
+	return NewSSLRenewalWorkerWith(acme, SSLRenewalOptions{
+		Interval:    cfg.SSLRenewalInterval,
+		RenewBefore: cfg.SSLRenewBefore,
+		Jitter:      cfg.SSLRenewalJitter,
+		Timeout:     cfg.ACMETimeout,
+	})
+}
+
+// NewSSLRenewalWorkerWith creates a renewal worker with explicit options.
+// Zero options fall back to defaults; a zero Jitter disables it
+func NewSSLRenewalWorkerWith(acme *ACMEService, opts SSLRenewalOptions) *SSLRenewalWorker {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Minute
+	}
+	if opts.RenewBefore <= 0 {
+		opts.RenewBefore = 30 * 24 * time.Hour
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = time.Hour
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 24 * time.Hour
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 10
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Minute
+	}
+
+	return &SSLRenewalWorker{
+		acme: acme,
+		opts: opts,
+		now:  time.Now,
+		jitter: func(max time.Duration) time.Duration {
+			if max <= 0 {
+				return 0
+			}
+			return rand.N(max)
+		},
+	}
+}
+
+// Run renews due certificates every interval until the context is cancelled
+func (w *SSLRenewalWorker) Run(ctx context.Context) {
+	log.Printf("🔐 SSL renewal worker started (interval %s, renewing %s before expiry)", w.opts.Interval, w.opts.RenewBefore)
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("🔐 SSL renewal worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce handles every domain whose renewal is due and returns how many
+// were handled
+func (w *SSLRenewalWorker) RunOnce(ctx context.Context) int {
+	domains, err := models.GetDomainsDueForRenewal(ctx, w.opts.RenewBefore, w.opts.BatchSize)
+	if err != nil {
+		log.Printf("WARN: SSL renewal worker failed to load domains: %v", err)
+		return 0
+	}
+
+	for i := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		w.renewDomain(ctx, &domains[i])
+	}
+
+	return len(domains)
+}
+
+// renewDomain schedules a domain that just became due at a jittered time,
+// or renews it when its scheduled time has come
+func (w *SSLRenewalWorker) renewDomain(ctx context.Context, d *models.RenewalDomain) {
+	if !d.NextRenewalAt.Valid {
+		next := w.now().Add(w.jitter(w.opts.Jitter))
+		if err := models.ScheduleSSLRenewal(ctx, d.ID, next); err != nil {
+			log.Printf("WARN: SSL renewal worker failed to schedule %s: %v", d.DomainName, err)
+		}
+		return
+	}
+
+	renewCtx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+
+	actor := models.DNSChangeActor{Source: models.DNSChangeSourceACME}
+	cert, err := w.acme.Renew(renewCtx, actor, &d.Domain, ACMERequest{}, models.SSLRenewalTriggerAuto)
+	if errors.Is(err, ErrACMEInProgress) {
+		// A manual renewal is running; look again on the next tick
+		return
+	}
+	if err == nil {
+		log.Printf("🔐 Renewed SSL certificate of %s, valid until %s", d.DomainName, cert.NotAfter.Format(time.RFC3339))
+		if d.RenewFailures > 0 {
+			w.notify(ctx, d, models.NotificationSSLRenewed,
+				"SSL certificate renewed",
+				fmt.Sprintf("The SSL certificate of %s was renewed after %d failed attempts and is valid until %s.",
+					d.DomainName, d.RenewFailures, cert.NotAfter.Format("2006-01-02")))
+		}
+		return
+	}
+
+	failures := d.RenewFailures + 1
+	delay := w.backoff(failures)
+	next := w.now().Add(delay + w.jitter(delay/10))
+	log.Printf("WARN: Failed to renew SSL certificate of %s (attempt %d, retrying in %s): %v", d.DomainName, failures, delay, err)
+	if err := models.RecordSSLRenewalFailure(ctx, d.ID, failures, next, err.Error()); err != nil {
+		log.Printf("WARN: SSL renewal worker failed to record failure for %s: %v", d.DomainName, err)
+	}
+
+	if w.notifyFailure(failures) {
+		w.notify(ctx, d, models.NotificationSSLRenewalFailed,
+			"SSL certificate renewal failed",
+			fmt.Sprintf("The SSL certificate of %s expires on %s and could not be renewed: %v. We will keep retrying.",
+				d.DomainName, d.SSLExpiresAt.Time.Format("2006-01-02"), err))
+	}
+}
+
+// notify sends a notification about a domain to its owner, logging failures
+func (w *SSLRenewalWorker) notify(ctx context.Context, d *models.RenewalDomain, notificationType, title, message string) {
+	domainID := d.ID
+	if err := models.CreateNotification(ctx, d.UserID, &domainID, notificationType, title, message); err != nil {
+		log.Printf("WARN: Failed to notify the owner of %s: %v", d.DomainName, err)
+	}
+}
+
+// notifyFailure reports whether the owner is told about the given
+// consecutive failure: the first one and the one whose retry delay reaches
+// the maximum backoff, rather than every retry
+func (w *SSLRenewalWorker) notifyFailure(failures int) bool {
+	return failures == 1 || w.backoff(failures) == w.opts.MaxBackoff && w.backoff(failures-1) < w.opts.MaxBackoff
+}
+
+// backoff returns the delay after the given number of consecutive failures
+func (w *SSLRenewalWorker) backoff(failures int) time.Duration {
+	delay := w.opts.BaseBackoff
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= w.opts.MaxBackoff {
+			return w.opts.MaxBackoff
+		}
+	}
+	return delay
+}
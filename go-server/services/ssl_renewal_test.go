@@ -0,0 +1,48 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSSLRenewalBackoff(t *testing.T) {
+	w := NewSSLRenewalWorkerWith(nil, SSLRenewalOptions{BaseBackoff: time.Hour, MaxBackoff: 24 * time.Hour})
+
+	want := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour, 24 * time.Hour, 24 * time.Hour}
+	for i, d := range want {
+		if got := w.backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, d)
+		}
+	}
+	if got := w.backoff(1000); got != 24*time.Hour {
+		t.Errorf("backoff(1000) = %s, want the maximum", got)
+	}
+}
+
+func TestSSLRenewalNotifiesFailures(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     SSLRenewalOptions
+		notified []int
+	}{
+		// 1h, 2h, 4h, 8h, 16h, 24h: told on the first failure and at 24h
+		{"doubling", SSLRenewalOptions{BaseBackoff: time.Hour, MaxBackoff: 24 * time.Hour}, []int{1, 6}},
+		// 1h, 2h, 4h: the maximum is reached exactly
+		{"exact maximum", SSLRenewalOptions{BaseBackoff: time.Hour, MaxBackoff: 4 * time.Hour}, []int{1, 3}},
+		// Already at the maximum on the first failure
+		{"no backoff", SSLRenewalOptions{BaseBackoff: time.Hour, MaxBackoff: time.Hour}, []int{1}},
+	}
+	for _, tc := range cases {
+		w := NewSSLRenewalWorkerWith(nil, tc.opts)
+		var notified []int
+		for failures := 1; failures <= 10; failures++ {
+			if w.notifyFailure(failures) {
+				notified = append(notified, failures)
+			}
+		}
+		if !slices.Equal(notified, tc.notified) {
+			t.Errorf("%s: notified on failures %v, want %v", tc.name, notified, tc.notified)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"cloudku-server/config"
+	"cloudku-server/models"
+	"cloudku-server/utils"
+)
+
+//go:embed templates/*.tmpl
+var vhostTemplateFS embed.FS
+
+// statusPageFS holds the default pages served while a domain is suspended or
+// in maintenance. They are copied to the status page directory only when
+// missing, so operators can replace them
+//
+//go:embed templates/pages/*.html
+var statusPageFS embed.FS
+
+var vhostTemplates = template.Must(template.New("vhost").Funcs(template.FuncMap{
+	"join":             strings.Join,
+	"apachePHPHandler": apachePHPHandler,
+	"nginxQuote":       nginxQuote,
+	"apacheTarget":     apacheTarget,
+}).ParseFS(vhostTemplateFS, "templates/*.tmpl"))
+
+// safeConfigValue restricts what may be interpolated into a web server
+// config. Anything else (spaces, quotes, ";", braces) could inject directives
+var safeConfigValue = regexp.MustCompile(`^[A-Za-z0-9._/:\-*]+$`)
+
+// Supported web servers
+const (
+	WebServerNginx  = "nginx"
+	WebServerApache = "apache"
+)
+
+// CommandRunner runs a shell-style command line and returns its combined
+// output. Injected so tests can fake config tests and reloads
+type CommandRunner func(ctx context.Context, command string) ([]byte, error)
+
+// execCommandRunner runs the command with os/exec, without a shell
+func execCommandRunner(ctx context.Context, command string) ([]byte, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return exec.CommandContext(ctx, fields[0], fields[1:]...).CombinedOutput()
+}
+
+// VhostSite is one server block: the domain itself or one of its subdomains
+type VhostSite struct {
+	Name         string
+	ServerNames  []string
+	DocumentRoot string
+	SSL          bool
+	// ForceHTTPS redirects plain HTTP to HTTPS when SSL is active
+	ForceHTTPS bool
+	CertPath   string
+	KeyPath    string
+	PHPHandler string
+	Redirects  []VhostRedirect
+	// StatusPage replaces the site's content with a page from StatusPageDir,
+	// answered with StatusCode, while the domain is suspended or in maintenance
+	Status        string
+	StatusPage    string
+	StatusPageDir string
+	StatusCode    int
+}
+
+// VhostConfig is everything rendered into a domain's config file
+type VhostConfig struct {
+	DomainName string
+	Sites      []VhostSite
+}
+
+// VhostOptions configures a VhostService
+type VhostOptions struct {
+	Server        string
+	Dir           string
+	TestCommand   string
+	ReloadCommand string
+	PHPHandler    string
+	CertDir       string
+	StatusPageDir string
+	Runner        CommandRunner
+}
+
+// vhostMu serialises writes and reloads across every VhostService (the API
+// and the background workers): a config test validates the whole server
+// config, so two concurrent writes could mask each other's errors
+var vhostMu sync.Mutex
+
+// VhostService renders web server virtual hosts for domains and installs
+// them atomically, validating the config before reloading the server
+type VhostService struct {
+	opts VhostOptions
+}
+
+// NewVhostService creates a vhost service from the application config
+func NewVhostService() *VhostService {
+	cfg := config.AppConfig
+	return NewVhostServiceWithOptions(VhostOptions{
+		Server:        cfg.VhostServer,
+		Dir:           cfg.VhostDir,
+		TestCommand:   cfg.VhostTestCmd,
+		ReloadCommand: cfg.VhostReloadCmd,
+		PHPHandler:    cfg.PHPHandler,
+		CertDir:       cfg.SSLCertDir,
+		StatusPageDir: cfg.StatusPageDir,
+	})
+}
+
+// NewVhostServiceWithOptions creates a vhost service with explicit options,
+// filling in server specific default commands
+func NewVhostServiceWithOptions(opts VhostOptions) *VhostService {
+	if opts.Server == "" {
+		opts.Server = WebServerNginx
+	}
+	if opts.TestCommand == "" {
+		opts.TestCommand = "nginx -t"
+		if opts.Server == WebServerApache {
+			opts.TestCommand = "apachectl configtest"
+		}
+	}
+	if opts.ReloadCommand == "" {
+		opts.ReloadCommand = "nginx -s reload"
+		if opts.Server == WebServerApache {
+			opts.ReloadCommand = "apachectl graceful"
+		}
+	}
+	if opts.Runner == nil {
+		opts.Runner = execCommandRunner
+	}
+	return &VhostService{opts: opts}
+}
+
+// Enabled reports whether vhost provisioning is configured
+func (s *VhostService) Enabled() bool {
+	return s.opts.Dir != ""
+}
+
+// CertificatePaths returns where the certificate chain and private key of a
+// domain are stored
+func CertificatePaths(certDir, domainName string) (certPath, keyPath string) {
+	dir := filepath.Join(certDir, domainName)
+	return filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem")
+}
+
+// absoluteDocumentRoot resolves a home-relative document root to the
+// absolute path the web server needs
+func absoluteDocumentRoot(userID int, documentRoot string) (string, error) {
+	path, err := utils.ResolveUserPath(strconv.Itoa(userID), documentRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// SiteHostnames returns every hostname the main site of a domain answers
+// on: the apex, www and each alias with its www host
+func SiteHostnames(ctx context.Context, d *models.Domain) ([]string, error) {
+	hostnames := []string{d.DomainName, "www." + d.DomainName}
+	aliases, err := models.GetAliasesByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load aliases: %w", err)
+	}
+	for _, a := range aliases {
+		hostnames = append(hostnames, a.AliasName, "www."+a.AliasName)
+	}
+	return hostnames, nil
+}
+
+// BuildConfig collects the domain, its aliases, redirect rules and
+// subdomains into the data the templates are rendered from
+func (s *VhostService) BuildConfig(ctx context.Context, d *models.Domain) (*VhostConfig, error) {
+	docRoot, err := absoluteDocumentRoot(d.UserID, d.DocumentRoot)
+	if err != nil {
+		return nil, fmt.Errorf("document root: %w", err)
+	}
+
+	serverNames, err := SiteHostnames(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	redirects, err := models.GetRedirectsByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load redirects: %w", err)
+	}
+	// Rules are validated on write; re-check so a rule that no longer fits
+	// (e.g. its alias was removed) is skipped instead of breaking the config
+	valid := redirects[:0]
+	for i := range redirects {
+		if err := ValidateRedirect(&redirects[i], serverNames); err != nil {
+			log.Printf("WARN: Skipping redirect %d of %s: %v", redirects[i].ID, d.DomainName, err)
+			continue
+		}
+		valid = append(valid, redirects[i])
+	}
+
+	site := VhostSite{
+		Name:         d.DomainName,
+		ServerNames:  serverNames,
+		DocumentRoot: docRoot,
+		ForceHTTPS:   d.ForceHTTPS,
+		PHPHandler:   s.opts.PHPHandler,
+		Redirects:    CompileRedirects(valid),
+	}
+
+	// Only reference a certificate once it exists on disk, otherwise the
+	// config test fails and the whole domain goes offline
+	certPath, keyPath := CertificatePaths(s.opts.CertDir, d.DomainName)
+	if d.SSLEnabled && fileExists(certPath) && fileExists(keyPath) {
+		site.SSL = true
+		site.CertPath, _ = filepath.Abs(certPath)
+		site.KeyPath, _ = filepath.Abs(keyPath)
+	}
+
+	cfg := &VhostConfig{DomainName: d.DomainName, Sites: []VhostSite{site}}
+
+	subdomains, err := models.GetSubdomainsByDomainID(ctx, d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load subdomains: %w", err)
+	}
+	for _, sub := range subdomains {
+		subRoot, err := absoluteDocumentRoot(d.UserID, sub.DocumentRoot)
+		if err != nil {
+			return nil, fmt.Errorf("subdomain %s document root: %w", sub.FullName, err)
+		}
+		subSite := VhostSite{
+			Name:         sub.FullName,
+			ServerNames:  []string{sub.FullName},
+			DocumentRoot: subRoot,
+			PHPHandler:   s.opts.PHPHandler,
+		}
+		// A wildcard certificate covers subdomains one label below the domain
+		label := strings.TrimSuffix(sub.FullName, "."+d.DomainName)
+		if site.SSL && d.SSLWildcard && label != sub.FullName && !strings.Contains(label, ".") {
+			subSite.SSL = true
+			subSite.ForceHTTPS = d.ForceHTTPS
+			subSite.CertPath = site.CertPath
+			subSite.KeyPath = site.KeyPath
+		}
+		cfg.Sites = append(cfg.Sites, subSite)
+	}
+
+	if page, code := statusPageFor(d.Status); page != "" {
+		dir, err := filepath.Abs(s.opts.StatusPageDir)
+		if err != nil {
+			return nil, fmt.Errorf("status page directory: %w", err)
+		}
+		for i := range cfg.Sites {
+			cfg.Sites[i].Status = d.Status
+			cfg.Sites[i].StatusPage = page
+			cfg.Sites[i].StatusPageDir = dir
+			cfg.Sites[i].StatusCode = code
+		}
+	}
+
+	return cfg, nil
+}
+
+// statusPageFor returns the page and HTTP status served instead of the site
+// content for a domain status, or "" when the site is served normally
+func statusPageFor(status string) (string, int) {
+	switch status {
+	case models.DomainStatusSuspended:
+		return "suspended.html", http.StatusForbidden
+	case models.DomainStatusMaintenance:
+		return "maintenance.html", http.StatusServiceUnavailable
+	}
+	return "", 0
+}
+
+// ensureStatusPages writes the default status pages into the status page
+// directory, leaving customised pages alone
+func (s *VhostService) ensureStatusPages() error {
+	if err := os.MkdirAll(s.opts.StatusPageDir, 0755); err != nil {
+		return err
+	}
+	entries, err := statusPageFS.ReadDir("templates/pages")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(s.opts.StatusPageDir, e.Name())
+		if fileExists(path) {
+			continue
+		}
+		content, err := statusPageFS.ReadFile("templates/pages/" + e.Name())
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render renders a vhost config for the configured web server
+func (s *VhostService) Render(cfg *VhostConfig) ([]byte, error) {
+	if err := validateVhostConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	name := "nginx.conf.tmpl"
+	if s.opts.Server == WebServerApache {
+		name = "apache.conf.tmpl"
+	}
+
+	var buf bytes.Buffer
+	if err := vhostTemplates.ExecuteTemplate(&buf, name, cfg); err != nil {
+		return nil, fmt.Errorf("render %s vhost: %w", s.opts.Server, err)
+	}
+	return append(bytes.TrimRight(buf.Bytes(), "\n"), '\n'), nil
+}
+
+// validateVhostConfig rejects values that are unsafe to interpolate
+func validateVhostConfig(cfg *VhostConfig) error {
+	check := func(value string) error {
+		if value != "" && !safeConfigValue.MatchString(value) {
+			return fmt.Errorf("unsafe value in vhost config: %q", value)
+		}
+		return nil
+	}
+
+	if err := check(cfg.DomainName); err != nil {
+		return err
+	}
+	for _, site := range cfg.Sites {
+		values := append([]string{site.Name, site.DocumentRoot, site.CertPath, site.KeyPath, site.PHPHandler,
+			site.StatusPage, site.StatusPageDir}, site.ServerNames...)
+		for _, v := range values {
+			if err := check(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SyncDomain renders and installs the vhost of a domain
+func (s *VhostService) SyncDomain(ctx context.Context, d *models.Domain) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	cfg, err := s.BuildConfig(ctx, d)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Sites) > 0 && cfg.Sites[0].StatusPage != "" {
+		if err := s.ensureStatusPages(); err != nil {
+			return fmt.Errorf("status pages: %w", err)
+		}
+	}
+	content, err := s.Render(cfg)
+	if err != nil {
+		return err
+	}
+	return s.install(ctx, d.DomainName, content)
+}
+
+// RemoveDomain removes the vhost of a domain
+func (s *VhostService) RemoveDomain(ctx context.Context, domainName string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	return s.install(ctx, domainName, nil)
+}
+
+// Reload tests the server config and reloads the web server, so replaced
+// certificate files are picked up without a config change
+func (s *VhostService) Reload(ctx context.Context) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	vhostMu.Lock()
+	defer vhostMu.Unlock()
+
+	if out, err := s.opts.Runner(ctx, s.opts.TestCommand); err != nil {
+		return fmt.Errorf("config test failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if out, err := s.opts.Runner(ctx, s.opts.ReloadCommand); err != nil {
+		return fmt.Errorf("reload failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// install atomically replaces (or removes, when content is nil) a domain's
+// config file, then tests the server config. A failing test restores the
+// previous file so a bad vhost never reaches the running server
+func (s *VhostService) install(ctx context.Context, domainName string, content []byte) error {
+	if !safeConfigValue.MatchString(domainName) {
+		return fmt.Errorf("invalid domain name %q", domainName)
+	}
+
+	vhostMu.Lock()
+	defer vhostMu.Unlock()
+
+	path := filepath.Join(s.opts.Dir, domainName+".conf")
+	previous, err := os.ReadFile(path)
+	hadPrevious := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read existing vhost: %w", err)
+	}
+
+	switch {
+	case content == nil && !hadPrevious:
+		return nil
+	case content != nil && hadPrevious && bytes.Equal(previous, content):
+		return nil
+	case content == nil:
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove vhost: %w", err)
+		}
+	default:
+		if err := writeFileAtomic(path, content, 0644); err != nil {
+			return fmt.Errorf("write vhost: %w", err)
+		}
+	}
+
+	if out, err := s.opts.Runner(ctx, s.opts.TestCommand); err != nil {
+		// Roll back to the previous state before reporting the failure
+		var rollbackErr error
+		if hadPrevious {
+			rollbackErr = writeFileAtomic(path, previous, 0644)
+		} else {
+			rollbackErr = os.Remove(path)
+		}
+		if rollbackErr != nil {
+			return fmt.Errorf("config test failed: %v: %s (rollback failed: %v)", err, strings.TrimSpace(string(out)), rollbackErr)
+		}
+		return fmt.Errorf("config test failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if out, err := s.opts.Runner(ctx, s.opts.ReloadCommand); err != nil {
+		return fmt.Errorf("reload failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes to a temp file in the target directory and renames
+// it into place, so readers never observe a partially written file. The temp
+// name does not end in .conf so it is never picked up by an include glob
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// apachePHPHandler converts an nginx style fastcgi address into an Apache
+// SetHandler target
+func apachePHPHandler(handler string) string {
+	if strings.HasPrefix(handler, "unix:") {
+		return "proxy:" + handler + "|fcgi://localhost"
+	}
+	return "proxy:fcgi://" + handler
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloudku-server/models"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.golden files")
+
+// fakeRunner records the commands it runs and fails the ones listed in fail
+type fakeRunner struct {
+	commands []string
+	fail     map[string]bool
+}
+
+func (r *fakeRunner) run(ctx context.Context, command string) ([]byte, error) {
+	r.commands = append(r.commands, command)
+	if r.fail[command] {
+		return []byte("syntax error in " + command), errors.New("exit status 1")
+	}
+	return nil, nil
+}
+
+func (r *fakeRunner) ran(command string) bool {
+	for _, c := range r.commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestVhostService(t *testing.T, server string, runner *fakeRunner) *VhostService {
+	t.Helper()
+	return NewVhostServiceWithOptions(VhostOptions{
+		Server:        server,
+		Dir:           t.TempDir(),
+		TestCommand:   "test-config",
+		ReloadCommand: "reload-server",
+		Runner:        runner.run,
+	})
+}
+
+func testVhostSite(ssl, forceHTTPS bool) VhostSite {
+	site := VhostSite{
+		Name:         "example.com",
+		ServerNames:  []string{"example.com", "www.example.com", "example.net", "www.example.net"},
+		DocumentRoot: "/home/1/public_html",
+		PHPHandler:   "unix:/run/php/php-fpm.sock",
+		ForceHTTPS:   forceHTTPS,
+	}
+	if ssl {
+		site.SSL = true
+		site.CertPath = "/etc/cloudku/ssl/example.com/fullchain.pem"
+		site.KeyPath = "/etc/cloudku/ssl/example.com/privkey.pem"
+	}
+	return site
+}
+
+func testVhostRedirects() []VhostRedirect {
+	return CompileRedirects([]models.DomainRedirect{
+		{SourcePath: "/old", MatchType: models.RedirectMatchExact, Target: "https://example.com/new", StatusCode: 301, PreserveQuery: true},
+		{SourceHost: "example.net", SourcePath: "/", MatchType: models.RedirectMatchPrefix, Target: "https://example.com/", StatusCode: 302},
+	})
+}
+
+func TestRenderGolden(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  func() *VhostConfig
+	}{
+		{"plain", func() *VhostConfig {
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{testVhostSite(false, false)}}
+		}},
+		{"ssl", func() *VhostConfig {
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{testVhostSite(true, false)}}
+		}},
+		{"force_https", func() *VhostConfig {
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{testVhostSite(true, true)}}
+		}},
+		{"redirects", func() *VhostConfig {
+			site := testVhostSite(false, false)
+			site.Redirects = testVhostRedirects()
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{site}}
+		}},
+		{"force_https_redirects", func() *VhostConfig {
+			site := testVhostSite(true, true)
+			site.Redirects = testVhostRedirects()
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{site}}
+		}},
+		{"subdomain", func() *VhostConfig {
+			sub := VhostSite{
+				Name:         "blog.example.com",
+				ServerNames:  []string{"blog.example.com"},
+				DocumentRoot: "/home/1/blog",
+			}
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{testVhostSite(false, false), sub}}
+		}},
+		{"maintenance", func() *VhostConfig {
+			site := testVhostSite(true, false)
+			site.Status = models.DomainStatusMaintenance
+			site.StatusPage = "maintenance.html"
+			site.StatusPageDir = "/var/lib/cloudku/status-pages"
+			site.StatusCode = 503
+			return &VhostConfig{DomainName: "example.com", Sites: []VhostSite{site}}
+		}},
+	}
+
+	for _, server := range []string{WebServerNginx, WebServerApache} {
+		s := NewVhostServiceWithOptions(VhostOptions{Server: server})
+		for _, tc := range cases {
+			t.Run(server+"/"+tc.name, func(t *testing.T) {
+				got, err := s.Render(tc.cfg())
+				if err != nil {
+					t.Fatalf("Render: %v", err)
+				}
+
+				golden := filepath.Join("testdata", "vhost_"+server+"_"+tc.name+".golden")
+				if *updateGolden {
+					if err := os.WriteFile(golden, got, 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("read golden file (run with -update to create it): %v", err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("%s does not match the rendered config:\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
+
+func TestRenderRejectsUnsafeValues(t *testing.T) {
+	s := NewVhostServiceWithOptions(VhostOptions{})
+	site := testVhostSite(false, false)
+	site.DocumentRoot = "/home/1/public_html; include /etc/passwd"
+
+	if _, err := s.Render(&VhostConfig{DomainName: "example.com", Sites: []VhostSite{site}}); err == nil {
+		t.Fatal("Render accepted a document root with a directive injection")
+	}
+}
+
+func TestInstallWritesAndReloads(t *testing.T) {
+	runner := &fakeRunner{}
+	s := newTestVhostService(t, WebServerNginx, runner)
+
+	if err := s.install(context.Background(), "example.com", []byte("server {}\n")); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(s.opts.Dir, "example.com.conf"))
+	if err != nil || string(got) != "server {}\n" {
+		t.Fatalf("vhost file = %q, %v", got, err)
+	}
+	if strings.Join(runner.commands, ",") != "test-config,reload-server" {
+		t.Errorf("commands = %v, want config test then reload", runner.commands)
+	}
+}
+
+func TestInstallUnchangedSkipsReload(t *testing.T) {
+	runner := &fakeRunner{}
+	s := newTestVhostService(t, WebServerNginx, runner)
+	path := filepath.Join(s.opts.Dir, "example.com.conf")
+	if err := os.WriteFile(path, []byte("server {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.install(context.Background(), "example.com", []byte("server {}\n")); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if len(runner.commands) != 0 {
+		t.Errorf("commands = %v, want none for an unchanged file", runner.commands)
+	}
+}
+
+func TestInstallRollsBackFailedConfigTest(t *testing.T) {
+	runner := &fakeRunner{fail: map[string]bool{"test-config": true}}
+	s := newTestVhostService(t, WebServerNginx, runner)
+	path := filepath.Join(s.opts.Dir, "example.com.conf")
+	if err := os.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.install(context.Background(), "example.com", []byte("broken\n"))
+	if err == nil || !strings.Contains(err.Error(), "config test failed") {
+		t.Fatalf("install error = %v, want config test failure", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "previous\n" {
+		t.Errorf("vhost file = %q, %v, want the previous file restored", got, err)
+	}
+	if runner.ran("reload-server") {
+		t.Error("server was reloaded after a failed config test")
+	}
+}
+
+func TestInstallRollsBackNewFile(t *testing.T) {
+	runner := &fakeRunner{fail: map[string]bool{"test-config": true}}
+	s := newTestVhostService(t, WebServerApache, runner)
+
+	if err := s.install(context.Background(), "example.com", []byte("broken\n")); err == nil {
+		t.Fatal("install succeeded despite the failed config test")
+	}
+
+	if _, err := os.Stat(filepath.Join(s.opts.Dir, "example.com.conf")); !os.IsNotExist(err) {
+		t.Errorf("new vhost file left behind after rollback: %v", err)
+	}
+	if runner.ran("reload-server") {
+		t.Error("server was reloaded after a failed config test")
+	}
+}
+
+func TestRemoveDomainRollsBackFailedConfigTest(t *testing.T) {
+	runner := &fakeRunner{fail: map[string]bool{"test-config": true}}
+	s := newTestVhostService(t, WebServerNginx, runner)
+	path := filepath.Join(s.opts.Dir, "example.com.conf")
+	if err := os.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveDomain(context.Background(), "example.com"); err == nil {
+		t.Fatal("RemoveDomain succeeded despite the failed config test")
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "previous\n" {
+		t.Errorf("vhost file = %q, %v, want the removed file restored", got, err)
+	}
+	if runner.ran("reload-server") {
+		t.Error("server was reloaded after a failed config test")
+	}
+}
+
+func TestInstallReportsReloadFailure(t *testing.T) {
+	runner := &fakeRunner{fail: map[string]bool{"reload-server": true}}
+	s := newTestVhostService(t, WebServerNginx, runner)
+
+	err := s.install(context.Background(), "example.com", []byte("server {}\n"))
+	if err == nil || !strings.Contains(err.Error(), "reload failed") {
+		t.Fatalf("install error = %v, want reload failure", err)
+	}
+	// The config passed its test, so it stays in place for the next reload
+	if _, err := os.Stat(filepath.Join(s.opts.Dir, "example.com.conf")); err != nil {
+		t.Errorf("vhost file missing after a reload failure: %v", err)
+	}
+}
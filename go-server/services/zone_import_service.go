@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloudku-server/database"
+	"cloudku-server/models"
+
+	"github.com/miekg/dns"
+)
+
+// Zone import modes
+const (
+	// ZoneImportMerge adds the records of the file and keeps the others
+	ZoneImportMerge = "merge"
+	// ZoneImportReplace makes the zone match the file, except for the
+	// records subdomains manage
+	ZoneImportReplace = "replace"
+)
+
+// ErrInvalidZoneFile is returned when a zone file cannot be parsed or
+// contains records that fail validation
+var ErrInvalidZoneFile = errors.New("invalid zone file")
+
+// ZoneRecord is a record of a parsed zone file, in dns_records form
+type ZoneRecord struct {
+	RecordType string `json:"record_type"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	TTL        int    `json:"ttl"`
+	Priority   *int   `json:"priority,omitempty"`
+}
+
+// ZoneFileIssue is a record of the zone file that will not be imported
+type ZoneFileIssue struct {
+	Record string `json:"record"`
+	Reason string `json:"reason"`
+}
+
+// ParsedZone is the content of a zone file
+type ParsedZone struct {
+	Records []ZoneRecord `json:"records"`
+	// SOA is the zone's SOA record, if the file has one
+	SOA *dns.SOA `json:"-"`
+	// Skipped lists records that are not imported, such as the apex NS set
+	// of the previous provider and DNSSEC records
+	Skipped []ZoneFileIssue `json:"skipped,omitempty"`
+	// Invalid lists records that failed validation; a zone with invalid
+	// records cannot be applied
+	Invalid []ZoneFileIssue `json:"invalid,omitempty"`
+}
+
+// ZoneRecordChange is a matched record whose TTL or priority changes
+type ZoneRecordChange struct {
+	Before models.DNSRecordResponse `json:"before"`
+	After  ZoneRecord               `json:"after"`
+}
+
+// ZoneDiff is what applying a zone file would change
+type ZoneDiff struct {
+	Mode      string                     `json:"mode"`
+	Add       []ZoneRecord               `json:"add"`
+	Update    []ZoneRecordChange         `json:"update"`
+	Remove    []models.DNSRecordResponse `json:"remove"`
+	Unchanged int                        `json:"unchanged"`
+	// Conflicts lists added records that clash with records that are kept
+	Conflicts []ZoneFileIssue `json:"conflicts,omitempty"`
+	// Protected lists records that replace mode keeps because a subdomain
+	// manages them
+	Protected []ZoneFileIssue `json:"protected,omitempty"`
+}
+
+// Empty reports whether applying the diff changes nothing
+func (d *ZoneDiff) Empty() bool {
+	return len(d.Add) == 0 && len(d.Update) == 0 && len(d.Remove) == 0
+}
+
+// ParseZoneFile reads an RFC 1035 zone file for zone. $ORIGIN, $TTL,
+// relative names, parenthesised multi-line records and quoted strings are
+// handled by the parser; each record is converted to dns_records form and
+// validated
+func ParseZoneFile(r io.Reader, zone string) (*ParsedZone, error) {
+	origin := dns.Fqdn(strings.ToLower(zone))
+	zp := dns.NewZoneParser(r, origin, "")
+	zp.SetDefaultTTL(DefaultDNSTTL)
+
+	parsed := &ParsedZone{}
+	seen := make(map[string]bool)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		owner := strings.ToLower(hdr.Name)
+		text := rr.String()
+
+		if !dns.IsSubDomain(origin, owner) {
+			parsed.Invalid = append(parsed.Invalid, ZoneFileIssue{text, "record is outside the zone " + zone})
+			continue
+		}
+
+		switch v := rr.(type) {
+		case *dns.SOA:
+			if owner == origin {
+				parsed.SOA = v
+			}
+			continue
+		case *dns.NS:
+			if owner == origin {
+				parsed.Skipped = append(parsed.Skipped, ZoneFileIssue{text, "apex NS records are managed by this server"})
+				continue
+			}
+		}
+
+		in, err := zoneRecordInput(rr)
+		if err != nil {
+			parsed.Skipped = append(parsed.Skipped, ZoneFileIssue{text, err.Error()})
+			continue
+		}
+		in.Name = strings.TrimSuffix(owner, origin)
+		// Some providers export very low TTLs; raise them to our minimum
+		if in.TTL > 0 && in.TTL < MinDNSTTL {
+			in.TTL = MinDNSTTL
+		}
+		NormalizeDNSRecord(&in, zone)
+
+		key := zoneRecordKey(in.RecordType, in.Name, in.Value)
+		if seen[key] {
+			continue
+		}
+
+		var accepted []models.DNSRecord
+		for _, rec := range parsed.Records {
+			accepted = append(accepted, models.DNSRecord{RecordType: rec.RecordType, Name: rec.Name, Value: rec.Value})
+		}
+		if err := ValidateDNSRecord(&in, accepted); err != nil {
+			parsed.Invalid = append(parsed.Invalid, ZoneFileIssue{text, err.Error()})
+			continue
+		}
+
+		seen[key] = true
+		parsed.Records = append(parsed.Records, ZoneRecord{
+			RecordType: in.RecordType, Name: in.Name, Value: in.Value, TTL: in.TTL, Priority: in.Priority,
+		})
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidZoneFile, err)
+	}
+
+	if len(parsed.Records) == 0 && len(parsed.Invalid) == 0 {
+		return nil, fmt.Errorf("%w: no records found", ErrInvalidZoneFile)
+	}
+	return parsed, nil
+}
+
+// zoneRecordInput converts a parsed resource record to the value and
+// priority layout of dns_records
+func zoneRecordInput(rr dns.RR) (DNSRecordInput, error) {
+	in := DNSRecordInput{
+		RecordType: dns.TypeToString[rr.Header().Rrtype],
+		TTL:        int(rr.Header().Ttl),
+	}
+	priority := func(p uint16) *int {
+		v := int(p)
+		return &v
+	}
+
+	switch v := rr.(type) {
+	case *dns.A:
+		in.Value = v.A.String()
+	case *dns.AAAA:
+		in.Value = v.AAAA.String()
+	case *dns.CNAME:
+		in.Value = v.Target
+	case *dns.NS:
+		in.Value = v.Ns
+	case *dns.PTR:
+		in.Value = v.Ptr
+	case *dns.MX:
+		in.Value, in.Priority = v.Mx, priority(v.Preference)
+	case *dns.SRV:
+		in.Value = fmt.Sprintf("%d %d %s", v.Weight, v.Port, v.Target)
+		in.Priority = priority(v.Priority)
+	case *dns.TXT:
+		in.Value = strings.Join(v.Txt, "")
+	case *dns.CAA:
+		in.Value = fmt.Sprintf("%d %s %s", v.Flag, v.Tag, strconv.Quote(v.Value))
+	default:
+		return in, fmt.Errorf("%s records are not supported", in.RecordType)
+	}
+	return in, nil
+}
+
+// zoneRecordKey identifies a record regardless of TTL
+func zoneRecordKey(recordType, name, value string) string {
+	return strings.ToUpper(recordType) + "|" + strings.ToLower(name) + "|" + strings.ToLower(value)
+}
+
+// samePriority compares a stored and an incoming priority
+func samePriority(r *models.DNSRecord, p *int) bool {
+	if !r.Priority.Valid || p == nil {
+		return !r.Priority.Valid && p == nil
+	}
+	return int(r.Priority.Int32) == *p
+}
+
+// DiffZone compares the records of a zone file with the stored records.
+// Records are matched on type, name and value; a matched record whose TTL
+// or priority differs is updated. In replace mode unmatched stored records
+// are removed, except the records of subdomains, which would otherwise be
+// left without their address record
+func DiffZone(existing []models.DNSRecord, incoming []ZoneRecord, mode string, subdomains []models.Subdomain) *ZoneDiff {
+	diff := &ZoneDiff{
+		Mode:   mode,
+		Add:    []ZoneRecord{},
+		Update: []ZoneRecordChange{},
+		Remove: []models.DNSRecordResponse{},
+	}
+
+	stored := make(map[string]*models.DNSRecord, len(existing))
+	for i := range existing {
+		r := &existing[i]
+		stored[zoneRecordKey(r.RecordType, r.Name, r.Value)] = r
+	}
+
+	managed := make(map[int]string, len(subdomains))
+	for _, s := range subdomains {
+		if s.DNSRecordID.Valid {
+			managed[int(s.DNSRecordID.Int32)] = s.FullName
+		}
+	}
+
+	matched := make(map[int]bool)
+	for _, in := range incoming {
+		r, ok := stored[zoneRecordKey(in.RecordType, in.Name, in.Value)]
+		if !ok {
+			diff.Add = append(diff.Add, in)
+			continue
+		}
+		matched[r.ID] = true
+		if r.TTL != in.TTL || !samePriority(r, in.Priority) {
+			diff.Update = append(diff.Update, ZoneRecordChange{Before: r.ToResponse(), After: in})
+		} else {
+			diff.Unchanged++
+		}
+	}
+
+	var kept []models.DNSRecord
+	for _, r := range existing {
+		switch {
+		case matched[r.ID]:
+			kept = append(kept, r)
+		case mode == ZoneImportReplace && managed[r.ID] != "":
+			kept = append(kept, r)
+			diff.Protected = append(diff.Protected, ZoneFileIssue{
+				Record: r.Name + " " + r.RecordType + " " + r.Value,
+				Reason: "managed by subdomain " + managed[r.ID],
+			})
+		case mode == ZoneImportReplace:
+			diff.Remove = append(diff.Remove, r.ToResponse())
+		default:
+			kept = append(kept, r)
+		}
+	}
+
+	// Merging, or keeping subdomain records, can put a CNAME next to records
+	// the file does not mention
+	if mode == ZoneImportMerge || len(diff.Protected) > 0 {
+		for _, add := range diff.Add {
+			in := DNSRecordInput{RecordType: add.RecordType, Name: add.Name, Value: add.Value, TTL: add.TTL, Priority: add.Priority}
+			if err := ValidateDNSRecord(&in, kept); err != nil {
+				diff.Conflicts = append(diff.Conflicts, ZoneFileIssue{
+					Record: add.Name + " " + add.RecordType + " " + add.Value,
+					Reason: err.Error(),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(diff.Add, func(i, j int) bool {
+		if diff.Add[i].RecordType != diff.Add[j].RecordType {
+			return diff.Add[i].RecordType < diff.Add[j].RecordType
+		}
+		return diff.Add[i].Name < diff.Add[j].Name
+	})
+	return diff
+}
+
+// ApplyZoneDiff writes a diff to the records of a domain in one transaction
+// and bumps the zone serial
+func ApplyZoneDiff(ctx context.Context, actor models.DNSChangeActor, domainID int, diff *ZoneDiff) error {
+	return models.UpdateZone(ctx, domainID, func(tx database.Querier) error {
+		return applyZoneDiff(ctx, tx, actor, domainID, diff)
+	})
+}
+
+func applyZoneDiff(ctx context.Context, tx database.Querier, actor models.DNSChangeActor, domainID int, diff *ZoneDiff) error {
+	for _, r := range diff.Remove {
+		if err := models.DeleteDNSRecord(ctx, tx, actor, r.ID, domainID); err != nil {
+			return fmt.Errorf("remove %s %s: %w", r.Name, r.RecordType, err)
+		}
+	}
+	for _, u := range diff.Update {
+		a := u.After
+		if _, err := models.UpdateDNSRecord(ctx, tx, actor, u.Before.ID, domainID, a.RecordType, a.Name, a.Value, a.TTL, a.Priority); err != nil {
+			return fmt.Errorf("update %s %s: %w", a.Name, a.RecordType, err)
+		}
+	}
+	for _, a := range diff.Add {
+		if _, err := models.InsertDNSRecord(ctx, tx, actor, domainID, a.RecordType, a.Name, a.Value, a.TTL, a.Priority); err != nil {
+			return fmt.Errorf("add %s %s: %w", a.Name, a.RecordType, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+
+	"cloudku-server/models"
+)
+
+func TestDiffZoneReplaceKeepsSubdomainRecords(t *testing.T) {
+	existing := []models.DNSRecord{
+		historyRecord(1, "A", "@", "203.0.113.10"),
+		historyRecord(2, "A", "blog", "203.0.113.10"),
+		historyRecord(3, "A", "old", "203.0.113.20"),
+	}
+	subdomains := []models.Subdomain{
+		{ID: 7, Name: "blog", FullName: "blog.example.com", DNSRecordID: sql.NullInt32{Int32: 2, Valid: true}},
+	}
+	incoming := []ZoneRecord{{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600}}
+
+	diff := DiffZone(existing, incoming, ZoneImportReplace, subdomains)
+	if len(diff.Remove) != 1 || diff.Remove[0].ID != 3 {
+		t.Errorf("remove = %+v, want only the old record", diff.Remove)
+	}
+	if len(diff.Protected) != 1 || diff.Protected[0].Reason != "managed by subdomain blog.example.com" {
+		t.Errorf("protected = %+v, want the blog subdomain record", diff.Protected)
+	}
+}
+
+func TestDiffZoneReplaceConflictsWithSubdomainRecords(t *testing.T) {
+	existing := []models.DNSRecord{historyRecord(2, "A", "blog", "203.0.113.10")}
+	subdomains := []models.Subdomain{
+		{ID: 7, Name: "blog", FullName: "blog.example.com", DNSRecordID: sql.NullInt32{Int32: 2, Valid: true}},
+	}
+	incoming := []ZoneRecord{{RecordType: "CNAME", Name: "blog", Value: "blog.example.net", TTL: 3600}}
+
+	diff := DiffZone(existing, incoming, ZoneImportReplace, subdomains)
+	if len(diff.Conflicts) != 1 {
+		t.Errorf("conflicts = %+v, want the CNAME next to the kept subdomain record", diff.Conflicts)
+	}
+}
+
+func TestDiffZoneReplaceRemovesUnmatched(t *testing.T) {
+	existing := []models.DNSRecord{
+		historyRecord(1, "A", "@", "203.0.113.10"),
+		historyRecord(3, "A", "old", "203.0.113.20"),
+	}
+	incoming := []ZoneRecord{{RecordType: "A", Name: "@", Value: "203.0.113.10", TTL: 3600}}
+
+	diff := DiffZone(existing, incoming, ZoneImportReplace, nil)
+	if len(diff.Remove) != 1 || diff.Remove[0].ID != 3 || len(diff.Protected) != 0 || len(diff.Conflicts) != 0 {
+		t.Errorf("diff = %+v, want the old record removed", diff)
+	}
+}
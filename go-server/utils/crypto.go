@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"cloudku-server/config"
+)
+
+// encryptedPrefix marks values produced by EncryptSecret, so the format can
+// change later without guessing
+const encryptedPrefix = "v1:"
+
+// ErrInvalidCiphertext is returned for values that were not produced by
+// EncryptSecret or were encrypted with another key
+var ErrInvalidCiphertext = errors.New("invalid or tampered ciphertext")
+
+// secretKey derives the AES-256 key from ENCRYPTION_KEY, falling back to
+// the JWT secret when it is not set
+func secretKey() []byte {
+	secret := config.AppConfig.EncryptionKey
+	if secret == "" {
+		secret = config.AppConfig.JWTSecret
+	}
+	sum := sha256.Sum256([]byte("cloudku-encryption:" + secret))
+	return sum[:]
+}
+
+func secretAEAD() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secretKey())
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts sensitive data at rest (private keys and the like)
+// with AES-256-GCM
+func EncryptSecret(plaintext []byte) (string, error) {
+	aead, err := secretAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret
+func DecryptSecret(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return nil, ErrInvalidCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	aead, err := secretAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
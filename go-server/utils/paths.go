@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathOutsideHome is returned when a user supplied path escapes the
+// user's home directory
+var ErrPathOutsideHome = errors.New("path is outside the user home directory")
+
+// UserFilesPath returns the home directory holding a user's files
+func UserFilesPath(userID string) string {
+	basePath := os.Getenv("USER_FILES_BASE_PATH")
+	if basePath == "" {
+		basePath = "./user-files"
+	}
+	return filepath.Join(basePath, userID)
+}
+
+// ResolveUserPath joins a home-relative path (e.g. a document root such as
+// "/public_html") onto the user's home and rejects path traversal
+func ResolveUserPath(userID string, relativePath string) (string, error) {
+	home := filepath.Clean(UserFilesPath(userID))
+	fullPath := filepath.Clean(filepath.Join(home, relativePath))
+
+	if fullPath != home && !strings.HasPrefix(fullPath, home+string(filepath.Separator)) {
+		return "", ErrPathOutsideHome
+	}
+
+	return fullPath, nil
+}